@@ -0,0 +1,310 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// Channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelEmail   = "email"
+)
+
+const (
+	defaultTitle = `[{{ .Status | toUpper }}{{ if eq .Status "firing" }}:{{ len .Alerts.Firing }}{{ end }}] {{ .CommonLabels.alertname }}`
+	defaultBody  = `{{ range .Alerts }}{{ .Status | toUpper }} {{ .Labels | labels }} value={{ .Value }}
+{{ with .Annotations.summary }}{{ . }}
+{{ end }}{{ with .Annotations.description }}{{ . }}
+{{ end }}{{ end }}{{ with .ExternalURL }}{{ . }}/alerts
+{{ end }}`
+)
+
+var templateFuncs = template.FuncMap{
+	"toUpper": strings.ToUpper,
+	"toLower": strings.ToLower,
+	"join":    func(sep string, s []string) string { return strings.Join(s, sep) },
+	"labels":  groupKey,
+}
+
+// permanentError marks a failure that retrying will not fix, such as a
+// rejected request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type channel struct {
+	config config.NotificationChannelConfig
+	title  *template.Template
+	body   *template.Template
+	client *http.Client
+}
+
+// newChannel validates a channel definition and parses its templates
+func newChannel(cfg config.NotificationChannelConfig) (*channel, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	switch cfg.Type {
+	case ChannelWebhook, ChannelSlack:
+		if cfg.URL == "" {
+			return nil, errors.New("url is required")
+		}
+	case ChannelEmail:
+		if cfg.SMTP.Host == "" || cfg.SMTP.From == "" || len(cfg.SMTP.To) == 0 {
+			return nil, errors.New("smtp host, from and to are required")
+		}
+		for _, address := range append([]string{cfg.SMTP.From}, cfg.SMTP.To...) {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return nil, fmt.Errorf("invalid email address %q: %v", address, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported channel type %q", cfg.Type)
+	}
+
+	ch := &channel{config: cfg, client: &http.Client{}}
+
+	title, body := cfg.Title, cfg.Body
+	if title == "" {
+		title = defaultTitle
+	}
+	if body == "" {
+		body = defaultBody
+	}
+	var err error
+	if ch.title, err = template.New("title").Funcs(templateFuncs).Parse(title); err != nil {
+		return nil, fmt.Errorf("invalid title template: %w", err)
+	}
+	if ch.body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return ch, nil
+}
+
+// filter returns the part of a notification this channel receives, or nil
+// when nothing is left to send
+func (ch *channel) filter(n *Notification) *Notification {
+	alerts := Alerts{}
+	for _, a := range n.Alerts {
+		if a.Status == storage.AlertResolved && !ch.config.SendResolved {
+			continue
+		}
+		matched := true
+		for name, value := range ch.config.Match {
+			if a.Labels[name] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			alerts = append(alerts, a)
+		}
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	filtered := *n
+	filtered.Receiver = ch.config.Name
+	filtered.Alerts = alerts
+	filtered.Status = storage.AlertResolved
+	if len(alerts.Firing()) > 0 {
+		filtered.Status = storage.AlertFiring
+	}
+	filtered.CommonLabels = commonLabels(alerts, func(a *Alert) map[string]string { return a.Labels })
+	filtered.CommonAnnotations = commonLabels(alerts, func(a *Alert) map[string]string { return a.Annotations })
+	return &filtered
+}
+
+func (ch *channel) send(ctx context.Context, n *Notification) error {
+	switch ch.config.Type {
+	case ChannelWebhook:
+		payload, err := json.Marshal(n)
+		if err != nil {
+			return &permanentError{err}
+		}
+		return ch.post(ctx, payload)
+	case ChannelSlack:
+		title, body, err := ch.render(n)
+		if err != nil {
+			return err
+		}
+		message := map[string]string{"text": strings.TrimSpace(title + "\n" + body)}
+		if ch.config.Channel != "" {
+			message["channel"] = ch.config.Channel
+		}
+		if ch.config.Username != "" {
+			message["username"] = ch.config.Username
+		}
+		payload, _ := json.Marshal(message)
+		return ch.post(ctx, payload)
+	case ChannelEmail:
+		title, body, err := ch.render(n)
+		if err != nil {
+			return err
+		}
+		return ch.mail(ctx, title, body)
+	}
+	return &permanentError{fmt.Errorf("unsupported channel type %q", ch.config.Type)}
+}
+
+func (ch *channel) render(n *Notification) (string, string, error) {
+	var title, body strings.Builder
+	if err := ch.title.Execute(&title, n); err != nil {
+		return "", "", &permanentError{fmt.Errorf("failed to render title: %w", err)}
+	}
+	if err := ch.body.Execute(&body, n); err != nil {
+		return "", "", &permanentError{fmt.Errorf("failed to render body: %w", err)}
+	}
+	return title.String(), body.String(), nil
+}
+
+// post sends a JSON payload. Client errors other than 429 are permanent.
+func (ch *channel) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range ch.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// mail sends a plain text email, upgrading to TLS when the server offers
+// STARTTLS
+func (ch *channel) mail(ctx context.Context, subject, body string) error {
+	cfg := ch.config.SMTP
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return &permanentError{err}
+		}
+	}
+
+	if err := c.Mail(envelopeAddress(cfg.From)); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(envelopeAddress(to)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + headerAddress(cfg.From) + "\r\n")
+	to := make([]string, 0, len(cfg.To))
+	for _, address := range cfg.To {
+		to = append(to, headerAddress(address))
+	}
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerText(subject)) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body = strings.NewReplacer("\r\n", "\r\n", "\r", "\r\n", "\n", "\r\n").Replace(body)
+	msg.WriteString(body)
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// headerText makes text from rule names and ingested labels safe for a
+// header line: line breaks and other control characters become spaces, so
+// they cannot start a header of their own
+func headerText(text string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, text))
+}
+
+// headerAddress formats an address validated by newChannel for an address
+// header
+func headerAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return headerText(address)
+	}
+	return parsed.String()
+}
+
+// envelopeAddress returns the bare address of "Name <address>" for the SMTP
+// envelope
+func envelopeAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.Address
+}
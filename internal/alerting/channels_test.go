@@ -0,0 +1,261 @@
+package alerting
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+func testNotification(alertname string) *Notification {
+	labels := map[string]string{"alertname": alertname, "service_name": "checkout"}
+	return &Notification{
+		Version:      "4",
+		Status:       storage.AlertFiring,
+		CommonLabels: labels,
+		Alerts: Alerts{{
+			Status:      storage.AlertFiring,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": "p99 above 1s"},
+			Value:       1.4,
+		}},
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   bool
+		permanent bool
+	}{
+		{"accepted", http.StatusOK, false, false},
+		{"no content", http.StatusNoContent, false, false},
+		{"rejected", http.StatusBadRequest, true, true},
+		{"rate limited", http.StatusTooManyRequests, true, false},
+		{"server error", http.StatusBadGateway, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Notification
+			var header string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("X-Token")
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decode payload: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			ch, err := newChannel(config.NotificationChannelConfig{
+				Name:    "hook",
+				Type:    ChannelWebhook,
+				URL:     server.URL,
+				Headers: map[string]string{"X-Token": "secret"},
+			})
+			if err != nil {
+				t.Fatalf("newChannel: %v", err)
+			}
+
+			err = ch.send(context.Background(), testNotification("HighLatency"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("send error = %v, want error %v", err, tt.wantErr)
+			}
+			var perm *permanentError
+			if errors.As(err, &perm) != tt.permanent {
+				t.Errorf("permanent = %v, want %v (%v)", !tt.permanent, tt.permanent, err)
+			}
+			if header != "secret" {
+				t.Errorf("X-Token header = %q, want secret", header)
+			}
+			if len(got.Alerts) != 1 || got.CommonLabels["alertname"] != "HighLatency" {
+				t.Errorf("payload = %+v, want the HighLatency alert", got)
+			}
+		})
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	ch, err := newChannel(config.NotificationChannelConfig{
+		Name:    "slack",
+		Type:    ChannelSlack,
+		URL:     server.URL,
+		Channel: "#alerts",
+	})
+	if err != nil {
+		t.Fatalf("newChannel: %v", err)
+	}
+	if err := ch.send(context.Background(), testNotification("HighLatency")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got["channel"] != "#alerts" || !strings.HasPrefix(got["text"], "[FIRING:1] HighLatency") {
+		t.Errorf("message = %v", got)
+	}
+}
+
+// smtpMessage is a message received by the SMTP stand-in
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// startSMTP accepts connections speaking just enough SMTP for net/smtp to
+// deliver a message, which is sent to the returned channel
+func startSMTP(t *testing.T) (string, int, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan smtpMessage, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = arg
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			if msg.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			messages <- msg
+			tp.PrintfLine("250 Queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestEmailChannel(t *testing.T) {
+	tests := []struct {
+		name        string
+		alertname   string
+		wantSubject string
+	}{
+		{"plain", "HighLatency", "[FIRING:1] HighLatency"},
+		{"non-ascii", "Latenz hoch ✗", "[FIRING:1] Latenz hoch ✗"},
+		{"newline", "HighLatency\nBcc: victim@example.com", "[FIRING:1] HighLatency Bcc: victim@example.com"},
+		{"carriage return", "HighLatency\rBcc: victim@example.com", "[FIRING:1] HighLatency Bcc: victim@example.com"},
+		{"crlf", "HighLatency\r\nBcc: victim@example.com", "[FIRING:1] HighLatency  Bcc: victim@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, messages := startSMTP(t)
+			ch, err := newChannel(config.NotificationChannelConfig{
+				Name: "mail",
+				Type: ChannelEmail,
+				SMTP: config.SMTPConfig{
+					Host: host,
+					Port: port,
+					From: "Alerts <alerts@example.com>",
+					To:   []string{"oncall@example.com", "team@example.com"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("newChannel: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := ch.send(ctx, testNotification(tt.alertname)); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			got := <-messages
+			if got.from != "FROM:<alerts@example.com>" {
+				t.Errorf("envelope from = %q", got.from)
+			}
+			if len(got.to) != 2 {
+				t.Errorf("envelope recipients = %v, want 2", got.to)
+			}
+
+			msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(got.data))))
+			if err != nil {
+				t.Fatalf("parse message: %v", err)
+			}
+			if bcc := msg.Header.Get("Bcc"); bcc != "" {
+				t.Errorf("injected Bcc header %q", bcc)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Fatalf("decode subject: %v", err)
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", subject, tt.wantSubject)
+			}
+			body, _ := io.ReadAll(msg.Body)
+			if !strings.Contains(string(body), "p99 above 1s") {
+				t.Errorf("body = %q, want the summary", body)
+			}
+		})
+	}
+}
+
+func TestEmailChannelRejectsInvalidAddresses(t *testing.T) {
+	for _, address := range []string{"not an address", "a@example.com\r\nBcc: b@example.com"} {
+		_, err := newChannel(config.NotificationChannelConfig{
+			Name: "mail",
+			Type: ChannelEmail,
+			SMTP: config.SMTPConfig{Host: "localhost", Port: 25, From: "alerts@example.com", To: []string{address}},
+		})
+		if err == nil {
+			t.Errorf("newChannel accepted recipient %q", address)
+		}
+	}
+}
@@ -0,0 +1,741 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"open-telemorph-prime/internal/anomaly"
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// Rule types
+const (
+	RuleMetric    = "metric"
+	RuleLogCount  = "log_count"
+	RuleSpanCount = "span_count"
+	RuleAnomaly   = "anomaly"
+)
+
+const (
+	defaultWindow = 5 * time.Minute
+
+	// resolvedRetention is how long a resolved alert stays listed before it
+	// is forgotten
+	resolvedRetention = 15 * time.Minute
+)
+
+var (
+	ErrInvalidRule  = errors.New("invalid rule")
+	ErrRuleNotFound = errors.New("rule not found")
+	ErrRuleExists   = errors.New("rule already exists")
+	ErrRuleReadOnly = errors.New("rule is defined in the config file")
+)
+
+// RuleStatus is a rule with its source, evaluation health and current alerts
+type RuleStatus struct {
+	Rule           *config.AlertRuleConfig `json:"rule"`
+	Source         string                  `json:"source"` // config or api
+	Health         string                  `json:"health"` // unknown, ok or error
+	LastError      string                  `json:"last_error,omitempty"`
+	LastEvaluation *time.Time              `json:"last_evaluation,omitempty"`
+	Alerts         []*storage.AlertState   `json:"alerts"`
+}
+
+type rule struct {
+	config.AlertRuleConfig
+	source      string
+	query       storage.MetricQuery
+	annotations map[string]*template.Template
+
+	health         string
+	lastError      string
+	lastEvaluation *time.Time
+}
+
+// Engine evaluates alerting rules against storage on a fixed interval and
+// moves each alert through inactive -> pending -> firing -> resolved. States
+// and transitions are persisted, so pending durations survive a restart.
+type Engine struct {
+	storage  storage.Storage
+	config   config.AlertingConfig
+	detector *anomaly.Detector
+	logger   *zap.Logger
+
+	notifier *notifier
+
+	mu           sync.Mutex
+	rules        map[string]*rule
+	states       map[string]*storage.AlertState
+	silences     map[string]*silence
+	windows      map[string]*maintenanceWindow
+	inhibitRules map[string]*inhibitRule
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewEngine loads the rules from the config file and storage, and restores
+// the alert states of those rules. Anomaly rules are scored by detector.
+func NewEngine(store storage.Storage, cfg config.AlertingConfig, detector *anomaly.Detector) (*Engine, error) {
+	e := &Engine{
+		storage:      store,
+		config:       cfg,
+		detector:     detector,
+		logger:       logger.Get(),
+		rules:        make(map[string]*rule),
+		states:       make(map[string]*storage.AlertState),
+		silences:     make(map[string]*silence),
+		windows:      make(map[string]*maintenanceWindow),
+		inhibitRules: make(map[string]*inhibitRule),
+		done:         make(chan struct{}),
+	}
+
+	n, err := newNotifier(cfg.Notifications, e.logger)
+	if err != nil {
+		return nil, err
+	}
+	e.notifier = n
+
+	for i := range cfg.Rules {
+		r, err := e.compileRule(cfg.Rules[i], "config")
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert rule %q: %w", cfg.Rules[i].Name, err)
+		}
+		if _, ok := e.rules[r.Name]; ok {
+			return nil, fmt.Errorf("duplicate alert rule %q", r.Name)
+		}
+		e.rules[r.Name] = r
+	}
+
+	stored, err := store.GetAlertRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load alert rules: %w", err)
+	}
+	for _, def := range stored {
+		if _, ok := e.rules[def.Name]; ok {
+			e.logger.Warn("Stored alert rule shadowed by config rule", zap.String("rule", def.Name))
+			continue
+		}
+		r, err := e.compileRule(*def, "api")
+		if err != nil {
+			e.logger.Error("Skipping invalid stored alert rule", zap.Error(err), zap.String("rule", def.Name))
+			continue
+		}
+		e.rules[r.Name] = r
+	}
+
+	states, err := store.GetAlertStates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load alert states: %w", err)
+	}
+	for _, st := range states {
+		if _, ok := e.rules[st.RuleName]; !ok {
+			store.DeleteAlertState(st.RuleName, st.Labels)
+			continue
+		}
+		e.states[stateKey(st.RuleName, st.Labels)] = st
+	}
+
+	if err := e.loadSilences(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Start runs the evaluation loop when alerting is enabled
+func (e *Engine) Start() {
+	if !e.config.Enabled {
+		e.logger.Info("Alert rule evaluation disabled")
+		return
+	}
+
+	e.notifier.start()
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(e.config.EvaluationInterval)
+		defer ticker.Stop()
+
+		e.evaluate(time.Now())
+		for {
+			select {
+			case now := <-ticker.C:
+				e.evaluate(now)
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+func (e *Engine) Stop() {
+	close(e.done)
+	e.wg.Wait()
+	e.notifier.stop()
+}
+
+// compileRule validates a rule definition, applying defaults, and prepares
+// its query and annotation templates
+func (e *Engine) compileRule(def config.AlertRuleConfig, source string) (*rule, error) {
+	r := &rule{AlertRuleConfig: def, source: source, health: "unknown"}
+
+	if r.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if r.Window == 0 {
+		r.Window = defaultWindow
+	}
+	if r.Window < 0 || r.For < 0 {
+		return nil, errors.New("window and for must not be negative")
+	}
+	if r.Type == RuleAnomaly {
+		// The condition is the score exceeding the threshold in Direction
+		if r.Operator == "" {
+			r.Operator = ">="
+		}
+		if r.Threshold == 0 {
+			r.Threshold = e.detector.Threshold()
+		}
+		if r.Direction == "" {
+			r.Direction = "both"
+		}
+		switch r.Direction {
+		case "up", "down", "both":
+		default:
+			return nil, fmt.Errorf("unsupported direction %q", r.Direction)
+		}
+	}
+	if _, err := compare(0, r.Operator, r.Threshold); err != nil {
+		return nil, err
+	}
+
+	switch r.Type {
+	case RuleMetric:
+		q, err := storage.ParseSeriesSelector(r.Query)
+		if err != nil {
+			return nil, err
+		}
+		if q.MetricName == "" {
+			return nil, errors.New("query must name a metric")
+		}
+		if r.Aggregation == "" {
+			r.Aggregation = "avg"
+		}
+		switch r.Aggregation {
+		case "avg", "min", "max", "sum", "count", "last":
+		default:
+			return nil, fmt.Errorf("unsupported aggregation %q", r.Aggregation)
+		}
+		r.query = q
+	case RuleAnomaly:
+		q, err := storage.ParseSeriesSelector(r.Query)
+		if err != nil {
+			return nil, err
+		}
+		if q.MetricName == "" {
+			return nil, errors.New("query must name a metric")
+		}
+		r.query = q
+	case RuleLogCount, RuleSpanCount:
+	default:
+		return nil, fmt.Errorf("unsupported rule type %q", r.Type)
+	}
+
+	r.annotations = make(map[string]*template.Template)
+	for name, text := range r.Annotations {
+		// Prometheus-style $labels and $value are available alongside .Labels
+		// and .Value
+		tmpl, err := template.New(name).Option("missingkey=zero").
+			Parse(`{{$labels := .Labels}}{{$value := .Value}}` + text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", name, err)
+		}
+		r.annotations[name] = tmpl
+	}
+
+	return r, nil
+}
+
+// compare applies a rule's condition to a value
+func compare(value float64, operator string, threshold float64) (bool, error) {
+	switch operator {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
+	}
+	return false, fmt.Errorf("unsupported operator %q", operator)
+}
+
+func stateKey(ruleName, labels string) string {
+	return ruleName + "\x00" + labels
+}
+
+// evaluate runs every rule once. Queries run without the lock held.
+func (e *Engine) evaluate(now time.Time) {
+	e.mu.Lock()
+	rules := make([]*rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		rules = append(rules, r)
+	}
+	e.mu.Unlock()
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	for _, r := range rules {
+		values, err := e.query(r, now)
+
+		e.mu.Lock()
+		if e.rules[r.Name] != r {
+			// Replaced or deleted while the query ran
+			e.mu.Unlock()
+			continue
+		}
+		evaluated := now
+		r.lastEvaluation = &evaluated
+		if err != nil {
+			r.health, r.lastError = "error", err.Error()
+			e.logger.Error("Failed to evaluate alert rule", zap.Error(err), zap.String("rule", r.Name))
+		} else {
+			r.health, r.lastError = "ok", ""
+			e.transition(r, values, now)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// query returns the rule's current value for each label set it covers
+func (e *Engine) query(r *rule, now time.Time) (map[string]float64, error) {
+	start := now.Add(-r.Window)
+
+	if r.Type == RuleAnomaly {
+		scores, err := e.detector.Scores(r.query, r.Window, r.Threshold, now)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]float64)
+		for _, score := range scores {
+			if score.Seasonality == anomaly.SeasonalityNone {
+				continue
+			}
+			labels := make(map[string]string)
+			json.Unmarshal([]byte(score.Labels), &labels)
+			if score.ServiceName != "" {
+				labels["service_name"] = score.ServiceName
+			}
+			value := score.Score
+			switch r.Direction {
+			case "down":
+				value = -value
+			case "both":
+				value = math.Abs(value)
+			}
+			values[r.labels(labels)] = value
+		}
+		return values, nil
+	}
+
+	if r.Type != RuleMetric {
+		q := storage.CountQuery{
+			Signal:      "logs",
+			ServiceName: r.Service,
+			Level:       r.Level,
+			Contains:    r.Contains,
+			Start:       start,
+			End:         now,
+		}
+		if r.Type == RuleSpanCount {
+			q = storage.CountQuery{
+				Signal:      "traces",
+				ServiceName: r.Service,
+				Operation:   r.Operation,
+				ErrorsOnly:  r.ErrorsOnly,
+				Start:       start,
+				End:         now,
+			}
+		}
+		n, err := e.storage.Count(q)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := map[string]string{}
+		if r.Service != "" {
+			labels["service_name"] = r.Service
+		}
+		return map[string]float64{r.labels(labels): float64(n)}, nil
+	}
+
+	q := r.query
+	q.Start, q.End = start, now
+	// One-second buckets keep the query on raw datapoints, which are
+	// complete for recent windows unlike the rollups being accumulated
+	q.Step = time.Second
+	result, err := e.storage.QueryMetricRange(q)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	for _, series := range result.Series {
+		// A series without datapoints in the window has no value, like one
+		// that is missing, so its alerts resolve rather than compare NaN
+		value, ok := aggregate(series.Samples, r.Aggregation)
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string)
+		json.Unmarshal([]byte(series.Labels), &labels)
+		if series.ServiceName != "" {
+			labels["service_name"] = series.ServiceName
+		}
+		values[r.labels(labels)] = value
+	}
+	return values, nil
+}
+
+// labels adds the rule's own labels and alertname to a label set and returns
+// it as canonical JSON
+func (r *rule) labels(labels map[string]string) string {
+	for name, value := range r.Labels {
+		labels[name] = value
+	}
+	labels["alertname"] = r.Name
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
+// aggregate reduces the samples of a series over the rule's window. It
+// reports false when the window holds no datapoints; only sum and count are
+// defined then.
+func aggregate(samples []*storage.MetricSample, aggregation string) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	var sum float64
+	var count int64
+	min, max := samples[0].Min, samples[0].Max
+	for _, s := range samples {
+		sum += s.Sum
+		count += s.Count
+		if s.Min < min {
+			min = s.Min
+		}
+		if s.Max > max {
+			max = s.Max
+		}
+	}
+
+	switch aggregation {
+	case "sum":
+		return sum, true
+	case "count":
+		return float64(count), true
+	}
+	if count == 0 {
+		return 0, false
+	}
+	switch aggregation {
+	case "min":
+		return min, true
+	case "max":
+		return max, true
+	case "last":
+		return samples[len(samples)-1].Last, true
+	}
+	return sum / float64(count), true
+}
+
+// transition advances the states of one rule's alerts given the values of
+// its latest evaluation. The caller must hold the lock.
+func (e *Engine) transition(r *rule, values map[string]float64, now time.Time) {
+	active := make(map[string]bool)
+	for labels, value := range values {
+		if ok, _ := compare(value, r.Operator, r.Threshold); !ok {
+			continue
+		}
+		key := stateKey(r.Name, labels)
+		active[key] = true
+
+		st, ok := e.states[key]
+		if !ok || st.State == storage.AlertResolved {
+			st = &storage.AlertState{
+				RuleName: r.Name,
+				Labels:   labels,
+				State:    storage.AlertPending,
+				ActiveAt: now,
+			}
+			e.states[key] = st
+			if r.For > 0 {
+				e.record(st, storage.AlertPending, value, now)
+			}
+		}
+		st.Value = value
+		st.Annotations = r.render(labels, value)
+		st.UpdatedAt = now
+		if st.State == storage.AlertPending && now.Sub(st.ActiveAt) >= r.For {
+			fired := now
+			st.State = storage.AlertFiring
+			st.FiredAt = &fired
+			e.record(st, storage.AlertFiring, value, now)
+		}
+		e.save(st)
+		if st.State == storage.AlertFiring {
+			e.notify(st)
+		}
+	}
+
+	for key, st := range e.states {
+		if st.RuleName != r.Name || active[key] {
+			continue
+		}
+		switch st.State {
+		case storage.AlertPending:
+			e.record(st, storage.AlertInactive, st.Value, now)
+			e.forget(key, st)
+		case storage.AlertFiring:
+			resolved := now
+			st.State = storage.AlertResolved
+			st.ResolvedAt = &resolved
+			st.UpdatedAt = now
+			e.record(st, storage.AlertResolved, st.Value, now)
+			e.save(st)
+			e.notify(st)
+		case storage.AlertResolved:
+			if now.Sub(*st.ResolvedAt) >= resolvedRetention {
+				e.forget(key, st)
+			}
+		}
+	}
+}
+
+// render expands a rule's annotation templates for one alert
+func (r *rule) render(labels string, value float64) string {
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{Value: value}
+	json.Unmarshal([]byte(labels), &data.Labels)
+
+	rendered := make(map[string]string, len(r.annotations))
+	for name, tmpl := range r.annotations {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			rendered[name] = r.Annotations[name]
+			continue
+		}
+		rendered[name] = b.String()
+	}
+	out, _ := json.Marshal(rendered)
+	return string(out)
+}
+
+func (e *Engine) record(st *storage.AlertState, state string, value float64, now time.Time) {
+	event := &storage.AlertEvent{
+		RuleName:  st.RuleName,
+		Labels:    st.Labels,
+		State:     state,
+		Value:     value,
+		Timestamp: now,
+	}
+	if err := e.storage.InsertAlertEvent(event); err != nil {
+		e.logger.Error("Failed to record alert transition", zap.Error(err), zap.String("rule", st.RuleName))
+	}
+}
+
+func (e *Engine) save(st *storage.AlertState) {
+	if err := e.storage.SaveAlertState(st); err != nil {
+		e.logger.Error("Failed to save alert state", zap.Error(err), zap.String("rule", st.RuleName))
+	}
+}
+
+// notify hands an alert to the notifier, marking it muted when a silence,
+// maintenance window or inhibit rule applies. The caller must hold the lock.
+func (e *Engine) notify(st *storage.AlertState) {
+	a := newAlert(st, e.config.Notifications.ExternalURL)
+	a.mutedBy = e.mutedBy(st.Labels, st.UpdatedAt)
+	e.notifier.add(a)
+}
+
+func (e *Engine) forget(key string, st *storage.AlertState) {
+	delete(e.states, key)
+	if err := e.storage.DeleteAlertState(st.RuleName, st.Labels); err != nil {
+		e.logger.Error("Failed to delete alert state", zap.Error(err), zap.String("rule", st.RuleName))
+	}
+}
+
+// Rules returns every rule with its status, ordered by name
+func (e *Engine) Rules() []*RuleStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names := make([]string, 0, len(e.rules))
+	for name := range e.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]*RuleStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, e.status(e.rules[name]))
+	}
+	return statuses
+}
+
+// Rule returns the status of one rule
+func (e *Engine) Rule(name string) (*RuleStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.rules[name]
+	if !ok {
+		return nil, ErrRuleNotFound
+	}
+	return e.status(r), nil
+}
+
+// status must be called with the lock held
+func (e *Engine) status(r *rule) *RuleStatus {
+	def := r.AlertRuleConfig
+	status := &RuleStatus{
+		Rule:           &def,
+		Source:         r.source,
+		Health:         r.health,
+		LastError:      r.lastError,
+		LastEvaluation: r.lastEvaluation,
+		Alerts:         []*storage.AlertState{},
+	}
+	for _, st := range e.states {
+		if st.RuleName == r.Name {
+			copied := *st
+			status.Alerts = append(status.Alerts, &copied)
+		}
+	}
+	sortStates(status.Alerts)
+	return status
+}
+
+// Alerts returns the pending, firing and recently resolved alerts, optionally
+// only those in state
+func (e *Engine) Alerts(state string) []*storage.AlertState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := []*storage.AlertState{}
+	for _, st := range e.states {
+		if state == "" || st.State == state {
+			copied := *st
+			alerts = append(alerts, &copied)
+		}
+	}
+	sortStates(alerts)
+	return alerts
+}
+
+func sortStates(states []*storage.AlertState) {
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].RuleName != states[j].RuleName {
+			return states[i].RuleName < states[j].RuleName
+		}
+		return states[i].Labels < states[j].Labels
+	})
+}
+
+// CreateRule validates and stores a new rule
+func (e *Engine) CreateRule(def config.AlertRuleConfig) (*RuleStatus, error) {
+	return e.putRule(def, false)
+}
+
+// UpdateRule replaces an API-defined rule. Its alerts keep their states.
+func (e *Engine) UpdateRule(def config.AlertRuleConfig) (*RuleStatus, error) {
+	return e.putRule(def, true)
+}
+
+func (e *Engine) putRule(def config.AlertRuleConfig, replace bool) (*RuleStatus, error) {
+	r, err := e.compileRule(def, "api")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	existing, ok := e.rules[r.Name]
+	switch {
+	case ok && existing.source == "config":
+		return nil, ErrRuleReadOnly
+	case ok && !replace:
+		return nil, ErrRuleExists
+	case !ok && replace:
+		return nil, ErrRuleNotFound
+	}
+
+	if err := e.storage.SaveAlertRule(&r.AlertRuleConfig); err != nil {
+		return nil, err
+	}
+	e.rules[r.Name] = r
+	return e.status(r), nil
+}
+
+// DeleteRule removes an API-defined rule together with its alerts
+func (e *Engine) DeleteRule(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.rules[name]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	if r.source == "config" {
+		return ErrRuleReadOnly
+	}
+
+	if err := e.storage.DeleteAlertRule(name); err != nil {
+		return err
+	}
+	delete(e.rules, name)
+	for key, st := range e.states {
+		if st.RuleName == name {
+			e.forget(key, st)
+		}
+	}
+	e.notifier.forget(name)
+	return nil
+}
+
+// Channels lists the notification channels without their credentials
+func (e *Engine) Channels() []*ChannelInfo {
+	return e.notifier.channelInfo()
+}
+
+// TestChannel sends a test notification to a channel right away
+func (e *Engine) TestChannel(name string) (*Delivery, error) {
+	return e.notifier.test(name)
+}
+
+// Deliveries returns the notification delivery log, newest first
+func (e *Engine) Deliveries(channel, result string, limit int) []*Delivery {
+	return e.notifier.deliveryLog(channel, result, limit)
+}
@@ -0,0 +1,44 @@
+package alerting
+
+import (
+	"testing"
+
+	"open-telemorph-prime/internal/storage"
+)
+
+func TestAggregate(t *testing.T) {
+	samples := []*storage.MetricSample{
+		{Min: 2, Max: 4, Sum: 6, Count: 2, Last: 4},
+		{Min: 1, Max: 9, Sum: 10, Count: 2, Last: 1},
+	}
+	empty := []*storage.MetricSample{{}, {}}
+
+	tests := []struct {
+		name        string
+		samples     []*storage.MetricSample
+		aggregation string
+		want        float64
+		wantOK      bool
+	}{
+		{"avg", samples, "avg", 4, true},
+		{"min", samples, "min", 1, true},
+		{"max", samples, "max", 9, true},
+		{"sum", samples, "sum", 16, true},
+		{"count", samples, "count", 4, true},
+		{"last", samples, "last", 1, true},
+		{"no samples", nil, "avg", 0, false},
+		{"empty window avg", empty, "avg", 0, false},
+		{"empty window min", empty, "min", 0, false},
+		{"empty window last", empty, "last", 0, false},
+		{"empty window sum", empty, "sum", 0, true},
+		{"empty window count", empty, "count", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := aggregate(tt.samples, tt.aggregation)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("aggregate(%s) = %v, %v; want %v, %v", tt.aggregation, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// maxDeliveries is how many delivery attempts the delivery log keeps
+const maxDeliveries = 1000
+
+var ErrChannelNotFound = errors.New("channel not found")
+
+// Alert is one alert as sent to notification channels, following the
+// Alertmanager webhook format
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	Value        float64           `json:"value"`
+
+	ruleName string
+	mutedBy  string // silence, maintenance window or inhibit rule
+	sent     bool   // included in a notification while firing
+}
+
+type Alerts []*Alert
+
+// Firing returns the firing alerts, for use in templates
+func (as Alerts) Firing() Alerts {
+	return as.withStatus(storage.AlertFiring)
+}
+
+// Resolved returns the resolved alerts, for use in templates
+func (as Alerts) Resolved() Alerts {
+	return as.withStatus(storage.AlertResolved)
+}
+
+func (as Alerts) withStatus(status string) Alerts {
+	result := Alerts{}
+	for _, a := range as {
+		if a.Status == status {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// Notification is a batch of alerts of one group sent to one channel. It is
+// the Alertmanager webhook payload (version 4) and the data of the message
+// templates.
+type Notification struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            Alerts            `json:"alerts"`
+}
+
+// Delivery is an entry of the delivery log
+type Delivery struct {
+	ID        int64     `json:"id"`
+	Channel   string    `json:"channel"`
+	Type      string    `json:"type"`
+	GroupKey  string    `json:"group_key"`
+	Status    string    `json:"status"` // firing or resolved
+	Alerts    int       `json:"alerts"`
+	Result    string    `json:"result"` // success or failed
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChannelInfo describes a configured channel without its credentials
+type ChannelInfo struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	Match        map[string]string `json:"match,omitempty"`
+	SendResolved bool              `json:"send_resolved"`
+}
+
+type alertGroup struct {
+	key       string
+	labels    map[string]string
+	alerts    map[string]*Alert // by fingerprint
+	changed   bool
+	created   time.Time
+	lastFlush time.Time
+}
+
+// notifier batches alerts into groups and delivers them to the configured
+// channels, retrying failed deliveries with exponential backoff
+type notifier struct {
+	config   config.NotificationConfig
+	channels []*channel
+	logger   *zap.Logger
+
+	mu         sync.Mutex
+	groups     map[string]*alertGroup
+	deliveries []*Delivery
+	lastID     int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newNotifier(cfg config.NotificationConfig, logger *zap.Logger) (*notifier, error) {
+	n := &notifier{
+		config: cfg,
+		logger: logger,
+		groups: make(map[string]*alertGroup),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	names := make(map[string]bool)
+	for _, chCfg := range cfg.Channels {
+		if names[chCfg.Name] {
+			return nil, fmt.Errorf("duplicate notification channel %q", chCfg.Name)
+		}
+		names[chCfg.Name] = true
+
+		ch, err := newChannel(chCfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification channel %q: %w", chCfg.Name, err)
+		}
+		n.channels = append(n.channels, ch)
+	}
+	return n, nil
+}
+
+func (n *notifier) start() {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				n.flush(now)
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop abandons pending retries and waits for in-flight deliveries
+func (n *notifier) stop() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// add records the current state of a firing or resolved alert. A group is
+// marked changed when an alert joins it, changes status or is muted or
+// unmuted.
+func (n *notifier) add(a *Alert) {
+	if len(n.channels) == 0 {
+		return
+	}
+
+	groupLabels := make(map[string]string)
+	for _, name := range n.config.GroupBy {
+		if value, ok := a.Labels[name]; ok {
+			groupLabels[name] = value
+		}
+	}
+	key := groupKey(groupLabels)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	g, ok := n.groups[key]
+	if !ok {
+		if a.Status == storage.AlertResolved {
+			// Resolved before it was ever sent
+			return
+		}
+		g = &alertGroup{key: key, labels: groupLabels, alerts: make(map[string]*Alert), created: time.Now()}
+		n.groups[key] = g
+	}
+
+	existing, ok := g.alerts[a.Fingerprint]
+	if ok {
+		a.sent = existing.sent
+	}
+	if !ok || existing.Status != a.Status || (existing.mutedBy == "") != (a.mutedBy == "") {
+		g.changed = true
+	}
+	g.alerts[a.Fingerprint] = a
+}
+
+// forget drops the alerts of a deleted rule without notifying
+func (n *notifier) forget(ruleName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for key, g := range n.groups {
+		for fp, a := range g.alerts {
+			if a.ruleName == ruleName {
+				delete(g.alerts, fp)
+			}
+		}
+		if len(g.alerts) == 0 {
+			delete(n.groups, key)
+		}
+	}
+}
+
+// flush sends the groups that are due: new groups after GroupWait, changed
+// groups after GroupInterval and firing groups again after RepeatInterval
+func (n *notifier) flush(now time.Time) {
+	n.mu.Lock()
+	var due []*Notification
+	for key, g := range n.groups {
+		var ready bool
+		switch {
+		case g.lastFlush.IsZero():
+			ready = now.Sub(g.created) >= n.config.GroupWait
+		case g.changed:
+			ready = now.Sub(g.lastFlush) >= n.config.GroupInterval
+		default:
+			ready = now.Sub(g.lastFlush) >= n.config.RepeatInterval && g.firing()
+		}
+		if !ready {
+			continue
+		}
+
+		if notification := g.notification(n.config.ExternalURL); len(notification.Alerts) > 0 {
+			due = append(due, notification)
+		}
+		g.lastFlush = now
+		g.changed = false
+		for fp, a := range g.alerts {
+			if a.Status == storage.AlertResolved {
+				delete(g.alerts, fp)
+			}
+		}
+		if len(g.alerts) == 0 {
+			delete(n.groups, key)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, notification := range due {
+		for _, ch := range n.channels {
+			if batch := ch.filter(notification); batch != nil {
+				n.wg.Add(1)
+				go func(ch *channel) {
+					defer n.wg.Done()
+					n.deliver(ch, batch, n.config.MaxRetries)
+				}(ch)
+			}
+		}
+	}
+}
+
+func (g *alertGroup) firing() bool {
+	for _, a := range g.alerts {
+		if a.Status == storage.AlertFiring {
+			return true
+		}
+	}
+	return false
+}
+
+// notification snapshots the alerts of a group to send, ordering them by
+// start time. Muted alerts are left out, as are resolved alerts whose firing
+// was never sent. The alerts included are marked sent.
+func (g *alertGroup) notification(externalURL string) *Notification {
+	alerts := make(Alerts, 0, len(g.alerts))
+	for _, a := range g.alerts {
+		if a.mutedBy != "" || (a.Status == storage.AlertResolved && !a.sent) {
+			continue
+		}
+		a.sent = true
+		copied := *a
+		alerts = append(alerts, &copied)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].StartsAt.Equal(alerts[j].StartsAt) {
+			return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+
+	return &Notification{
+		Version:     "4",
+		GroupKey:    g.key,
+		GroupLabels: g.labels,
+		ExternalURL: externalURL,
+		Alerts:      alerts,
+	}
+}
+
+// deliver sends a notification, retrying with exponential backoff unless the
+// failure is permanent, and records the outcome in the delivery log
+func (n *notifier) deliver(ch *channel, notification *Notification, retries int) *Delivery {
+	d := &Delivery{
+		Channel:   ch.config.Name,
+		Type:      ch.config.Type,
+		GroupKey:  notification.GroupKey,
+		Status:    notification.Status,
+		Alerts:    len(notification.Alerts),
+		Timestamp: time.Now(),
+	}
+
+	backoff := n.config.RetryBackoff
+	for {
+		d.Attempts++
+		ctx, cancel := context.WithTimeout(n.ctx, n.config.Timeout)
+		err := ch.send(ctx, notification)
+		cancel()
+		if err == nil {
+			d.Result, d.Error = "success", ""
+			break
+		}
+
+		d.Result, d.Error = "failed", err.Error()
+		var permanent *permanentError
+		if errors.As(err, &permanent) || d.Attempts > retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+		}
+		if n.ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	if d.Result == "failed" {
+		n.logger.Error("Failed to deliver alert notification",
+			zap.String("channel", d.Channel),
+			zap.String("group", d.GroupKey),
+			zap.Int("attempts", d.Attempts),
+			zap.String("error", d.Error),
+		)
+	}
+
+	n.mu.Lock()
+	n.lastID++
+	d.ID = n.lastID
+	n.deliveries = append(n.deliveries, d)
+	if len(n.deliveries) > maxDeliveries {
+		n.deliveries = n.deliveries[len(n.deliveries)-maxDeliveries:]
+	}
+	n.mu.Unlock()
+
+	return d
+}
+
+// test sends a synthetic firing alert to one channel, without retries
+func (n *notifier) test(name string) (*Delivery, error) {
+	for _, ch := range n.channels {
+		if ch.config.Name != name {
+			continue
+		}
+		now := time.Now()
+		labels := map[string]string{"alertname": "TestNotification", "severity": "info"}
+		for k, v := range ch.config.Match {
+			labels[k] = v
+		}
+		alert := &Alert{
+			Status:      storage.AlertFiring,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": "Test notification from Open-Telemorph-Prime"},
+			StartsAt:    now,
+			Fingerprint: fingerprint(labels),
+		}
+		g := &alertGroup{key: groupKey(labels), labels: labels, alerts: map[string]*Alert{alert.Fingerprint: alert}}
+		return n.deliver(ch, ch.filter(g.notification(n.config.ExternalURL)), 0), nil
+	}
+	return nil, ErrChannelNotFound
+}
+
+// deliveryLog returns the delivery log, newest first, optionally narrowed to
+// one channel and result
+func (n *notifier) deliveryLog(channel, result string, limit int) []*Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deliveries := []*Delivery{}
+	for i := len(n.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := n.deliveries[i]
+		if (channel == "" || d.Channel == channel) && (result == "" || d.Result == result) {
+			copied := *d
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries
+}
+
+func (n *notifier) channelInfo() []*ChannelInfo {
+	channels := make([]*ChannelInfo, 0, len(n.channels))
+	for _, ch := range n.channels {
+		channels = append(channels, &ChannelInfo{
+			Name:         ch.config.Name,
+			Type:         ch.config.Type,
+			Match:        ch.config.Match,
+			SendResolved: ch.config.SendResolved,
+		})
+	}
+	return channels
+}
+
+// newAlert converts an alert state for notification
+func newAlert(st *storage.AlertState, externalURL string) *Alert {
+	a := &Alert{
+		Status:       st.State,
+		GeneratorURL: strings.TrimSuffix(externalURL, "/") + "/alerts",
+		Value:        st.Value,
+		ruleName:     st.RuleName,
+	}
+	json.Unmarshal([]byte(st.Labels), &a.Labels)
+	json.Unmarshal([]byte(st.Annotations), &a.Annotations)
+	if a.Annotations == nil {
+		a.Annotations = map[string]string{}
+	}
+	if st.FiredAt != nil {
+		a.StartsAt = *st.FiredAt
+	}
+	if st.ResolvedAt != nil {
+		a.EndsAt = *st.ResolvedAt
+	}
+	a.Fingerprint = fingerprint(a.Labels)
+	return a
+}
+
+func fingerprint(labels map[string]string) string {
+	data, _ := json.Marshal(labels)
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// groupKey renders group labels as a stable selector, e.g. {alertname="X"}
+func groupKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// commonLabels returns the label pairs shared by every alert
+func commonLabels(alerts Alerts, get func(*Alert) map[string]string) map[string]string {
+	common := make(map[string]string)
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range get(alerts[0]) {
+		common[k] = v
+	}
+	for _, a := range alerts[1:] {
+		values := get(a)
+		for k, v := range common {
+			if values[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
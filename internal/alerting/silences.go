@@ -0,0 +1,521 @@
+package alerting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidSilence      = errors.New("invalid silence")
+	ErrSilenceNotFound     = errors.New("silence not found")
+	ErrInvalidWindow       = errors.New("invalid maintenance window")
+	ErrWindowNotFound      = errors.New("maintenance window not found")
+	ErrWindowExists        = errors.New("maintenance window already exists")
+	ErrInvalidInhibitRule  = errors.New("invalid inhibit rule")
+	ErrInhibitRuleNotFound = errors.New("inhibit rule not found")
+	ErrInhibitRuleExists   = errors.New("inhibit rule already exists")
+)
+
+// SilenceStatus is a silence with its state: pending, active or expired
+type SilenceStatus struct {
+	storage.Silence
+	Status string `json:"status"`
+}
+
+// MaintenanceWindowStatus is a maintenance window and whether it is open now
+type MaintenanceWindowStatus struct {
+	storage.MaintenanceWindow
+	Active bool `json:"active"`
+}
+
+type silence struct {
+	storage.Silence
+	matchers []storage.LabelMatcher
+}
+
+type maintenanceWindow struct {
+	storage.MaintenanceWindow
+	matchers   []storage.LabelMatcher
+	weekdays   map[time.Weekday]bool
+	start, end int // minutes after midnight
+	location   *time.Location
+}
+
+type inhibitRule struct {
+	storage.InhibitRule
+	source, target []storage.LabelMatcher
+}
+
+// weekdays maps the lowercase full and three-letter names of each day
+var weekdays = func() map[string]time.Weekday {
+	names := make(map[string]time.Weekday, 14)
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		names[name] = wd
+		names[name[:3]] = wd
+	}
+	return names
+}()
+
+func parseMatchers(values []string) ([]storage.LabelMatcher, error) {
+	matchers := make([]storage.LabelMatcher, 0, len(values))
+	for _, value := range values {
+		m, err := storage.ParseLabelMatcher(value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func matchesAll(matchers []storage.LabelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func compileSilence(def storage.Silence) (*silence, error) {
+	if len(def.Matchers) == 0 {
+		return nil, errors.New("at least one matcher is required")
+	}
+	if def.EndsAt.IsZero() || !def.EndsAt.After(def.StartsAt) {
+		return nil, errors.New("ends_at must be after starts_at")
+	}
+	matchers, err := parseMatchers(def.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	// As in Alertmanager, a silence must select something: matchers that
+	// all match an empty label set, such as foo=~".*", would mute every alert
+	if matchesAll(matchers, map[string]string{}) {
+		return nil, errors.New("at least one matcher must not match the empty string")
+	}
+	return &silence{Silence: def, matchers: matchers}, nil
+}
+
+func (s *silence) status(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	}
+	return "expired"
+}
+
+func compileWindow(def storage.MaintenanceWindow) (*maintenanceWindow, error) {
+	if def.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	w := &maintenanceWindow{MaintenanceWindow: def, weekdays: make(map[time.Weekday]bool)}
+
+	var err error
+	if w.start, err = parseClock(def.Start); err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	if w.end, err = parseClock(def.End); err != nil {
+		return nil, fmt.Errorf("invalid end: %w", err)
+	}
+	if w.start == w.end {
+		return nil, errors.New("start and end must differ")
+	}
+	for _, day := range def.Weekdays {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", day)
+		}
+		w.weekdays[wd] = true
+	}
+	if w.location, err = time.LoadLocation(def.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid timezone: %w", err)
+	}
+	if w.matchers, err = parseMatchers(def.Matchers); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parseClock parses "15:04" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// open reports whether the window is open at now. A window running past
+// midnight belongs to the weekday it started on.
+func (w *maintenanceWindow) open(now time.Time) bool {
+	local := now.In(w.location)
+	minute := local.Hour()*60 + local.Minute()
+	day := func(wd time.Weekday) bool { return len(w.weekdays) == 0 || w.weekdays[wd] }
+
+	if w.start < w.end {
+		return day(local.Weekday()) && minute >= w.start && minute < w.end
+	}
+	return (day(local.Weekday()) && minute >= w.start) ||
+		(day((local.Weekday()+6)%7) && minute < w.end)
+}
+
+func (w *maintenanceWindow) covers(labels map[string]string) bool {
+	if len(w.Services) > 0 {
+		found := false
+		for _, service := range w.Services {
+			if labels["service_name"] == service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchesAll(w.matchers, labels)
+}
+
+func compileInhibitRule(def storage.InhibitRule) (*inhibitRule, error) {
+	if def.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(def.SourceMatchers) == 0 || len(def.TargetMatchers) == 0 {
+		return nil, errors.New("source_matchers and target_matchers are required")
+	}
+	source, err := parseMatchers(def.SourceMatchers)
+	if err != nil {
+		return nil, err
+	}
+	target, err := parseMatchers(def.TargetMatchers)
+	if err != nil {
+		return nil, err
+	}
+	return &inhibitRule{InhibitRule: def, source: source, target: target}, nil
+}
+
+// loadSilences restores silences, maintenance windows and inhibit rules from
+// storage, skipping invalid ones
+func (e *Engine) loadSilences() error {
+	silences, err := e.storage.GetSilences()
+	if err != nil {
+		return fmt.Errorf("failed to load silences: %w", err)
+	}
+	for _, def := range silences {
+		s, err := compileSilence(*def)
+		if err != nil {
+			e.logger.Error("Skipping invalid stored silence", zap.Error(err), zap.String("silence", def.ID))
+			continue
+		}
+		e.silences[s.ID] = s
+	}
+
+	windows, err := e.storage.GetMaintenanceWindows()
+	if err != nil {
+		return fmt.Errorf("failed to load maintenance windows: %w", err)
+	}
+	for _, def := range windows {
+		w, err := compileWindow(*def)
+		if err != nil {
+			e.logger.Error("Skipping invalid stored maintenance window", zap.Error(err), zap.String("window", def.Name))
+			continue
+		}
+		e.windows[w.Name] = w
+	}
+
+	rules, err := e.storage.GetInhibitRules()
+	if err != nil {
+		return fmt.Errorf("failed to load inhibit rules: %w", err)
+	}
+	for _, def := range rules {
+		r, err := compileInhibitRule(*def)
+		if err != nil {
+			e.logger.Error("Skipping invalid stored inhibit rule", zap.Error(err), zap.String("rule", def.Name))
+			continue
+		}
+		e.inhibitRules[r.Name] = r
+	}
+	return nil
+}
+
+// mutedBy returns why notifications for an alert are suppressed, or "" when
+// they are not. The caller must hold the lock.
+func (e *Engine) mutedBy(labelsJSON string, now time.Time) string {
+	labels := make(map[string]string)
+	json.Unmarshal([]byte(labelsJSON), &labels)
+
+	for _, s := range e.silences {
+		if s.status(now) == "active" && matchesAll(s.matchers, labels) {
+			return "silence " + s.ID
+		}
+	}
+	for _, w := range e.windows {
+		if w.open(now) && w.covers(labels) {
+			return "maintenance window " + w.Name
+		}
+	}
+	for _, r := range e.inhibitRules {
+		if !matchesAll(r.target, labels) {
+			continue
+		}
+		for _, st := range e.states {
+			if st.State != storage.AlertFiring || st.Labels == labelsJSON {
+				continue
+			}
+			source := make(map[string]string)
+			json.Unmarshal([]byte(st.Labels), &source)
+			if matchesAll(r.source, source) && equalLabels(r.Equal, source, labels) {
+				return "inhibit rule " + r.Name
+			}
+		}
+	}
+	return ""
+}
+
+func equalLabels(names []string, a, b map[string]string) bool {
+	for _, name := range names {
+		if a[name] != b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Silences returns every silence with its status, newest first
+func (e *Engine) Silences() []*SilenceStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	silences := make([]*SilenceStatus, 0, len(e.silences))
+	for _, s := range e.silences {
+		silences = append(silences, &SilenceStatus{Silence: s.Silence, Status: s.status(now)})
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].CreatedAt.After(silences[j].CreatedAt) })
+	return silences
+}
+
+func (e *Engine) Silence(id string) (*SilenceStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.silences[id]
+	if !ok {
+		return nil, ErrSilenceNotFound
+	}
+	return &SilenceStatus{Silence: s.Silence, Status: s.status(time.Now())}, nil
+}
+
+// CreateSilence stores a new silence. It starts now unless StartsAt is set.
+func (e *Engine) CreateSilence(def storage.Silence) (*SilenceStatus, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	def.ID = hex.EncodeToString(id)
+	def.CreatedAt = time.Now()
+	if def.StartsAt.IsZero() {
+		def.StartsAt = def.CreatedAt
+	}
+	return e.putSilence(def)
+}
+
+// UpdateSilence replaces the matchers, times and comment of a silence
+func (e *Engine) UpdateSilence(def storage.Silence) (*SilenceStatus, error) {
+	e.mu.Lock()
+	existing, ok := e.silences[def.ID]
+	e.mu.Unlock()
+	if !ok {
+		return nil, ErrSilenceNotFound
+	}
+
+	def.CreatedAt = existing.CreatedAt
+	if def.StartsAt.IsZero() {
+		def.StartsAt = existing.StartsAt
+	}
+	return e.putSilence(def)
+}
+
+func (e *Engine) putSilence(def storage.Silence) (*SilenceStatus, error) {
+	s, err := compileSilence(def)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSilence, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.storage.SaveSilence(&s.Silence); err != nil {
+		return nil, err
+	}
+	e.silences[s.ID] = s
+	return &SilenceStatus{Silence: s.Silence, Status: s.status(time.Now())}, nil
+}
+
+func (e *Engine) DeleteSilence(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.silences[id]; !ok {
+		return ErrSilenceNotFound
+	}
+	if err := e.storage.DeleteSilence(id); err != nil {
+		return err
+	}
+	delete(e.silences, id)
+	return nil
+}
+
+// MaintenanceWindows returns every maintenance window, ordered by name
+func (e *Engine) MaintenanceWindows() []*MaintenanceWindowStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	windows := make([]*MaintenanceWindowStatus, 0, len(e.windows))
+	for _, w := range e.windows {
+		windows = append(windows, &MaintenanceWindowStatus{MaintenanceWindow: w.MaintenanceWindow, Active: w.open(now)})
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Name < windows[j].Name })
+	return windows
+}
+
+func (e *Engine) MaintenanceWindow(name string) (*MaintenanceWindowStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.windows[name]
+	if !ok {
+		return nil, ErrWindowNotFound
+	}
+	return &MaintenanceWindowStatus{MaintenanceWindow: w.MaintenanceWindow, Active: w.open(time.Now())}, nil
+}
+
+func (e *Engine) CreateMaintenanceWindow(def storage.MaintenanceWindow) (*MaintenanceWindowStatus, error) {
+	return e.putWindow(def, false)
+}
+
+func (e *Engine) UpdateMaintenanceWindow(def storage.MaintenanceWindow) (*MaintenanceWindowStatus, error) {
+	return e.putWindow(def, true)
+}
+
+func (e *Engine) putWindow(def storage.MaintenanceWindow, replace bool) (*MaintenanceWindowStatus, error) {
+	w, err := compileWindow(def)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWindow, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.windows[w.Name]
+	switch {
+	case ok && !replace:
+		return nil, ErrWindowExists
+	case !ok && replace:
+		return nil, ErrWindowNotFound
+	}
+
+	if err := e.storage.SaveMaintenanceWindow(&w.MaintenanceWindow); err != nil {
+		return nil, err
+	}
+	e.windows[w.Name] = w
+	return &MaintenanceWindowStatus{MaintenanceWindow: w.MaintenanceWindow, Active: w.open(time.Now())}, nil
+}
+
+func (e *Engine) DeleteMaintenanceWindow(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.windows[name]; !ok {
+		return ErrWindowNotFound
+	}
+	if err := e.storage.DeleteMaintenanceWindow(name); err != nil {
+		return err
+	}
+	delete(e.windows, name)
+	return nil
+}
+
+// InhibitRules returns every inhibit rule, ordered by name
+func (e *Engine) InhibitRules() []*storage.InhibitRule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := make([]*storage.InhibitRule, 0, len(e.inhibitRules))
+	for _, r := range e.inhibitRules {
+		def := r.InhibitRule
+		rules = append(rules, &def)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+func (e *Engine) InhibitRule(name string) (*storage.InhibitRule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.inhibitRules[name]
+	if !ok {
+		return nil, ErrInhibitRuleNotFound
+	}
+	def := r.InhibitRule
+	return &def, nil
+}
+
+func (e *Engine) CreateInhibitRule(def storage.InhibitRule) (*storage.InhibitRule, error) {
+	return e.putInhibitRule(def, false)
+}
+
+func (e *Engine) UpdateInhibitRule(def storage.InhibitRule) (*storage.InhibitRule, error) {
+	return e.putInhibitRule(def, true)
+}
+
+func (e *Engine) putInhibitRule(def storage.InhibitRule, replace bool) (*storage.InhibitRule, error) {
+	r, err := compileInhibitRule(def)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInhibitRule, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.inhibitRules[r.Name]
+	switch {
+	case ok && !replace:
+		return nil, ErrInhibitRuleExists
+	case !ok && replace:
+		return nil, ErrInhibitRuleNotFound
+	}
+
+	if err := e.storage.SaveInhibitRule(&r.InhibitRule); err != nil {
+		return nil, err
+	}
+	e.inhibitRules[r.Name] = r
+	result := r.InhibitRule
+	return &result, nil
+}
+
+func (e *Engine) DeleteInhibitRule(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.inhibitRules[name]; !ok {
+		return ErrInhibitRuleNotFound
+	}
+	if err := e.storage.DeleteInhibitRule(name); err != nil {
+		return err
+	}
+	delete(e.inhibitRules, name)
+	return nil
+}
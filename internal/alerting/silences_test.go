@@ -0,0 +1,71 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/storage"
+)
+
+func TestCompileSilence(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		matchers []string
+		wantErr  bool
+	}{
+		{"equality", []string{`alertname="HighLatency"`}, false},
+		{"regex", []string{`service_name=~"pay.*"`}, false},
+		{"no matchers", nil, true},
+		{"matches everything", []string{`foo=~".*"`}, true},
+		{"negative only", []string{`alertname!="HighLatency"`}, true},
+		{"empty value", []string{`alertname=""`}, true},
+		{"one selective matcher", []string{`foo=~".*"`, `alertname="HighLatency"`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSilence(storage.Silence{
+				Matchers: tt.matchers,
+				StartsAt: now,
+				EndsAt:   now.Add(time.Hour),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileSilence(%v) error = %v, want error %v", tt.matchers, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileWindowWeekdays(t *testing.T) {
+	tests := []struct {
+		day     string
+		want    time.Weekday
+		wantErr bool
+	}{
+		{"mon", time.Monday, false},
+		{"Saturday", time.Saturday, false},
+		{"SUN", time.Sunday, false},
+		{"", 0, true},
+		{"x", 0, true},
+		// Lowercases to a single byte "k"
+		{"K", 0, true},
+		{"Kon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.day, func(t *testing.T) {
+			w, err := compileWindow(storage.MaintenanceWindow{
+				Name:     "nightly",
+				Start:    "22:00",
+				End:      "02:00",
+				Weekdays: []string{tt.day},
+				Timezone: "UTC",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileWindow(%q) error = %v, want error %v", tt.day, err, tt.wantErr)
+			}
+			if err == nil && !w.weekdays[tt.want] {
+				t.Errorf("compileWindow(%q) weekdays = %v, want %v", tt.day, w.weekdays, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// Seasonality of the baseline bucket a score was computed against
+const (
+	SeasonalityWeekly  = "weekly"
+	SeasonalityDaily   = "daily"
+	SeasonalityOverall = "overall"
+	SeasonalityNone    = "none" // not enough history to score
+)
+
+// Score compares a series' value with its expected value at that time
+type Score struct {
+	MetricName  string    `json:"metric_name"`
+	ServiceName string    `json:"service_name"`
+	Labels      string    `json:"labels"`
+	Timestamp   time.Time `json:"timestamp"`
+	Value       float64   `json:"value"`
+	Expected    float64   `json:"expected"`
+	Stddev      float64   `json:"stddev"`
+	Lower       float64   `json:"lower"` // Expected -/+ threshold standard deviations
+	Upper       float64   `json:"upper"`
+	Score       float64   `json:"score"` // z-score, signed
+	Seasonality string    `json:"seasonality"`
+	Samples     int       `json:"samples"`
+	Anomalous   bool      `json:"anomalous"`
+}
+
+// Point is a scored sample of a series over a time range
+type Point struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Value       float64   `json:"value"`
+	Expected    float64   `json:"expected"`
+	Lower       float64   `json:"lower"`
+	Upper       float64   `json:"upper"`
+	Score       float64   `json:"score"`
+	Seasonality string    `json:"seasonality"`
+	Anomalous   bool      `json:"anomalous"`
+}
+
+type SeriesScores struct {
+	MetricName  string   `json:"metric_name"`
+	ServiceName string   `json:"service_name"`
+	Labels      string   `json:"labels"`
+	Points      []*Point `json:"points"`
+}
+
+// bucket accumulates a mean and variance with Welford's algorithm
+type bucket struct {
+	count int
+	mean  float64
+	m2    float64
+}
+
+func (b *bucket) add(v float64) {
+	b.count++
+	delta := v - b.mean
+	b.mean += delta / float64(b.count)
+	b.m2 += delta * (v - b.mean)
+}
+
+func (b *bucket) stddev() float64 {
+	if b.count < 2 {
+		return 0
+	}
+	return math.Sqrt(b.m2 / float64(b.count-1))
+}
+
+// baseline holds a series' hourly averages by hour of the week, hour of the
+// day and overall
+type baseline struct {
+	weekly  [7 * 24]bucket
+	daily   [24]bucket
+	overall bucket
+}
+
+type cachedBaselines struct {
+	built    time.Time
+	used     time.Time
+	series   map[string]*baseline
+	err      error         // of the build, for callers that waited on it
+	building chan struct{} // closed once built
+}
+
+// Detector scores metric series against seasonal baselines built from the
+// stored hourly averages. Baselines are cached per query and rebuilt every
+// RefreshInterval.
+type Detector struct {
+	storage storage.Storage
+	config  config.AnomalyConfig
+
+	mu    sync.Mutex
+	cache map[string]*cachedBaselines
+}
+
+func NewDetector(store storage.Storage, cfg config.AnomalyConfig) *Detector {
+	return &Detector{
+		storage: store,
+		config:  cfg,
+		cache:   make(map[string]*cachedBaselines),
+	}
+}
+
+// Threshold is the configured default z-score threshold
+func (d *Detector) Threshold() float64 {
+	return d.config.Threshold
+}
+
+// Scores scores the average of each series selected by q over the window
+// ending at now. Series without datapoints in the window are left out.
+func (d *Detector) Scores(q storage.MetricQuery, window time.Duration, threshold float64, now time.Time) ([]*Score, error) {
+	baselines, err := d.baselines(q, now)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Start, q.End = now.Add(-window), now
+	// One-second buckets keep the query on raw datapoints, as for metric
+	// alert rules
+	q.Step = time.Second
+	q.Exemplars = false
+	result, err := d.storage.QueryMetricRange(q)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := []*Score{}
+	for _, series := range result.Series {
+		var sum float64
+		var count int64
+		for _, s := range series.Samples {
+			sum += s.Sum
+			count += s.Count
+		}
+		if count == 0 {
+			continue
+		}
+
+		score := &Score{
+			MetricName:  series.MetricName,
+			ServiceName: series.ServiceName,
+			Labels:      series.Labels,
+			Timestamp:   now,
+			Value:       sum / float64(count),
+		}
+		p := d.score(baselines[seriesKey(series)], score.Value, now, threshold)
+		score.Expected, score.Lower, score.Upper = p.Expected, p.Lower, p.Upper
+		score.Score, score.Seasonality, score.Anomalous = p.Score, p.Seasonality, p.Anomalous
+		score.Stddev, score.Samples = p.stddev, p.samples
+		scores = append(scores, score)
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		return math.Abs(scores[i].Score) > math.Abs(scores[j].Score)
+	})
+	return scores, nil
+}
+
+// QueryRange scores every bucket of the series selected by q, for charting
+// values against their expected band
+func (d *Detector) QueryRange(q storage.MetricQuery, threshold float64, now time.Time) ([]*SeriesScores, error) {
+	baselines, err := d.baselines(q, now)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Exemplars = false
+	result, err := d.storage.QueryMetricRange(q)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]*SeriesScores, 0, len(result.Series))
+	for _, series := range result.Series {
+		s := &SeriesScores{
+			MetricName:  series.MetricName,
+			ServiceName: series.ServiceName,
+			Labels:      series.Labels,
+			Points:      make([]*Point, 0, len(series.Samples)),
+		}
+		b := baselines[seriesKey(series)]
+		for _, sample := range series.Samples {
+			p := d.score(b, sample.Avg, sample.Timestamp, threshold)
+			s.Points = append(s.Points, &p.Point)
+		}
+		scores = append(scores, s)
+	}
+	return scores, nil
+}
+
+type scored struct {
+	Point
+	stddev  float64
+	samples int
+}
+
+// score compares a value with the most specific baseline bucket for its time
+// that holds enough samples. The standard deviation is floored at 1% of the
+// expected value so flat series don't turn every wobble into an anomaly.
+func (d *Detector) score(b *baseline, value float64, at time.Time, threshold float64) scored {
+	p := scored{Point: Point{Timestamp: at, Value: value, Seasonality: SeasonalityNone}}
+	if b == nil {
+		return p
+	}
+
+	utc := at.UTC()
+	hour := utc.Hour()
+	var bk *bucket
+	switch {
+	case b.weekly[int(utc.Weekday())*24+hour].count >= d.config.MinSamples:
+		bk, p.Seasonality = &b.weekly[int(utc.Weekday())*24+hour], SeasonalityWeekly
+	case b.daily[hour].count >= d.config.MinSamples:
+		bk, p.Seasonality = &b.daily[hour], SeasonalityDaily
+	case b.overall.count >= d.config.MinSamples:
+		bk, p.Seasonality = &b.overall, SeasonalityOverall
+	default:
+		return p
+	}
+
+	p.Expected = bk.mean
+	p.samples = bk.count
+	p.stddev = bk.stddev()
+	stddev := math.Max(p.stddev, math.Max(math.Abs(bk.mean)*0.01, 1e-9))
+	p.Score = (value - bk.mean) / stddev
+	p.Lower = bk.mean - threshold*stddev
+	p.Upper = bk.mean + threshold*stddev
+	p.Anomalous = math.Abs(p.Score) >= threshold
+	return p
+}
+
+// baselines returns the baselines of the series selected by q, rebuilding
+// them when older than the refresh interval. Concurrent callers wait for a
+// single build.
+func (d *Detector) baselines(q storage.MetricQuery, now time.Time) (map[string]*baseline, error) {
+	key := queryKey(q)
+
+	d.mu.Lock()
+	d.evict(now)
+	cached, ok := d.cache[key]
+	if ok && now.Sub(cached.built) < d.config.RefreshInterval {
+		cached.used = now
+		building := cached.building
+		d.mu.Unlock()
+		<-building
+		return cached.series, cached.err
+	}
+	cached = &cachedBaselines{built: now, used: now, building: make(chan struct{})}
+	d.cache[key] = cached
+	d.mu.Unlock()
+
+	series, err := d.build(q, now)
+	if err != nil || len(series) == 0 {
+		// Retry next time rather than caching a failure or a query whose
+		// history has not been rolled up yet
+		d.mu.Lock()
+		delete(d.cache, key)
+		d.mu.Unlock()
+	}
+	cached.series, cached.err = series, err
+	close(cached.building)
+	return series, err
+}
+
+// evict drops baselines unused for two refresh intervals. The caller must
+// hold the lock.
+func (d *Detector) evict(now time.Time) {
+	for key, cached := range d.cache {
+		if now.Sub(cached.used) > 2*d.config.RefreshInterval {
+			delete(d.cache, key)
+		}
+	}
+}
+
+// build reads the hourly averages over the lookback, excluding the current
+// hour, and buckets them by their hour of the week and day (UTC)
+func (d *Detector) build(q storage.MetricQuery, now time.Time) (map[string]*baseline, error) {
+	end := now.UTC().Truncate(time.Hour)
+	q.Start, q.End = end.Add(-d.config.Lookback), end
+	q.Step = time.Hour
+	q.Exemplars = false
+	result, err := d.storage.QueryMetricRange(q)
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[string]*baseline, len(result.Series))
+	for _, series := range result.Series {
+		b := &baseline{}
+		for _, s := range series.Samples {
+			if s.Count == 0 {
+				continue
+			}
+			utc := s.Timestamp.UTC()
+			b.weekly[int(utc.Weekday())*24+utc.Hour()].add(s.Avg)
+			b.daily[utc.Hour()].add(s.Avg)
+			b.overall.add(s.Avg)
+		}
+		baselines[seriesKey(series)] = b
+	}
+	return baselines, nil
+}
+
+func seriesKey(s *storage.MetricSeries) string {
+	return s.MetricName + "\x00" + s.ServiceName + "\x00" + s.Labels
+}
+
+func queryKey(q storage.MetricQuery) string {
+	parts := []string{q.MetricName, q.ServiceName}
+	for _, m := range q.Matchers {
+		parts = append(parts, m.Name+m.Type+m.Value)
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,66 @@
+package anomaly
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+var errQuery = errors.New("query failed")
+
+// blockingStorage fails metric queries once released
+type blockingStorage struct {
+	storage.Storage
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingStorage) QueryMetricRange(q storage.MetricQuery) (*storage.MetricQueryResult, error) {
+	s.calls.Add(1)
+	<-s.release
+	return nil, errQuery
+}
+
+func TestBaselinesReturnsBuildErrorToWaiters(t *testing.T) {
+	store := &blockingStorage{release: make(chan struct{})}
+	d := NewDetector(store, config.DefaultConfig().Anomaly)
+	q := storage.MetricQuery{MetricName: "http.server.duration"}
+	now := time.Now()
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := d.baselines(q, now)
+		errs <- err
+	}()
+	for store.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The second caller finds the build in progress and waits on it
+	go func() {
+		_, err := d.baselines(q, now)
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(store.release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, errQuery) {
+			t.Errorf("caller %d error = %v, want %v", i, err, errQuery)
+		}
+	}
+	if calls := store.calls.Load(); calls != 1 {
+		t.Errorf("queries = %d, want the waiter to share the first build", calls)
+	}
+
+	// A failed build is not cached
+	if _, err := d.baselines(q, now); !errors.Is(err, errQuery) {
+		t.Errorf("retry error = %v, want %v", err, errQuery)
+	}
+	if calls := store.calls.Load(); calls != 2 {
+		t.Errorf("queries after retry = %d, want 2", calls)
+	}
+}
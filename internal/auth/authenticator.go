@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	"go.uber.org/zap"
+)
+
+// Signals an API key can be limited to
+const (
+	SignalTraces  = "traces"
+	SignalMetrics = "metrics"
+	SignalLogs    = "logs"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("missing or invalid API key")
+	ErrPermissionDenied = errors.New("not permitted to write")
+	ErrInvalidAPIKey    = errors.New("invalid API key")
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrAPIKeyExists     = errors.New("API key already exists")
+	ErrAPIKeyReadOnly   = errors.New("API key is defined in the config file")
+	ErrAPIKeyRevoked    = errors.New("API key is revoked")
+)
+
+// keyName restricts key names to characters that are safe in URL paths
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// minSecretLength is the shortest secret accepted for keys in the config file
+const minSecretLength = 16
+
+// secretPrefix starts every generated secret, so leaked keys are easy to
+// search for
+const secretPrefix = "otp_"
+
+// key is an API key with its source and compiled scopes
+type key struct {
+	storage.APIKey
+	source  string // config or api
+	signals map[string]bool
+	dirty   bool // last used changed since the last flush
+}
+
+// KeyStatus is an API key as reported by the admin API, without its hash
+type KeyStatus struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"` // config or api
+	Prefix    string     `json:"prefix"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Services  []string   `json:"services,omitempty"`
+	Signals   []string   `json:"signals,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// Identity is the API key or client certificate mapping a request
+// authenticated with
+type Identity struct {
+	Name     string
+	Tenant   string
+	services []string
+}
+
+// AllowsService reports whether the key may write data of a service. Scopes
+// are path.Match patterns.
+func (id *Identity) AllowsService(service string) bool {
+	if len(id.services) == 0 {
+		return true
+	}
+	for _, pattern := range id.services {
+		if ok, _ := path.Match(pattern, service); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the API keys presented to the OTLP receivers and
+// manages the keys created through the admin API
+type Authenticator struct {
+	storage storage.Storage
+	config  config.IngestAuthConfig
+	logger  *zap.Logger
+
+	mu     sync.Mutex
+	keys   map[string]*key // by name
+	byHash map[string]*key
+	certs  []*certPrincipal
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewAuthenticator validates the keys of cfg and loads the stored ones from
+// store
+func NewAuthenticator(store storage.Storage, cfg config.IngestAuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		storage: store,
+		config:  cfg,
+		logger:  logger.Get(),
+		keys:    make(map[string]*key),
+		byHash:  make(map[string]*key),
+		done:    make(chan struct{}),
+	}
+
+	for _, def := range cfg.Keys {
+		if len(def.Key) < minSecretLength {
+			return nil, fmt.Errorf("%w %q: key must be at least %d characters", ErrInvalidAPIKey, def.Name, minSecretLength)
+		}
+		k, err := compileKey(def, "config")
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidAPIKey, def.Name, err)
+		}
+		if _, ok := a.keys[k.Name]; ok {
+			return nil, fmt.Errorf("duplicate API key %q", k.Name)
+		}
+		k.setSecret(def.Key)
+		if _, ok := a.byHash[k.Hash]; ok {
+			return nil, fmt.Errorf("%w %q: key is shared with another API key", ErrInvalidAPIKey, k.Name)
+		}
+		a.keys[k.Name] = k
+		a.byHash[k.Hash] = k
+	}
+
+	certNames := make(map[string]bool)
+	for _, def := range cfg.ClientCerts {
+		p, err := compileCertPrincipal(def)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidClientCert, def.Name, err)
+		}
+		if certNames[p.Name] {
+			return nil, fmt.Errorf("duplicate client certificate mapping %q", p.Name)
+		}
+		certNames[p.Name] = true
+		a.certs = append(a.certs, p)
+	}
+
+	stored, err := store.GetAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	for _, sk := range stored {
+		if _, ok := a.keys[sk.Name]; ok {
+			a.logger.Warn("Stored API key is shadowed by a key in the config file",
+				zap.String("name", sk.Name),
+			)
+			continue
+		}
+		k := &key{APIKey: *sk, source: "api", signals: signalSet(sk.Signals)}
+		a.keys[k.Name] = k
+		if k.RevokedAt == nil {
+			a.byHash[k.Hash] = k
+		}
+	}
+
+	return a, nil
+}
+
+func compileKey(def config.APIKeyConfig, source string) (*key, error) {
+	if !keyName.MatchString(def.Name) {
+		return nil, errors.New("name must be 1-64 letters, digits, '_', '.' or '-'")
+	}
+	if def.Tenant != "" && !tenancy.ValidID(def.Tenant) {
+		return nil, fmt.Errorf("invalid tenant %q", def.Tenant)
+	}
+	for _, service := range def.Services {
+		if _, err := path.Match(service, ""); err != nil {
+			return nil, fmt.Errorf("invalid service pattern %q", service)
+		}
+	}
+	for _, signal := range def.Signals {
+		switch signal {
+		case SignalTraces, SignalMetrics, SignalLogs:
+		default:
+			return nil, fmt.Errorf("unknown signal %q (want traces, metrics or logs)", signal)
+		}
+	}
+
+	return &key{
+		APIKey: storage.APIKey{
+			Name:      def.Name,
+			Tenant:    def.Tenant,
+			Services:  def.Services,
+			Signals:   def.Signals,
+			CreatedAt: time.Now(),
+		},
+		source:  source,
+		signals: signalSet(def.Signals),
+	}, nil
+}
+
+func signalSet(signals []string) map[string]bool {
+	set := make(map[string]bool, len(signals))
+	for _, signal := range signals {
+		set[signal] = true
+	}
+	return set
+}
+
+// setSecret stores the hash of a key's secret and the prefix shown to tell
+// keys apart
+func (k *key) setSecret(secret string) {
+	k.Hash = hashSecret(secret)
+	k.Prefix = secret[:min(12, len(secret)/3)]
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Start writes the last-used times of stored keys back periodically
+func (a *Authenticator) Start() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush()
+			case <-a.done:
+				a.flush()
+				return
+			}
+		}
+	}()
+}
+
+func (a *Authenticator) Stop() {
+	close(a.done)
+	a.wg.Wait()
+}
+
+func (a *Authenticator) flush() {
+	a.mu.Lock()
+	var dirty []*storage.APIKey
+	for _, k := range a.keys {
+		if k.dirty {
+			copied := k.APIKey
+			dirty = append(dirty, &copied)
+			k.dirty = false
+		}
+	}
+	a.mu.Unlock()
+
+	if len(dirty) == 0 {
+		return
+	}
+	if err := a.storage.SaveAPIKeys(dirty); err != nil {
+		a.logger.Error("Failed to save API key usage", zap.Error(err))
+	}
+}
+
+// Enabled reports whether ingestion requires an API key
+func (a *Authenticator) Enabled() bool {
+	return a.config.Enabled
+}
+
+// Authenticate returns the identity of the key with secret, checking that it
+// may write signal
+func (a *Authenticator) Authenticate(secret, signal string) (*Identity, error) {
+	if secret == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, ok := a.byHash[hashSecret(secret)]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if len(k.signals) > 0 && !k.signals[signal] {
+		return nil, fmt.Errorf("%w %s", ErrPermissionDenied, signal)
+	}
+
+	now := time.Now()
+	k.LastUsed = &now
+	k.dirty = k.source == "api"
+
+	return &Identity{Name: k.Name, Tenant: k.Tenant, services: k.Services}, nil
+}
+
+// Keys lists the API keys by name
+func (a *Authenticator) Keys() []*KeyStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]*KeyStatus, 0, len(a.keys))
+	for _, k := range a.keys {
+		list = append(list, k.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// CreateKey adds a key, returning it with its generated secret. The secret
+// is not stored and cannot be shown again.
+func (a *Authenticator) CreateKey(def config.APIKeyConfig) (*KeyStatus, string, error) {
+	k, err := compileKey(def, "api")
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidAPIKey, err)
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	k.setSecret(secret)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.keys[k.Name]; ok {
+		return nil, "", ErrAPIKeyExists
+	}
+	if err := a.save(k); err != nil {
+		return nil, "", err
+	}
+	a.keys[k.Name] = k
+	a.byHash[k.Hash] = k
+
+	a.logger.Info("Created API key", zap.String("name", k.Name), zap.String("tenant", k.Tenant))
+	return k.status(), secret, nil
+}
+
+// RevokeKey stops a key from authenticating. The key is kept, revoked.
+func (a *Authenticator) RevokeKey(name string) (*KeyStatus, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, err := a.apiKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil {
+		return k.status(), nil
+	}
+
+	revoked := *k
+	now := time.Now()
+	revoked.RevokedAt = &now
+	if err := a.save(&revoked); err != nil {
+		return nil, err
+	}
+	delete(a.byHash, k.Hash)
+	*k = revoked
+
+	a.logger.Info("Revoked API key", zap.String("name", k.Name))
+	return k.status(), nil
+}
+
+// RotateKey replaces a key's secret, returning the new one. The old secret
+// stops working at once.
+func (a *Authenticator) RotateKey(name string) (*KeyStatus, string, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k, err := a.apiKey(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if k.RevokedAt != nil {
+		return nil, "", ErrAPIKeyRevoked
+	}
+
+	rotated := *k
+	now := time.Now()
+	rotated.RotatedAt = &now
+	rotated.setSecret(secret)
+	if err := a.save(&rotated); err != nil {
+		return nil, "", err
+	}
+	delete(a.byHash, k.Hash)
+	*k = rotated
+	a.byHash[k.Hash] = k
+
+	a.logger.Info("Rotated API key", zap.String("name", k.Name))
+	return k.status(), secret, nil
+}
+
+// apiKey returns a key created through the admin API. The caller must hold
+// mu.
+func (a *Authenticator) apiKey(name string) (*key, error) {
+	k, ok := a.keys[name]
+	switch {
+	case !ok:
+		return nil, ErrAPIKeyNotFound
+	case k.source == "config":
+		return nil, ErrAPIKeyReadOnly
+	}
+	return k, nil
+}
+
+// save stores a key. The caller must hold mu.
+func (a *Authenticator) save(k *key) error {
+	copied := k.APIKey
+	k.dirty = false
+	if err := a.storage.SaveAPIKeys([]*storage.APIKey{&copied}); err != nil {
+		return fmt.Errorf("failed to save API key: %w", err)
+	}
+	return nil
+}
+
+func (k *key) status() *KeyStatus {
+	return &KeyStatus{
+		Name:      k.Name,
+		Source:    k.source,
+		Prefix:    k.Prefix,
+		Tenant:    k.Tenant,
+		Services:  k.Services,
+		Signals:   k.Signals,
+		CreatedAt: k.CreatedAt,
+		RotatedAt: k.RotatedAt,
+		RevokedAt: k.RevokedAt,
+		LastUsed:  k.LastUsed,
+	}
+}
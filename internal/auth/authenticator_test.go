@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+func newTestStorage(t *testing.T) storage.Storage {
+	t.Helper()
+	cfg := config.DefaultConfig().Storage
+	cfg.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	store, err := storage.New(cfg)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newTestAuthenticator(t *testing.T, store storage.Storage, keys ...config.APIKeyConfig) *Authenticator {
+	t.Helper()
+	cfg := config.DefaultConfig().Ingestion.Auth
+	cfg.Enabled = true
+	cfg.Keys = keys
+	a, err := NewAuthenticator(store, cfg)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return a
+}
+
+func TestNewAuthenticatorValidatesKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []config.APIKeyConfig
+	}{
+		{"short secret", []config.APIKeyConfig{{Name: "ci", Key: "short"}}},
+		{"invalid name", []config.APIKeyConfig{{Name: "ci/prod", Key: "0123456789abcdef"}}},
+		{"invalid tenant", []config.APIKeyConfig{{Name: "ci", Key: "0123456789abcdef", Tenant: "../a"}}},
+		{"invalid service pattern", []config.APIKeyConfig{{Name: "ci", Key: "0123456789abcdef", Services: []string{"["}}}},
+		{"unknown signal", []config.APIKeyConfig{{Name: "ci", Key: "0123456789abcdef", Signals: []string{"profiles"}}}},
+		{"duplicate name", []config.APIKeyConfig{{Name: "ci", Key: "0123456789abcdef"}, {Name: "ci", Key: "fedcba9876543210"}}},
+		{"shared secret", []config.APIKeyConfig{{Name: "a", Key: "0123456789abcdef"}, {Name: "b", Key: "0123456789abcdef"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig().Ingestion.Auth
+			cfg.Keys = tt.keys
+			if _, err := NewAuthenticator(newTestStorage(t), cfg); err == nil {
+				t.Error("NewAuthenticator succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAuthenticateScopes(t *testing.T) {
+	a := newTestAuthenticator(t, newTestStorage(t),
+		config.APIKeyConfig{Name: "all", Key: "all-secret-0123456789"},
+		config.APIKeyConfig{Name: "traces", Key: "traces-secret-0123456789", Signals: []string{SignalTraces}},
+		config.APIKeyConfig{Name: "team-a", Key: "team-a-secret-0123456789", Tenant: "team-a", Services: []string{"payments-*", "checkout"}},
+	)
+
+	tests := []struct {
+		name       string
+		secret     string
+		signal     string
+		wantErr    error
+		wantName   string
+		wantTenant string
+	}{
+		{"no secret", "", SignalTraces, ErrUnauthenticated, "", ""},
+		{"unknown secret", "all-secret-01234567890", SignalTraces, ErrUnauthenticated, "", ""},
+		{"secret prefix", "all-secret", SignalTraces, ErrUnauthenticated, "", ""},
+		{"any signal", "all-secret-0123456789", SignalLogs, nil, "all", ""},
+		{"allowed signal", "traces-secret-0123456789", SignalTraces, nil, "traces", ""},
+		{"other signal", "traces-secret-0123456789", SignalMetrics, ErrPermissionDenied, "", ""},
+		{"tenant key", "team-a-secret-0123456789", SignalMetrics, nil, "team-a", "team-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(tt.secret, tt.signal)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if id.Name != tt.wantName || id.Tenant != tt.wantTenant {
+				t.Errorf("identity = %s/%s, want %s/%s", id.Name, id.Tenant, tt.wantName, tt.wantTenant)
+			}
+		})
+	}
+
+	id, err := a.Authenticate("team-a-secret-0123456789", SignalLogs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for service, want := range map[string]bool{
+		"checkout":        true,
+		"checkout-api":    false,
+		"payments-eu":     true,
+		"payments":        false,
+		"payments-eu/api": false,
+	} {
+		if got := id.AllowsService(service); got != want {
+			t.Errorf("AllowsService(%q) = %v, want %v", service, got, want)
+		}
+	}
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	store := newTestStorage(t)
+	a := newTestAuthenticator(t, store, config.APIKeyConfig{Name: "config", Key: "config-secret-0123456789"})
+
+	status, secret, err := a.CreateKey(config.APIKeyConfig{Name: "ci", Signals: []string{SignalTraces}})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if !strings.HasPrefix(secret, secretPrefix) || !strings.HasPrefix(secret, status.Prefix) {
+		t.Errorf("secret %q, prefix %q", secret, status.Prefix)
+	}
+	if _, _, err := a.CreateKey(config.APIKeyConfig{Name: "ci"}); !errors.Is(err, ErrAPIKeyExists) {
+		t.Errorf("CreateKey of an existing name error = %v, want %v", err, ErrAPIKeyExists)
+	}
+
+	// Only the hash is stored, and a restart finds the key by it
+	stored, err := store.GetAPIKeys()
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("GetAPIKeys = %v, %v", stored, err)
+	}
+	if stored[0].Hash != hashSecret(secret) || strings.Contains(stored[0].Hash, secret) {
+		t.Errorf("stored hash %q is not the secret's SHA-256", stored[0].Hash)
+	}
+	restarted := newTestAuthenticator(t, store, config.APIKeyConfig{Name: "config", Key: "config-secret-0123456789"})
+	if _, err := restarted.Authenticate(secret, SignalTraces); err != nil {
+		t.Errorf("Authenticate after a restart: %v", err)
+	}
+
+	_, rotated, err := a.RotateKey("ci")
+	if err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	if _, err := a.Authenticate(secret, SignalTraces); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("old secret error = %v, want %v", err, ErrUnauthenticated)
+	}
+	if _, err := a.Authenticate(rotated, SignalTraces); err != nil {
+		t.Errorf("rotated secret: %v", err)
+	}
+
+	if _, err := a.RevokeKey("ci"); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+	if _, err := a.Authenticate(rotated, SignalTraces); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("revoked secret error = %v, want %v", err, ErrUnauthenticated)
+	}
+	if _, _, err := a.RotateKey("ci"); !errors.Is(err, ErrAPIKeyRevoked) {
+		t.Errorf("RotateKey of a revoked key error = %v, want %v", err, ErrAPIKeyRevoked)
+	}
+
+	for name, want := range map[string]error{"config": ErrAPIKeyReadOnly, "missing": ErrAPIKeyNotFound} {
+		if _, err := a.RevokeKey(name); !errors.Is(err, want) {
+			t.Errorf("RevokeKey(%q) error = %v, want %v", name, err, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+var ErrInvalidClientCert = errors.New("invalid client certificate mapping")
+
+// certPrincipal maps the verified client certificates matching a pattern to
+// an ingestion principal
+type certPrincipal struct {
+	config.ClientCertConfig
+	signals  map[string]bool
+	lastUsed *time.Time
+}
+
+// CertStatus is a client certificate mapping as reported by the admin API
+type CertStatus struct {
+	config.ClientCertConfig
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+func compileCertPrincipal(def config.ClientCertConfig) (*certPrincipal, error) {
+	if def.Match == "" {
+		return nil, errors.New("match is required")
+	}
+	if _, err := path.Match(def.Match, ""); err != nil {
+		return nil, fmt.Errorf("invalid match pattern %q", def.Match)
+	}
+	// The scopes are those of an API key
+	k, err := compileKey(config.APIKeyConfig{
+		Name:     def.Name,
+		Tenant:   def.Tenant,
+		Services: def.Services,
+		Signals:  def.Signals,
+	}, "config")
+	if err != nil {
+		return nil, err
+	}
+	return &certPrincipal{ClientCertConfig: def, signals: k.signals}, nil
+}
+
+// matches reports whether any name of cert matches the pattern
+func (p *certPrincipal) matches(cert *x509.Certificate) bool {
+	for _, name := range certNames(cert) {
+		if ok, _ := path.Match(p.Match, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// certNames returns the common name and the DNS, email and URI names of a
+// certificate
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// verifiedCert returns the client certificate of a connection once it has
+// been verified against the listener's client CAs
+func verifiedCert(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// AuthenticateCert returns the identity of the first mapping matching a
+// verified client certificate, checking that it may write signal. It
+// returns nil without an error when no mapping matches.
+func (a *Authenticator) AuthenticateCert(cert *x509.Certificate, signal string) (*Identity, error) {
+	if cert == nil {
+		return nil, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range a.certs {
+		if !p.matches(cert) {
+			continue
+		}
+		if len(p.signals) > 0 && !p.signals[signal] {
+			return nil, fmt.Errorf("%w %s", ErrPermissionDenied, signal)
+		}
+		now := time.Now()
+		p.lastUsed = &now
+		return &Identity{Name: p.Name, Tenant: p.Tenant, services: p.Services}, nil
+	}
+	return nil, nil
+}
+
+// ClientCerts lists the client certificate mappings in the order they are
+// tried
+func (a *Authenticator) ClientCerts() []*CertStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]*CertStatus, 0, len(a.certs))
+	for _, p := range a.certs {
+		list = append(list, &CertStatus{ClientCertConfig: p.ClientCertConfig, LastUsed: p.lastUsed})
+	}
+	return list
+}
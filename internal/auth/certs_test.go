@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"testing"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func TestAuthenticateCert(t *testing.T) {
+	store := newTestStorage(t)
+	cfg := config.DefaultConfig().Ingestion.Auth
+	cfg.ClientCerts = []config.ClientCertConfig{
+		{Name: "collectors", Match: "*.collectors.example.com", Tenant: "team-a"},
+		{Name: "spiffe", Match: "spiffe://example.com/ns/prod/*", Signals: []string{SignalTraces}},
+		{Name: "ops", Match: "ops@example.com", Services: []string{"infra-*"}},
+	}
+	a, err := NewAuthenticator(store, cfg)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/ns/prod/agent")
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		signal   string
+		wantName string
+		wantErr  error
+	}{
+		{"no certificate", nil, SignalTraces, "", nil},
+		{"common name", &x509.Certificate{Subject: pkix.Name{CommonName: "eu.collectors.example.com"}}, SignalLogs, "collectors", nil},
+		{"DNS name", &x509.Certificate{Subject: pkix.Name{CommonName: "agent"}, DNSNames: []string{"us.collectors.example.com"}}, SignalLogs, "collectors", nil},
+		{"nested subdomain", &x509.Certificate{Subject: pkix.Name{CommonName: "a.b.collectors.example.com"}}, SignalLogs, "collectors", nil},
+		{"URI name", &x509.Certificate{URIs: []*url.URL{spiffe}}, SignalTraces, "spiffe", nil},
+		{"signal not allowed", &x509.Certificate{URIs: []*url.URL{spiffe}}, SignalMetrics, "", ErrPermissionDenied},
+		{"email name", &x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, SignalMetrics, "ops", nil},
+		{"no mapping", &x509.Certificate{Subject: pkix.Name{CommonName: "collectors.example.com"}}, SignalTraces, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.AuthenticateCert(tt.cert, tt.signal)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthenticateCert error = %v, want %v", err, tt.wantErr)
+			}
+			name := ""
+			if id != nil {
+				name = id.Name
+			}
+			if name != tt.wantName {
+				t.Errorf("identity = %q, want %q", name, tt.wantName)
+			}
+		})
+	}
+
+	id, err := a.AuthenticateCert(&x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, SignalLogs)
+	if err != nil || !id.AllowsService("infra-dns") || id.AllowsService("checkout") {
+		t.Errorf("ops identity = %+v, %v; want scoped to infra-*", id, err)
+	}
+	id, err = a.AuthenticateCert(&x509.Certificate{Subject: pkix.Name{CommonName: "eu.collectors.example.com"}}, SignalLogs)
+	if err != nil || id.Tenant != "team-a" {
+		t.Errorf("collectors identity = %+v, %v; want tenant team-a", id, err)
+	}
+
+	if a.ClientCerts()[0].LastUsed == nil {
+		t.Error("last use of a matched mapping not recorded")
+	}
+}
+
+func TestCompileCertPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		def  config.ClientCertConfig
+	}{
+		{"no match", config.ClientCertConfig{Name: "a"}},
+		{"invalid pattern", config.ClientCertConfig{Name: "a", Match: "["}},
+		{"invalid name", config.ClientCertConfig{Name: "a b", Match: "*"}},
+		{"unknown signal", config.ClientCertConfig{Name: "a", Match: "*", Signals: []string{"events"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileCertPrincipal(tt.def); err == nil {
+				t.Error("compileCertPrincipal succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestVerifiedCert(t *testing.T) {
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  *x509.Certificate
+	}{
+		{"plaintext", nil, nil},
+		{"unverified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}, nil},
+		{"verified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}, VerifiedChains: [][]*x509.Certificate{{leaf}}}, leaf},
+	}
+	for _, tt := range tests {
+		if got := verifiedCert(tt.state); got != tt.want {
+			t.Errorf("%s: verifiedCert = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// contextKey holds the request's identity in the gin context
+const contextKey = "api_key"
+
+type identityKey struct{}
+
+// Middleware authenticates OTLP/HTTP requests. The signal is the last
+// segment of the route, as in /v1/traces. A verified client certificate
+// matching a mapping authenticates the request without an API key. A key or
+// mapping bound to a tenant assigns the request to it.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signal := path.Base(c.FullPath())
+		id, err := a.AuthenticateCert(verifiedCert(c.Request.TLS), signal)
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+		if id == nil {
+			if !a.config.Enabled {
+				c.Next()
+				return
+			}
+
+			secret := bearer(c.GetHeader("Authorization"))
+			if secret == "" {
+				secret = c.GetHeader(a.config.Header)
+			}
+			if id, err = a.Authenticate(secret, signal); err != nil {
+				Abort(c, err)
+				return
+			}
+		}
+
+		c.Set(contextKey, id)
+		if id.Tenant != "" {
+			tenancy.Assign(c, id.Tenant)
+		}
+		c.Next()
+	}
+}
+
+// Abort rejects an OTLP/HTTP request with the status code and JSON-encoded
+// google.rpc.Status body OTLP exporters expect
+func Abort(c *gin.Context, err error) {
+	code, httpStatus := codes.Unauthenticated, http.StatusUnauthorized
+	if errors.Is(err, ErrPermissionDenied) {
+		code, httpStatus = codes.PermissionDenied, http.StatusForbidden
+	} else {
+		c.Header("WWW-Authenticate", `Bearer realm="otlp"`)
+	}
+	c.AbortWithStatusJSON(httpStatus, gin.H{"code": code, "message": err.Error()})
+}
+
+// FromContext returns the identity a request authenticated with, or nil when
+// authentication is disabled
+func FromContext(c *gin.Context) *Identity {
+	if id, ok := c.Get(contextKey); ok {
+		return id.(*Identity)
+	}
+	return nil
+}
+
+// UnaryInterceptor authenticates OTLP/gRPC calls from their client
+// certificate or metadata
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticateGRPC(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming gRPC calls from their client
+// certificate or metadata
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticateGRPC(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// IdentityFromContext returns the identity a gRPC call authenticated with, or
+// nil when authentication is disabled
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+func (a *Authenticator) authenticateGRPC(ctx context.Context, method string) (context.Context, error) {
+	signal := grpcSignal(method)
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	id, err := a.AuthenticateCert(verifiedCert(state), signal)
+	if err == nil && id == nil {
+		if !a.config.Enabled {
+			return ctx, nil
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		secret := ""
+		if values := md.Get("authorization"); len(values) > 0 {
+			secret = bearer(values[0])
+		}
+		if values := md.Get(a.config.Header); secret == "" && len(values) > 0 {
+			secret = values[0]
+		}
+		id, err = a.Authenticate(secret, signal)
+	}
+	if err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, identityKey{}, id), nil
+}
+
+// grpcSignal reads the signal from an OTLP collector service method, such as
+// /opentelemetry.proto.collector.trace.v1.TraceService/Export
+func grpcSignal(method string) string {
+	switch {
+	case strings.Contains(method, ".trace."):
+		return SignalTraces
+	case strings.Contains(method, ".metrics."):
+		return SignalMetrics
+	case strings.Contains(method, ".logs."):
+		return SignalLogs
+	}
+	return ""
+}
+
+// bearer returns the token of a Bearer authorization header
+func bearer(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -11,8 +12,12 @@ import (
 type Config struct {
 	Server    ServerConfig    `yaml:"server"`
 	Storage   StorageConfig   `yaml:"storage"`
+	Tenancy   TenancyConfig   `yaml:"tenancy"`
+	Auth      AuthConfig      `yaml:"auth"`
 	Ingestion IngestionConfig `yaml:"ingestion"`
 	Web       WebConfig       `yaml:"web"`
+	Alerting  AlertingConfig  `yaml:"alerting"`
+	Anomaly   AnomalyConfig   `yaml:"anomaly"`
 	Logging   LoggingConfig   `yaml:"logging"`
 }
 
@@ -21,30 +26,494 @@ type ServerConfig struct {
 	Environment  string        `yaml:"environment"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+	CORS         CORSConfig    `yaml:"cors"`
+}
+
+// CORSConfig is the cross-origin policy of a listener. AllowedOrigins are
+// exact origins such as "https://app.example.com", subdomain patterns such
+// as "https://*.example.com", or "*" for any origin; without any, browsers
+// may not call the listener from other origins. Requests under ExcludePaths
+// never get CORS headers. MaxAge is how long browsers may cache a preflight.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+	ExcludePaths     []string      `yaml:"exclude_paths"`
+}
+
+// TLSConfig serves a listener over TLS. The certificate, key and client CA
+// files are checked every ReloadInterval and reloaded when they change, so
+// renewed certificates apply without a restart; 0 loads them once. With
+// ClientCAFile set, the listener asks clients for a certificate signed by
+// one of its CAs; ClientAuth is "require" (the default with a CA) or
+// "optional".
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	CertFile       string        `yaml:"cert_file"`
+	KeyFile        string        `yaml:"key_file"`
+	ClientCAFile   string        `yaml:"client_ca_file,omitempty"`
+	ClientAuth     string        `yaml:"client_auth,omitempty"`
+	MinVersion     string        `yaml:"min_version"` // 1.2 or 1.3
+	ReloadInterval time.Duration `yaml:"reload_interval"`
 }
 
 type StorageConfig struct {
-	Type           string `yaml:"type"`
-	Path           string `yaml:"path"`
-	RetentionDays  int    `yaml:"retention_days"`
-	MaxConnections int    `yaml:"max_connections"`
+	Type              string             `yaml:"type"`
+	Path              string             `yaml:"path"`
+	RetentionDays     int                `yaml:"retention_days"`
+	MaxConnections    int                `yaml:"max_connections"`
+	PartitionInterval time.Duration      `yaml:"partition_interval"`
+	Rollups           RollupConfig       `yaml:"rollups"`
+	Catalog           CatalogConfig      `yaml:"catalog"`
+	ServiceGraph      ServiceGraphConfig `yaml:"service_graph"`
+	File              FileStorageConfig  `yaml:"file"`
+}
+
+// RollupConfig configures metric downsampling. Each tier keeps
+// min/max/sum/count/last per series at its resolution for its own retention
+// period. An explicitly empty tier list disables rollups.
+type RollupConfig struct {
+	FlushInterval time.Duration      `yaml:"flush_interval"`
+	Tiers         []RollupTierConfig `yaml:"tiers"`
+}
+
+type RollupTierConfig struct {
+	Resolution    time.Duration `yaml:"resolution"`
+	RetentionDays int           `yaml:"retention_days"`
+}
+
+// CatalogConfig configures the service catalog maintained at ingest. Window is
+// the default period rates and error rates are computed over (at most 1h), and
+// services or instances not seen for OfflineAfter are reported offline.
+type CatalogConfig struct {
+	Window        time.Duration `yaml:"window"`
+	OfflineAfter  time.Duration `yaml:"offline_after"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// ServiceGraphConfig configures the service map derived from spans at ingest.
+// Spans are held for Wait so parents and children arriving out of order can be
+// paired, with at most MaxPending spans held at once. Window is the default
+// period edge statistics are computed over (at most 1h).
+type ServiceGraphConfig struct {
+	Window     time.Duration `yaml:"window"`
+	Wait       time.Duration `yaml:"wait"`
+	MaxPending int           `yaml:"max_pending"`
+}
+
+// FileStorageConfig configures the segmented file storage engine used when
+// storage.type is "file"
+type FileStorageConfig struct {
+	Dir             string        `yaml:"dir"`
+	SegmentDuration time.Duration `yaml:"segment_duration"`
+	BlockSize       int           `yaml:"block_size"`
+	FlushInterval   time.Duration `yaml:"flush_interval"`
+	BloomBits       int           `yaml:"bloom_bits"`
+}
+
+// TenancyConfig separates the data of teams sharing one instance. Requests
+// name their tenant in Header; each tenant's signals are stored apart from
+// the others' and every query reads only the requesting tenant's data.
+// Requests without the header belong to DefaultTenant, whose data stays at
+// the configured storage path, unless RequireTenant rejects them. Tenants not
+// listed in Tenants are accepted when AllowUnlisted is set, up to MaxTenants
+// in all.
+type TenancyConfig struct {
+	Enabled       bool           `yaml:"enabled"`
+	Header        string         `yaml:"header"`
+	DefaultTenant string         `yaml:"default_tenant"`
+	RequireTenant bool           `yaml:"require_tenant"`
+	AllowUnlisted bool           `yaml:"allow_unlisted"`
+	MaxTenants    int            `yaml:"max_tenants"`
+	Tenants       []TenantConfig `yaml:"tenants"`
+}
+
+// TenantConfig overrides the storage retention and the ingest limits of one
+// tenant. Unset fields fall back to storage.retention_days and
+// ingestion.limits.
+type TenantConfig struct {
+	ID            string              `yaml:"id" json:"id"`
+	RetentionDays int                 `yaml:"retention_days,omitempty" json:"retention_days,omitempty"`
+	Limits        *IngestLimitsConfig `yaml:"limits,omitempty" json:"limits,omitempty"`
+}
+
+// AuthConfig requires users to sign in to the web UI and API. Users log in
+// with a password, or through OIDC, and get a session kept in a cookie and
+// usable as a bearer token; API tokens act as their user without expiring
+// with the session. Users defined here are read-only; users created through
+// the API are stored with the default tenant's data.
+type AuthConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	SessionTTL   time.Duration `yaml:"session_ttl"`
+	CookieName   string        `yaml:"cookie_name"`
+	SecureCookie bool          `yaml:"secure_cookie"`
+	Users        []UserConfig  `yaml:"users"`
+	OIDC         OIDCConfig    `yaml:"oidc"`
+}
+
+// UserConfig is a local user. PasswordHash is a bcrypt hash, as printed by
+// -hash-password; Password is only accepted when creating users through the
+// API. Role is viewer, editor or admin. With tenancy enabled the user may
+// only query Tenants ("*" for all), or the default tenant when it is empty.
+type UserConfig struct {
+	Username     string   `yaml:"username" json:"username"`
+	Password     string   `yaml:"-" json:"password,omitempty"`
+	PasswordHash string   `yaml:"password_hash" json:"-"`
+	Role         string   `yaml:"role" json:"role"`
+	Tenants      []string `yaml:"tenants,omitempty" json:"tenants,omitempty"`
+}
+
+// OIDCConfig enables login through an OpenID Connect provider with the
+// authorization code flow. Users get the highest role RoleMapping gives a
+// value of their RolesClaim, or DefaultRole; without either they are
+// refused. They may query the tenants named by their TenantsClaim and
+// DefaultTenants, like local users.
+type OIDCConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	Issuer         string            `yaml:"issuer"`
+	ClientID       string            `yaml:"client_id"`
+	ClientSecret   string            `yaml:"client_secret"`
+	RedirectURL    string            `yaml:"redirect_url"`
+	Scopes         []string          `yaml:"scopes"`
+	UsernameClaim  string            `yaml:"username_claim"`
+	RolesClaim     string            `yaml:"roles_claim"`
+	RoleMapping    map[string]string `yaml:"role_mapping"`
+	DefaultRole    string            `yaml:"default_role"`
+	TenantsClaim   string            `yaml:"tenants_claim"`
+	DefaultTenants []string          `yaml:"default_tenants"`
 }
 
 type IngestionConfig struct {
-	GRPCPort      int           `yaml:"grpc_port"`
-	HTTPPort      int           `yaml:"http_port"`
-	GRPCEnabled   bool          `yaml:"grpc_enabled"`
-	HTTPEnabled   bool          `yaml:"http_enabled"`
-	BatchSize     int           `yaml:"batch_size"`
+	GRPCPort      int                `yaml:"grpc_port"`
+	HTTPPort      int                `yaml:"http_port"`
+	GRPCEnabled   bool               `yaml:"grpc_enabled"`
+	HTTPEnabled   bool               `yaml:"http_enabled"`
+	GRPCTLS       TLSConfig          `yaml:"grpc_tls"`
+	HTTPTLS       TLSConfig          `yaml:"http_tls"`
+	HTTPCORS      CORSConfig         `yaml:"http_cors"`
+	BatchSize     int                `yaml:"batch_size"`
+	FlushInterval time.Duration      `yaml:"flush_interval"`
+	SpanMetrics   SpanMetricsConfig  `yaml:"span_metrics"`
+	LogPatterns   LogPatternsConfig  `yaml:"log_patterns"`
+	Issues        IssuesConfig       `yaml:"issues"`
+	TailSampling  TailSamplingConfig `yaml:"tail_sampling"`
+	Limits        IngestLimitsConfig `yaml:"limits"`
+	Redaction     RedactionConfig    `yaml:"redaction"`
+	Transform     TransformConfig    `yaml:"transform"`
+	Auth          IngestAuthConfig   `yaml:"auth"`
+}
+
+// SpanMetricsConfig configures the RED metrics generated from ingested spans.
+// Series are keyed by service, operation, span kind and status plus the span
+// attributes listed in Dimensions. Buckets are the duration histogram bounds.
+type SpanMetricsConfig struct {
+	Enabled       bool            `yaml:"enabled"`
+	FlushInterval time.Duration   `yaml:"flush_interval"`
+	Dimensions    []string        `yaml:"dimensions"`
+	Buckets       []time.Duration `yaml:"buckets"`
+}
+
+// LogPatternsConfig configures the Drain log pattern miner. Each service's
+// messages are routed by token count and their first Depth tokens to a leaf
+// of at most MaxChildren branches per node, and join the most similar pattern
+// there when at least Similarity of their tokens match it. Services keep at
+// most MaxPatterns patterns, evicting the least recently seen. Counts are kept
+// per Resolution bucket for Retention.
+type LogPatternsConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Depth         int           `yaml:"depth"`
+	Similarity    float64       `yaml:"similarity"`
+	MaxChildren   int           `yaml:"max_children"`
+	MaxPatterns   int           `yaml:"max_patterns"`
+	Resolution    time.Duration `yaml:"resolution"`
+	Retention     time.Duration `yaml:"retention"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// IssuesConfig configures error tracking. Exception span events and error logs
+// are grouped into issues by fingerprint, computed from at most MaxFrames
+// stack frames. Issues not seen for Retention are dropped.
+type IssuesConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MaxFrames     int           `yaml:"max_frames"`
+	Retention     time.Duration `yaml:"retention"`
 	FlushInterval time.Duration `yaml:"flush_interval"`
 }
 
+// TailSamplingConfig configures tail-based trace sampling. Spans are buffered
+// per trace ID for DecisionWait after the trace's first span, then the trace
+// is stored if any of Policies keeps it. At most MaxTraces traces are
+// buffered, deciding the oldest early when full, and a trace reaching
+// MaxSpansPerTrace spans is decided early. Spans arriving after their trace
+// was decided follow the decision for DecisionCache, remembering at most
+// MaxDecisions decisions.
+type TailSamplingConfig struct {
+	Enabled          bool                   `yaml:"enabled"`
+	DecisionWait     time.Duration          `yaml:"decision_wait"`
+	MaxTraces        int                    `yaml:"max_traces"`
+	MaxSpansPerTrace int                    `yaml:"max_spans_per_trace"`
+	DecisionCache    time.Duration          `yaml:"decision_cache"`
+	MaxDecisions     int                    `yaml:"max_decisions"`
+	Policies         []SamplingPolicyConfig `yaml:"policies"`
+}
+
+// SamplingPolicyConfig is one tail sampling policy. Type selects what keeps a
+// trace:
+//   - errors: any span has an error status
+//   - latency: the trace spans at least Threshold
+//   - attribute: any span has attribute Key, with one of Values if given
+//   - probabilistic: a share of traces, Rate by default or ServiceRates for
+//     the service of the trace's root span, chosen by trace ID
+type SamplingPolicyConfig struct {
+	Name         string             `yaml:"name" json:"name"`
+	Type         string             `yaml:"type" json:"type"`
+	Threshold    time.Duration      `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+	Key          string             `yaml:"key,omitempty" json:"key,omitempty"`
+	Values       []string           `yaml:"values,omitempty" json:"values,omitempty"`
+	Rate         float64            `yaml:"rate,omitempty" json:"rate,omitempty"`
+	ServiceRates map[string]float64 `yaml:"service_rates,omitempty" json:"service_rates,omitempty"`
+}
+
+// IngestLimitsConfig configures the per-service ingest policies for logs and
+// metrics. Policies can be replaced at runtime through the admin API; the
+// config file applies again on restart.
+type IngestLimitsConfig struct {
+	Enabled  bool                 `yaml:"enabled" json:"enabled"`
+	Policies []IngestPolicyConfig `yaml:"policies" json:"policies"`
+}
+
+// IngestPolicyConfig limits one signal (logs or metrics) of a service, or of
+// every service without a policy of its own when Service is "*". Logs are
+// sampled at SampleRate (all kept when unset), then records beyond RateLimit
+// per second, with bursts of Burst, are dropped. Logs at or above
+// KeepSeverity (ERROR when unset) are always kept.
+type IngestPolicyConfig struct {
+	Service      string   `yaml:"service" json:"service"`
+	Signal       string   `yaml:"signal" json:"signal"`
+	SampleRate   *float64 `yaml:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	RateLimit    float64  `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	Burst        int      `yaml:"burst,omitempty" json:"burst,omitempty"`
+	KeepSeverity string   `yaml:"keep_severity,omitempty" json:"keep_severity,omitempty"`
+}
+
+// RedactionConfig configures the redaction rules applied to incoming
+// telemetry before it is stored. Rules run in order.
+type RedactionConfig struct {
+	Enabled bool                  `yaml:"enabled"`
+	Rules   []RedactionRuleConfig `yaml:"rules"`
+}
+
+// RedactionRuleConfig is one redaction rule. Action is what it does to a
+// value:
+//   - delete: removes the attribute (Keys required)
+//   - hash: replaces the value with a salted SHA-256 digest
+//   - mask: replaces matches of Pattern, or of a Builtin pattern (email, jwt,
+//     credit_card), with Replacement
+//   - truncate: cuts the value to MaxLength characters
+//
+// Scopes picks the values the rule applies to: resource, span and log
+// attributes and log bodies (all by default). With Keys set, only attributes
+// whose key matches one of them (path.Match patterns) are changed.
+type RedactionRuleConfig struct {
+	Name        string   `yaml:"name"`
+	Action      string   `yaml:"action"`
+	Scopes      []string `yaml:"scopes"`
+	Keys        []string `yaml:"keys"`
+	Pattern     string   `yaml:"pattern"`
+	Builtin     string   `yaml:"builtin"`
+	Replacement string   `yaml:"replacement"`
+	Salt        string   `yaml:"salt"`
+	MaxLength   int      `yaml:"max_length"`
+}
+
+// TransformConfig configures the transformation statements run on each
+// incoming span, log and metric datapoint, written in a subset of the
+// OpenTelemetry Transformation Language (OTTL). Statements run in order.
+type TransformConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	TraceStatements  []string `yaml:"trace_statements"`
+	LogStatements    []string `yaml:"log_statements"`
+	MetricStatements []string `yaml:"metric_statements"`
+}
+
+// IngestAuthConfig requires OTLP senders to present an API key, as
+// "Authorization: Bearer <key>" or in Header, on HTTP requests and gRPC
+// metadata alike. Keys listed here are read-only; keys created through the
+// admin API are stored with the default tenant's data, and their last-used
+// times are written back every FlushInterval.
+type IngestAuthConfig struct {
+	Enabled       bool               `yaml:"enabled"`
+	Header        string             `yaml:"header"`
+	FlushInterval time.Duration      `yaml:"flush_interval"`
+	Keys          []APIKeyConfig     `yaml:"keys"`
+	ClientCerts   []ClientCertConfig `yaml:"client_certs"`
+}
+
+// APIKeyConfig is an ingestion API key. Key is the secret senders present
+// and is generated for keys created through the admin API. Tenant binds the
+// data written with the key to a tenant. Services and Signals (traces,
+// metrics, logs) limit what the key may write, everything when empty.
+type APIKeyConfig struct {
+	Name     string   `yaml:"name" json:"name"`
+	Key      string   `yaml:"key" json:"-"`
+	Tenant   string   `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+	Services []string `yaml:"services,omitempty" json:"services,omitempty"`
+	Signals  []string `yaml:"signals,omitempty" json:"signals,omitempty"`
+}
+
+// ClientCertConfig maps senders presenting a verified TLS client
+// certificate to an ingestion principal, so they need no API key. Match is a
+// path.Match pattern tested against the certificate's common name and its
+// DNS, email and URI names; the first matching entry applies. Tenant,
+// Services and Signals scope the principal as they do an API key.
+type ClientCertConfig struct {
+	Name     string   `yaml:"name" json:"name"`
+	Match    string   `yaml:"match" json:"match"`
+	Tenant   string   `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+	Services []string `yaml:"services,omitempty" json:"services,omitempty"`
+	Signals  []string `yaml:"signals,omitempty" json:"signals,omitempty"`
+}
+
 type WebConfig struct {
 	Enabled bool   `yaml:"enabled"`
 	Title   string `yaml:"title"`
 	Theme   string `yaml:"theme"`
 }
 
+// AlertingConfig configures the alert rule engine. Rules defined here are
+// read-only; rules created through the API are stored with the data.
+type AlertingConfig struct {
+	Enabled            bool               `yaml:"enabled"`
+	EvaluationInterval time.Duration      `yaml:"evaluation_interval"`
+	Rules              []AlertRuleConfig  `yaml:"rules"`
+	Notifications      NotificationConfig `yaml:"notifications"`
+}
+
+// NotificationConfig configures how firing and resolved alerts are sent.
+// Alerts sharing the GroupBy label values are batched: a new group waits
+// GroupWait before its first notification, changes are sent at most every
+// GroupInterval, and still-firing groups are re-sent every RepeatInterval.
+// Failed deliveries are retried up to MaxRetries times, doubling RetryBackoff
+// after each attempt.
+type NotificationConfig struct {
+	ExternalURL    string                      `yaml:"external_url"`
+	GroupBy        []string                    `yaml:"group_by"`
+	GroupWait      time.Duration               `yaml:"group_wait"`
+	GroupInterval  time.Duration               `yaml:"group_interval"`
+	RepeatInterval time.Duration               `yaml:"repeat_interval"`
+	Timeout        time.Duration               `yaml:"timeout"`
+	MaxRetries     int                         `yaml:"max_retries"`
+	RetryBackoff   time.Duration               `yaml:"retry_backoff"`
+	Channels       []NotificationChannelConfig `yaml:"channels"`
+}
+
+// NotificationChannelConfig is one destination. Type is webhook (Alertmanager
+// webhook payload), slack (Slack/Mattermost incoming webhook) or email. Match
+// restricts the channel to alerts carrying all of the given labels. Title and
+// Body are Go templates; Title is the email subject.
+type NotificationChannelConfig struct {
+	Name         string            `yaml:"name"`
+	Type         string            `yaml:"type"`
+	Match        map[string]string `yaml:"match,omitempty"`
+	SendResolved bool              `yaml:"send_resolved"`
+	Title        string            `yaml:"title,omitempty"`
+	Body         string            `yaml:"body,omitempty"`
+
+	// webhook and slack
+	URL     string            `yaml:"url,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// slack
+	Channel  string `yaml:"channel,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	// email
+	SMTP SMTPConfig `yaml:"smtp,omitempty"`
+}
+
+// SMTPConfig configures email delivery. STARTTLS is used when the server
+// offers it; authentication only when a username is set.
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// AnomalyConfig configures the baselines anomaly scores are computed against.
+// A series' baseline is built from its hourly averages over Lookback, kept
+// per hour of the week and per hour of the day, and rebuilt every
+// RefreshInterval. A seasonal bucket is used once it holds MinSamples hours.
+// Threshold is the default absolute z-score above which a value is anomalous.
+type AnomalyConfig struct {
+	Lookback        time.Duration `yaml:"lookback"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	MinSamples      int           `yaml:"min_samples"`
+	Threshold       float64       `yaml:"threshold"`
+}
+
+// AlertRuleConfig defines an alerting rule. Metric rules aggregate the series
+// selected by Query over Window, one alert per series; log_count and
+// span_count rules count the matching records within Window; anomaly rules
+// score the average of each series over Window against its baseline, in the
+// given Direction. An alert fires once the condition has held for For.
+type AlertRuleConfig struct {
+	Name        string            `yaml:"name" json:"name"`
+	Type        string            `yaml:"type" json:"type"` // metric, log_count, span_count or anomaly
+	Query       string            `yaml:"query,omitempty" json:"query,omitempty"`
+	Aggregation string            `yaml:"aggregation,omitempty" json:"aggregation,omitempty"` // avg, min, max, sum, count or last
+	Service     string            `yaml:"service,omitempty" json:"service,omitempty"`
+	Level       string            `yaml:"level,omitempty" json:"level,omitempty"`
+	Contains    string            `yaml:"contains,omitempty" json:"contains,omitempty"`
+	Operation   string            `yaml:"operation,omitempty" json:"operation,omitempty"`
+	ErrorsOnly  bool              `yaml:"errors_only,omitempty" json:"errors_only,omitempty"`
+	Direction   string            `yaml:"direction,omitempty" json:"direction,omitempty"` // up, down or both
+	Window      time.Duration     `yaml:"window" json:"-"`
+	Operator    string            `yaml:"operator" json:"operator"`
+	Threshold   float64           `yaml:"threshold" json:"threshold"`
+	For         time.Duration     `yaml:"for" json:"-"`
+	Labels      map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+}
+
+// MarshalJSON writes durations as Go duration strings ("5m"), as in YAML
+func (r AlertRuleConfig) MarshalJSON() ([]byte, error) {
+	type plain AlertRuleConfig
+	return json.Marshal(struct {
+		plain
+		Window string `json:"window"`
+		For    string `json:"for"`
+	}{plain(r), r.Window.String(), r.For.String()})
+}
+
+func (r *AlertRuleConfig) UnmarshalJSON(data []byte) error {
+	type plain AlertRuleConfig
+	aux := struct {
+		*plain
+		Window string `json:"window"`
+		For    string `json:"for"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if aux.Window != "" {
+		if r.Window, err = time.ParseDuration(aux.Window); err != nil {
+			return fmt.Errorf("invalid window: %w", err)
+		}
+	}
+	if aux.For != "" {
+		if r.For, err = time.ParseDuration(aux.For); err != nil {
+			return fmt.Errorf("invalid for: %w", err)
+		}
+	}
+	return nil
+}
+
 type LoggingConfig struct {
 	Level    string `yaml:"level"`
 	Format   string `yaml:"format"`
@@ -105,6 +574,11 @@ func (c *Config) setDefaults() {
 	if c.Server.WriteTimeout == 0 {
 		c.Server.WriteTimeout = 30 * time.Second
 	}
+	for _, t := range []*TLSConfig{&c.Server.TLS, &c.Ingestion.GRPCTLS, &c.Ingestion.HTTPTLS} {
+		if t.MinVersion == "" {
+			t.MinVersion = "1.2"
+		}
+	}
 
 	if c.Storage.Type == "" {
 		c.Storage.Type = "sqlite"
@@ -118,6 +592,48 @@ func (c *Config) setDefaults() {
 	if c.Storage.MaxConnections == 0 {
 		c.Storage.MaxConnections = 10
 	}
+	if c.Storage.PartitionInterval == 0 {
+		c.Storage.PartitionInterval = 24 * time.Hour
+	}
+	if c.Storage.Rollups.FlushInterval == 0 {
+		c.Storage.Rollups.FlushInterval = 10 * time.Second
+	}
+	if c.Storage.Rollups.Tiers == nil {
+		c.Storage.Rollups.Tiers = defaultRollupTiers()
+	}
+	if c.Storage.Catalog.Window == 0 {
+		c.Storage.Catalog.Window = 15 * time.Minute
+	}
+	if c.Storage.Catalog.OfflineAfter == 0 {
+		c.Storage.Catalog.OfflineAfter = 5 * time.Minute
+	}
+	if c.Storage.Catalog.FlushInterval == 0 {
+		c.Storage.Catalog.FlushInterval = 10 * time.Second
+	}
+	if c.Storage.ServiceGraph.Window == 0 {
+		c.Storage.ServiceGraph.Window = 15 * time.Minute
+	}
+	if c.Storage.ServiceGraph.Wait == 0 {
+		c.Storage.ServiceGraph.Wait = 10 * time.Second
+	}
+	if c.Storage.ServiceGraph.MaxPending == 0 {
+		c.Storage.ServiceGraph.MaxPending = 100000
+	}
+	if c.Storage.File.Dir == "" {
+		c.Storage.File.Dir = "./data/segments"
+	}
+	if c.Storage.File.SegmentDuration == 0 {
+		c.Storage.File.SegmentDuration = time.Hour
+	}
+	if c.Storage.File.BlockSize == 0 {
+		c.Storage.File.BlockSize = 1000
+	}
+	if c.Storage.File.FlushInterval == 0 {
+		c.Storage.File.FlushInterval = time.Second
+	}
+	if c.Storage.File.BloomBits == 0 {
+		c.Storage.File.BloomBits = 1 << 20
+	}
 
 	if c.Ingestion.GRPCPort == 0 {
 		c.Ingestion.GRPCPort = 4317
@@ -136,6 +652,60 @@ func (c *Config) setDefaults() {
 	if c.Ingestion.FlushInterval == 0 {
 		c.Ingestion.FlushInterval = 5 * time.Second
 	}
+	if c.Ingestion.SpanMetrics.FlushInterval == 0 {
+		c.Ingestion.SpanMetrics.FlushInterval = 15 * time.Second
+	}
+	if len(c.Ingestion.SpanMetrics.Buckets) == 0 {
+		c.Ingestion.SpanMetrics.Buckets = defaultSpanMetricsBuckets()
+	}
+	if c.Ingestion.LogPatterns.Depth == 0 {
+		c.Ingestion.LogPatterns.Depth = 2
+	}
+	if c.Ingestion.LogPatterns.Similarity == 0 {
+		c.Ingestion.LogPatterns.Similarity = 0.5
+	}
+	if c.Ingestion.LogPatterns.MaxChildren == 0 {
+		c.Ingestion.LogPatterns.MaxChildren = 100
+	}
+	if c.Ingestion.LogPatterns.MaxPatterns == 0 {
+		c.Ingestion.LogPatterns.MaxPatterns = 1000
+	}
+	if c.Ingestion.LogPatterns.Resolution == 0 {
+		c.Ingestion.LogPatterns.Resolution = 5 * time.Minute
+	}
+	if c.Ingestion.LogPatterns.Retention == 0 {
+		c.Ingestion.LogPatterns.Retention = 7 * 24 * time.Hour
+	}
+	if c.Ingestion.LogPatterns.FlushInterval == 0 {
+		c.Ingestion.LogPatterns.FlushInterval = 30 * time.Second
+	}
+	if c.Ingestion.Issues.MaxFrames == 0 {
+		c.Ingestion.Issues.MaxFrames = 30
+	}
+	if c.Ingestion.Issues.Retention == 0 {
+		c.Ingestion.Issues.Retention = 30 * 24 * time.Hour
+	}
+	if c.Ingestion.Issues.FlushInterval == 0 {
+		c.Ingestion.Issues.FlushInterval = 10 * time.Second
+	}
+	if c.Ingestion.TailSampling.DecisionWait == 0 {
+		c.Ingestion.TailSampling.DecisionWait = 10 * time.Second
+	}
+	if c.Ingestion.TailSampling.MaxTraces == 0 {
+		c.Ingestion.TailSampling.MaxTraces = 50000
+	}
+	if c.Ingestion.TailSampling.MaxSpansPerTrace == 0 {
+		c.Ingestion.TailSampling.MaxSpansPerTrace = 1000
+	}
+	if c.Ingestion.TailSampling.DecisionCache == 0 {
+		c.Ingestion.TailSampling.DecisionCache = time.Minute
+	}
+	if c.Ingestion.TailSampling.MaxDecisions == 0 {
+		c.Ingestion.TailSampling.MaxDecisions = 200000
+	}
+	if c.Ingestion.TailSampling.Policies == nil {
+		c.Ingestion.TailSampling.Policies = defaultSamplingPolicies()
+	}
 
 	if c.Web.Title == "" {
 		c.Web.Title = "Open-Telemorph-Prime"
@@ -144,6 +714,101 @@ func (c *Config) setDefaults() {
 		c.Web.Theme = "light"
 	}
 
+	if c.Alerting.EvaluationInterval == 0 {
+		c.Alerting.EvaluationInterval = 30 * time.Second
+	}
+	if c.Alerting.Notifications.GroupBy == nil {
+		c.Alerting.Notifications.GroupBy = []string{"alertname"}
+	}
+	if c.Tenancy.Header == "" {
+		c.Tenancy.Header = "X-Scope-OrgID"
+	}
+	if c.Tenancy.DefaultTenant == "" {
+		c.Tenancy.DefaultTenant = "default"
+	}
+	if c.Tenancy.MaxTenants == 0 {
+		c.Tenancy.MaxTenants = 100
+	}
+	if c.Auth.SessionTTL == 0 {
+		c.Auth.SessionTTL = 24 * time.Hour
+	}
+	if c.Auth.CookieName == "" {
+		c.Auth.CookieName = "otp_session"
+	}
+	if c.Auth.OIDC.Scopes == nil {
+		c.Auth.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	if c.Auth.OIDC.UsernameClaim == "" {
+		c.Auth.OIDC.UsernameClaim = "preferred_username"
+	}
+	if c.Auth.OIDC.RolesClaim == "" {
+		c.Auth.OIDC.RolesClaim = "groups"
+	}
+	if c.Ingestion.Auth.Header == "" {
+		c.Ingestion.Auth.Header = "X-API-Key"
+	}
+	// CORS headers default to those the listener reads, so they are set
+	// after the tenant and API key headers
+	if c.Server.CORS.AllowedMethods == nil {
+		c.Server.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	if c.Server.CORS.AllowedHeaders == nil {
+		c.Server.CORS.AllowedHeaders = serverCORSHeaders(c)
+	}
+	if c.Server.CORS.ExcludePaths == nil {
+		c.Server.CORS.ExcludePaths = []string{"/api/v1/admin"}
+	}
+	if c.Ingestion.HTTPCORS.AllowedMethods == nil {
+		c.Ingestion.HTTPCORS.AllowedMethods = []string{"POST"}
+	}
+	if c.Ingestion.HTTPCORS.AllowedHeaders == nil {
+		c.Ingestion.HTTPCORS.AllowedHeaders = ingestCORSHeaders(c)
+	}
+	for _, cors := range []*CORSConfig{&c.Server.CORS, &c.Ingestion.HTTPCORS} {
+		if cors.MaxAge == 0 {
+			cors.MaxAge = 10 * time.Minute
+		}
+	}
+	if c.Ingestion.Auth.FlushInterval == 0 {
+		c.Ingestion.Auth.FlushInterval = time.Minute
+	}
+	if c.Alerting.Notifications.GroupWait == 0 {
+		c.Alerting.Notifications.GroupWait = 30 * time.Second
+	}
+	if c.Alerting.Notifications.GroupInterval == 0 {
+		c.Alerting.Notifications.GroupInterval = 5 * time.Minute
+	}
+	if c.Alerting.Notifications.RepeatInterval == 0 {
+		c.Alerting.Notifications.RepeatInterval = 4 * time.Hour
+	}
+	if c.Alerting.Notifications.Timeout == 0 {
+		c.Alerting.Notifications.Timeout = 10 * time.Second
+	}
+	if c.Alerting.Notifications.MaxRetries == 0 {
+		c.Alerting.Notifications.MaxRetries = 5
+	}
+	if c.Alerting.Notifications.RetryBackoff == 0 {
+		c.Alerting.Notifications.RetryBackoff = time.Second
+	}
+	for i := range c.Alerting.Notifications.Channels {
+		if c.Alerting.Notifications.Channels[i].SMTP.Port == 0 {
+			c.Alerting.Notifications.Channels[i].SMTP.Port = 25
+		}
+	}
+
+	if c.Anomaly.Lookback == 0 {
+		c.Anomaly.Lookback = 28 * 24 * time.Hour
+	}
+	if c.Anomaly.RefreshInterval == 0 {
+		c.Anomaly.RefreshInterval = time.Hour
+	}
+	if c.Anomaly.MinSamples == 0 {
+		c.Anomaly.MinSamples = 3
+	}
+	if c.Anomaly.Threshold == 0 {
+		c.Anomaly.Threshold = 3
+	}
+
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
@@ -153,35 +818,177 @@ func (c *Config) setDefaults() {
 }
 
 func DefaultConfig() *Config {
-	return &Config{
+	cfg := &Config{
 		Server: ServerConfig{
 			Port:         8080,
 			Environment:  "development",
 			ReadTimeout:  30 * time.Second,
 			WriteTimeout: 30 * time.Second,
+			TLS:          defaultTLS(),
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+				MaxAge:         10 * time.Minute,
+				ExcludePaths:   []string{"/api/v1/admin"},
+			},
 		},
 		Storage: StorageConfig{
-			Type:           "sqlite",
-			Path:           "./data/telemorph.db",
-			RetentionDays:  30,
-			MaxConnections: 10,
+			Type:              "sqlite",
+			Path:              "./data/telemorph.db",
+			RetentionDays:     30,
+			MaxConnections:    10,
+			PartitionInterval: 24 * time.Hour,
+			Rollups: RollupConfig{
+				FlushInterval: 10 * time.Second,
+				Tiers:         defaultRollupTiers(),
+			},
+			Catalog: CatalogConfig{
+				Window:        15 * time.Minute,
+				OfflineAfter:  5 * time.Minute,
+				FlushInterval: 10 * time.Second,
+			},
+			ServiceGraph: ServiceGraphConfig{
+				Window:     15 * time.Minute,
+				Wait:       10 * time.Second,
+				MaxPending: 100000,
+			},
+			File: FileStorageConfig{
+				Dir:             "./data/segments",
+				SegmentDuration: time.Hour,
+				BlockSize:       1000,
+				FlushInterval:   time.Second,
+				BloomBits:       1 << 20,
+			},
+		},
+		Tenancy: TenancyConfig{
+			Header:        "X-Scope-OrgID",
+			DefaultTenant: "default",
+			MaxTenants:    100,
+		},
+		Auth: AuthConfig{
+			SessionTTL: 24 * time.Hour,
+			CookieName: "otp_session",
+			OIDC: OIDCConfig{
+				Scopes:        []string{"openid", "profile", "email"},
+				UsernameClaim: "preferred_username",
+				RolesClaim:    "groups",
+			},
 		},
 		Ingestion: IngestionConfig{
-			GRPCPort:      4317,
-			HTTPPort:      4318,
-			GRPCEnabled:   true,
-			HTTPEnabled:   true,
+			GRPCPort:    4317,
+			HTTPPort:    4318,
+			GRPCEnabled: true,
+			HTTPEnabled: true,
+			GRPCTLS:     defaultTLS(),
+			HTTPTLS:     defaultTLS(),
+			HTTPCORS: CORSConfig{
+				AllowedMethods: []string{"POST"},
+				MaxAge:         10 * time.Minute,
+			},
 			BatchSize:     1000,
 			FlushInterval: 5 * time.Second,
+			SpanMetrics: SpanMetricsConfig{
+				Enabled:       true,
+				FlushInterval: 15 * time.Second,
+				Buckets:       defaultSpanMetricsBuckets(),
+			},
+			LogPatterns: LogPatternsConfig{
+				Enabled:       true,
+				Depth:         2,
+				Similarity:    0.5,
+				MaxChildren:   100,
+				MaxPatterns:   1000,
+				Resolution:    5 * time.Minute,
+				Retention:     7 * 24 * time.Hour,
+				FlushInterval: 30 * time.Second,
+			},
+			Issues: IssuesConfig{
+				Enabled:       true,
+				MaxFrames:     30,
+				Retention:     30 * 24 * time.Hour,
+				FlushInterval: 10 * time.Second,
+			},
+			TailSampling: TailSamplingConfig{
+				DecisionWait:     10 * time.Second,
+				MaxTraces:        50000,
+				MaxSpansPerTrace: 1000,
+				DecisionCache:    time.Minute,
+				MaxDecisions:     200000,
+				Policies:         defaultSamplingPolicies(),
+			},
+			Auth: IngestAuthConfig{
+				Header:        "X-API-Key",
+				FlushInterval: time.Minute,
+			},
 		},
 		Web: WebConfig{
 			Enabled: true,
 			Title:   "Open-Telemorph-Prime",
 			Theme:   "light",
 		},
+		Alerting: AlertingConfig{
+			Enabled:            true,
+			EvaluationInterval: 30 * time.Second,
+			Notifications: NotificationConfig{
+				GroupBy:        []string{"alertname"},
+				GroupWait:      30 * time.Second,
+				GroupInterval:  5 * time.Minute,
+				RepeatInterval: 4 * time.Hour,
+				Timeout:        10 * time.Second,
+				MaxRetries:     5,
+				RetryBackoff:   time.Second,
+			},
+		},
+		Anomaly: AnomalyConfig{
+			Lookback:        28 * 24 * time.Hour,
+			RefreshInterval: time.Hour,
+			MinSamples:      3,
+			Threshold:       3,
+		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
 		},
 	}
+	cfg.Server.CORS.AllowedHeaders = serverCORSHeaders(cfg)
+	cfg.Ingestion.HTTPCORS.AllowedHeaders = ingestCORSHeaders(cfg)
+	return cfg
+}
+
+// serverCORSHeaders are the request headers the UI server reads
+func serverCORSHeaders(c *Config) []string {
+	return []string{"Content-Type", "Authorization", c.Tenancy.Header}
+}
+
+// ingestCORSHeaders are the request headers the OTLP HTTP receiver reads
+func ingestCORSHeaders(c *Config) []string {
+	return []string{"Content-Type", "Content-Encoding", "Authorization", c.Ingestion.Auth.Header, c.Tenancy.Header}
+}
+
+func defaultTLS() TLSConfig {
+	return TLSConfig{MinVersion: "1.2", ReloadInterval: 30 * time.Second}
+}
+
+func defaultRollupTiers() []RollupTierConfig {
+	return []RollupTierConfig{
+		{Resolution: time.Minute, RetentionDays: 90},
+		{Resolution: time.Hour, RetentionDays: 365},
+	}
+}
+
+// defaultSamplingPolicies keeps failed and slow traces and a tenth of the rest
+func defaultSamplingPolicies() []SamplingPolicyConfig {
+	return []SamplingPolicyConfig{
+		{Name: "errors", Type: "errors"},
+		{Name: "slow", Type: "latency", Threshold: time.Second},
+		{Name: "baseline", Type: "probabilistic", Rate: 0.1},
+	}
+}
+
+func defaultSpanMetricsBuckets() []time.Duration {
+	return []time.Duration{
+		2 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond, 8 * time.Millisecond,
+		10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+		400 * time.Millisecond, 800 * time.Millisecond, time.Second, 1400 * time.Millisecond,
+		2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second,
+	}
 }
@@ -0,0 +1,200 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"open-telemorph-prime/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrInvalidCORSConfig = errors.New("invalid CORS config")
+
+// originPattern matches origins whose host is a subdomain of a domain, from
+// a pattern such as https://*.example.com
+type originPattern struct {
+	prefix string // scheme and "://"
+	suffix string // "." and the domain, with any port
+}
+
+func (p originPattern) matches(origin string) bool {
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	// The wildcard stands for subdomain labels only
+	sub := origin[len(p.prefix) : len(origin)-len(p.suffix)]
+	return !strings.ContainsAny(sub, "/:@") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
+}
+
+// Policy answers CORS preflights and adds CORS headers to the responses of
+// a listener
+type Policy struct {
+	config   config.CORSConfig
+	any      bool // every origin is allowed
+	origins  map[string]bool
+	patterns []originPattern
+	methods  map[string]bool
+	headers  map[string]bool // canonical header names
+
+	allowMethods string
+	allowHeaders string
+	maxAge       string
+}
+
+// NewPolicy validates the origins of cfg
+func NewPolicy(cfg config.CORSConfig) (*Policy, error) {
+	p := &Policy{
+		config:  cfg,
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		switch {
+		case origin == "*":
+			if cfg.AllowCredentials {
+				return nil, fmt.Errorf("%w: allow_credentials cannot be used with the \"*\" origin", ErrInvalidCORSConfig)
+			}
+			p.any = true
+		case strings.Contains(origin, "://*."):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			if strings.Contains(suffix, "*") || !validOrigin(prefix+"sub"+suffix) {
+				return nil, fmt.Errorf("%w: invalid origin pattern %q", ErrInvalidCORSConfig, origin)
+			}
+			p.patterns = append(p.patterns, originPattern{prefix: prefix, suffix: suffix})
+		case strings.Contains(origin, "*"):
+			return nil, fmt.Errorf("%w: invalid origin pattern %q (want scheme://*.domain)", ErrInvalidCORSConfig, origin)
+		default:
+			if !validOrigin(origin) {
+				return nil, fmt.Errorf("%w: invalid origin %q (want scheme://host[:port])", ErrInvalidCORSConfig, origin)
+			}
+			p.origins[origin] = true
+		}
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, method := range cfg.AllowedMethods {
+		method = strings.ToUpper(method)
+		p.methods[method] = true
+		methods = append(methods, method)
+	}
+	headers := make([]string, 0, len(cfg.AllowedHeaders))
+	for _, header := range cfg.AllowedHeaders {
+		header = http.CanonicalHeaderKey(header)
+		if header == "" || p.headers[header] {
+			continue
+		}
+		p.headers[header] = true
+		headers = append(headers, header)
+	}
+	p.allowMethods = strings.Join(methods, ", ")
+	p.allowHeaders = strings.Join(headers, ", ")
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	return p, nil
+}
+
+// validOrigin reports whether origin is a scheme and host with an optional
+// port, as browsers send in the Origin header
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.User == nil &&
+		origin == u.Scheme+"://"+u.Host
+}
+
+// allowsOrigin reports whether requests from origin may read responses
+func (p *Policy) allowsOrigin(origin string) bool {
+	if p.any || p.origins[origin] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether path is under one of the excluded paths
+func (p *Policy) excluded(path string) bool {
+	for _, prefix := range p.config.ExcludePaths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware applies the policy. Preflights from allowed origins are answered
+// with 204 and refused with 403 otherwise; other requests are served, with
+// CORS headers only for allowed origins. Requests without an Origin header
+// and requests to excluded paths pass through untouched.
+func (p *Policy) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || p.excluded(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if preflight {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !p.allowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if p.any {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if p.config.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			c.Next()
+			return
+		}
+
+		if !p.methods[strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))] {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		for _, header := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if header != "" && !p.headers[header] {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		c.Header("Access-Control-Allow-Methods", p.allowMethods)
+		if p.allowHeaders != "" {
+			c.Header("Access-Control-Allow-Headers", p.allowHeaders)
+		}
+		if p.maxAge != "" {
+			c.Header("Access-Control-Max-Age", p.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
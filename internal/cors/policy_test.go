@@ -0,0 +1,169 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewPolicyValidatesOrigins(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		wantErr     bool
+	}{
+		{"none", nil, false, false},
+		{"exact", []string{"https://app.example.com"}, false, false},
+		{"with port", []string{"http://localhost:3000"}, false, false},
+		{"pattern", []string{"https://*.example.com"}, false, false},
+		{"any", []string{"*"}, false, false},
+		{"credentials with exact", []string{"https://app.example.com"}, true, false},
+		{"credentials with any", []string{"*"}, true, true},
+		{"trailing slash", []string{"https://app.example.com/"}, false, true},
+		{"path", []string{"https://app.example.com/ui"}, false, true},
+		{"no scheme", []string{"app.example.com"}, false, true},
+		{"user info", []string{"https://user@app.example.com"}, false, true},
+		{"two wildcards", []string{"https://*.*.example.com"}, false, true},
+		{"wildcard not a subdomain", []string{"https://*example.com"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicy(config.CORSConfig{AllowedOrigins: tt.origins, AllowCredentials: tt.credentials})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCORSConfig) {
+					t.Errorf("NewPolicy error = %v, want %v", err, ErrInvalidCORSConfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewPolicy: %v", err)
+			}
+		})
+	}
+}
+
+func TestPolicyAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{"none configured", nil, "https://app.example.com", false},
+		{"exact", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"other scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"other port", []string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{"subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"nested subdomain", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"pattern domain itself", []string{"https://*.example.com"}, "https://example.com", false},
+		{"pattern lookalike", []string{"https://*.example.com"}, "https://app.example.com.evil.io", false},
+		{"pattern suffix", []string{"https://*.example.com"}, "https://evilexample.com", false},
+		{"pattern scheme", []string{"https://*.example.com"}, "http://app.example.com", false},
+		{"pattern user info", []string{"https://*.example.com"}, "https://evil.io@x.example.com", false},
+		{"pattern with port", []string{"http://*.example.com:8080"}, "http://app.example.com:8080", true},
+		{"any", []string{"*"}, "https://anything.io", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(config.CORSConfig{AllowedOrigins: tt.origins})
+			if err != nil {
+				t.Fatalf("NewPolicy: %v", err)
+			}
+			if got := p.allowsOrigin(tt.origin); got != tt.want {
+				t.Errorf("allowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "x-scope-orgid"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		ExcludePaths:     []string{"/api/v1/admin"},
+	}
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	router := gin.New()
+	router.Use(p.Middleware())
+	router.Any("/*path", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		reqMethod   string // Access-Control-Request-Method
+		reqHeaders  string // Access-Control-Request-Headers
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"same origin", http.MethodGet, "/api/v1/logs", "", "", "", http.StatusOK, "", ""},
+		{"allowed origin", http.MethodGet, "/api/v1/logs", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com", ""},
+		{"other origin", http.MethodGet, "/api/v1/logs", "https://evil.io", "", "", http.StatusOK, "", ""},
+		{"excluded path", http.MethodGet, "/api/v1/admin/users", "https://app.example.com", "", "", http.StatusOK, "", ""},
+		{"preflight", http.MethodOptions, "/api/v1/logs", "https://app.example.com", "POST", "content-type, X-Scope-OrgID", http.StatusNoContent, "https://app.example.com", "GET, POST"},
+		{"preflight other origin", http.MethodOptions, "/api/v1/logs", "https://evil.io", "POST", "", http.StatusForbidden, "", ""},
+		{"preflight method", http.MethodOptions, "/api/v1/logs", "https://app.example.com", "DELETE", "", http.StatusForbidden, "https://app.example.com", ""},
+		{"preflight header", http.MethodOptions, "/api/v1/logs", "https://app.example.com", "GET", "X-Debug", http.StatusForbidden, "https://app.example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			if tt.reqHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			credentials := w.Header().Get("Access-Control-Allow-Credentials") == "true"
+			if credentials != (tt.wantOrigin != "") {
+				t.Errorf("Access-Control-Allow-Credentials = %v, want %v", credentials, tt.wantOrigin != "")
+			}
+			if tt.wantMethods != "" && w.Header().Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", w.Header().Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
+
+func TestDefaultServerPolicyIsSameOrigin(t *testing.T) {
+	p, err := NewPolicy(config.DefaultConfig().Server.CORS)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	if p.any || p.allowsOrigin("https://app.example.com") {
+		t.Error("the default server policy allows other origins")
+	}
+}
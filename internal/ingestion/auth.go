@@ -0,0 +1,53 @@
+package ingestion
+
+import (
+	"fmt"
+	"net/http"
+
+	"open-telemorph-prime/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeServices rejects a request whose API key or client certificate
+// may not write one of the services in it. Nothing of the request is stored
+// in that case.
+func authorizeServices(id *auth.Identity, services []string) error {
+	if id == nil {
+		return nil
+	}
+	for _, service := range services {
+		if !id.AllowsService(service) {
+			return fmt.Errorf("%w service %q", auth.ErrPermissionDenied, service)
+		}
+	}
+	return nil
+}
+
+// allowsService reports whether a record of service may be stored for the
+// identity. It is checked on the service name a record is stored under,
+// after transforms, which may rename it.
+func allowsService(id *auth.Identity, service string) bool {
+	return id == nil || id.AllowsService(service)
+}
+
+// respondExported answers an OTLP/HTTP export. Records rejected for their
+// service are reported as a partial success in field, which exporters log
+// and do not retry.
+func respondExported(c *gin.Context, rejected int, field string) {
+	if rejected == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "partial_success",
+		"partialSuccess": gin.H{
+			field:          rejected,
+			"errorMessage": rejectedMessage(rejected),
+		},
+	})
+}
+
+func rejectedMessage(rejected int) string {
+	return fmt.Sprintf("%d records rejected: %s their service", rejected, auth.ErrPermissionDenied)
+}
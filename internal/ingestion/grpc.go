@@ -0,0 +1,294 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"open-telemorph-prime/internal/auth"
+	"open-telemorph-prime/internal/tenancy"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// registerGRPC registers the OTLP collector services on a gRPC server. The
+// export requests are converted to the OTLP/JSON shape of the HTTP receiver
+// and stored the same way.
+func (s *Service) registerGRPC(server grpc.ServiceRegistrar) {
+	coltracepb.RegisterTraceServiceServer(server, &traceReceiver{s: s})
+	colmetricspb.RegisterMetricsServiceServer(server, &metricsReceiver{s: s})
+	collogspb.RegisterLogsServiceServer(server, &logsReceiver{s: s})
+}
+
+type traceReceiver struct {
+	coltracepb.UnimplementedTraceServiceServer
+	s *Service
+}
+
+func (r *traceReceiver) Export(ctx context.Context, in *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	tenant, err := r.s.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := tracesFromProto(in)
+	if err := authorizeServices(auth.IdentityFromContext(ctx), req.services()); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	resp := &coltracepb.ExportTraceServiceResponse{}
+	if rejected := r.s.processTraces(tenant, auth.IdentityFromContext(ctx), req); rejected > 0 {
+		resp.PartialSuccess = &coltracepb.ExportTracePartialSuccess{RejectedSpans: int64(rejected), ErrorMessage: rejectedMessage(rejected)}
+	}
+	return resp, nil
+}
+
+type metricsReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	s *Service
+}
+
+func (r *metricsReceiver) Export(ctx context.Context, in *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	tenant, err := r.s.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := metricsFromProto(in)
+	if err := authorizeServices(auth.IdentityFromContext(ctx), req.services()); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected := r.s.processMetrics(tenant, auth.IdentityFromContext(ctx), req); rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: int64(rejected), ErrorMessage: rejectedMessage(rejected)}
+	}
+	return resp, nil
+}
+
+type logsReceiver struct {
+	collogspb.UnimplementedLogsServiceServer
+	s *Service
+}
+
+func (r *logsReceiver) Export(ctx context.Context, in *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	tenant, err := r.s.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := logsFromProto(in)
+	if err := authorizeServices(auth.IdentityFromContext(ctx), req.services()); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected := r.s.processLogs(tenant, auth.IdentityFromContext(ctx), req); rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: int64(rejected), ErrorMessage: rejectedMessage(rejected)}
+	}
+	return resp, nil
+}
+
+// grpcTenant resolves the tenant of a call from its metadata, as the tenancy
+// middleware does from the header of an HTTP request. A key or certificate
+// mapping bound to a tenant assigns the call to it.
+func (s *Service) grpcTenant(ctx context.Context) (*tenancy.Tenant, error) {
+	id := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(strings.ToLower(s.tenants.Header())); len(values) > 0 {
+		id = values[0]
+	}
+	if identity := auth.IdentityFromContext(ctx); identity != nil && identity.Tenant != "" {
+		if id != "" && id != identity.Tenant {
+			return nil, status.Error(codes.PermissionDenied, tenancy.ErrTenantMismatch.Error())
+		}
+		id = identity.Tenant
+	}
+
+	t, err := s.tenants.Resolve(id)
+	if err != nil {
+		code := codes.Internal
+		switch {
+		case errors.Is(err, tenancy.ErrInvalidTenant):
+			code = codes.InvalidArgument
+		case errors.Is(err, tenancy.ErrTenantRequired):
+			code = codes.Unauthenticated
+		case errors.Is(err, tenancy.ErrUnknownTenant), errors.Is(err, tenancy.ErrTooManyTenants):
+			code = codes.PermissionDenied
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	return t, nil
+}
+
+// sized allocates a slice of the type of s, which lets the conversions below
+// fill the anonymous element types of the request structs
+func sized[S ~[]E, E any](s S, n int) S {
+	return make(S, n)
+}
+
+func tracesFromProto(in *coltracepb.ExportTraceServiceRequest) *tracesRequest {
+	req := &tracesRequest{}
+	req.ResourceSpans = sized(req.ResourceSpans, len(in.GetResourceSpans()))
+	for i, rs := range in.GetResourceSpans() {
+		out := &req.ResourceSpans[i]
+		out.Resource.Attributes = attributesFromProto(rs.GetResource().GetAttributes())
+		out.ScopeSpans = sized(out.ScopeSpans, len(rs.GetScopeSpans()))
+		for j, ss := range rs.GetScopeSpans() {
+			spans := sized(out.ScopeSpans[j].Spans, len(ss.GetSpans()))
+			for k, span := range ss.GetSpans() {
+				spans[k].TraceId = hex.EncodeToString(span.GetTraceId())
+				spans[k].SpanId = hex.EncodeToString(span.GetSpanId())
+				spans[k].ParentSpanId = hex.EncodeToString(span.GetParentSpanId())
+				spans[k].Name = span.GetName()
+				spans[k].Kind = []byte(strconv.Itoa(int(span.GetKind())))
+				spans[k].StartTimeUnixNano = unixNano(span.GetStartTimeUnixNano())
+				spans[k].EndTimeUnixNano = unixNano(span.GetEndTimeUnixNano())
+				spans[k].Status.Code = span.GetStatus().GetCode().String()
+				spans[k].Attributes = attributesFromProto(span.GetAttributes())
+				spans[k].Events = sized(spans[k].Events, len(span.GetEvents()))
+				for l, event := range span.GetEvents() {
+					spans[k].Events[l].TimeUnixNano = unixNano(event.GetTimeUnixNano())
+					spans[k].Events[l].Name = event.GetName()
+					spans[k].Events[l].Attributes = attributesFromProto(event.GetAttributes())
+				}
+			}
+			out.ScopeSpans[j].Spans = spans
+		}
+	}
+	return req
+}
+
+func metricsFromProto(in *colmetricspb.ExportMetricsServiceRequest) *metricsRequest {
+	req := &metricsRequest{}
+	req.ResourceMetrics = sized(req.ResourceMetrics, len(in.GetResourceMetrics()))
+	for i, rm := range in.GetResourceMetrics() {
+		out := &req.ResourceMetrics[i]
+		out.Resource.Attributes = attributesFromProto(rm.GetResource().GetAttributes())
+		out.ScopeMetrics = sized(out.ScopeMetrics, len(rm.GetScopeMetrics()))
+		for j, sm := range rm.GetScopeMetrics() {
+			metrics := sized(out.ScopeMetrics[j].Metrics, len(sm.GetMetrics()))
+			for k, metric := range sm.GetMetrics() {
+				metrics[k].Name = metric.GetName()
+
+				gauge := sized(metrics[k].Data.Gauge.DataPoints, len(metric.GetGauge().GetDataPoints()))
+				for l, dp := range metric.GetGauge().GetDataPoints() {
+					gauge[l].TimeUnixNano = unixNano(dp.GetTimeUnixNano())
+					gauge[l].AsDouble = dataPointValue(dp)
+					gauge[l].Attributes = attributesFromProto(dp.GetAttributes())
+					gauge[l].Exemplars = sized(gauge[l].Exemplars, len(dp.GetExemplars()))
+					for m, ex := range dp.GetExemplars() {
+						gauge[l].Exemplars[m].TimeUnixNano = unixNano(ex.GetTimeUnixNano())
+						gauge[l].Exemplars[m].AsDouble = exemplarValue(ex)
+						gauge[l].Exemplars[m].TraceID = hex.EncodeToString(ex.GetTraceId())
+						gauge[l].Exemplars[m].SpanID = hex.EncodeToString(ex.GetSpanId())
+						gauge[l].Exemplars[m].FilteredAttributes = attributesFromProto(ex.GetFilteredAttributes())
+					}
+				}
+				metrics[k].Data.Gauge.DataPoints = gauge
+
+				sum := sized(metrics[k].Data.Sum.DataPoints, len(metric.GetSum().GetDataPoints()))
+				for l, dp := range metric.GetSum().GetDataPoints() {
+					sum[l].TimeUnixNano = unixNano(dp.GetTimeUnixNano())
+					sum[l].AsDouble = dataPointValue(dp)
+					sum[l].Attributes = attributesFromProto(dp.GetAttributes())
+					sum[l].Exemplars = sized(sum[l].Exemplars, len(dp.GetExemplars()))
+					for m, ex := range dp.GetExemplars() {
+						sum[l].Exemplars[m].TimeUnixNano = unixNano(ex.GetTimeUnixNano())
+						sum[l].Exemplars[m].AsDouble = exemplarValue(ex)
+						sum[l].Exemplars[m].TraceID = hex.EncodeToString(ex.GetTraceId())
+						sum[l].Exemplars[m].SpanID = hex.EncodeToString(ex.GetSpanId())
+						sum[l].Exemplars[m].FilteredAttributes = attributesFromProto(ex.GetFilteredAttributes())
+					}
+				}
+				metrics[k].Data.Sum.DataPoints = sum
+			}
+			out.ScopeMetrics[j].Metrics = metrics
+		}
+	}
+	return req
+}
+
+func logsFromProto(in *collogspb.ExportLogsServiceRequest) *logsRequest {
+	req := &logsRequest{}
+	req.ResourceLogs = sized(req.ResourceLogs, len(in.GetResourceLogs()))
+	for i, rl := range in.GetResourceLogs() {
+		out := &req.ResourceLogs[i]
+		out.Resource.Attributes = attributesFromProto(rl.GetResource().GetAttributes())
+		out.ScopeLogs = sized(out.ScopeLogs, len(rl.GetScopeLogs()))
+		for j, sl := range rl.GetScopeLogs() {
+			records := sized(out.ScopeLogs[j].LogRecords, len(sl.GetLogRecords()))
+			for k, record := range sl.GetLogRecords() {
+				ts := record.GetTimeUnixNano()
+				if ts == 0 {
+					ts = record.GetObservedTimeUnixNano()
+				}
+				records[k].TimeUnixNano = unixNano(ts)
+				records[k].SeverityText = record.GetSeverityText()
+				records[k].Body.StringValue = anyValueString(record.GetBody())
+				records[k].Attributes = attributesFromProto(record.GetAttributes())
+				records[k].TraceId = hex.EncodeToString(record.GetTraceId())
+				records[k].SpanId = hex.EncodeToString(record.GetSpanId())
+			}
+			out.ScopeLogs[j].LogRecords = records
+		}
+	}
+	return req
+}
+
+// attributesFromProto converts protobuf attributes to their OTLP/JSON form,
+// keeping scalar values as strings as the HTTP receiver does
+func attributesFromProto(kvs []*commonpb.KeyValue) attributes {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := sized(attributes(nil), len(kvs))
+	for i, kv := range kvs {
+		attrs[i].Key = kv.GetKey()
+		attrs[i].Value.StringValue = anyValueString(kv.GetValue())
+	}
+	return attrs
+}
+
+// anyValueString renders a scalar value as text. Arrays, maps and bytes have
+// no OTLP/JSON string form the receiver stores and read as empty.
+func anyValueString(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+func dataPointValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+func exemplarValue(ex *metricspb.Exemplar) float64 {
+	if v, ok := ex.GetValue().(*metricspb.Exemplar_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return ex.GetAsDouble()
+}
+
+// unixNano formats a timestamp as the decimal string of OTLP/JSON, empty
+// when unset
+func unixNano(ns uint64) string {
+	if ns == 0 {
+		return ""
+	}
+	return strconv.FormatUint(ns, 10)
+}
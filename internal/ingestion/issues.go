@@ -0,0 +1,78 @@
+package ingestion
+
+import (
+	"strings"
+	"time"
+
+	"open-telemorph-prime/internal/issues"
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.27.0"
+)
+
+// errorSeverities are the severity text prefixes of logs tracked as issues
+var errorSeverities = []string{"ERROR", "FATAL", "CRITICAL", "PANIC", "ALERT", "EMERG"}
+
+// attributes is the OTLP/JSON attribute list shared by spans, events and logs
+type attributes = []struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func attributeValue(attrs attributes, key string) string {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// isErrorLog reports whether a log is tracked as an issue: it has an error
+// severity or records an exception
+func isErrorLog(severityText string, attrs attributes) bool {
+	severity := strings.ToUpper(severityText)
+	for _, prefix := range errorSeverities {
+		if strings.HasPrefix(severity, prefix) {
+			return true
+		}
+	}
+	return attributeValue(attrs, string(semconv.ExceptionTypeKey)) != ""
+}
+
+// observeException records an exception from the exception.* attributes of a
+// span event
+func (s *Service) observeException(tenant *tenancy.Tenant, resource storage.ServiceResource, traceID, spanID string, ts time.Time, attrs attributes) {
+	tenant.Issues.Observe(issues.Occurrence{
+		Service:    resource.ServiceName,
+		Version:    resource.Version,
+		Type:       attributeValue(attrs, string(semconv.ExceptionTypeKey)),
+		Message:    attributeValue(attrs, string(semconv.ExceptionMessageKey)),
+		Stacktrace: attributeValue(attrs, string(semconv.ExceptionStacktraceKey)),
+		TraceID:    traceID,
+		SpanID:     spanID,
+		Timestamp:  ts,
+	})
+}
+
+// observeErrorLog records an error log, preferring its exception.*
+// attributes and falling back to the body as the message
+func (s *Service) observeErrorLog(tenant *tenancy.Tenant, resource storage.ServiceResource, traceID, spanID string, ts time.Time, body string, attrs attributes) {
+	message := attributeValue(attrs, string(semconv.ExceptionMessageKey))
+	if message == "" {
+		message = body
+	}
+	tenant.Issues.Observe(issues.Occurrence{
+		Service:    resource.ServiceName,
+		Version:    resource.Version,
+		Type:       attributeValue(attrs, string(semconv.ExceptionTypeKey)),
+		Message:    message,
+		Stacktrace: attributeValue(attrs, string(semconv.ExceptionStacktraceKey)),
+		TraceID:    traceID,
+		SpanID:     spanID,
+		Timestamp:  ts,
+	})
+}
@@ -6,41 +6,103 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
+	"open-telemorph-prime/internal/auth"
 	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/cors"
 	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/redaction"
 	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+	"open-telemorph-prime/internal/tlsconfig"
+	"open-telemorph-prime/internal/transform"
 
 	"github.com/gin-gonic/gin"
 	semconv "go.opentelemetry.io/otel/semconv/v1.27.0"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type Service struct {
-	storage    storage.Storage
+	tenants    *tenancy.Registry
+	auth       *auth.Authenticator
 	config     config.IngestionConfig
 	httpServer *http.Server
 	grpcServer *grpc.Server
 	logger     *zap.Logger
+
+	// httpTLS and grpcTLS serve the receivers' certificates, nil when a
+	// receiver listens in plaintext
+	httpTLS *tlsconfig.Reloader
+	grpcTLS *tlsconfig.Reloader
+
+	// cors lets browser SDKs on allowed origins send to the HTTP receiver
+	cors *cors.Policy
+
+	redactor  *redaction.Redactor
+	transform *transform.Transformer
+
+	// spanMetrics holds a processor per tenant, created on the tenant's
+	// first span
+	spanMetricsMu sync.Mutex
+	spanMetrics   map[string]*spanMetricsProcessor
+	started       bool
 }
 
-func NewService(storage storage.Storage, config config.IngestionConfig) *Service {
+func NewService(tenants *tenancy.Registry, authenticator *auth.Authenticator, config config.IngestionConfig, redactor *redaction.Redactor, transformer *transform.Transformer) *Service {
 	return &Service{
-		storage: storage,
-		config:  config,
-		logger:  logger.Get(),
+		tenants:     tenants,
+		auth:        authenticator,
+		config:      config,
+		logger:      logger.Get(),
+		redactor:    redactor,
+		transform:   transformer,
+		spanMetrics: make(map[string]*spanMetricsProcessor),
 	}
 }
 
 func (s *Service) Start() error {
+	policy, err := cors.NewPolicy(s.config.HTTPCORS)
+	if err != nil {
+		return err
+	}
+	s.cors = policy
+
+	if s.config.HTTPEnabled && s.config.HTTPTLS.Enabled {
+		r, err := tlsconfig.NewReloader("otlp_http", s.config.HTTPTLS)
+		if err != nil {
+			return err
+		}
+		s.httpTLS = r
+		s.httpTLS.Start()
+	}
+	if s.config.GRPCEnabled && s.config.GRPCTLS.Enabled {
+		r, err := tlsconfig.NewReloader("otlp_grpc", s.config.GRPCTLS)
+		if err != nil {
+			return err
+		}
+		s.grpcTLS = r
+		s.grpcTLS.Start()
+	}
+
+	s.spanMetricsMu.Lock()
+	for _, p := range s.spanMetrics {
+		p.start()
+	}
+	s.started = true
+	s.spanMetricsMu.Unlock()
+
 	// Start HTTP server for OTLP HTTP endpoints if enabled
 	if s.config.HTTPEnabled {
 		go s.startHTTPServer()
 		s.logger.Info("OTLP HTTP server enabled",
 			zap.Int("port", s.config.HTTPPort),
 			zap.String("protocol", "http"),
+			zap.Bool("tls", s.httpTLS != nil),
 		)
 	} else {
 		s.logger.Info("OTLP HTTP server disabled")
@@ -52,6 +114,7 @@ func (s *Service) Start() error {
 		s.logger.Info("OTLP gRPC server enabled",
 			zap.Int("port", s.config.GRPCPort),
 			zap.String("protocol", "grpc"),
+			zap.Bool("tls", s.grpcTLS != nil),
 		)
 	} else {
 		s.logger.Info("OTLP gRPC server disabled")
@@ -65,9 +128,11 @@ func (s *Service) startHTTPServer() {
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
+	router.Use(s.cors.Middleware())
 
-	// OTLP HTTP endpoints
-	otlp := router.Group("/v1")
+	// OTLP HTTP endpoints. Authentication runs first, since an API key may
+	// assign the request's tenant.
+	otlp := router.Group("/v1", s.auth.Middleware(), s.tenants.Middleware())
 	{
 		otlp.POST("/traces", s.HandleTraces)
 		otlp.POST("/metrics", s.HandleMetrics)
@@ -84,7 +149,14 @@ func (s *Service) startHTTPServer() {
 		zap.Int("port", s.config.HTTPPort),
 		zap.String("protocol", "http"),
 	)
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var err error
+	if s.httpTLS != nil {
+		s.httpServer.TLSConfig = s.httpTLS.Config()
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		s.logger.Error("Failed to start OTLP HTTP server",
 			zap.Error(err),
 			zap.Int("port", s.config.HTTPPort),
@@ -104,14 +176,15 @@ func (s *Service) startGRPCServer() {
 	}
 
 	// Create gRPC server
-	s.grpcServer = grpc.NewServer()
-
-	// TODO: Register OTLP gRPC services here
-	// For now, we'll just start the server without any services
-	// In a full implementation, you would register:
-	// - traceservice.RegisterTraceServiceServer(s.grpcServer, s)
-	// - metricsservice.RegisterMetricsServiceServer(s.grpcServer, s)
-	// - logsservice.RegisterLogsServiceServer(s.grpcServer, s)
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.auth.UnaryInterceptor()),
+		grpc.StreamInterceptor(s.auth.StreamInterceptor()),
+	}
+	if s.grpcTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.grpcTLS.Config())))
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+	s.registerGRPC(s.grpcServer)
 
 	s.logger.Info("Starting OTLP gRPC server",
 		zap.Int("port", s.config.GRPCPort),
@@ -142,56 +215,262 @@ func (s *Service) Stop(ctx context.Context) error {
 		s.grpcServer.GracefulStop()
 	}
 
+	if s.httpTLS != nil {
+		s.httpTLS.Stop()
+		s.httpTLS = nil
+	}
+	if s.grpcTLS != nil {
+		s.grpcTLS.Stop()
+		s.grpcTLS = nil
+	}
+
+	// Flush span metrics accumulated since the last interval
+	s.spanMetricsMu.Lock()
+	for _, p := range s.spanMetrics {
+		p.stop()
+	}
+	s.spanMetrics = make(map[string]*spanMetricsProcessor)
+	s.started = false
+	s.spanMetricsMu.Unlock()
+
 	return nil
 }
 
-// HTTP handlers for OTLP endpoints
-func (s *Service) HandleTraces(c *gin.Context) {
-	var req struct {
-		ResourceSpans []struct {
-			Resource struct {
+// tracesRequest, metricsRequest and logsRequest are the OTLP export
+// requests as decoded from OTLP/JSON and converted from OTLP/gRPC
+type tracesRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceId           string          `json:"traceId"`
+				SpanId            string          `json:"spanId"`
+				ParentSpanId      string          `json:"parentSpanId"`
+				Name              string          `json:"name"`
+				Kind              json.RawMessage `json:"kind"`
+				StartTimeUnixNano string          `json:"startTimeUnixNano"`
+				EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+				Status            struct {
+					Code string `json:"code"`
+				} `json:"status"`
 				Attributes []struct {
 					Key   string `json:"key"`
 					Value struct {
 						StringValue string `json:"stringValue"`
 					} `json:"value"`
 				} `json:"attributes"`
-			} `json:"resource"`
-			ScopeSpans []struct {
-				Spans []struct {
-					TraceId           string `json:"traceId"`
-					SpanId            string `json:"spanId"`
-					ParentSpanId      string `json:"parentSpanId"`
-					Name              string `json:"name"`
-					StartTimeUnixNano string `json:"startTimeUnixNano"`
-					EndTimeUnixNano   string `json:"endTimeUnixNano"`
-					Status            struct {
-						Code string `json:"code"`
-					} `json:"status"`
-					Attributes []struct {
+				Events []struct {
+					TimeUnixNano string `json:"timeUnixNano"`
+					Name         string `json:"name"`
+					Attributes   []struct {
 						Key   string `json:"key"`
 						Value struct {
 							StringValue string `json:"stringValue"`
 						} `json:"value"`
 					} `json:"attributes"`
-				} `json:"spans"`
-			} `json:"scopeSpans"`
-		} `json:"resourceSpans"`
+				} `json:"events"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// services lists the service of each resource in the request
+func (req *tracesRequest) services() []string {
+	services := make([]string, 0, len(req.ResourceSpans))
+	for _, resourceSpan := range req.ResourceSpans {
+		services = append(services, extractServiceNameFromResource(resourceSpan.Resource))
 	}
+	return services
+}
 
+type metricsRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []struct {
+				Name string `json:"name"`
+				Data struct {
+					Gauge struct {
+						DataPoints []struct {
+							TimeUnixNano string  `json:"timeUnixNano"`
+							AsDouble     float64 `json:"asDouble"`
+							Attributes   []struct {
+								Key   string `json:"key"`
+								Value struct {
+									StringValue string `json:"stringValue"`
+								} `json:"value"`
+							} `json:"attributes"`
+							Exemplars []struct {
+								TimeUnixNano       string  `json:"timeUnixNano"`
+								AsDouble           float64 `json:"asDouble"`
+								TraceID            string  `json:"traceId"`
+								SpanID             string  `json:"spanId"`
+								FilteredAttributes []struct {
+									Key   string `json:"key"`
+									Value struct {
+										StringValue string `json:"stringValue"`
+									} `json:"value"`
+								} `json:"filteredAttributes"`
+							} `json:"exemplars"`
+						} `json:"dataPoints"`
+					} `json:"gauge"`
+					Sum struct {
+						DataPoints []struct {
+							TimeUnixNano string  `json:"timeUnixNano"`
+							AsDouble     float64 `json:"asDouble"`
+							Attributes   []struct {
+								Key   string `json:"key"`
+								Value struct {
+									StringValue string `json:"stringValue"`
+								} `json:"value"`
+							} `json:"attributes"`
+							Exemplars []struct {
+								TimeUnixNano       string  `json:"timeUnixNano"`
+								AsDouble           float64 `json:"asDouble"`
+								TraceID            string  `json:"traceId"`
+								SpanID             string  `json:"spanId"`
+								FilteredAttributes []struct {
+									Key   string `json:"key"`
+									Value struct {
+										StringValue string `json:"stringValue"`
+									} `json:"value"`
+								} `json:"filteredAttributes"`
+							} `json:"exemplars"`
+						} `json:"dataPoints"`
+					} `json:"sum"`
+				} `json:"data"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+// services lists the service of each resource in the request
+func (req *metricsRequest) services() []string {
+	services := make([]string, 0, len(req.ResourceMetrics))
+	for _, resourceMetric := range req.ResourceMetrics {
+		services = append(services, extractServiceNameFromResource(resourceMetric.Resource))
+	}
+	return services
+}
+
+type logsRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []struct {
+				TimeUnixNano string `json:"timeUnixNano"`
+				SeverityText string `json:"severityText"`
+				Body         struct {
+					StringValue string `json:"stringValue"`
+				} `json:"body"`
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+				TraceId string `json:"traceId"`
+				SpanId  string `json:"spanId"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+// services lists the service of each resource in the request
+func (req *logsRequest) services() []string {
+	services := make([]string, 0, len(req.ResourceLogs))
+	for _, resourceLog := range req.ResourceLogs {
+		services = append(services, extractServiceNameFromResource(resourceLog.Resource))
+	}
+	return services
+}
+
+// HTTP handlers for OTLP endpoints
+func (s *Service) HandleTraces(c *gin.Context) {
+	var req tracesRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := authorizeServices(auth.FromContext(c), req.services()); err != nil {
+		auth.Abort(c, err)
+		return
+	}
+
+	rejected := s.processTraces(tenancy.FromContext(c), auth.FromContext(c), &req)
+	respondExported(c, rejected, "rejectedSpans")
+}
 
+// processTraces stores the spans of an export request in a tenant. Spans
+// whose service, after transforms, the identity may not write are rejected;
+// their number is returned.
+func (s *Service) processTraces(tenant *tenancy.Tenant, id *auth.Identity, req *tracesRequest) int {
+	rejected := 0
 	// Process traces
 	for _, resourceSpan := range req.ResourceSpans {
+		resourceSpan.Resource.Attributes = s.redactor.Attributes(redaction.ScopeResource, resourceSpan.Resource.Attributes)
 		serviceName := extractServiceNameFromResource(resourceSpan.Resource)
+		resource := extractServiceResource(resourceSpan.Resource)
+		s.observeResource(tenant, resource)
 
 		for _, scopeSpan := range resourceSpan.ScopeSpans {
 			for _, span := range scopeSpan.Spans {
-				startTime, _ := time.Parse(time.RFC3339Nano, span.StartTimeUnixNano)
-				endTime, _ := time.Parse(time.RFC3339Nano, span.EndTimeUnixNano)
+				serviceName, resource := serviceName, resource
+				if s.transform.Spans() {
+					rec := newRecord(resourceSpan.Resource.Attributes, span.Attributes, map[string]interface{}{
+						"name":           span.Name,
+						"kind":           spanKindName(span.Kind),
+						"status.code":    span.Status.Code,
+						"trace_id":       span.TraceId,
+						"span_id":        span.SpanId,
+						"parent_span_id": span.ParentSpanId,
+					})
+					s.transform.Span(rec)
+					if rec.Dropped {
+						continue
+					}
+					span.Name = transform.String(rec.Fields["name"])
+					span.Kind, _ = json.Marshal(transform.String(rec.Fields["kind"]))
+					span.Status.Code = transform.String(rec.Fields["status.code"])
+					span.TraceId = transform.String(rec.Fields["trace_id"])
+					span.SpanId = transform.String(rec.Fields["span_id"])
+					span.ParentSpanId = transform.String(rec.Fields["parent_span_id"])
+					span.Attributes = fromAttributeMap(rec.Attributes)
+					resource = s.transformedResource(tenant, rec.Resource, resource)
+					serviceName = resource.ServiceName
+				}
+				if !allowsService(id, serviceName) {
+					rejected++
+					continue
+				}
+
+				span.Attributes = s.redactor.Attributes(redaction.ScopeSpan, span.Attributes)
+				for i := range span.Events {
+					span.Events[i].Attributes = s.redactor.Attributes(redaction.ScopeSpan, span.Events[i].Attributes)
+				}
+				startTime := parseTimestamp(span.StartTimeUnixNano)
+				endTime := parseTimestamp(span.EndTimeUnixNano)
 
 				trace := &storage.Trace{
 					TraceID:       span.TraceId,
@@ -208,88 +487,80 @@ func (s *Service) HandleTraces(c *gin.Context) {
 					trace.ParentSpanID = &span.ParentSpanId
 				}
 
-				if err := s.storage.InsertTrace(trace); err != nil {
-					s.logger.Error("Failed to insert trace",
-						zap.Error(err),
-						zap.String("trace_id", trace.TraceID),
-						zap.String("span_id", trace.SpanID),
-					)
+				// Span metrics and issues see every span, sampled or not
+				tenant.Sampler.Add(trace)
+
+				if p := s.spanMetricsFor(tenant); p != nil {
+					p.observe(trace, spanKindName(span.Kind))
+				}
+
+				for _, event := range span.Events {
+					if event.Name == semconv.ExceptionEventName {
+						s.observeException(tenant, resource, span.TraceId, span.SpanId, parseTimestamp(event.TimeUnixNano), event.Attributes)
+					}
 				}
 			}
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	return rejected
 }
 
 func (s *Service) HandleMetrics(c *gin.Context) {
-	var req struct {
-		ResourceMetrics []struct {
-			Resource struct {
-				Attributes []struct {
-					Key   string `json:"key"`
-					Value struct {
-						StringValue string `json:"stringValue"`
-					} `json:"value"`
-				} `json:"attributes"`
-			} `json:"resource"`
-			ScopeMetrics []struct {
-				Metrics []struct {
-					Name string `json:"name"`
-					Data struct {
-						Gauge struct {
-							DataPoints []struct {
-								TimeUnixNano string  `json:"timeUnixNano"`
-								AsDouble     float64 `json:"asDouble"`
-								Attributes   []struct {
-									Key   string `json:"key"`
-									Value struct {
-										StringValue string `json:"stringValue"`
-									} `json:"value"`
-								} `json:"attributes"`
-							} `json:"dataPoints"`
-						} `json:"gauge"`
-						Sum struct {
-							DataPoints []struct {
-								TimeUnixNano string  `json:"timeUnixNano"`
-								AsDouble     float64 `json:"asDouble"`
-								Attributes   []struct {
-									Key   string `json:"key"`
-									Value struct {
-										StringValue string `json:"stringValue"`
-									} `json:"value"`
-								} `json:"attributes"`
-							} `json:"dataPoints"`
-						} `json:"sum"`
-					} `json:"data"`
-				} `json:"metrics"`
-			} `json:"scopeMetrics"`
-		} `json:"resourceMetrics"`
-	}
-
+	var req metricsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := authorizeServices(auth.FromContext(c), req.services()); err != nil {
+		auth.Abort(c, err)
+		return
+	}
 
+	rejected := s.processMetrics(tenancy.FromContext(c), auth.FromContext(c), &req)
+	respondExported(c, rejected, "rejectedDataPoints")
+}
+
+// processMetrics stores the metric datapoints of an export request in a
+// tenant, rejecting those of services the identity may not write as
+// processTraces does
+func (s *Service) processMetrics(tenant *tenancy.Tenant, id *auth.Identity, req *metricsRequest) int {
+	rejected := 0
 	// Process metrics
 	for _, resourceMetric := range req.ResourceMetrics {
-		serviceName := extractServiceNameFromResource(resourceMetric.Resource)
+		resourceMetric.Resource.Attributes = s.redactor.Attributes(redaction.ScopeResource, resourceMetric.Resource.Attributes)
+		resource := extractServiceResource(resourceMetric.Resource)
+		s.observeResource(tenant, resource)
 
 		for _, scopeMetric := range resourceMetric.ScopeMetrics {
 			for _, metric := range scopeMetric.Metrics {
 				// Handle gauge metrics
 				for _, dataPoint := range metric.Data.Gauge.DataPoints {
-					timestamp, _ := time.Parse(time.RFC3339Nano, dataPoint.TimeUnixNano)
+					name, value, resource := metric.Name, dataPoint.AsDouble, resource
+					if s.transform.Datapoints() {
+						var ok bool
+						name, value, resource, ok = s.transformDatapoint(tenant, name, value, &dataPoint.Attributes, resourceMetric.Resource.Attributes, resource)
+						if !ok {
+							continue
+						}
+					}
+					if !allowsService(id, resource.ServiceName) {
+						rejected++
+						continue
+					}
+					if !tenant.Limits.AllowMetric(resource.ServiceName) {
+						continue
+					}
+					timestamp := parseTimestamp(dataPoint.TimeUnixNano)
 					metricData := &storage.Metric{
-						MetricName:  metric.Name,
-						Value:       dataPoint.AsDouble,
+						MetricName:  name,
+						Value:       value,
 						Timestamp:   timestamp,
-						ServiceName: serviceName,
+						ServiceName: resource.ServiceName,
 						Labels:      convertAttributesToJSON(dataPoint.Attributes),
+						Exemplars:   convertExemplars(dataPoint.Exemplars),
 					}
 
-					if err := s.storage.InsertMetric(metricData); err != nil {
+					if err := tenant.Storage.InsertMetric(metricData); err != nil {
 						s.logger.Error("Failed to insert metric",
 							zap.Error(err),
 							zap.String("metric_name", metricData.MetricName),
@@ -300,16 +571,32 @@ func (s *Service) HandleMetrics(c *gin.Context) {
 
 				// Handle sum metrics
 				for _, dataPoint := range metric.Data.Sum.DataPoints {
-					timestamp, _ := time.Parse(time.RFC3339Nano, dataPoint.TimeUnixNano)
+					name, value, resource := metric.Name, dataPoint.AsDouble, resource
+					if s.transform.Datapoints() {
+						var ok bool
+						name, value, resource, ok = s.transformDatapoint(tenant, name, value, &dataPoint.Attributes, resourceMetric.Resource.Attributes, resource)
+						if !ok {
+							continue
+						}
+					}
+					if !allowsService(id, resource.ServiceName) {
+						rejected++
+						continue
+					}
+					if !tenant.Limits.AllowMetric(resource.ServiceName) {
+						continue
+					}
+					timestamp := parseTimestamp(dataPoint.TimeUnixNano)
 					metricData := &storage.Metric{
-						MetricName:  metric.Name,
-						Value:       dataPoint.AsDouble,
+						MetricName:  name,
+						Value:       value,
 						Timestamp:   timestamp,
-						ServiceName: serviceName,
+						ServiceName: resource.ServiceName,
 						Labels:      convertAttributesToJSON(dataPoint.Attributes),
+						Exemplars:   convertExemplars(dataPoint.Exemplars),
 					}
 
-					if err := s.storage.InsertMetric(metricData); err != nil {
+					if err := tenant.Storage.InsertMetric(metricData); err != nil {
 						s.logger.Error("Failed to insert metric",
 							zap.Error(err),
 							zap.String("metric_name", metricData.MetricName),
@@ -320,53 +607,70 @@ func (s *Service) HandleMetrics(c *gin.Context) {
 			}
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	return rejected
 }
 
 func (s *Service) HandleLogs(c *gin.Context) {
-	var req struct {
-		ResourceLogs []struct {
-			Resource struct {
-				Attributes []struct {
-					Key   string `json:"key"`
-					Value struct {
-						StringValue string `json:"stringValue"`
-					} `json:"value"`
-				} `json:"attributes"`
-			} `json:"resource"`
-			ScopeLogs []struct {
-				LogRecords []struct {
-					TimeUnixNano string `json:"timeUnixNano"`
-					SeverityText string `json:"severityText"`
-					Body         struct {
-						StringValue string `json:"stringValue"`
-					} `json:"body"`
-					Attributes []struct {
-						Key   string `json:"key"`
-						Value struct {
-							StringValue string `json:"stringValue"`
-						} `json:"value"`
-					} `json:"attributes"`
-					TraceId string `json:"traceId"`
-					SpanId  string `json:"spanId"`
-				} `json:"logRecords"`
-			} `json:"scopeLogs"`
-		} `json:"resourceLogs"`
-	}
-
+	var req logsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := authorizeServices(auth.FromContext(c), req.services()); err != nil {
+		auth.Abort(c, err)
+		return
+	}
 
+	rejected := s.processLogs(tenancy.FromContext(c), auth.FromContext(c), &req)
+	respondExported(c, rejected, "rejectedLogRecords")
+}
+
+// processLogs stores the log records of an export request in a tenant,
+// rejecting those of services the identity may not write as processTraces
+// does
+func (s *Service) processLogs(tenant *tenancy.Tenant, id *auth.Identity, req *logsRequest) int {
+	rejected := 0
 	// Process logs
 	for _, resourceLog := range req.ResourceLogs {
+		resourceLog.Resource.Attributes = s.redactor.Attributes(redaction.ScopeResource, resourceLog.Resource.Attributes)
 		serviceName := extractServiceNameFromResource(resourceLog.Resource)
+		resource := extractServiceResource(resourceLog.Resource)
+		s.observeResource(tenant, resource)
 
 		for _, scopeLog := range resourceLog.ScopeLogs {
 			for _, logRecord := range scopeLog.LogRecords {
-				timestamp, _ := time.Parse(time.RFC3339Nano, logRecord.TimeUnixNano)
+				serviceName, resource := serviceName, resource
+				if s.transform.Logs() {
+					rec := newRecord(resourceLog.Resource.Attributes, logRecord.Attributes, map[string]interface{}{
+						"body":          logRecord.Body.StringValue,
+						"severity_text": logRecord.SeverityText,
+						"trace_id":      logRecord.TraceId,
+						"span_id":       logRecord.SpanId,
+					})
+					s.transform.Log(rec)
+					if rec.Dropped {
+						continue
+					}
+					logRecord.Body.StringValue = transform.String(rec.Fields["body"])
+					logRecord.SeverityText = transform.String(rec.Fields["severity_text"])
+					logRecord.TraceId = transform.String(rec.Fields["trace_id"])
+					logRecord.SpanId = transform.String(rec.Fields["span_id"])
+					logRecord.Attributes = fromAttributeMap(rec.Attributes)
+					resource = s.transformedResource(tenant, rec.Resource, resource)
+					serviceName = resource.ServiceName
+				}
+				if !allowsService(id, serviceName) {
+					rejected++
+					continue
+				}
+				// Limits apply to the record as stored, after transforms
+				if !tenant.Limits.AllowLog(serviceName, logRecord.SeverityText, logRecord.TraceId) {
+					continue
+				}
+
+				logRecord.Attributes = s.redactor.Attributes(redaction.ScopeLog, logRecord.Attributes)
+				logRecord.Body.StringValue = s.redactor.Body(logRecord.Body.StringValue)
+				timestamp := parseTimestamp(logRecord.TimeUnixNano)
 
 				logData := &storage.Log{
 					Timestamp:   timestamp,
@@ -382,19 +686,23 @@ func (s *Service) HandleLogs(c *gin.Context) {
 				if logRecord.SpanId != "" {
 					logData.SpanID = &logRecord.SpanId
 				}
+				logData.PatternID = tenant.Patterns.Assign(serviceName, logData.Message, timestamp)
 
-				if err := s.storage.InsertLog(logData); err != nil {
+				if err := tenant.Storage.InsertLog(logData); err != nil {
 					s.logger.Error("Failed to insert log",
 						zap.Error(err),
 						zap.String("service_name", logData.ServiceName),
 						zap.String("level", logData.Level),
 					)
 				}
+
+				if isErrorLog(logRecord.SeverityText, logRecord.Attributes) {
+					s.observeErrorLog(tenant, resource, logRecord.TraceId, logRecord.SpanId, timestamp, logRecord.Body.StringValue, logRecord.Attributes)
+				}
 			}
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	return rejected
 }
 
 // Helper functions
@@ -418,6 +726,106 @@ func extractServiceNameFromResource(resource struct {
 	return "unknown"
 }
 
+// extractServiceResource reads the service identity attributes used by the
+// service catalog. Both the current and the deprecated deployment environment
+// keys are accepted.
+func extractServiceResource(resource struct {
+	Attributes []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+}) storage.ServiceResource {
+	res := storage.ServiceResource{ServiceName: extractServiceNameFromResource(resource)}
+	for _, attr := range resource.Attributes {
+		switch attr.Key {
+		case string(semconv.ServiceVersionKey):
+			res.Version = attr.Value.StringValue
+		case string(semconv.ServiceInstanceIDKey):
+			res.InstanceID = attr.Value.StringValue
+		case string(semconv.DeploymentEnvironmentNameKey), "deployment.environment":
+			res.Environment = attr.Value.StringValue
+		}
+	}
+	return res
+}
+
+func (s *Service) observeResource(tenant *tenancy.Tenant, res storage.ServiceResource) {
+	if err := tenant.Storage.ObserveResource(res); err != nil {
+		s.logger.Error("Failed to record service resource",
+			zap.Error(err),
+			zap.String("tenant", tenant.ID),
+			zap.String("service_name", res.ServiceName),
+		)
+	}
+}
+
+// spanMetricsFor returns the span metrics processor of a tenant, or nil when
+// span metrics are disabled
+func (s *Service) spanMetricsFor(tenant *tenancy.Tenant) *spanMetricsProcessor {
+	if !s.config.SpanMetrics.Enabled {
+		return nil
+	}
+
+	s.spanMetricsMu.Lock()
+	defer s.spanMetricsMu.Unlock()
+
+	p, ok := s.spanMetrics[tenant.ID]
+	if !ok {
+		p = newSpanMetricsProcessor(tenant.Storage, s.config.SpanMetrics, s.logger)
+		if s.started {
+			p.start()
+		}
+		s.spanMetrics[tenant.ID] = p
+	}
+	return p
+}
+
+// parseTimestamp parses an OTLP/JSON timestamp, which is a decimal string of
+// nanoseconds since the epoch. RFC 3339 strings are accepted as well, and
+// missing or malformed values fall back to the time of receipt.
+func parseTimestamp(value string) time.Time {
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil && nanos > 0 {
+		return time.Unix(0, nanos)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// convertExemplars keeps the exemplars of a datapoint, which link it to the
+// trace and span an example measurement was taken in
+func convertExemplars(exemplars []struct {
+	TimeUnixNano       string  `json:"timeUnixNano"`
+	AsDouble           float64 `json:"asDouble"`
+	TraceID            string  `json:"traceId"`
+	SpanID             string  `json:"spanId"`
+	FilteredAttributes []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"filteredAttributes"`
+}) []*storage.Exemplar {
+	if len(exemplars) == 0 {
+		return nil
+	}
+
+	result := make([]*storage.Exemplar, 0, len(exemplars))
+	for _, e := range exemplars {
+		result = append(result, &storage.Exemplar{
+			Timestamp:  parseTimestamp(e.TimeUnixNano),
+			Value:      e.AsDouble,
+			TraceID:    e.TraceID,
+			SpanID:     e.SpanID,
+			Attributes: convertAttributesToJSON(e.FilteredAttributes),
+		})
+	}
+	return result
+}
+
 func convertAttributesToJSON(attributes []struct {
 	Key   string `json:"key"`
 	Value struct {
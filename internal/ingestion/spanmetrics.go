@@ -0,0 +1,225 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// Names of the metrics generated from spans
+const (
+	spanMetricCalls         = "traces.span.metrics.calls"
+	spanMetricErrors        = "traces.span.metrics.errors"
+	spanMetricDurationSum   = "traces.span.metrics.duration_sum"
+	spanMetricDurationCount = "traces.span.metrics.duration_count"
+	spanMetricDurationBkt   = "traces.span.metrics.duration_bucket"
+)
+
+// spanKinds maps OTLP span kind enum values to their names
+var spanKinds = []string{
+	"SPAN_KIND_UNSPECIFIED",
+	"SPAN_KIND_INTERNAL",
+	"SPAN_KIND_SERVER",
+	"SPAN_KIND_CLIENT",
+	"SPAN_KIND_PRODUCER",
+	"SPAN_KIND_CONSUMER",
+}
+
+// spanKindName normalizes an OTLP/JSON span kind, which encoders emit either
+// as the enum number or as its name
+func spanKindName(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return spanKinds[0]
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err == nil {
+		if n >= 0 && n < len(spanKinds) {
+			return spanKinds[n]
+		}
+		return spanKinds[0]
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil && name != "" {
+		return name
+	}
+	return spanKinds[0]
+}
+
+type spanMetricsSeries struct {
+	service string
+	labels  string
+	calls   int64
+	errors  int64
+	sum     float64 // seconds
+	buckets []int64
+}
+
+// spanMetricsProcessor aggregates request, error and duration (RED) metrics
+// per service, operation, span kind, status and configured dimensions, and
+// writes them to metric storage as deltas once per flush interval
+type spanMetricsProcessor struct {
+	storage storage.Storage
+	config  config.SpanMetricsConfig
+	logger  *zap.Logger
+
+	mu     sync.Mutex
+	series map[string]*spanMetricsSeries
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSpanMetricsProcessor(storage storage.Storage, cfg config.SpanMetricsConfig, logger *zap.Logger) *spanMetricsProcessor {
+	return &spanMetricsProcessor{
+		storage: storage,
+		config:  cfg,
+		logger:  logger,
+		series:  make(map[string]*spanMetricsSeries),
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *spanMetricsProcessor) start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.config.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.flush()
+			case <-p.done:
+				p.flush()
+				return
+			}
+		}
+	}()
+}
+
+func (p *spanMetricsProcessor) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// observe counts one span. Extra dimensions are taken from the span's
+// attributes; spans without a dimension's attribute omit that label.
+func (p *spanMetricsProcessor) observe(trace *storage.Trace, kind string) {
+	status := trace.StatusCode
+	if status == "" {
+		status = "STATUS_CODE_UNSET"
+	}
+
+	dims := map[string]string{
+		"span.name":   trace.OperationName,
+		"span.kind":   kind,
+		"status.code": status,
+	}
+	if len(p.config.Dimensions) > 0 {
+		var attrs map[string]string
+		json.Unmarshal([]byte(trace.Attributes), &attrs)
+		for _, name := range p.config.Dimensions {
+			if value, ok := attrs[name]; ok {
+				dims[name] = value
+			}
+		}
+	}
+	labels, _ := json.Marshal(dims)
+
+	seconds := float64(trace.DurationNanos) / float64(time.Second)
+	key := trace.ServiceName + "\x00" + string(labels)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.series[key]
+	if !ok {
+		s = &spanMetricsSeries{
+			service: trace.ServiceName,
+			labels:  string(labels),
+			buckets: make([]int64, len(p.config.Buckets)),
+		}
+		p.series[key] = s
+	}
+	s.calls++
+	if storage.IsErrorStatus(trace.StatusCode) {
+		s.errors++
+	}
+	s.sum += seconds
+	for i, bound := range p.config.Buckets {
+		if seconds <= bound.Seconds() {
+			s.buckets[i]++
+		}
+	}
+}
+
+// flush writes the series observed since the last flush. Histogram buckets
+// are cumulative, with "le" in seconds as in Prometheus.
+func (p *spanMetricsProcessor) flush() {
+	p.mu.Lock()
+	series := p.series
+	p.series = make(map[string]*spanMetricsSeries)
+	p.mu.Unlock()
+
+	if len(series) == 0 {
+		return
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := series[key]
+		p.write(spanMetricCalls, s.service, s.labels, float64(s.calls), now)
+		p.write(spanMetricErrors, s.service, s.labels, float64(s.errors), now)
+		p.write(spanMetricDurationSum, s.service, s.labels, s.sum, now)
+		p.write(spanMetricDurationCount, s.service, s.labels, float64(s.calls), now)
+		for i, bound := range p.config.Buckets {
+			p.write(spanMetricDurationBkt, s.service, withLabel(s.labels, "le", formatBound(bound)), float64(s.buckets[i]), now)
+		}
+		p.write(spanMetricDurationBkt, s.service, withLabel(s.labels, "le", "+Inf"), float64(s.calls), now)
+	}
+}
+
+func (p *spanMetricsProcessor) write(name, service, labels string, value float64, ts time.Time) {
+	metric := &storage.Metric{
+		MetricName:  name,
+		Value:       value,
+		Timestamp:   ts,
+		ServiceName: service,
+		Labels:      labels,
+	}
+	if err := p.storage.InsertMetric(metric); err != nil {
+		p.logger.Error("Failed to insert span metric",
+			zap.Error(err),
+			zap.String("metric_name", name),
+			zap.String("service_name", service),
+		)
+	}
+}
+
+// withLabel adds a label to canonical label JSON, keeping keys sorted
+func withLabel(labels, name, value string) string {
+	dims := make(map[string]string)
+	json.Unmarshal([]byte(labels), &dims)
+	dims[name] = value
+	data, _ := json.Marshal(dims)
+	return string(data)
+}
+
+func formatBound(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
@@ -0,0 +1,80 @@
+package ingestion
+
+import (
+	"sort"
+
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+	"open-telemorph-prime/internal/transform"
+)
+
+// newRecord prepares a span, log or datapoint for the transformer. The
+// record gets its own copy of the resource attributes.
+func newRecord(resourceAttrs, attrs attributes, fields map[string]interface{}) *transform.Record {
+	return &transform.Record{
+		Fields:     fields,
+		Attributes: attributeMap(attrs),
+		Resource:   attributeMap(resourceAttrs),
+	}
+}
+
+func attributeMap(attrs attributes) map[string]interface{} {
+	m := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value.StringValue
+	}
+	return m
+}
+
+// fromAttributeMap converts transformed attributes back, encoding values
+// that are no longer strings
+func fromAttributeMap(m map[string]interface{}) attributes {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make(attributes, len(keys))
+	for i, key := range keys {
+		attrs[i].Key = key
+		attrs[i].Value.StringValue = transform.String(m[key])
+	}
+	return attrs
+}
+
+// transformedResource reads the service identity from a record's transformed
+// resource attributes, recording it in the service catalog when a statement
+// changed it
+func (s *Service) transformedResource(tenant *tenancy.Tenant, attrs map[string]interface{}, original storage.ServiceResource) storage.ServiceResource {
+	var resource struct {
+		Attributes attributes `json:"attributes"`
+	}
+	resource.Attributes = fromAttributeMap(attrs)
+
+	res := extractServiceResource(resource)
+	if res != original {
+		s.observeResource(tenant, res)
+	}
+	return res
+}
+
+// transformDatapoint runs the metric statements on a gauge or sum datapoint,
+// updating its attributes in place. It returns the datapoint's metric name,
+// value and resource, and false when it was dropped.
+func (s *Service) transformDatapoint(tenant *tenancy.Tenant, name string, value float64, attrs *attributes, resourceAttrs attributes, resource storage.ServiceResource) (string, float64, storage.ServiceResource, bool) {
+	rec := newRecord(resourceAttrs, *attrs, map[string]interface{}{
+		"metric.name": name,
+		"value":       value,
+	})
+	s.transform.Datapoint(rec)
+	if rec.Dropped {
+		return "", 0, resource, false
+	}
+
+	*attrs = fromAttributeMap(rec.Attributes)
+	if v, ok := transform.Float(rec.Fields["value"]); ok {
+		value = v
+	}
+	return transform.String(rec.Fields["metric.name"]), value, s.transformedResource(tenant, rec.Resource, resource), true
+}
@@ -0,0 +1,109 @@
+package issues
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// file.go:42, index.js:10:15
+	lineNumberRe = regexp.MustCompile(`:\d+(:\d+)?\b`)
+	// File "app.py", line 42, in handler
+	pythonLineRe = regexp.MustCompile(`,? line \d+`)
+	// Go argument words and pc offsets, JVM and native addresses
+	addressRe = regexp.MustCompile(`\+?0x[0-9a-fA-F]+`)
+)
+
+// pythonTraceback starts Python tracebacks, which list the innermost frame
+// last
+const pythonTraceback = "Traceback (most recent call last)"
+
+// frames normalizes the frames of a stack trace so occurrences of the same
+// exception agree: line numbers and addresses are removed, along with lines
+// repeating the message, which vary between occurrences. At most max frames
+// are kept, innermost first.
+func frames(stacktrace, message string, max int) []string {
+	lines := strings.Split(stacktrace, "\n")
+	innermostLast := strings.Contains(stacktrace, pythonTraceback)
+
+	var frames []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "",
+			strings.HasPrefix(line, pythonTraceback),
+			strings.HasPrefix(line, "goroutine "),
+			strings.HasPrefix(line, "..."):
+			continue
+		case message != "" && strings.Contains(line, message):
+			continue
+		}
+
+		// Causes carry their own message
+		if rest, ok := strings.CutPrefix(line, "Caused by: "); ok {
+			typ, _, _ := strings.Cut(rest, ": ")
+			line = "Caused by: " + typ
+		}
+
+		line = addressRe.ReplaceAllString(line, "")
+		line = pythonLineRe.ReplaceAllString(line, "")
+		line = lineNumberRe.ReplaceAllString(line, "")
+		frames = append(frames, line)
+	}
+
+	if innermostLast {
+		for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+			frames[i], frames[j] = frames[j], frames[i]
+		}
+	}
+	if len(frames) > max {
+		frames = frames[:max]
+	}
+	return frames
+}
+
+// culprit describes the innermost frame that names code
+func culprit(frames []string) string {
+	for _, frame := range frames {
+		if rest, ok := strings.CutPrefix(frame, "at "); ok {
+			return rest
+		}
+		if strings.HasPrefix(frame, "File ") {
+			return frame
+		}
+	}
+	if len(frames) > 0 {
+		return frames[0]
+	}
+	return ""
+}
+
+// normalizeMessage replaces the tokens of a message that hold digits, such
+// as IDs, counts and durations, so messages without a stack trace group by
+// their wording
+func normalizeMessage(message string) string {
+	tokens := strings.Fields(message)
+	for i, tok := range tokens {
+		if strings.IndexFunc(tok, unicode.IsDigit) >= 0 {
+			tokens[i] = "<*>"
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// fingerprint identifies an issue by the exception type and its normalized
+// frames, or its normalized message when there are no frames
+func fingerprint(typ string, frames []string, message string) string {
+	h := fnv.New64a()
+	h.Write([]byte(typ))
+	h.Write([]byte{0})
+	if len(frames) > 0 {
+		h.Write([]byte(strings.Join(frames, "\n")))
+	} else {
+		h.Write([]byte(normalizeMessage(message)))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,140 @@
+package issues
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFramesIgnoreLineNumbersAndAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		a, b    string
+	}{
+		{
+			"java",
+			"user 42 not found",
+			`java.lang.IllegalStateException: user 42 not found
+	at com.shop.UserService.load(UserService.java:42)
+	at com.shop.Api.handle(Api.java:118)
+Caused by: java.sql.SQLException: timeout after 30s
+	at com.zaxxer.Pool.get(Pool.java:7)`,
+			`java.lang.IllegalStateException: user 42 not found
+	at com.shop.UserService.load(UserService.java:57)
+	at com.shop.Api.handle(Api.java:121)
+Caused by: java.sql.SQLException: timeout after 10s
+	at com.zaxxer.Pool.get(Pool.java:9)`,
+		},
+		{
+			"go",
+			"",
+			`goroutine 7 [running]:
+main.handler(0xc000012345, 0x10)
+	/app/main.go:42 +0x1d
+main.main()
+	/app/main.go:10 +0x25`,
+			`goroutine 31 [running]:
+main.handler(0xc0000abcde, 0x2)
+	/app/main.go:44 +0x2f
+main.main()
+	/app/main.go:11 +0x27`,
+		},
+		{
+			"python",
+			"",
+			`Traceback (most recent call last):
+  File "app.py", line 12, in main
+  File "db.py", line 80, in query
+KeyError: 'id'`,
+			`Traceback (most recent call last):
+  File "app.py", line 14, in main
+  File "db.py", line 95, in query
+KeyError: 'id'`,
+		},
+		{
+			"javascript",
+			"",
+			`TypeError: x is undefined
+    at render (app.js:10:15)
+    at main (index.js:3:1)`,
+			`TypeError: x is undefined
+    at render (app.js:12:7)
+    at main (index.js:4:2)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fa, fb := frames(tt.a, tt.message, 30), frames(tt.b, tt.message, 30)
+			if strings.Join(fa, "\n") != strings.Join(fb, "\n") {
+				t.Errorf("frames differ:\n%q\n%q", fa, fb)
+			}
+			if fingerprint("Error", fa, tt.message) != fingerprint("Error", fb, tt.message) {
+				t.Error("fingerprints differ")
+			}
+			for _, frame := range fa {
+				if strings.Contains(frame, "0x") {
+					t.Errorf("address left in frame %q", frame)
+				}
+			}
+		})
+	}
+}
+
+func TestFramesOrder(t *testing.T) {
+	python := frames("Traceback (most recent call last):\n  File \"app.py\", line 12, in main\n  File \"db.py\", line 80, in query\n", "", 30)
+	if len(python) != 2 || !strings.Contains(python[0], "db.py") {
+		t.Errorf("python frames = %q, want the innermost frame first", python)
+	}
+	if got := culprit(python); !strings.Contains(got, "db.py") {
+		t.Errorf("culprit = %q", got)
+	}
+
+	java := frames("Error: boom\n\tat a.B.c(B.java:1)\n\tat a.D.e(D.java:2)", "boom", 30)
+	if got := culprit(java); got != "a.B.c(B.java)" {
+		t.Errorf("culprit = %q, want a.B.c(B.java)", got)
+	}
+}
+
+func TestFramesDistinguishCode(t *testing.T) {
+	a := frames("Error\n\tat a.B.c(B.java:1)\n\tat a.D.e(D.java:2)", "", 30)
+	b := frames("Error\n\tat a.B.c(B.java:1)\n\tat a.F.g(F.java:2)", "", 30)
+	if fingerprint("Error", a, "") == fingerprint("Error", b, "") {
+		t.Error("different call paths share a fingerprint")
+	}
+	if fingerprint("Error", a, "") == fingerprint("TypeError", a, "") {
+		t.Error("different exception types share a fingerprint")
+	}
+}
+
+func TestMaxFramesTruncates(t *testing.T) {
+	var trace strings.Builder
+	trace.WriteString("Error: deep\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&trace, "\tat pkg.Frame%d(F.java:%d)\n", i, i+1)
+	}
+	deep := trace.String()
+
+	got := frames(deep, "deep", 5)
+	if len(got) != 5 || got[0] != "at pkg.Frame0(F.java)" {
+		t.Errorf("frames = %q, want the 5 innermost", got)
+	}
+
+	// Frames past the limit do not change the fingerprint
+	other := strings.Replace(deep, "Frame25(", "FrameOther(", 1)
+	if fingerprint("Error", frames(deep, "deep", 5), "") != fingerprint("Error", frames(other, "deep", 5), "") {
+		t.Error("a frame past max_frames changed the fingerprint")
+	}
+	if fingerprint("Error", frames(deep, "deep", 30), "") == fingerprint("Error", frames(other, "deep", 30), "") {
+		t.Error("a frame within max_frames did not change the fingerprint")
+	}
+}
+
+func TestMessageFingerprintWithoutFrames(t *testing.T) {
+	if fingerprint("Error", nil, "order 123 failed after 3 retries") != fingerprint("Error", nil, "order 456 failed after 5 retries") {
+		t.Error("messages differing in numbers got different fingerprints")
+	}
+	if fingerprint("Error", nil, "order 123 failed") == fingerprint("Error", nil, "order 123 shipped") {
+		t.Error("different messages share a fingerprint")
+	}
+}
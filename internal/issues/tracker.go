@@ -0,0 +1,325 @@
+package issues
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// pruneInterval is how often issues past the retention are dropped
+const pruneInterval = time.Hour
+
+var (
+	ErrIssueNotFound = errors.New("issue not found")
+	ErrInvalidStatus = errors.New("invalid issue status")
+)
+
+// Occurrence is one exception seen at ingest, from a span event or a log
+type Occurrence struct {
+	Service    string
+	Version    string
+	Type       string
+	Message    string
+	Stacktrace string
+	TraceID    string
+	SpanID     string
+	Timestamp  time.Time
+}
+
+// Query selects issues by Status and Service (all when empty), sorted by
+// Sort (last_seen, first_seen or count, descending), returning at most Limit
+// (all when 0)
+type Query struct {
+	Status  string
+	Service string
+	Sort    string
+	Limit   int
+}
+
+// Tracker groups exception occurrences into issues by fingerprint and keeps
+// their status, writing changes back to storage every flush interval
+type Tracker struct {
+	storage storage.Storage
+	config  config.IssuesConfig
+	logger  *zap.Logger
+
+	mu     sync.Mutex
+	issues map[string]*storage.Issue
+	dirty  map[string]bool
+	pruned time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTracker creates a tracker and restores the stored issues. Stored issues
+// stay available while tracking is disabled.
+func NewTracker(store storage.Storage, cfg config.IssuesConfig) (*Tracker, error) {
+	t := &Tracker{
+		storage: store,
+		config:  cfg,
+		logger:  logger.Get(),
+		issues:  make(map[string]*storage.Issue),
+		dirty:   make(map[string]bool),
+		pruned:  time.Now(),
+		done:    make(chan struct{}),
+	}
+
+	stored, err := store.GetIssues()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load issues: %w", err)
+	}
+	for _, issue := range stored {
+		t.issues[issue.ID] = issue
+	}
+	return t, nil
+}
+
+func (t *Tracker) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.flush()
+				if now := time.Now(); now.Sub(t.pruned) >= pruneInterval {
+					t.prune(now)
+					t.pruned = now
+				}
+			case <-t.done:
+				t.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop writes back the changes since the last flush
+func (t *Tracker) Stop() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+// Observe adds an occurrence to its issue, opening the issue on first sight
+// and marking it regressed when it occurs again after being resolved
+func (t *Tracker) Observe(o Occurrence) {
+	if !t.config.Enabled {
+		return
+	}
+	now := time.Now()
+	if o.Timestamp.IsZero() || o.Timestamp.After(now) {
+		o.Timestamp = now
+	}
+
+	frames := frames(o.Stacktrace, o.Message, t.config.MaxFrames)
+	id := fingerprint(o.Type, frames, o.Message)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	issue, ok := t.issues[id]
+	if !ok {
+		issue = &storage.Issue{
+			ID:         id,
+			Type:       o.Type,
+			Message:    o.Message,
+			Culprit:    culprit(frames),
+			Stacktrace: o.Stacktrace,
+			Status:     storage.IssueUnresolved,
+			FirstSeen:  o.Timestamp,
+			LastSeen:   o.Timestamp,
+			Services:   []string{},
+			Versions:   []string{},
+		}
+		t.issues[id] = issue
+	}
+
+	issue.Count++
+	if o.Timestamp.Before(issue.FirstSeen) {
+		issue.FirstSeen = o.Timestamp
+	}
+	if o.Timestamp.After(issue.LastSeen) {
+		issue.LastSeen = o.Timestamp
+	}
+	if issue.Stacktrace == "" {
+		issue.Stacktrace = o.Stacktrace
+	}
+	issue.Services = insertSorted(issue.Services, o.Service)
+	issue.Versions = insertSorted(issue.Versions, o.Version)
+	if o.TraceID != "" {
+		issue.SampleTraceID, issue.SampleSpanID = o.TraceID, o.SpanID
+	}
+
+	if issue.Status == storage.IssueResolved && issue.ResolvedAt != nil && o.Timestamp.After(*issue.ResolvedAt) {
+		issue.Status = storage.IssueRegressed
+		regressedAt := o.Timestamp
+		issue.RegressedAt = &regressedAt
+		t.logger.Info("Issue regressed",
+			zap.String("issue", issue.ID),
+			zap.String("type", issue.Type),
+			zap.String("service_name", o.Service),
+		)
+	}
+	t.dirty[id] = true
+}
+
+// Issues lists the issues selected by q
+func (t *Tracker) Issues(q Query) []*storage.Issue {
+	t.mu.Lock()
+	issues := []*storage.Issue{}
+	for _, issue := range t.issues {
+		if q.Status != "" && issue.Status != q.Status {
+			continue
+		}
+		if q.Service != "" && !contains(issue.Services, q.Service) {
+			continue
+		}
+		issues = append(issues, clone(issue))
+	}
+	t.mu.Unlock()
+
+	sort.Slice(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		switch q.Sort {
+		case "count":
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+		case "first_seen":
+			if !a.FirstSeen.Equal(b.FirstSeen) {
+				return a.FirstSeen.After(b.FirstSeen)
+			}
+		}
+		return a.LastSeen.After(b.LastSeen)
+	})
+	if q.Limit > 0 && len(issues) > q.Limit {
+		issues = issues[:q.Limit]
+	}
+	return issues
+}
+
+func (t *Tracker) Issue(id string) (*storage.Issue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	issue, ok := t.issues[id]
+	if !ok {
+		return nil, ErrIssueNotFound
+	}
+	return clone(issue), nil
+}
+
+// SetStatus resolves, ignores or reopens an issue. Ignored issues keep
+// counting occurrences but never regress.
+func (t *Tracker) SetStatus(id, status string) (*storage.Issue, error) {
+	switch status {
+	case storage.IssueUnresolved, storage.IssueResolved, storage.IssueIgnored:
+	default:
+		return nil, fmt.Errorf("%w: %q (want unresolved, resolved or ignored)", ErrInvalidStatus, status)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	issue, ok := t.issues[id]
+	if !ok {
+		return nil, ErrIssueNotFound
+	}
+
+	issue.Status = status
+	issue.RegressedAt = nil
+	issue.ResolvedAt = nil
+	if status == storage.IssueResolved {
+		now := time.Now()
+		issue.ResolvedAt = &now
+	}
+	t.dirty[id] = true
+	return clone(issue), nil
+}
+
+// flush writes the issues changed since the last flush
+func (t *Tracker) flush() {
+	t.mu.Lock()
+	changed := make([]*storage.Issue, 0, len(t.dirty))
+	for id := range t.dirty {
+		if issue, ok := t.issues[id]; ok {
+			changed = append(changed, clone(issue))
+		}
+	}
+	t.dirty = make(map[string]bool)
+	t.mu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	if err := t.storage.SaveIssues(changed); err != nil {
+		t.logger.Error("Failed to write issues",
+			zap.Error(err),
+			zap.Int("count", len(changed)),
+		)
+	}
+}
+
+// prune drops issues not seen within the retention
+func (t *Tracker) prune(now time.Time) {
+	cutoff := now.Add(-t.config.Retention)
+
+	t.mu.Lock()
+	for id, issue := range t.issues {
+		if issue.LastSeen.Before(cutoff) {
+			delete(t.issues, id)
+			delete(t.dirty, id)
+		}
+	}
+	t.mu.Unlock()
+
+	if err := t.storage.PruneIssues(cutoff); err != nil {
+		t.logger.Error("Failed to prune issues", zap.Error(err))
+	}
+}
+
+func clone(issue *storage.Issue) *storage.Issue {
+	copied := *issue
+	copied.Services = append([]string{}, issue.Services...)
+	copied.Versions = append([]string{}, issue.Versions...)
+	if issue.ResolvedAt != nil {
+		resolvedAt := *issue.ResolvedAt
+		copied.ResolvedAt = &resolvedAt
+	}
+	if issue.RegressedAt != nil {
+		regressedAt := *issue.RegressedAt
+		copied.RegressedAt = &regressedAt
+	}
+	return &copied
+}
+
+// insertSorted adds value to a sorted list unless it is empty or present
+func insertSorted(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	i := sort.SearchStrings(list, value)
+	if i < len(list) && list[i] == value {
+		return list
+	}
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = value
+	return list
+}
+
+func contains(list []string, value string) bool {
+	i := sort.SearchStrings(list, value)
+	return i < len(list) && list[i] == value
+}
@@ -0,0 +1,83 @@
+package issues
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// emptyStorage is a storage without stored issues
+type emptyStorage struct {
+	storage.Storage
+}
+
+func (emptyStorage) GetIssues() ([]*storage.Issue, error) {
+	return nil, nil
+}
+
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+	cfg := config.DefaultConfig().Ingestion.Issues
+	cfg.Enabled = true
+	tracker, err := NewTracker(emptyStorage{}, cfg)
+	if err != nil {
+		t.Fatalf("NewTracker: %v", err)
+	}
+	return tracker
+}
+
+func TestObserveGroupsOccurrences(t *testing.T) {
+	tracker := newTestTracker(t)
+	now := time.Now()
+
+	occurrences := []Occurrence{
+		{Service: "api", Version: "1.2", Type: "NullPointerException", Message: "order 1 missing", Stacktrace: "NullPointerException: order 1 missing\n\tat shop.Orders.get(Orders.java:10)", TraceID: "t1", SpanID: "s1", Timestamp: now.Add(-time.Minute)},
+		{Service: "worker", Version: "1.3", Type: "NullPointerException", Message: "order 2 missing", Stacktrace: "NullPointerException: order 2 missing\n\tat shop.Orders.get(Orders.java:12)", TraceID: "t2", SpanID: "s2", Timestamp: now},
+		{Service: "api", Type: "NullPointerException", Message: "cart empty", Stacktrace: "NullPointerException: cart empty\n\tat shop.Cart.total(Cart.java:3)", Timestamp: now},
+	}
+	for _, o := range occurrences {
+		tracker.Observe(o)
+	}
+
+	issues := tracker.Issues(Query{Sort: "count"})
+	if len(issues) != 2 {
+		t.Fatalf("%d issues, want 2", len(issues))
+	}
+	issue := issues[0]
+	if issue.Count != 2 || issue.Culprit != "shop.Orders.get(Orders.java)" || issue.SampleTraceID != "t2" {
+		t.Errorf("issue = %+v", issue)
+	}
+	if len(issue.Services) != 2 || len(issue.Versions) != 2 || !issue.FirstSeen.Equal(now.Add(-time.Minute)) {
+		t.Errorf("services %v, versions %v, first seen %v", issue.Services, issue.Versions, issue.FirstSeen)
+	}
+}
+
+func TestResolvedIssueRegresses(t *testing.T) {
+	tracker := newTestTracker(t)
+	o := Occurrence{Service: "api", Type: "TimeoutError", Message: "db timeout after 30s"}
+	tracker.Observe(o)
+	id := tracker.Issues(Query{})[0].ID
+
+	if _, err := tracker.SetStatus(id, "fixed"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("SetStatus error = %v, want %v", err, ErrInvalidStatus)
+	}
+	if _, err := tracker.SetStatus("missing", storage.IssueResolved); !errors.Is(err, ErrIssueNotFound) {
+		t.Errorf("SetStatus error = %v, want %v", err, ErrIssueNotFound)
+	}
+	if _, err := tracker.SetStatus(id, storage.IssueResolved); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	tracker.Observe(o)
+	issue, err := tracker.Issue(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue.Status != storage.IssueRegressed || issue.RegressedAt == nil || issue.Count != 2 {
+		t.Errorf("issue after a new occurrence = %+v", issue)
+	}
+}
@@ -0,0 +1,175 @@
+package patterns
+
+import (
+	"container/list"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// wildcard replaces the variable tokens of a template
+const wildcard = "<*>"
+
+// node is an inner node of a Drain parse tree, or a leaf holding patterns
+type node struct {
+	children map[string]*node
+	patterns []*pattern
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// tree is the Drain parse tree of one service. Messages are routed by token
+// count, then by their leading tokens; tokens holding digits take the
+// wildcard branch, as does any token once a node has no room left.
+type tree struct {
+	lengths map[int]*node
+	lru     *list.List // *pattern, most recently seen first
+}
+
+func newTree() *tree {
+	return &tree{lengths: make(map[int]*node), lru: list.New()}
+}
+
+type pattern struct {
+	id        string
+	service   string
+	tokens    []string
+	total     int64
+	firstSeen time.Time
+	lastSeen  time.Time
+	counts    map[int64]int64 // bucket start (unix nanos) -> logs
+	dirty     map[int64]bool  // buckets changed since the last flush
+	changed   bool            // template or totals changed since the last flush
+
+	leaf *node
+	elem *list.Element
+}
+
+func (p *pattern) template() string {
+	return strings.Join(p.tokens, " ")
+}
+
+// tokenize splits a message on whitespace, replacing tokens that look like
+// numbers, IDs or addresses with the wildcard up front
+func tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, tok := range tokens {
+		if isVariable(tok) {
+			tokens[i] = wildcard
+		}
+	}
+	return tokens
+}
+
+// isVariable reports whether a token is made of digits, hex digits and
+// separators only, with at least one digit
+func isVariable(tok string) bool {
+	digit := false
+	for _, r := range tok {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F', strings.ContainsRune(".:-_/+,=x#", r):
+		default:
+			return false
+		}
+	}
+	return digit
+}
+
+func hasDigit(tok string) bool {
+	return strings.IndexFunc(tok, unicode.IsDigit) >= 0
+}
+
+// descend returns the leaf for tokens. With create set, missing nodes are
+// added; otherwise nil is returned when there is no such leaf.
+func (t *tree) descend(tokens []string, depth, maxChildren int, create bool) *node {
+	n, ok := t.lengths[len(tokens)]
+	if !ok {
+		if !create {
+			return nil
+		}
+		n = newNode()
+		t.lengths[len(tokens)] = n
+	}
+
+	for i := 0; i < depth && i < len(tokens); i++ {
+		key := tokens[i]
+		if hasDigit(key) {
+			key = wildcard
+		}
+		child, ok := n.children[key]
+		if !ok && key != wildcard {
+			// Keep one branch free for the wildcard
+			if !create || len(n.children) >= maxChildren-1 {
+				key = wildcard
+			}
+			child, ok = n.children[key]
+		}
+		if !ok {
+			if !create {
+				return nil
+			}
+			child = newNode()
+			n.children[key] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// match returns the leaf pattern most similar to tokens, if it reaches
+// threshold. Ties go to the pattern with more wildcards.
+func (n *node) match(tokens []string, threshold float64) *pattern {
+	var best *pattern
+	bestSim, bestParams := -1.0, -1
+	for _, p := range n.patterns {
+		sim, params := similarity(p.tokens, tokens)
+		if sim > bestSim || (sim == bestSim && params > bestParams) {
+			best, bestSim, bestParams = p, sim, params
+		}
+	}
+	if best == nil || bestSim < threshold {
+		return nil
+	}
+	return best
+}
+
+// similarity is the fraction of positions where tokens equal the template,
+// and the number of template wildcards. A wildcard only matches a token that
+// was itself replaced by the wildcard.
+func similarity(template, tokens []string) (float64, int) {
+	if len(template) == 0 {
+		return 1, 0
+	}
+	same, params := 0, 0
+	for i, tok := range template {
+		if tok == wildcard {
+			params++
+		}
+		if tok == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(template)), params
+}
+
+// merge generalizes the template to cover tokens
+func (p *pattern) merge(tokens []string) {
+	for i, tok := range p.tokens {
+		if tok != wildcard && tok != tokens[i] {
+			p.tokens[i] = wildcard
+		}
+	}
+}
+
+func (n *node) remove(p *pattern) {
+	for i, existing := range n.patterns {
+		if existing == p {
+			n.patterns = append(n.patterns[:i], n.patterns[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package patterns
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// pruneInterval is how often counts older than the retention are dropped
+const pruneInterval = time.Hour
+
+// Pattern is a catalog entry. Count is the number of matching logs within
+// the queried window, Total since the pattern was first seen.
+type Pattern struct {
+	ID          string    `json:"id"`
+	ServiceName string    `json:"service_name"`
+	Template    string    `json:"template"`
+	Count       int64     `json:"count"`
+	Total       int64     `json:"total"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Trend is a pattern's counts per step over the window, compared with the
+// preceding window of the same length. Change is the relative difference,
+// null when the pattern had no logs in the preceding window.
+type Trend struct {
+	Pattern
+	Previous int64         `json:"previous"`
+	Change   *float64      `json:"change"`
+	Points   []*TrendPoint `json:"points"`
+}
+
+type TrendPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count"`
+}
+
+// Query selects the patterns of Service (all when empty) over the Window
+// ending now, returning at most Limit (all when 0)
+type Query struct {
+	Service string
+	Window  time.Duration
+	Limit   int
+}
+
+// TrendQuery selects the patterns listed in IDs, or else the top patterns of
+// Query, and counts them per Step
+type TrendQuery struct {
+	Query
+	IDs  []string
+	Step time.Duration
+}
+
+// Miner assigns ingested log messages to Drain patterns and keeps the
+// pattern catalog with per-bucket counts, writing changes back to storage
+// every flush interval
+type Miner struct {
+	storage storage.Storage
+	config  config.LogPatternsConfig
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	trees    map[string]*tree
+	patterns map[string]*pattern
+	pruned   time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewMiner creates a miner and restores the stored pattern catalog. Stored
+// patterns are kept queryable while mining is disabled.
+func NewMiner(store storage.Storage, cfg config.LogPatternsConfig) (*Miner, error) {
+	m := &Miner{
+		storage:  store,
+		config:   cfg,
+		logger:   logger.Get(),
+		trees:    make(map[string]*tree),
+		patterns: make(map[string]*pattern),
+		pruned:   time.Now(),
+		done:     make(chan struct{}),
+	}
+	if err := m.restore(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Miner) restore() error {
+	stored, err := m.storage.GetLogPatterns()
+	if err != nil {
+		return fmt.Errorf("failed to load log patterns: %w", err)
+	}
+
+	// Oldest first, so the least recently seen are evicted if the stored
+	// catalog exceeds the limit
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].LastSeen.Before(stored[j].LastSeen) })
+
+	cutoff := time.Now().Add(-m.config.Retention)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sp := range stored {
+		if sp.LastSeen.Before(cutoff) {
+			continue
+		}
+		p := &pattern{
+			id:        sp.ID,
+			service:   sp.ServiceName,
+			tokens:    strings.Fields(sp.Template),
+			total:     sp.Count,
+			firstSeen: sp.FirstSeen,
+			lastSeen:  sp.LastSeen,
+			counts:    make(map[int64]int64, len(sp.Counts)),
+			dirty:     make(map[int64]bool),
+		}
+		for _, c := range sp.Counts {
+			if !c.Timestamp.Before(cutoff) {
+				p.counts[c.Timestamp.UnixNano()] = c.Count
+			}
+		}
+		m.add(p)
+	}
+	return nil
+}
+
+func (m *Miner) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.flush()
+				if now := time.Now(); now.Sub(m.pruned) >= pruneInterval {
+					m.prune(now)
+					m.pruned = now
+				}
+			case <-m.done:
+				m.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop writes back the changes since the last flush
+func (m *Miner) Stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// Assign returns the ID of the pattern message matches within service,
+// creating the pattern when there is none, and counts the log in the bucket
+// of ts. It returns "" when mining is disabled.
+func (m *Miner) Assign(service, message string, ts time.Time) string {
+	if !m.config.Enabled {
+		return ""
+	}
+	tokens := tokenize(message)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.trees[service]
+	if !ok {
+		t = newTree()
+		m.trees[service] = t
+	}
+
+	var p *pattern
+	if leaf := t.descend(tokens, m.config.Depth, m.config.MaxChildren, false); leaf != nil {
+		p = leaf.match(tokens, m.config.Similarity)
+	}
+	if p == nil {
+		p = &pattern{
+			id:        m.newID(service, tokens),
+			service:   service,
+			tokens:    tokens,
+			firstSeen: now,
+			counts:    make(map[int64]int64),
+			dirty:     make(map[int64]bool),
+		}
+		m.add(p)
+	} else {
+		p.merge(tokens)
+		t.lru.MoveToFront(p.elem)
+	}
+
+	p.total++
+	p.lastSeen = now
+	p.changed = true
+
+	if ts.IsZero() || ts.After(now) {
+		ts = now
+	}
+	if ts.After(now.Add(-m.config.Retention)) {
+		bucket := ts.Truncate(m.config.Resolution).UnixNano()
+		p.counts[bucket]++
+		p.dirty[bucket] = true
+	}
+	return p.id
+}
+
+// add places p in its service's tree. The caller must hold the lock.
+func (m *Miner) add(p *pattern) {
+	t, ok := m.trees[p.service]
+	if !ok {
+		t = newTree()
+		m.trees[p.service] = t
+	}
+	if t.lru.Len() >= m.config.MaxPatterns {
+		m.evict(t.lru.Back().Value.(*pattern))
+	}
+	p.leaf = t.descend(p.tokens, m.config.Depth, m.config.MaxChildren, true)
+	p.leaf.patterns = append(p.leaf.patterns, p)
+	p.elem = t.lru.PushFront(p)
+	m.patterns[p.id] = p
+}
+
+// evict removes p from the catalog. Its stored record ages out with the
+// retention. The caller must hold the lock.
+func (m *Miner) evict(p *pattern) {
+	p.leaf.remove(p)
+	m.trees[p.service].lru.Remove(p.elem)
+	delete(m.patterns, p.id)
+}
+
+// newID derives a pattern ID from the service and the first message seen,
+// salted on the rare collision with a live pattern
+func (m *Miner) newID(service string, tokens []string) string {
+	seed := service + "\x00" + strings.Join(tokens, " ")
+	for i := 0; ; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(seed))
+		if i > 0 {
+			fmt.Fprintf(h, "\x00%d", i)
+		}
+		id := fmt.Sprintf("%016x", h.Sum64())
+		if _, exists := m.patterns[id]; !exists {
+			return id
+		}
+	}
+}
+
+// flush writes the patterns changed since the last flush with their changed
+// buckets
+func (m *Miner) flush() {
+	m.mu.Lock()
+	var changed []*storage.LogPattern
+	for _, p := range m.patterns {
+		if !p.changed {
+			continue
+		}
+		sp := m.stored(p)
+		for bucket := range p.dirty {
+			sp.Counts = append(sp.Counts, &storage.PatternCount{Timestamp: time.Unix(0, bucket), Count: p.counts[bucket]})
+		}
+		changed = append(changed, sp)
+		p.changed = false
+		p.dirty = make(map[int64]bool)
+	}
+	m.mu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	if err := m.storage.SaveLogPatterns(changed); err != nil {
+		m.logger.Error("Failed to write log patterns",
+			zap.Error(err),
+			zap.Int("count", len(changed)),
+		)
+	}
+}
+
+// prune drops counts older than the retention and patterns not seen since
+func (m *Miner) prune(now time.Time) {
+	cutoff := now.Add(-m.config.Retention)
+
+	m.mu.Lock()
+	for _, p := range m.patterns {
+		if p.lastSeen.Before(cutoff) {
+			m.evict(p)
+			continue
+		}
+		for bucket := range p.counts {
+			if bucket < cutoff.UnixNano() {
+				delete(p.counts, bucket)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if err := m.storage.PruneLogPatterns(cutoff); err != nil {
+		m.logger.Error("Failed to prune log patterns", zap.Error(err))
+	}
+}
+
+func (m *Miner) stored(p *pattern) *storage.LogPattern {
+	return &storage.LogPattern{
+		ID:          p.id,
+		ServiceName: p.service,
+		Template:    p.template(),
+		Count:       p.total,
+		FirstSeen:   p.firstSeen,
+		LastSeen:    p.lastSeen,
+	}
+}
+
+// summary describes p with its count since from. The caller must hold the
+// lock.
+func (m *Miner) summary(p *pattern, from time.Time) *Pattern {
+	return &Pattern{
+		ID:          p.id,
+		ServiceName: p.service,
+		Template:    p.template(),
+		Count:       p.countBetween(from.Truncate(m.config.Resolution), time.Time{}),
+		Total:       p.total,
+		FirstSeen:   p.firstSeen,
+		LastSeen:    p.lastSeen,
+	}
+}
+
+// countBetween sums the buckets starting in [from, to), with no upper bound
+// when to is zero
+func (p *pattern) countBetween(from, to time.Time) int64 {
+	var count int64
+	for bucket, n := range p.counts {
+		if bucket >= from.UnixNano() && (to.IsZero() || bucket < to.UnixNano()) {
+			count += n
+		}
+	}
+	return count
+}
+
+// Top returns the patterns with logs in the window, most frequent first
+func (m *Miner) Top(q Query) []*Pattern {
+	from := time.Now().Add(-q.Window)
+
+	m.mu.Lock()
+	patterns := []*Pattern{}
+	for _, p := range m.patterns {
+		if q.Service != "" && p.service != q.Service {
+			continue
+		}
+		if s := m.summary(p, from); s.Count > 0 {
+			patterns = append(patterns, s)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].ID < patterns[j].ID
+	})
+	return limit(patterns, q.Limit)
+}
+
+// New returns the patterns first seen within the window, newest first
+func (m *Miner) New(q Query) []*Pattern {
+	from := time.Now().Add(-q.Window)
+
+	m.mu.Lock()
+	patterns := []*Pattern{}
+	for _, p := range m.patterns {
+		if q.Service != "" && p.service != q.Service {
+			continue
+		}
+		if !p.firstSeen.Before(from) {
+			patterns = append(patterns, m.summary(p, from))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].FirstSeen.After(patterns[j].FirstSeen) })
+	return limit(patterns, q.Limit)
+}
+
+// Trends returns the counts per step of the selected patterns over the
+// window. The step is rounded up to a multiple of the bucket resolution.
+func (m *Miner) Trends(q TrendQuery) []*Trend {
+	step := q.Step
+	if step < m.config.Resolution {
+		step = m.config.Resolution
+	}
+	if rem := step % m.config.Resolution; rem != 0 {
+		step += m.config.Resolution - rem
+	}
+
+	ids := q.IDs
+	if len(ids) == 0 {
+		for _, p := range m.Top(q.Query) {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	now := time.Now()
+	from := now.Add(-q.Window)
+	start := from.Truncate(step)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	trends := []*Trend{}
+	for _, id := range ids {
+		p, ok := m.patterns[id]
+		if !ok || (q.Service != "" && p.service != q.Service) {
+			continue
+		}
+		trend := &Trend{
+			Pattern:  *m.summary(p, from),
+			Previous: p.countBetween(from.Add(-q.Window).Truncate(m.config.Resolution), from.Truncate(m.config.Resolution)),
+		}
+		if trend.Previous > 0 {
+			change := float64(trend.Count-trend.Previous) / float64(trend.Previous)
+			trend.Change = &change
+		}
+		for ts := start; !ts.After(now); ts = ts.Add(step) {
+			trend.Points = append(trend.Points, &TrendPoint{Timestamp: ts, Count: p.countBetween(ts, ts.Add(step))})
+		}
+		trends = append(trends, trend)
+	}
+
+	sort.SliceStable(trends, func(i, j int) bool { return trends[i].Count > trends[j].Count })
+	return trends
+}
+
+func limit(patterns []*Pattern, n int) []*Pattern {
+	if n > 0 && len(patterns) > n {
+		return patterns[:n]
+	}
+	return patterns
+}
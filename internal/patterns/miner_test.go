@@ -0,0 +1,157 @@
+package patterns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// emptyStorage is a storage without stored patterns
+type emptyStorage struct {
+	storage.Storage
+}
+
+func (emptyStorage) GetLogPatterns() ([]*storage.LogPattern, error) {
+	return nil, nil
+}
+
+func newTestMiner(t *testing.T, modify func(cfg *config.LogPatternsConfig)) *Miner {
+	t.Helper()
+	cfg := config.DefaultConfig().Ingestion.LogPatterns
+	cfg.Enabled = true
+	if modify != nil {
+		modify(&cfg)
+	}
+	m, err := NewMiner(emptyStorage{}, cfg)
+	if err != nil {
+		t.Fatalf("NewMiner: %v", err)
+	}
+	return m
+}
+
+func templateOf(t *testing.T, m *Miner, id string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.patterns[id]
+	if !ok {
+		t.Fatalf("pattern %s not found", id)
+	}
+	return p.template()
+}
+
+func TestTokenizeMasksParameters(t *testing.T) {
+	tests := map[string]string{
+		"user 42 logged in":                 "user <*> logged in",
+		"GET /api/v1/users/123 took 12.5ms": "GET /api/v1/users/123 took 12.5ms",
+		"connect 10.0.0.1:5432 failed":      "connect <*> failed",
+		"request 9f86d081-884c-7d65 done":   "request <*> done",
+		"pointer 0x7ffd5a3c at frame #3":    "pointer <*> at frame <*>",
+		"cache miss for deadbeef":           "cache miss for deadbeef",
+		"retrying   in  5s":                 "retrying in 5s",
+	}
+	for message, want := range tests {
+		if got := strings.Join(tokenize(message), " "); got != want {
+			t.Errorf("tokenize(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestAssignMergesTemplates(t *testing.T) {
+	m := newTestMiner(t, nil)
+	now := time.Now()
+
+	first := m.Assign("api", "user 42 logged in from web", now)
+	if second := m.Assign("api", "user 7 logged in from web", now); second != first {
+		t.Fatalf("messages differing in a number got patterns %s and %s", first, second)
+	}
+	if got := templateOf(t, m, first); got != "user <*> logged in from web" {
+		t.Errorf("template = %q", got)
+	}
+
+	// A differing word is generalized once the rest is similar enough
+	if merged := m.Assign("api", "user 9 logged in from mobile", now); merged != first {
+		t.Fatalf("similar message got pattern %s, want %s", merged, first)
+	}
+	if got := templateOf(t, m, first); got != "user <*> logged in from <*>" {
+		t.Errorf("merged template = %q", got)
+	}
+
+	// Dissimilar messages, other lengths and other services get their own
+	for _, tt := range []struct{ service, message string }{
+		{"api", "cache warmed for region eu"},
+		{"api", "user 5 logged out"},
+		{"worker", "user 42 logged in from web"},
+	} {
+		if id := m.Assign(tt.service, tt.message, now); id == first {
+			t.Errorf("%s: %q joined pattern %q", tt.service, tt.message, templateOf(t, m, first))
+		}
+	}
+
+	top := m.Top(Query{Service: "api", Window: time.Hour})
+	if len(top) != 3 || top[0].ID != first || top[0].Count != 3 || top[0].Total != 3 {
+		t.Errorf("Top = %+v", top)
+	}
+}
+
+func TestSimilarityThreshold(t *testing.T) {
+	m := newTestMiner(t, func(cfg *config.LogPatternsConfig) { cfg.Similarity = 0.9 })
+	now := time.Now()
+
+	first := m.Assign("api", "job started on queue mail", now)
+	if id := m.Assign("api", "job started on queue sms", now); id == first {
+		t.Error("message below the similarity threshold merged")
+	}
+	if got := templateOf(t, m, first); got != "job started on queue mail" {
+		t.Errorf("template changed to %q", got)
+	}
+}
+
+func TestMaxPatternsEvictsLeastRecentlySeen(t *testing.T) {
+	m := newTestMiner(t, func(cfg *config.LogPatternsConfig) { cfg.MaxPatterns = 2 })
+	now := time.Now()
+
+	a := m.Assign("api", "alpha happened", now)
+	b := m.Assign("api", "beta happened twice over", now)
+	m.Assign("api", "alpha happened", now) // a is now the most recent
+	c := m.Assign("api", "gamma happened in three words", now)
+
+	m.mu.Lock()
+	_, hasA := m.patterns[a]
+	_, hasB := m.patterns[b]
+	_, hasC := m.patterns[c]
+	size := m.trees["api"].lru.Len()
+	m.mu.Unlock()
+	if !hasA || hasB || !hasC || size != 2 {
+		t.Errorf("patterns a=%v b=%v c=%v, %d in the LRU; want b evicted", hasA, hasB, hasC, size)
+	}
+
+	// The limit is per service
+	m.Assign("worker", "delta happened", now)
+	if _, ok := m.patterns[a]; !ok {
+		t.Error("pattern of another service evicted")
+	}
+
+	// An evicted template starts over when seen again, evicting the next
+	again := m.Assign("api", "beta happened twice over", now)
+	m.mu.Lock()
+	p, ok := m.patterns[again]
+	size = m.trees["api"].lru.Len()
+	m.mu.Unlock()
+	if !ok {
+		t.Fatal("returning template not in the catalog")
+	}
+	if p.total != 1 || size != 2 {
+		t.Errorf("returning template counted %d, %d in the LRU; want 1 and 2", p.total, size)
+	}
+}
+
+func TestAssignDisabled(t *testing.T) {
+	m := newTestMiner(t, func(cfg *config.LogPatternsConfig) { cfg.Enabled = false })
+	if id := m.Assign("api", "user 42 logged in", time.Now()); id != "" {
+		t.Errorf("Assign = %q while disabled", id)
+	}
+}
@@ -0,0 +1,268 @@
+package redaction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"open-telemorph-prime/internal/config"
+)
+
+// Rule actions
+const (
+	ActionDelete   = "delete"
+	ActionHash     = "hash"
+	ActionMask     = "mask"
+	ActionTruncate = "truncate"
+)
+
+// Scopes a rule applies to
+const (
+	ScopeResource = "resource"
+	ScopeSpan     = "span"
+	ScopeLog      = "log"
+	ScopeBody     = "body"
+)
+
+// defaultReplacement replaces masked matches
+const defaultReplacement = "[REDACTED]"
+
+// builtins are the patterns available to mask rules by name. Card numbers are
+// also checked against the Luhn checksum.
+var builtins = map[string]*regexp.Regexp{
+	"email":       regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	"jwt":         regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	"credit_card": regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+}
+
+var ErrInvalidRule = errors.New("invalid redaction rule")
+
+// Attributes is the OTLP/JSON attribute list of resources, spans, span
+// events and logs
+type Attributes = []struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// rule is a compiled redaction rule
+type rule struct {
+	config.RedactionRuleConfig
+	scopes  map[string]bool
+	pattern *regexp.Regexp
+	luhn    bool
+	hits    atomic.Int64
+}
+
+// RuleStatus is a rule with the number of values it changed since startup
+type RuleStatus struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Hits   int64  `json:"hits"`
+}
+
+// Redactor applies the redaction rules to incoming telemetry
+type Redactor struct {
+	enabled bool
+	rules   []*rule
+}
+
+// NewRedactor compiles the redaction rules of cfg
+func NewRedactor(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{enabled: cfg.Enabled}
+
+	names := make(map[string]bool)
+	for _, def := range cfg.Rules {
+		compiled, err := compileRule(def)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidRule, def.Name, err)
+		}
+		if names[compiled.Name] {
+			return nil, fmt.Errorf("duplicate redaction rule %q", compiled.Name)
+		}
+		names[compiled.Name] = true
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+func compileRule(def config.RedactionRuleConfig) (*rule, error) {
+	r := &rule{RedactionRuleConfig: def, scopes: make(map[string]bool)}
+
+	if r.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(r.Scopes) == 0 {
+		r.Scopes = []string{ScopeResource, ScopeSpan, ScopeLog, ScopeBody}
+	}
+	for _, scope := range r.Scopes {
+		switch scope {
+		case ScopeResource, ScopeSpan, ScopeLog, ScopeBody:
+			r.scopes[scope] = true
+		default:
+			return nil, fmt.Errorf("unsupported scope %q", scope)
+		}
+	}
+	for _, key := range r.Keys {
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("invalid key pattern %q", key)
+		}
+	}
+
+	switch r.Action {
+	case ActionDelete:
+		if len(r.Keys) == 0 {
+			return nil, errors.New("delete needs keys")
+		}
+	case ActionHash:
+	case ActionMask:
+		switch {
+		case r.Pattern != "" && r.Builtin != "":
+			return nil, errors.New("pattern and builtin are exclusive")
+		case r.Pattern != "":
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+			r.pattern = pattern
+		case r.Builtin != "":
+			pattern, ok := builtins[r.Builtin]
+			if !ok {
+				return nil, fmt.Errorf("unknown builtin %q (want email, jwt or credit_card)", r.Builtin)
+			}
+			r.pattern = pattern
+			r.luhn = r.Builtin == "credit_card"
+		default:
+			return nil, errors.New("mask needs a pattern or builtin")
+		}
+		if r.Replacement == "" {
+			r.Replacement = defaultReplacement
+		}
+	case ActionTruncate:
+		if r.MaxLength <= 0 {
+			return nil, errors.New("max_length must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported action %q", r.Action)
+	}
+	return r, nil
+}
+
+// Attributes applies the rules for scope (resource, span or log) to attrs,
+// returning the attributes left
+func (r *Redactor) Attributes(scope string, attrs Attributes) Attributes {
+	if !r.enabled || len(attrs) == 0 {
+		return attrs
+	}
+	for _, rule := range r.rules {
+		if !rule.scopes[scope] {
+			continue
+		}
+		kept := attrs[:0]
+		for _, attr := range attrs {
+			if !rule.matchesKey(attr.Key) {
+				kept = append(kept, attr)
+				continue
+			}
+			if rule.Action == ActionDelete {
+				rule.hits.Add(1)
+				continue
+			}
+			attr.Value.StringValue = rule.apply(attr.Value.StringValue)
+			kept = append(kept, attr)
+		}
+		attrs = kept
+	}
+	return attrs
+}
+
+// Body applies the rules for log bodies. Rules limited to keys skip bodies.
+func (r *Redactor) Body(body string) string {
+	if !r.enabled || body == "" {
+		return body
+	}
+	for _, rule := range r.rules {
+		if rule.scopes[ScopeBody] && len(rule.Keys) == 0 && rule.Action != ActionDelete {
+			body = rule.apply(body)
+		}
+	}
+	return body
+}
+
+// Rules lists the rules with their hit counts
+func (r *Redactor) Rules() []*RuleStatus {
+	list := make([]*RuleStatus, 0, len(r.rules))
+	for _, rule := range r.rules {
+		list = append(list, &RuleStatus{Name: rule.Name, Action: rule.Action, Hits: rule.hits.Load()})
+	}
+	return list
+}
+
+func (r *Redactor) Enabled() bool {
+	return r.enabled
+}
+
+func (r *rule) matchesKey(key string) bool {
+	if len(r.Keys) == 0 {
+		return true
+	}
+	for _, pattern := range r.Keys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply changes one value, counting a hit when it changed
+func (r *rule) apply(value string) string {
+	result := value
+	switch r.Action {
+	case ActionHash:
+		if value != "" {
+			sum := sha256.Sum256([]byte(r.Salt + value))
+			result = hex.EncodeToString(sum[:8])
+		}
+	case ActionMask:
+		result = r.pattern.ReplaceAllStringFunc(value, func(match string) string {
+			if r.luhn && !luhnValid(match) {
+				return match
+			}
+			return r.Replacement
+		})
+	case ActionTruncate:
+		if utf8.RuneCountInString(value) > r.MaxLength {
+			result = string([]rune(value)[:r.MaxLength])
+		}
+	}
+	if result != value {
+		r.hits.Add(1)
+	}
+	return result
+}
+
+// luhnValid reports whether the digits of a number pass the Luhn checksum
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,335 @@
+package sampling
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+// Signals limited at ingest
+const (
+	SignalLogs    = "logs"
+	SignalMetrics = "metrics"
+)
+
+// anyService is the service of a policy applying to every service without a
+// policy of its own
+const anyService = "*"
+
+var ErrInvalidIngestPolicy = errors.New("invalid ingest policy")
+
+// maxServices bounds the services counted, with a token bucket each, so
+// senders inventing service names cannot grow the limiter without bound.
+// Services beyond it share the counters and bucket of anyService until idle
+// ones are evicted.
+const maxServices = 10000
+
+// serviceIdle is how long a service goes without records before its counters
+// and bucket may be evicted
+const serviceIdle = 10 * time.Minute
+
+// severities ranks log severity texts by prefix, following the OTLP severity
+// number ranges
+var severities = []struct {
+	prefix string
+	rank   int
+}{
+	{"TRACE", 1},
+	{"DEBUG", 5},
+	{"INFO", 9},
+	{"NOTICE", 10},
+	{"WARN", 13},
+	{"ERR", 17},
+	{"CRIT", 21},
+	{"FATAL", 21},
+	{"PANIC", 21},
+	{"ALERT", 21},
+	{"EMERG", 21},
+}
+
+// severityRank ranks a severity text, 0 when it is not recognized
+func severityRank(text string) int {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	for _, s := range severities {
+		if strings.HasPrefix(text, s.prefix) {
+			return s.rank
+		}
+	}
+	return 0
+}
+
+// ingestPolicy is a compiled ingest policy
+type ingestPolicy struct {
+	config.IngestPolicyConfig
+	rate     float64 // sample rate
+	keepRank int
+	burst    float64
+}
+
+func compileIngestPolicy(def config.IngestPolicyConfig) (*ingestPolicy, error) {
+	p := &ingestPolicy{IngestPolicyConfig: def, rate: 1}
+
+	if p.Service == "" {
+		return nil, errors.New(`service is required ("*" for every service)`)
+	}
+	switch p.Signal {
+	case SignalLogs:
+		if p.SampleRate != nil {
+			if *p.SampleRate < 0 || *p.SampleRate > 1 {
+				return nil, fmt.Errorf("sample_rate %v is outside [0, 1]", *p.SampleRate)
+			}
+			p.rate = *p.SampleRate
+		}
+		if p.KeepSeverity == "" {
+			p.KeepSeverity = "ERROR"
+		}
+		if p.keepRank = severityRank(p.KeepSeverity); p.keepRank == 0 {
+			return nil, fmt.Errorf("unknown keep_severity %q", p.KeepSeverity)
+		}
+	case SignalMetrics:
+		if p.SampleRate != nil || p.KeepSeverity != "" {
+			return nil, errors.New("sample_rate and keep_severity apply to logs only")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signal %q (want logs or metrics)", p.Signal)
+	}
+	if p.RateLimit < 0 || p.Burst < 0 {
+		return nil, errors.New("rate_limit and burst must not be negative")
+	}
+	p.burst = float64(p.Burst)
+	if p.burst == 0 {
+		p.burst = math.Max(1, math.Ceil(p.RateLimit))
+	}
+	return p, nil
+}
+
+// bucket is a token bucket refilled at the policy's rate limit
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(p *ingestPolicy, now time.Time) bool {
+	b.tokens = math.Min(p.burst, b.tokens+now.Sub(b.last).Seconds()*p.RateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// IngestStats counts the records of one service and signal since startup
+type IngestStats struct {
+	Service          string `json:"service"`
+	Signal           string `json:"signal"`
+	Received         int64  `json:"received"`
+	Accepted         int64  `json:"accepted"`
+	KeptBySeverity   int64  `json:"kept_by_severity"`
+	DroppedSampled   int64  `json:"dropped_sampled"`
+	DroppedRateLimit int64  `json:"dropped_rate_limit"`
+
+	seen time.Time // last record
+}
+
+// Limiter applies the ingest policies to incoming logs and metric
+// datapoints. Each service gets its own token bucket per signal.
+type Limiter struct {
+	mu       sync.Mutex
+	enabled  bool
+	defs     []config.IngestPolicyConfig
+	policies map[string]*ingestPolicy // signal + service
+	buckets  map[string]*bucket       // signal + service
+	stats    map[string]*IngestStats  // signal + service
+
+	evicted time.Time // last scan for idle services
+}
+
+// NewLimiter compiles the ingest policies of cfg
+func NewLimiter(cfg config.IngestLimitsConfig) (*Limiter, error) {
+	l := &Limiter{stats: make(map[string]*IngestStats)}
+	if err := l.Configure(cfg); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Config returns the policies in effect
+func (l *Limiter) Config() config.IngestLimitsConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return config.IngestLimitsConfig{
+		Enabled:  l.enabled,
+		Policies: append([]config.IngestPolicyConfig{}, l.defs...),
+	}
+}
+
+// Configure replaces the policies. Token buckets start over; counters are
+// kept.
+func (l *Limiter) Configure(cfg config.IngestLimitsConfig) error {
+	policies := make(map[string]*ingestPolicy, len(cfg.Policies))
+	defs := make([]config.IngestPolicyConfig, 0, len(cfg.Policies))
+	for _, def := range cfg.Policies {
+		p, err := compileIngestPolicy(def)
+		if err != nil {
+			return fmt.Errorf("%w for %s of %q: %v", ErrInvalidIngestPolicy, def.Signal, def.Service, err)
+		}
+		key := p.Signal + "\x00" + p.Service
+		if _, ok := policies[key]; ok {
+			return fmt.Errorf("%w: duplicate policy for %s of %q", ErrInvalidIngestPolicy, p.Signal, p.Service)
+		}
+		policies[key] = p
+		defs = append(defs, p.IngestPolicyConfig)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.enabled = cfg.Enabled
+	l.defs = defs
+	l.policies = policies
+	l.buckets = make(map[string]*bucket)
+	return nil
+}
+
+// AllowLog reports whether a log of service is ingested. Logs with a trace ID
+// are sampled by it, so the logs of a trace are kept or dropped together.
+func (l *Limiter) AllowLog(service, severity, traceID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, key, st := l.policy(SignalLogs, service)
+	if p == nil {
+		return true
+	}
+	st.Received++
+
+	if severityRank(severity) >= p.keepRank {
+		st.KeptBySeverity++
+		st.Accepted++
+		return true
+	}
+	if p.rate < 1 {
+		ratio := rand.Float64()
+		if traceID != "" {
+			ratio = traceRatio(traceID)
+		}
+		if ratio >= p.rate {
+			st.DroppedSampled++
+			return false
+		}
+	}
+	return l.take(p, key, st)
+}
+
+// AllowMetric reports whether a metric datapoint of service is ingested
+func (l *Limiter) AllowMetric(service string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, key, st := l.policy(SignalMetrics, service)
+	if p == nil {
+		return true
+	}
+	st.Received++
+	return l.take(p, key, st)
+}
+
+// Stats returns the counters of every service and signal a policy applied to
+func (l *Limiter) Stats() []*IngestStats {
+	l.mu.Lock()
+	list := make([]*IngestStats, 0, len(l.stats))
+	for _, st := range l.stats {
+		copied := *st
+		list = append(list, &copied)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Signal < list[j].Signal
+	})
+	return list
+}
+
+// policy returns the policy for a signal of service, the key of the
+// service's counters and bucket and its counters, or a nil policy when there
+// is none. Must be called with mu held.
+func (l *Limiter) policy(signal, service string) (*ingestPolicy, string, *IngestStats) {
+	if !l.enabled {
+		return nil, "", nil
+	}
+	p, ok := l.policies[signal+"\x00"+service]
+	if !ok {
+		if p, ok = l.policies[signal+"\x00"+anyService]; !ok {
+			return nil, "", nil
+		}
+	}
+
+	now := time.Now()
+	key := signal + "\x00" + service
+	st, ok := l.stats[key]
+	if !ok {
+		if len(l.stats) >= maxServices && !l.evictIdle(now) {
+			service, key = anyService, signal+"\x00"+anyService
+			st = l.stats[key]
+		}
+		if st == nil {
+			st = &IngestStats{Service: service, Signal: signal}
+			l.stats[key] = st
+		}
+	}
+	st.seen = now
+	return p, key, st
+}
+
+// evictIdle drops the counters and buckets of services idle for
+// serviceIdle, reporting whether any were dropped. Services are scanned at
+// most once a minute. Must be called with mu held.
+func (l *Limiter) evictIdle(now time.Time) bool {
+	if now.Sub(l.evicted) < time.Minute {
+		return false
+	}
+	l.evicted = now
+
+	evicted := false
+	for key, st := range l.stats {
+		if st.Service != anyService && now.Sub(st.seen) >= serviceIdle {
+			delete(l.stats, key)
+			delete(l.buckets, key)
+			evicted = true
+		}
+	}
+	return evicted
+}
+
+// take consumes a token from the bucket at key. Must be called with mu held.
+func (l *Limiter) take(p *ingestPolicy, key string, st *IngestStats) bool {
+	if p.RateLimit == 0 {
+		st.Accepted++
+		return true
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: p.burst, last: now}
+		l.buckets[key] = b
+	}
+	if !b.take(p, now) {
+		st.DroppedRateLimit++
+		return false
+	}
+	st.Accepted++
+	return true
+}
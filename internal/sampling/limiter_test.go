@@ -0,0 +1,210 @@
+package sampling
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func newTestLimiter(t *testing.T, policies ...config.IngestPolicyConfig) *Limiter {
+	t.Helper()
+	l, err := NewLimiter(config.IngestLimitsConfig{Enabled: true, Policies: policies})
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	return l
+}
+
+func rate(r float64) *float64 { return &r }
+
+func TestNewLimiterValidatesPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.IngestPolicyConfig
+	}{
+		{"no service", config.IngestPolicyConfig{Signal: SignalLogs}},
+		{"unknown signal", config.IngestPolicyConfig{Service: "*", Signal: "traces"}},
+		{"sample rate above 1", config.IngestPolicyConfig{Service: "*", Signal: SignalLogs, SampleRate: rate(1.5)}},
+		{"unknown severity", config.IngestPolicyConfig{Service: "*", Signal: SignalLogs, KeepSeverity: "LOUD"}},
+		{"sample rate for metrics", config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, SampleRate: rate(0.5)}},
+		{"negative rate limit", config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, RateLimit: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLimiter(config.IngestLimitsConfig{Enabled: true, Policies: []config.IngestPolicyConfig{tt.policy}})
+			if !errors.Is(err, ErrInvalidIngestPolicy) {
+				t.Errorf("NewLimiter error = %v, want %v", err, ErrInvalidIngestPolicy)
+			}
+		})
+	}
+
+	dup := config.IngestPolicyConfig{Service: "api", Signal: SignalLogs}
+	if _, err := NewLimiter(config.IngestLimitsConfig{Policies: []config.IngestPolicyConfig{dup, dup}}); !errors.Is(err, ErrInvalidIngestPolicy) {
+		t.Errorf("duplicate policy error = %v, want %v", err, ErrInvalidIngestPolicy)
+	}
+}
+
+func TestBucketRefillsUpToBurst(t *testing.T) {
+	p, err := compileIngestPolicy(config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, RateLimit: 2, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	b := &bucket{tokens: p.burst, last: start}
+
+	steps := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, true},
+		{0, true},
+		{0, true},
+		{0, false}, // burst spent
+		{250 * time.Millisecond, false},
+		{500 * time.Millisecond, true}, // one token at 2/s
+		{500 * time.Millisecond, false},
+		{time.Hour, true}, // refilled to the burst only
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, false},
+	}
+	for i, s := range steps {
+		if got := b.take(p, start.Add(s.after)); got != s.want {
+			t.Errorf("step %d: take after %v = %v, want %v", i, s.after, got, s.want)
+		}
+	}
+}
+
+func TestDefaultBurst(t *testing.T) {
+	for limit, want := range map[float64]float64{0.5: 1, 1: 1, 2.5: 3, 100: 100} {
+		p, err := compileIngestPolicy(config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, RateLimit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.burst != want {
+			t.Errorf("burst for rate_limit %v = %v, want %v", limit, p.burst, want)
+		}
+	}
+}
+
+func TestAllowLogKeepsSeverity(t *testing.T) {
+	l := newTestLimiter(t,
+		config.IngestPolicyConfig{Service: "api", Signal: SignalLogs, SampleRate: rate(0)},
+		config.IngestPolicyConfig{Service: "worker", Signal: SignalLogs, SampleRate: rate(0), KeepSeverity: "WARN"},
+	)
+
+	tests := []struct {
+		service  string
+		severity string
+		want     bool
+	}{
+		{"api", "INFO", false},
+		{"api", "WARN", false},
+		{"api", "ERROR", true},
+		{"api", "error", true},
+		{"api", "FATAL", true},
+		{"api", "", false},
+		{"worker", "INFO", false},
+		{"worker", "WARNING", true},
+		{"worker", "ERROR", true},
+	}
+	for _, tt := range tests {
+		if got := l.AllowLog(tt.service, tt.severity, ""); got != tt.want {
+			t.Errorf("AllowLog(%q, %q) = %v, want %v", tt.service, tt.severity, got, tt.want)
+		}
+	}
+
+	st := l.Stats()[0]
+	if st.Service != "api" || st.Received != 6 || st.KeptBySeverity != 3 || st.DroppedSampled != 3 {
+		t.Errorf("api stats = %+v", st)
+	}
+}
+
+func TestAllowLogSamplesByTrace(t *testing.T) {
+	l := newTestLimiter(t, config.IngestPolicyConfig{Service: "*", Signal: SignalLogs, SampleRate: rate(0.5)})
+
+	kept := 0
+	for i := 0; i < 200; i++ {
+		traceID := fmt.Sprintf("%032x", i*7919)
+		first := l.AllowLog("api", "INFO", traceID)
+		for j := 0; j < 3; j++ {
+			if l.AllowLog("api", "DEBUG", traceID) != first {
+				t.Fatalf("logs of trace %s sampled apart", traceID)
+			}
+		}
+		if first {
+			kept++
+		}
+	}
+	if kept < 60 || kept > 140 {
+		t.Errorf("kept %d of 200 traces at rate 0.5", kept)
+	}
+}
+
+func TestPerServicePolicies(t *testing.T) {
+	l := newTestLimiter(t,
+		config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, RateLimit: 0.001, Burst: 2},
+		config.IngestPolicyConfig{Service: "billing", Signal: SignalMetrics, RateLimit: 0.001, Burst: 5},
+		config.IngestPolicyConfig{Service: "billing", Signal: SignalLogs, SampleRate: rate(0)},
+	)
+
+	// Every service has its own bucket; billing has its own burst
+	want := map[string]int{"api": 2, "web": 2, "billing": 5}
+	for service, n := range want {
+		accepted := 0
+		for i := 0; i < 10; i++ {
+			if l.AllowMetric(service) {
+				accepted++
+			}
+		}
+		if accepted != n {
+			t.Errorf("accepted %d metrics of %s, want %d", accepted, service, n)
+		}
+	}
+
+	// Logs of services without a logs policy are not limited
+	if !l.AllowLog("api", "DEBUG", "") || l.AllowLog("billing", "DEBUG", "") {
+		t.Error("logs limited by the wrong policy")
+	}
+
+	l.Configure(config.IngestLimitsConfig{Enabled: false})
+	if !l.AllowMetric("api") {
+		t.Error("metric dropped with limits disabled")
+	}
+	if stats := l.Stats(); len(stats) != 4 || stats[0].Service != "api" || stats[0].DroppedRateLimit != 8 {
+		t.Errorf("stats after Configure = %+v", stats)
+	}
+}
+
+func TestLimiterBoundsServices(t *testing.T) {
+	l := newTestLimiter(t, config.IngestPolicyConfig{Service: "*", Signal: SignalMetrics, RateLimit: 0.001, Burst: 1})
+
+	for i := 0; i < maxServices+100; i++ {
+		l.AllowMetric(fmt.Sprintf("svc-%d", i))
+	}
+	if n := len(l.stats); n > maxServices+1 {
+		t.Fatalf("%d services tracked, want at most %d", n, maxServices+1)
+	}
+	// Services past the bound share the bucket of "*"
+	if st := l.stats[SignalMetrics+"\x00"+anyService]; st == nil || st.Received != 100 || st.Accepted != 1 {
+		t.Errorf("overflow stats = %+v", st)
+	}
+
+	// Idle services make room for new ones
+	for _, st := range l.stats {
+		st.seen = st.seen.Add(-serviceIdle)
+	}
+	l.evicted = time.Time{}
+	if !l.AllowMetric("new") {
+		t.Error("metric of a new service dropped")
+	}
+	if st := l.stats[SignalMetrics+"\x00new"]; st == nil {
+		t.Error("new service not tracked after idle ones were evicted")
+	}
+	if len(l.buckets) > 2 {
+		t.Errorf("%d buckets left after eviction", len(l.buckets))
+	}
+}
@@ -0,0 +1,126 @@
+package sampling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// Policy types
+const (
+	PolicyErrors        = "errors"
+	PolicyLatency       = "latency"
+	PolicyAttribute     = "attribute"
+	PolicyProbabilistic = "probabilistic"
+)
+
+// policy is a compiled sampling policy
+type policy struct {
+	config.SamplingPolicyConfig
+	values map[string]bool
+}
+
+func compilePolicy(def config.SamplingPolicyConfig) (*policy, error) {
+	p := &policy{SamplingPolicyConfig: def}
+
+	if p.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	switch p.Type {
+	case PolicyErrors:
+	case PolicyLatency:
+		if p.Threshold <= 0 {
+			return nil, errors.New("threshold must be positive")
+		}
+	case PolicyAttribute:
+		if p.Key == "" {
+			return nil, errors.New("key is required")
+		}
+		if len(p.Values) > 0 {
+			p.values = make(map[string]bool, len(p.Values))
+			for _, value := range p.Values {
+				p.values[value] = true
+			}
+		}
+	case PolicyProbabilistic:
+		if p.Rate < 0 || p.Rate > 1 {
+			return nil, fmt.Errorf("rate %v is outside [0, 1]", p.Rate)
+		}
+		for service, rate := range p.ServiceRates {
+			if rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("rate %v of service %q is outside [0, 1]", rate, service)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %q", p.Type)
+	}
+	return p, nil
+}
+
+// keep reports whether the policy keeps a trace
+func (p *policy) keep(t *trace) bool {
+	switch p.Type {
+	case PolicyErrors:
+		for _, span := range t.spans {
+			if storage.IsErrorStatus(span.StatusCode) {
+				return true
+			}
+		}
+	case PolicyLatency:
+		return t.duration() >= p.Threshold
+	case PolicyAttribute:
+		for _, span := range t.spans {
+			var attrs map[string]interface{}
+			if err := json.Unmarshal([]byte(span.Attributes), &attrs); err != nil {
+				continue
+			}
+			value, ok := attrs[p.Key]
+			if ok && (p.values == nil || p.values[fmt.Sprint(value)]) {
+				return true
+			}
+		}
+	case PolicyProbabilistic:
+		rate, ok := p.ServiceRates[t.rootService()]
+		if !ok {
+			rate = p.Rate
+		}
+		return traceRatio(t.id) < rate
+	}
+	return false
+}
+
+// traceRatio maps a trace ID to [0, 1), so every span of a trace gets the
+// same probabilistic decision
+func traceRatio(traceID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// duration is the time from the earliest span start to the latest span end
+func (t *trace) duration() time.Duration {
+	var start, end int64 = math.MaxInt64, math.MinInt64
+	for _, span := range t.spans {
+		spanStart := span.StartTime.UnixNano()
+		start = min(start, spanStart)
+		end = max(end, spanStart+span.DurationNanos)
+	}
+	return time.Duration(end - start)
+}
+
+// rootService is the service of the trace's root span, or of its first span
+// while the root has not arrived
+func (t *trace) rootService() string {
+	for _, span := range t.spans {
+		if span.ParentSpanID == nil {
+			return span.ServiceName
+		}
+	}
+	return t.spans[0].ServiceName
+}
@@ -0,0 +1,293 @@
+package sampling
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// decideInterval is how often traces past the decision wait are decided
+const decideInterval = time.Second
+
+var ErrInvalidPolicy = errors.New("invalid sampling policy")
+
+// trace is the buffered spans of one trace awaiting a decision
+type trace struct {
+	id      string
+	arrived time.Time
+	spans   []*storage.Trace
+	elem    *list.Element
+}
+
+// decision is remembered so spans arriving late follow their trace
+type decision struct {
+	traceID string
+	keep    bool
+	at      time.Time
+}
+
+// Stats are the decision counters since startup
+type Stats struct {
+	Enabled          bool           `json:"enabled"`
+	BufferedTraces   int            `json:"buffered_traces"`
+	BufferedSpans    int            `json:"buffered_spans"`
+	TracesKept       int64          `json:"traces_kept"`
+	TracesDropped    int64          `json:"traces_dropped"`
+	SpansKept        int64          `json:"spans_kept"`
+	SpansDropped     int64          `json:"spans_dropped"`
+	LateSpansKept    int64          `json:"late_spans_kept"`
+	LateSpansDropped int64          `json:"late_spans_dropped"`
+	EarlyDecisions   int64          `json:"early_decisions"`
+	Policies         []*PolicyStats `json:"policies"`
+}
+
+// PolicyStats counts the traces kept by a policy. A trace is credited to the
+// first policy in order that keeps it.
+type PolicyStats struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Kept int64  `json:"kept"`
+}
+
+// Sampler buffers spans per trace and writes the traces kept by its policies
+// to storage once their decision wait has passed
+type Sampler struct {
+	storage  storage.Storage
+	config   config.TailSamplingConfig
+	logger   *zap.Logger
+	policies []*policy
+
+	mu        sync.Mutex
+	traces    map[string]*trace
+	arrivals  *list.List // *trace, oldest first
+	decided   map[string]*decision
+	decisions *list.List // *decision, oldest first
+	spans     int
+	stats     Stats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSampler compiles the sampling policies. While sampling is disabled,
+// spans are written as they arrive.
+func NewSampler(store storage.Storage, cfg config.TailSamplingConfig) (*Sampler, error) {
+	s := &Sampler{
+		storage:   store,
+		config:    cfg,
+		logger:    logger.Get(),
+		traces:    make(map[string]*trace),
+		arrivals:  list.New(),
+		decided:   make(map[string]*decision),
+		decisions: list.New(),
+		stats:     Stats{Enabled: cfg.Enabled, Policies: []*PolicyStats{}},
+		done:      make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, def := range cfg.Policies {
+		p, err := compilePolicy(def)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidPolicy, def.Name, err)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate sampling policy %q", p.Name)
+		}
+		names[p.Name] = true
+		s.policies = append(s.policies, p)
+		s.stats.Policies = append(s.stats.Policies, &PolicyStats{Name: p.Name, Type: p.Type})
+	}
+	if cfg.Enabled && len(s.policies) == 0 {
+		return nil, errors.New("tail sampling needs at least one policy")
+	}
+	if cfg.Enabled && (cfg.MaxTraces <= 0 || cfg.MaxSpansPerTrace <= 0 || cfg.MaxDecisions <= 0) {
+		return nil, errors.New("tail sampling max_traces, max_spans_per_trace and max_decisions must be positive")
+	}
+	return s, nil
+}
+
+func (s *Sampler) Start() {
+	if !s.config.Enabled {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(decideInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.decideExpired(time.Now())
+			case <-s.done:
+				s.decideAll()
+				return
+			}
+		}
+	}()
+}
+
+// Stop decides the buffered traces right away, writing those kept
+func (s *Sampler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Add hands a span to the sampler. Spans without a trace ID, and all spans
+// while sampling is disabled, are written straight away.
+func (s *Sampler) Add(span *storage.Trace) {
+	if !s.config.Enabled || span.TraceID == "" {
+		s.write([]*storage.Trace{span})
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	if d, ok := s.decided[span.TraceID]; ok {
+		if d.keep {
+			s.stats.LateSpansKept++
+		} else {
+			s.stats.LateSpansDropped++
+		}
+		s.mu.Unlock()
+		if d.keep {
+			s.write([]*storage.Trace{span})
+		}
+		return
+	}
+
+	var kept []*storage.Trace
+	t, ok := s.traces[span.TraceID]
+	if !ok {
+		for len(s.traces) >= s.config.MaxTraces {
+			oldest := s.arrivals.Front().Value.(*trace)
+			s.stats.EarlyDecisions++
+			kept = append(kept, s.decide(oldest, now)...)
+		}
+		t = &trace{id: span.TraceID, arrived: now}
+		t.elem = s.arrivals.PushBack(t)
+		s.traces[t.id] = t
+	}
+	t.spans = append(t.spans, span)
+	s.spans++
+	if len(t.spans) >= s.config.MaxSpansPerTrace {
+		s.stats.EarlyDecisions++
+		kept = append(kept, s.decide(t, now)...)
+	}
+	s.mu.Unlock()
+
+	s.write(kept)
+}
+
+// Stats returns the decision counters
+func (s *Sampler) Stats() *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.BufferedTraces = len(s.traces)
+	stats.BufferedSpans = s.spans
+	stats.Policies = make([]*PolicyStats, len(s.stats.Policies))
+	for i, p := range s.stats.Policies {
+		copied := *p
+		stats.Policies[i] = &copied
+	}
+	return &stats
+}
+
+// decideExpired decides the traces buffered for the decision wait and
+// forgets decisions older than the decision cache
+func (s *Sampler) decideExpired(now time.Time) {
+	var kept []*storage.Trace
+
+	s.mu.Lock()
+	for e := s.arrivals.Front(); e != nil; e = s.arrivals.Front() {
+		t := e.Value.(*trace)
+		if now.Sub(t.arrived) < s.config.DecisionWait {
+			break
+		}
+		kept = append(kept, s.decide(t, now)...)
+	}
+	for e := s.decisions.Front(); e != nil; e = s.decisions.Front() {
+		d := e.Value.(*decision)
+		if now.Sub(d.at) < s.config.DecisionCache {
+			break
+		}
+		s.forget(e)
+	}
+	s.mu.Unlock()
+
+	s.write(kept)
+}
+
+func (s *Sampler) decideAll() {
+	now := time.Now()
+	var kept []*storage.Trace
+
+	s.mu.Lock()
+	for e := s.arrivals.Front(); e != nil; e = s.arrivals.Front() {
+		kept = append(kept, s.decide(e.Value.(*trace), now)...)
+	}
+	s.mu.Unlock()
+
+	s.write(kept)
+}
+
+// decide removes a trace from the buffer and returns its spans if a policy
+// keeps it. Must be called with mu held.
+func (s *Sampler) decide(t *trace, now time.Time) []*storage.Trace {
+	s.arrivals.Remove(t.elem)
+	delete(s.traces, t.id)
+	s.spans -= len(t.spans)
+
+	keep := false
+	for i, p := range s.policies {
+		if p.keep(t) {
+			s.stats.Policies[i].Kept++
+			keep = true
+			break
+		}
+	}
+
+	d := &decision{traceID: t.id, keep: keep, at: now}
+	s.decided[t.id] = d
+	s.decisions.PushBack(d)
+	for s.decisions.Len() > s.config.MaxDecisions {
+		s.forget(s.decisions.Front())
+	}
+
+	if !keep {
+		s.stats.TracesDropped++
+		s.stats.SpansDropped += int64(len(t.spans))
+		return nil
+	}
+	s.stats.TracesKept++
+	s.stats.SpansKept += int64(len(t.spans))
+	return t.spans
+}
+
+// forget drops a remembered decision. Must be called with mu held.
+func (s *Sampler) forget(e *list.Element) {
+	delete(s.decided, e.Value.(*decision).traceID)
+	s.decisions.Remove(e)
+}
+
+func (s *Sampler) write(spans []*storage.Trace) {
+	for _, span := range spans {
+		if err := s.storage.InsertTrace(span); err != nil {
+			s.logger.Error("Failed to insert trace",
+				zap.Error(err),
+				zap.String("trace_id", span.TraceID),
+				zap.String("span_id", span.SpanID),
+			)
+		}
+	}
+}
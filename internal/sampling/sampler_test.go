@@ -0,0 +1,213 @@
+package sampling
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// recorder is a storage recording the spans written to it
+type recorder struct {
+	storage.Storage
+
+	mu    sync.Mutex
+	spans []*storage.Trace
+}
+
+func (r *recorder) InsertTrace(span *storage.Trace) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func (r *recorder) written() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.spans)
+}
+
+func newTestSampler(t *testing.T, modify func(cfg *config.TailSamplingConfig)) (*Sampler, *recorder) {
+	t.Helper()
+	cfg := config.DefaultConfig().Ingestion.TailSampling
+	cfg.Enabled = true
+	cfg.Policies = []config.SamplingPolicyConfig{{Name: "errors", Type: PolicyErrors}}
+	if modify != nil {
+		modify(&cfg)
+	}
+	store := &recorder{}
+	s, err := NewSampler(store, cfg)
+	if err != nil {
+		t.Fatalf("NewSampler: %v", err)
+	}
+	return s, store
+}
+
+func span(traceID, spanID, service, status string, start time.Time, duration time.Duration) *storage.Trace {
+	return &storage.Trace{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		ServiceName:   service,
+		StartTime:     start,
+		DurationNanos: int64(duration),
+		Attributes:    "{}",
+		StatusCode:    status,
+	}
+}
+
+func TestPolicyKeep(t *testing.T) {
+	start := time.Now()
+	parent := "root"
+	child := span("t1", "b", "api", "STATUS_CODE_OK", start.Add(100*time.Millisecond), time.Second)
+	child.ParentSpanID = &parent
+	child.Attributes = `{"http.route":"/checkout","retries":3}`
+	ok := &trace{id: "t1", spans: []*storage.Trace{
+		span("t1", "root", "frontend", "STATUS_CODE_OK", start, 200*time.Millisecond),
+		child,
+	}}
+	failed := &trace{id: "t2", spans: []*storage.Trace{span("t2", "a", "api", "STATUS_CODE_ERROR", start, time.Millisecond)}}
+
+	tests := []struct {
+		name   string
+		policy config.SamplingPolicyConfig
+		trace  *trace
+		want   bool
+	}{
+		{"errors", config.SamplingPolicyConfig{Type: PolicyErrors}, failed, true},
+		{"no errors", config.SamplingPolicyConfig{Type: PolicyErrors}, ok, false},
+		{"latency across spans", config.SamplingPolicyConfig{Type: PolicyLatency, Threshold: 1100 * time.Millisecond}, ok, true},
+		{"under latency", config.SamplingPolicyConfig{Type: PolicyLatency, Threshold: 1200 * time.Millisecond}, ok, false},
+		{"attribute", config.SamplingPolicyConfig{Type: PolicyAttribute, Key: "http.route"}, ok, true},
+		{"attribute value", config.SamplingPolicyConfig{Type: PolicyAttribute, Key: "retries", Values: []string{"3"}}, ok, true},
+		{"other attribute value", config.SamplingPolicyConfig{Type: PolicyAttribute, Key: "http.route", Values: []string{"/cart"}}, ok, false},
+		{"probabilistic all", config.SamplingPolicyConfig{Type: PolicyProbabilistic, Rate: 1}, ok, true},
+		{"probabilistic none", config.SamplingPolicyConfig{Type: PolicyProbabilistic, Rate: 0}, ok, false},
+		{"root service rate", config.SamplingPolicyConfig{Type: PolicyProbabilistic, Rate: 1, ServiceRates: map[string]float64{"frontend": 0}}, ok, false},
+		{"other service rate", config.SamplingPolicyConfig{Type: PolicyProbabilistic, Rate: 0, ServiceRates: map[string]float64{"api": 1}}, ok, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.Name = tt.name
+			p, err := compilePolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("compilePolicy: %v", err)
+			}
+			if got := p.keep(tt.trace); got != tt.want {
+				t.Errorf("keep = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbabilisticIsConsistent(t *testing.T) {
+	p, err := compilePolicy(config.SamplingPolicyConfig{Name: "baseline", Type: PolicyProbabilistic, Rate: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		tr := &trace{id: fmt.Sprintf("%032x", i*7919), spans: []*storage.Trace{{ServiceName: "api"}}}
+		first := p.keep(tr)
+		if p.keep(tr) != first {
+			t.Fatalf("trace %s decided differently", tr.id)
+		}
+		if first {
+			kept++
+		}
+	}
+	if kept < 150 || kept > 350 {
+		t.Errorf("kept %d of 1000 traces at rate 0.25", kept)
+	}
+}
+
+func TestSamplerDecidesAfterWait(t *testing.T) {
+	s, store := newTestSampler(t, nil)
+	now := time.Now()
+
+	s.Add(span("kept", "a", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	s.Add(span("kept", "b", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	s.Add(span("dropped", "a", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	if store.written() != 0 {
+		t.Fatal("spans written before the decision wait")
+	}
+
+	s.decideExpired(now.Add(s.config.DecisionWait + time.Second))
+	if store.written() != 2 {
+		t.Errorf("%d spans written, want the 2 of the kept trace", store.written())
+	}
+	stats := s.Stats()
+	if stats.TracesKept != 1 || stats.TracesDropped != 1 || stats.SpansDropped != 1 || stats.Policies[0].Kept != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSamplerLateSpansFollowDecision(t *testing.T) {
+	s, store := newTestSampler(t, nil)
+	now := time.Now()
+
+	s.Add(span("kept", "a", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	s.Add(span("dropped", "a", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	s.decideExpired(now.Add(s.config.DecisionWait + time.Second))
+
+	s.Add(span("kept", "b", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	s.Add(span("dropped", "b", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	stats := s.Stats()
+	if store.written() != 2 || stats.LateSpansKept != 1 || stats.LateSpansDropped != 1 || stats.BufferedSpans != 0 {
+		t.Errorf("written %d, stats %+v", store.written(), stats)
+	}
+
+	// Past the decision cache, a span starts a new trace
+	s.decideExpired(now.Add(s.config.DecisionWait + s.config.DecisionCache + 2*time.Second))
+	s.Add(span("dropped", "c", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	if stats := s.Stats(); stats.BufferedTraces != 1 || stats.LateSpansDropped != 1 {
+		t.Errorf("stats after the decision cache = %+v", stats)
+	}
+}
+
+func TestSamplerEarlyDecisions(t *testing.T) {
+	s, store := newTestSampler(t, func(cfg *config.TailSamplingConfig) {
+		cfg.MaxTraces = 2
+		cfg.MaxSpansPerTrace = 3
+		cfg.MaxDecisions = 2
+	})
+	now := time.Now()
+
+	// A full buffer decides its oldest trace
+	s.Add(span("t1", "a", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	s.Add(span("t2", "a", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	s.Add(span("t3", "a", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	if stats := s.Stats(); store.written() != 1 || stats.EarlyDecisions != 1 || stats.BufferedTraces != 2 {
+		t.Errorf("written %d, stats %+v", store.written(), stats)
+	}
+
+	// A trace reaching max_spans_per_trace is decided, later spans follow
+	s.Add(span("t3", "b", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	s.Add(span("t3", "c", "api", "STATUS_CODE_ERROR", now, time.Millisecond))
+	s.Add(span("t3", "d", "api", "STATUS_CODE_OK", now, time.Millisecond))
+	stats := s.Stats()
+	if store.written() != 5 || stats.EarlyDecisions != 2 || stats.LateSpansKept != 1 || stats.BufferedTraces != 1 {
+		t.Errorf("written %d, stats %+v", store.written(), stats)
+	}
+
+	// Only the latest max_decisions decisions are remembered
+	s.decideAll()
+	if len(s.decided) != 2 || s.decisions.Len() != 2 {
+		t.Errorf("%d decisions remembered, want 2", len(s.decided))
+	}
+	if _, ok := s.decided["t1"]; ok {
+		t.Error("oldest decision still remembered")
+	}
+}
+
+func TestSamplerDisabledWritesSpans(t *testing.T) {
+	s, store := newTestSampler(t, func(cfg *config.TailSamplingConfig) { cfg.Enabled = false })
+	s.Add(span("t1", "a", "api", "STATUS_CODE_OK", time.Now(), time.Millisecond))
+	s.Add(span("", "b", "api", "STATUS_CODE_OK", time.Now(), time.Millisecond))
+	if store.written() != 2 {
+		t.Errorf("%d spans written, want 2", store.written())
+	}
+}
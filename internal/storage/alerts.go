@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// Alert states. Inactive alerts are not stored.
+const (
+	AlertInactive = "inactive"
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertState is the current state of one alert instance: a rule and the
+// label set it was evaluated for. Labels and Annotations are JSON strings.
+type AlertState struct {
+	RuleName    string     `json:"rule_name"`
+	Labels      string     `json:"labels"`
+	Annotations string     `json:"annotations"`
+	State       string     `json:"state"`
+	Value       float64    `json:"value"`
+	ActiveAt    time.Time  `json:"active_at"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// AlertEvent records a state transition of an alert instance
+type AlertEvent struct {
+	ID        int64     `json:"id"`
+	RuleName  string    `json:"rule_name"`
+	Labels    string    `json:"labels"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AlertHistoryQuery selects alert events within [Start, End), newest first.
+// An empty RuleName matches every rule.
+type AlertHistoryQuery struct {
+	RuleName string
+	Start    time.Time
+	End      time.Time
+	Limit    int
+}
+
+// CountQuery counts the logs or spans of Signal within [Start, End). Empty
+// filters match everything; Level and Contains apply to logs, Operation and
+// ErrorsOnly to spans.
+type CountQuery struct {
+	Signal      string // "logs" or "traces"
+	ServiceName string
+	Level       string
+	Contains    string
+	Operation   string
+	ErrorsOnly  bool
+	Start       time.Time
+	End         time.Time
+}
+
+func (q CountQuery) matchesLog(l *Log) bool {
+	return (q.ServiceName == "" || l.ServiceName == q.ServiceName) &&
+		(q.Level == "" || strings.EqualFold(l.Level, q.Level)) &&
+		(q.Contains == "" || strings.Contains(l.Message, q.Contains))
+}
+
+func (q CountQuery) matchesSpan(t *Trace) bool {
+	return (q.ServiceName == "" || t.ServiceName == q.ServiceName) &&
+		(q.Operation == "" || t.OperationName == q.Operation) &&
+		(!q.ErrorsOnly || IsErrorStatus(t.StatusCode))
+}
+
+func alertStateKey(ruleName, labels string) string {
+	return ruleName + "\x00" + labels
+}
+
+// Silence mutes notifications for alerts matching all Matchers between
+// StartsAt and EndsAt. Matchers use the label matcher syntax (env=prod,
+// severity=~"warn|info").
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []string  `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MaintenanceWindow mutes notifications for the alerts of Services (all
+// services when empty) that also match Matchers, every day listed in Weekdays
+// (every day when empty) from Start to End, both "15:04" in Timezone. A window
+// ending before it starts runs past midnight.
+type MaintenanceWindow struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services,omitempty"`
+	Matchers []string `json:"matchers,omitempty"`
+	Weekdays []string `json:"weekdays,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+// InhibitRule mutes the alerts matching TargetMatchers while an alert
+// matching SourceMatchers fires with the same values for the Equal labels
+type InhibitRule struct {
+	Name           string   `json:"name"`
+	SourceMatchers []string `json:"source_matchers"`
+	TargetMatchers []string `json:"target_matchers"`
+	Equal          []string `json:"equal,omitempty"`
+}
@@ -0,0 +1,19 @@
+package storage
+
+import "time"
+
+// APIKey authenticates OTLP ingestion. Only the SHA-256 hash of its secret is
+// stored, with a short prefix of the secret to tell keys apart. Revoked keys
+// are kept so their history stays visible.
+type APIKey struct {
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Prefix    string     `json:"prefix"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Services  []string   `json:"services,omitempty"`
+	Signals   []string   `json:"signals,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
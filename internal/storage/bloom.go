@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+var errInvalidBloomFilter = errors.New("invalid bloom filter encoding")
+
+// bloomFilter is a fixed-size bloom filter using double hashing over FNV-64a
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+func newBloomFilter(size int, hashes uint32) *bloomFilter {
+	if size < 64 {
+		size = 64
+	}
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		hashes: hashes,
+	}
+}
+
+func (b *bloomFilter) Add(value string) {
+	h1, h2 := bloomHashes(value)
+	n := uint64(len(b.bits) * 64)
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain reports whether value may have been added. False positives are
+// possible, false negatives are not.
+func (b *bloomFilter) MayContain(value string) bool {
+	h1, h2 := bloomHashes(value)
+	n := uint64(len(b.bits) * 64)
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary encodes the filter as the hash count followed by the bit set
+func (b *bloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 4+len(b.bits)*8)
+	binary.LittleEndian.PutUint32(data, b.hashes)
+	for i, word := range b.bits {
+		binary.LittleEndian.PutUint64(data[4+i*8:], word)
+	}
+	return data, nil
+}
+
+func (b *bloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 || (len(data)-4)%8 != 0 {
+		return errInvalidBloomFilter
+	}
+	b.hashes = binary.LittleEndian.Uint32(data)
+	b.bits = make([]uint64, (len(data)-4)/8)
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[4+i*8:])
+	}
+	return nil
+}
+
+func bloomHashes(value string) (uint64, uint64) {
+	a := fnv.New64a()
+	a.Write([]byte(value))
+	b := fnv.New64()
+	b.Write([]byte(value))
+	// An odd step keeps the probe sequence from collapsing onto one bit
+	return a.Sum64(), b.Sum64() | 1
+}
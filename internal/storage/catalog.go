@@ -0,0 +1,454 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// catalogHistory is how many one-minute activity buckets are kept per
+	// service, bounding the rate window
+	catalogHistory = 60
+
+	// degradedErrorRate is the span error rate at which a service is
+	// reported with a warning status
+	degradedErrorRate = 0.05
+)
+
+// ServiceResource describes the process behind a batch of telemetry, taken
+// from its OTLP resource attributes
+type ServiceResource struct {
+	ServiceName string
+	Version     string
+	InstanceID  string
+	Environment string
+}
+
+// ServiceInstance is one process of a service, keyed by service.instance.id.
+// Telemetry without an instance ID is attributed to the empty ID.
+type ServiceInstance struct {
+	ID          string    `json:"id"`
+	Version     string    `json:"version,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// ServiceSummary is a service catalog entry. Rates are per second and the
+// error rate is the fraction of spans with an error status, both over Window.
+type ServiceSummary struct {
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Signals      []string  `json:"signals"`
+	Versions     []string  `json:"versions"`
+	Environments []string  `json:"environments"`
+	Instances    int       `json:"instances"`
+	Window       string    `json:"window"`
+	SpanRate     float64   `json:"span_rate"`
+	LogRate      float64   `json:"log_rate"`
+	MetricRate   float64   `json:"metric_rate"`
+	ErrorRate    float64   `json:"error_rate"`
+}
+
+// ServiceDetail extends a summary with the service's instances and its
+// per-minute activity over the window
+type ServiceDetail struct {
+	ServiceSummary
+	InstanceList []*ServiceInstance `json:"instance_list"`
+	Activity     []*ServiceActivity `json:"activity"`
+}
+
+type ServiceActivity struct {
+	Timestamp time.Time `json:"timestamp"`
+	Spans     int64     `json:"spans"`
+	Errors    int64     `json:"errors"`
+	Logs      int64     `json:"logs"`
+	Metrics   int64     `json:"metrics"`
+}
+
+// catalogRecord is the persisted form of a catalog entry. Activity counters
+// are kept in memory only.
+type catalogRecord struct {
+	Name      string             `json:"name"`
+	FirstSeen time.Time          `json:"first_seen"`
+	LastSeen  time.Time          `json:"last_seen"`
+	Signals   []string           `json:"signals"`
+	Instances []*ServiceInstance `json:"instances"`
+}
+
+type activityBucket struct {
+	minute  int64
+	spans   int64
+	errors  int64
+	logs    int64
+	metrics int64
+}
+
+type catalogEntry struct {
+	name      string
+	firstSeen time.Time
+	lastSeen  time.Time
+	signals   map[string]bool
+	instances map[string]*ServiceInstance
+	activity  [catalogHistory]activityBucket
+}
+
+// serviceCatalog tracks every service seen at ingest so listing services
+// never has to scan stored signals
+type serviceCatalog struct {
+	mu      sync.RWMutex
+	config  config.CatalogConfig
+	entries map[string]*catalogEntry
+	dirty   map[string]bool
+}
+
+func newServiceCatalog(cfg config.CatalogConfig) *serviceCatalog {
+	return &serviceCatalog{
+		config:  cfg,
+		entries: make(map[string]*catalogEntry),
+		dirty:   make(map[string]bool),
+	}
+}
+
+// entry returns the entry for name, creating it on first sight. The caller
+// must hold the write lock.
+func (c *serviceCatalog) entry(name string, now time.Time) *catalogEntry {
+	e, ok := c.entries[name]
+	if !ok {
+		e = &catalogEntry{
+			name:      name,
+			firstSeen: now,
+			signals:   make(map[string]bool),
+			instances: make(map[string]*ServiceInstance),
+		}
+		c.entries[name] = e
+	}
+	if now.After(e.lastSeen) {
+		e.lastSeen = now
+	}
+	c.dirty[name] = true
+	return e
+}
+
+// observe counts one record of signal ("traces", "logs" or "metrics")
+func (c *serviceCatalog) observe(service, signal string, isError bool) {
+	if service == "" {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entry(service, now)
+	e.signals[signal] = true
+
+	minute := now.Unix() / 60
+	b := &e.activity[minute%catalogHistory]
+	if b.minute != minute {
+		*b = activityBucket{minute: minute}
+	}
+	switch signal {
+	case "traces":
+		b.spans++
+		if isError {
+			b.errors++
+		}
+	case "logs":
+		b.logs++
+	case "metrics":
+		b.metrics++
+	}
+}
+
+func (c *serviceCatalog) observeResource(res ServiceResource) {
+	if res.ServiceName == "" {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entry(res.ServiceName, now)
+	inst, ok := e.instances[res.InstanceID]
+	if !ok {
+		inst = &ServiceInstance{ID: res.InstanceID, FirstSeen: now}
+		e.instances[res.InstanceID] = inst
+	}
+	inst.LastSeen = now
+	if res.Version != "" {
+		inst.Version = res.Version
+	}
+	if res.Environment != "" {
+		inst.Environment = res.Environment
+	}
+}
+
+// restore loads persisted entries, keeping whatever was observed since
+func (c *serviceCatalog) restore(records []*catalogRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range records {
+		if _, ok := c.entries[r.Name]; ok {
+			continue
+		}
+		e := &catalogEntry{
+			name:      r.Name,
+			firstSeen: r.FirstSeen,
+			lastSeen:  r.LastSeen,
+			signals:   make(map[string]bool),
+			instances: make(map[string]*ServiceInstance),
+		}
+		for _, signal := range r.Signals {
+			e.signals[signal] = true
+		}
+		for _, inst := range r.Instances {
+			e.instances[inst.ID] = inst
+		}
+		c.entries[r.Name] = e
+	}
+}
+
+func (c *serviceCatalog) names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *catalogEntry) record() *catalogRecord {
+	r := &catalogRecord{
+		Name:      e.name,
+		FirstSeen: e.firstSeen,
+		LastSeen:  e.lastSeen,
+		Signals:   sortedKeys(e.signals),
+	}
+	for _, inst := range e.instances {
+		copied := *inst
+		r.Instances = append(r.Instances, &copied)
+	}
+	sort.Slice(r.Instances, func(i, j int) bool { return r.Instances[i].ID < r.Instances[j].ID })
+	return r
+}
+
+// drain returns the entries changed since the last drain
+func (c *serviceCatalog) drain() []*catalogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records := make([]*catalogRecord, 0, len(c.dirty))
+	for name := range c.dirty {
+		if e, ok := c.entries[name]; ok {
+			records = append(records, e.record())
+		}
+	}
+	c.dirty = make(map[string]bool)
+	return records
+}
+
+func (c *serviceCatalog) snapshot() []*catalogRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	records := make([]*catalogRecord, 0, len(c.entries))
+	for _, e := range c.entries {
+		records = append(records, e.record())
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records
+}
+
+// forget drops services and instances not seen since cutoff and returns the
+// names of the removed services
+func (c *serviceCatalog) forget(cutoff time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var removed []string
+	for name, e := range c.entries {
+		if e.lastSeen.Before(cutoff) {
+			delete(c.entries, name)
+			delete(c.dirty, name)
+			removed = append(removed, name)
+			continue
+		}
+		for id, inst := range e.instances {
+			if inst.LastSeen.Before(cutoff) {
+				delete(e.instances, id)
+			}
+		}
+	}
+	return removed
+}
+
+// clampWindow bounds a requested rate window to the retained history,
+// falling back to the configured window
+func (c *serviceCatalog) clampWindow(window time.Duration) time.Duration {
+	if window <= 0 {
+		window = c.config.Window
+	}
+	if window < time.Minute {
+		window = time.Minute
+	}
+	if window > catalogHistory*time.Minute {
+		window = catalogHistory * time.Minute
+	}
+	return window.Truncate(time.Minute)
+}
+
+func (c *serviceCatalog) summaries(window time.Duration) []*ServiceSummary {
+	window = c.clampWindow(window)
+	now := time.Now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	summaries := make([]*ServiceSummary, 0, len(c.entries))
+	for _, e := range c.entries {
+		summaries = append(summaries, c.summarize(e, window, now))
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// detail returns the detail view of a service, or nil if it is unknown
+func (c *serviceCatalog) detail(name string, window time.Duration) *ServiceDetail {
+	window = c.clampWindow(window)
+	now := time.Now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[name]
+	if !ok {
+		return nil
+	}
+
+	detail := &ServiceDetail{ServiceSummary: *c.summarize(e, window, now)}
+	detail.InstanceList = e.record().Instances
+
+	last := now.Unix() / 60
+	for minute := last - int64(window/time.Minute) + 1; minute <= last; minute++ {
+		activity := &ServiceActivity{Timestamp: time.Unix(minute*60, 0)}
+		if b := e.activity[minute%catalogHistory]; b.minute == minute {
+			activity.Spans, activity.Errors, activity.Logs, activity.Metrics = b.spans, b.errors, b.logs, b.metrics
+		}
+		detail.Activity = append(detail.Activity, activity)
+	}
+	return detail
+}
+
+// summarize computes the summary of e. The caller must hold the read lock.
+func (c *serviceCatalog) summarize(e *catalogEntry, window time.Duration, now time.Time) *ServiceSummary {
+	s := &ServiceSummary{
+		Name:      e.name,
+		FirstSeen: e.firstSeen,
+		LastSeen:  e.lastSeen,
+		Signals:   sortedKeys(e.signals),
+		Window:    FormatResolution(window),
+	}
+
+	versions := make(map[string]bool)
+	environments := make(map[string]bool)
+	for _, inst := range e.instances {
+		if inst.Version != "" {
+			versions[inst.Version] = true
+		}
+		if inst.Environment != "" {
+			environments[inst.Environment] = true
+		}
+		if now.Sub(inst.LastSeen) < c.config.OfflineAfter {
+			s.Instances++
+		}
+	}
+	s.Versions = sortedKeys(versions)
+	s.Environments = sortedKeys(environments)
+
+	var spans, errors, logs, metrics int64
+	first := now.Unix()/60 - int64(window/time.Minute) + 1
+	for _, b := range e.activity {
+		if b.minute >= first {
+			spans += b.spans
+			errors += b.errors
+			logs += b.logs
+			metrics += b.metrics
+		}
+	}
+	seconds := window.Seconds()
+	s.SpanRate = float64(spans) / seconds
+	s.LogRate = float64(logs) / seconds
+	s.MetricRate = float64(metrics) / seconds
+	if spans > 0 {
+		s.ErrorRate = float64(errors) / float64(spans)
+	}
+
+	switch {
+	case now.Sub(e.lastSeen) >= c.config.OfflineAfter:
+		s.Status = "offline"
+	case s.ErrorRate >= degradedErrorRate:
+		s.Status = "warning"
+	default:
+		s.Status = "online"
+	}
+
+	return s
+}
+
+// runCatalogLoop periodically hands the changed catalog entries to write
+// until done is closed, then performs a final flush
+func runCatalogLoop(catalog *serviceCatalog, interval time.Duration, done <-chan struct{}, write func([]*catalogRecord) error) {
+	flush := func() {
+		records := catalog.drain()
+		if len(records) == 0 {
+			return
+		}
+		if err := write(records); err != nil {
+			logger.Get().Error("Failed to write service catalog",
+				zap.Error(err),
+				zap.Int("count", len(records)),
+			)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-done:
+			flush()
+			return
+		}
+	}
+}
+
+// IsErrorStatus reports whether an OTLP span status code denotes an error
+func IsErrorStatus(code string) bool {
+	return code == "STATUS_CODE_ERROR" || code == "2"
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
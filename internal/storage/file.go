@@ -0,0 +1,817 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// FileStorage is an append-only storage engine that keeps each signal in
+// time-partitioned, compressed segment files. Retention drops whole segments.
+type FileStorage struct {
+	config  config.StorageConfig
+	metrics *signalStore
+	traces  *signalStore
+	logs    *signalStore
+
+	// Rollup tiers are stored as partial aggregates, one store per tier, and
+	// merged at query time
+	rollups     *rollupAggregator
+	rollupTiers map[time.Duration]*signalStore
+
+	catalog *serviceCatalog
+	graph   *serviceGraph
+
+	alertMu          sync.Mutex
+	alertRules       map[string]*config.AlertRuleConfig
+	alertStates      map[string]*AlertState
+	lastAlertEventID int64
+	silences         map[string]*Silence
+	maintenance      map[string]*MaintenanceWindow
+	inhibitRules     map[string]*InhibitRule
+
+	patternMu sync.Mutex
+	patterns  map[string]*filePattern
+
+	issueMu sync.Mutex
+	issues  map[string]*Issue
+
+	apiKeyMu sync.Mutex
+	apiKeys  map[string]*APIKey
+
+	userMu     sync.Mutex
+	users      map[string]*User
+	userTokens map[string]*UserToken
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// signalStore owns the segments of a single signal
+type signalStore struct {
+	mu        sync.RWMutex
+	dir       string
+	duration  time.Duration
+	blockSize int
+	bloomBits int
+	segments  map[int64]*segment
+	lastID    int64
+}
+
+func NewFileStorage(cfg config.StorageConfig) (*FileStorage, error) {
+	storage := &FileStorage{
+		config:       cfg,
+		rollups:      newRollupAggregator(cfg.Rollups.Tiers),
+		rollupTiers:  make(map[time.Duration]*signalStore),
+		catalog:      newServiceCatalog(cfg.Catalog),
+		graph:        newServiceGraph(cfg.ServiceGraph),
+		alertRules:   make(map[string]*config.AlertRuleConfig),
+		alertStates:  make(map[string]*AlertState),
+		silences:     make(map[string]*Silence),
+		maintenance:  make(map[string]*MaintenanceWindow),
+		inhibitRules: make(map[string]*InhibitRule),
+		patterns:     make(map[string]*filePattern),
+		issues:       make(map[string]*Issue),
+		apiKeys:      make(map[string]*APIKey),
+		users:        make(map[string]*User),
+		userTokens:   make(map[string]*UserToken),
+		done:         make(chan struct{}),
+	}
+
+	var err error
+	if storage.metrics, err = openSignalStore(filepath.Join(cfg.File.Dir, "metrics"), cfg.File); err != nil {
+		return nil, err
+	}
+	if storage.traces, err = openSignalStore(filepath.Join(cfg.File.Dir, "traces"), cfg.File); err != nil {
+		return nil, err
+	}
+	if storage.logs, err = openSignalStore(filepath.Join(cfg.File.Dir, "logs"), cfg.File); err != nil {
+		return nil, err
+	}
+	for _, tier := range cfg.Rollups.Tiers {
+		dir := filepath.Join(cfg.File.Dir, "rollups_"+FormatResolution(tier.Resolution))
+		if storage.rollupTiers[tier.Resolution], err = openSignalStore(dir, cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := storage.loadCatalog(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadAlerts(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadPatterns(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadIssues(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadUsers(); err != nil {
+		return nil, err
+	}
+
+	storage.wg.Add(4)
+	go storage.flushLoop(cfg.File.FlushInterval)
+	go func() {
+		defer storage.wg.Done()
+		runRollupLoop(storage.rollups, cfg.Rollups.FlushInterval, storage.done, storage.writeRollups)
+	}()
+	go func() {
+		defer storage.wg.Done()
+		runCatalogLoop(storage.catalog, cfg.Catalog.FlushInterval, storage.done, storage.writeCatalog)
+	}()
+	go func() {
+		defer storage.wg.Done()
+		storage.graph.run(storage.done)
+	}()
+
+	return storage, nil
+}
+
+func openSignalStore(dir string, cfg config.FileStorageConfig) (*signalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create segment directory: %w", err)
+	}
+
+	store := &signalStore{
+		dir:       dir,
+		duration:  cfg.SegmentDuration,
+		blockSize: cfg.BlockSize,
+		bloomBits: cfg.BloomBits,
+		segments:  make(map[int64]*segment),
+	}
+
+	indexes, err := filepath.Glob(filepath.Join(dir, "*"+segmentIndexExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, indexPath := range indexes {
+		seg, err := openSegment(indexPath)
+		if err != nil {
+			return nil, err
+		}
+		store.segments[seg.start.UnixNano()] = seg
+		if seg.index.LastID > store.lastID {
+			store.lastID = seg.index.LastID
+		}
+	}
+
+	return store, nil
+}
+
+func (s *FileStorage) flushLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, store := range s.stores() {
+				if err := store.flush(); err != nil {
+					logger.Get().Error("Failed to flush segments",
+						zap.Error(err),
+						zap.String("dir", store.dir),
+					)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *FileStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	var firstErr error
+	for _, store := range s.stores() {
+		if err := store.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *FileStorage) stores() []*signalStore {
+	stores := []*signalStore{s.metrics, s.traces, s.logs}
+	for _, store := range s.rollupTiers {
+		stores = append(stores, store)
+	}
+	return stores
+}
+
+// insert assigns the next record ID, encodes the record and appends it to the
+// segment covering ts
+func (st *signalStore) insert(ts time.Time, service string, traceID string, encode func(id int64) ([]byte, error)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	id := st.lastID + 1
+	data, err := encode(id)
+	if err != nil {
+		return err
+	}
+	st.lastID = id
+
+	start := ts.Truncate(st.duration)
+	seg, ok := st.segments[start.UnixNano()]
+	if !ok {
+		seg = newSegment(st.dir, start, st.duration, st.bloomBits)
+		st.segments[start.UnixNano()] = seg
+	}
+
+	seg.append(segmentRecord{id: id, timestamp: ts.UnixNano(), data: data}, service, traceID)
+	if len(seg.pending) >= st.blockSize {
+		return seg.flush()
+	}
+	return nil
+}
+
+// flush writes the pending records of every segment and closes the data
+// files of sealed segments, so open files do not grow with retention
+func (st *signalStore) flush() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	var firstErr error
+	for _, seg := range st.segments {
+		if err := seg.flush(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if seg.sealed(now) {
+			if err := seg.closeFile(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (st *signalStore) close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var firstErr error
+	for _, seg := range st.segments {
+		if err := seg.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newestFirst returns the segments overlapping [from, to], newest first
+func (st *signalStore) newestFirst(from, to time.Time) []*segment {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	segments := make([]*segment, 0, len(st.segments))
+	for _, seg := range st.segments {
+		if seg.overlaps(from, to) {
+			segments = append(segments, seg)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.After(segments[j].start)
+	})
+	return segments
+}
+
+// scan reads the records of seg overlapping [from, to], holding mu only to
+// snapshot the segment
+func (st *signalStore) scan(seg *segment, from, to time.Time, fn func(data []byte) error) error {
+	return st.snapshot(seg).scan(from, to, fn)
+}
+
+func (st *signalStore) snapshot(seg *segment) segmentSnapshot {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return seg.snapshot()
+}
+
+// mayHaveService reports whether a segment may hold records of a service.
+// The bloom filters are updated by insert, so they are read under mu.
+func (st *signalStore) mayHaveService(seg *segment, service string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return seg.serviceBloom.MayContain(service)
+}
+
+// mayHaveTrace reports whether a segment may hold records of a trace
+func (st *signalStore) mayHaveTrace(seg *segment, traceID string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return seg.traceBloom.MayContain(traceID)
+}
+
+// dropBefore removes every segment that ends at or before cutoff
+func (st *signalStore) dropBefore(cutoff time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, seg := range st.segments {
+		if seg.end.After(cutoff) {
+			continue
+		}
+		if err := seg.remove(); err != nil {
+			return fmt.Errorf("failed to remove segment %s: %w", seg.dataPath, err)
+		}
+		delete(st.segments, key)
+	}
+	return nil
+}
+
+// serviceRanges calls fn with every service recorded in a segment and the
+// segment's time range
+func (st *signalStore) serviceRanges(fn func(service string, start, end time.Time)) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	for _, seg := range st.segments {
+		for service := range seg.services {
+			fn(service, seg.start, seg.end)
+		}
+	}
+}
+
+// recent decodes records from the newest blocks until offset+limit records
+// accepted by keep (all when nil) have been collected, returning them newest
+// first. Segments and blocks are read newest first, stopping once the next
+// cannot hold a record newer than those collected.
+func recent[T any](st *signalStore, limit int, offset int, timeOf func(*T) time.Time, keep func(*T) bool) ([]*T, error) {
+	need := offset + limit
+	if need <= 0 {
+		return nil, nil
+	}
+
+	var results []*T
+	// full keeps the newest records collected and reports whether they
+	// outrank anything at or before maxTime
+	full := func(maxTime int64) bool {
+		sort.SliceStable(results, func(i, j int) bool {
+			return timeOf(results[i]).After(timeOf(results[j]))
+		})
+		if len(results) > need {
+			results = results[:need]
+		}
+		return len(results) == need && maxTime < timeOf(results[need-1]).UnixNano()
+	}
+	decode := func(data []byte) error {
+		var rec T
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		if keep == nil || keep(&rec) {
+			results = append(results, &rec)
+		}
+		return nil
+	}
+
+	for _, seg := range st.newestFirst(time.Time{}, time.Time{}) {
+		if full(seg.end.UnixNano() - 1) {
+			break
+		}
+		if err := st.snapshot(seg).scanNewest(full, decode); err != nil {
+			return nil, err
+		}
+	}
+	full(math.MinInt64)
+
+	if offset >= len(results) {
+		return nil, nil
+	}
+	return results[offset:], nil
+}
+
+// receiptTime substitutes the current time for records that arrive without a
+// usable timestamp so they land in a live partition
+func receiptTime(ts time.Time) time.Time {
+	if ts.IsZero() || ts.Year() < 1970 {
+		return time.Now()
+	}
+	return ts
+}
+
+// Metric methods
+func (s *FileStorage) InsertMetric(metric *Metric) error {
+	metric.Timestamp = receiptTime(metric.Timestamp)
+	metric.CreatedAt = time.Now()
+	err := s.metrics.insert(metric.Timestamp, metric.ServiceName, "", func(id int64) ([]byte, error) {
+		metric.ID = id
+		return json.Marshal(metric)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.rollups.observe(metric)
+	s.catalog.observe(metric.ServiceName, "metrics", false)
+	return nil
+}
+
+func (s *FileStorage) GetMetrics(limit int, offset int) ([]*Metric, error) {
+	return recent(s.metrics, limit, offset, func(m *Metric) time.Time { return m.Timestamp }, nil)
+}
+
+func (s *FileStorage) QueryMetricRange(q MetricQuery) (*MetricQueryResult, error) {
+	return queryMetricRange(q, s.rollups, s.queryRawMetrics, s.queryRollups, s.QueryExemplars)
+}
+
+// QueryExemplars returns the exemplars stored with the raw datapoints matched
+// by q, oldest first
+func (s *FileStorage) QueryExemplars(q MetricQuery) ([]*Exemplar, error) {
+	metrics, err := s.queryRawMetrics(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var exemplars []*Exemplar
+	for _, m := range metrics {
+		for _, e := range m.Exemplars {
+			e.MetricName, e.ServiceName, e.Labels = m.MetricName, m.ServiceName, m.Labels
+			exemplars = append(exemplars, e)
+		}
+	}
+	sort.SliceStable(exemplars, func(i, j int) bool {
+		return exemplars[i].Timestamp.Before(exemplars[j].Timestamp)
+	})
+	return exemplars, nil
+}
+
+func (s *FileStorage) queryRawMetrics(q MetricQuery) ([]*Metric, error) {
+	var metrics []*Metric
+	for _, seg := range s.metrics.newestFirst(q.Start, q.End) {
+		if q.ServiceName != "" && !s.metrics.mayHaveService(seg, q.ServiceName) {
+			continue
+		}
+		err := s.metrics.scan(seg, q.Start, q.End, func(data []byte) error {
+			var m Metric
+			if err := json.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			if m.MetricName != q.MetricName || m.Timestamp.Before(q.Start) || !m.Timestamp.Before(q.End) {
+				return nil
+			}
+			if q.ServiceName != "" && m.ServiceName != q.ServiceName {
+				return nil
+			}
+			if !matchesAll(q.Matchers, m.Labels) {
+				return nil
+			}
+			metrics = append(metrics, &m)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+func (s *FileStorage) writeRollups(resolution time.Duration, rollups []*MetricRollup) error {
+	store, ok := s.rollupTiers[resolution]
+	if !ok {
+		return fmt.Errorf("no rollup store for resolution %s", FormatResolution(resolution))
+	}
+	for _, r := range rollups {
+		err := store.insert(r.Bucket, r.ServiceName, "", func(int64) ([]byte, error) {
+			return json.Marshal(r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryRollups reads the partial aggregates of one tier in q's range and
+// merges those belonging to the same bucket
+func (s *FileStorage) queryRollups(resolution time.Duration, q MetricQuery) ([]*MetricRollup, error) {
+	store, ok := s.rollupTiers[resolution]
+	if !ok {
+		return nil, nil
+	}
+
+	start := q.Start.Truncate(resolution)
+	merged := make(map[rollupKey]*MetricRollup)
+	for _, seg := range store.newestFirst(start, q.End) {
+		if q.ServiceName != "" && !store.mayHaveService(seg, q.ServiceName) {
+			continue
+		}
+		err := store.scan(seg, start, q.End, func(data []byte) error {
+			var r MetricRollup
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if r.MetricName != q.MetricName || r.Bucket.Before(start) || !r.Bucket.Before(q.End) {
+				return nil
+			}
+			if q.ServiceName != "" && r.ServiceName != q.ServiceName {
+				return nil
+			}
+			if !matchesAll(q.Matchers, r.Labels) {
+				return nil
+			}
+			if existing, ok := merged[r.key()]; ok {
+				existing.merge(&r)
+			} else {
+				merged[r.key()] = &r
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rollups := make([]*MetricRollup, 0, len(merged))
+	for _, r := range merged {
+		rollups = append(rollups, r)
+	}
+	return rollups, nil
+}
+
+// Trace methods
+func (s *FileStorage) InsertTrace(trace *Trace) error {
+	trace.StartTime = receiptTime(trace.StartTime)
+	trace.CreatedAt = time.Now()
+	err := s.traces.insert(trace.StartTime, trace.ServiceName, trace.TraceID, func(id int64) ([]byte, error) {
+		trace.ID = id
+		return json.Marshal(trace)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.catalog.observe(trace.ServiceName, "traces", IsErrorStatus(trace.StatusCode))
+	s.graph.observe(trace)
+	return nil
+}
+
+func (s *FileStorage) GetTraces(limit int, offset int) ([]*Trace, error) {
+	return recent(s.traces, limit, offset, func(t *Trace) time.Time { return t.StartTime }, nil)
+}
+
+// GetTrace returns every span of a trace, consulting each segment's trace ID
+// bloom filter to skip segments that cannot contain it
+func (s *FileStorage) GetTrace(traceID string) ([]*Trace, error) {
+	var spans []*Trace
+	for _, seg := range s.traces.newestFirst(time.Time{}, time.Time{}) {
+		if !s.traces.mayHaveTrace(seg, traceID) {
+			continue
+		}
+		err := s.traces.scan(seg, time.Time{}, time.Time{}, func(data []byte) error {
+			var t Trace
+			if err := json.Unmarshal(data, &t); err != nil {
+				return err
+			}
+			if t.TraceID == traceID {
+				spans = append(spans, &t)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+	return spans, nil
+}
+
+// Log methods
+func (s *FileStorage) InsertLog(log *Log) error {
+	log.Timestamp = receiptTime(log.Timestamp)
+	log.CreatedAt = time.Now()
+	traceID := ""
+	if log.TraceID != nil {
+		traceID = *log.TraceID
+	}
+	err := s.logs.insert(log.Timestamp, log.ServiceName, traceID, func(id int64) ([]byte, error) {
+		log.ID = id
+		return json.Marshal(log)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.catalog.observe(log.ServiceName, "logs", false)
+	return nil
+}
+
+func (s *FileStorage) GetLogs(limit int, offset int) ([]*Log, error) {
+	return recent(s.logs, limit, offset, func(l *Log) time.Time { return l.Timestamp }, nil)
+}
+
+// GetTraceLogs returns the logs carrying a trace ID, oldest first, consulting
+// each log segment's trace ID bloom filter to skip segments
+func (s *FileStorage) GetTraceLogs(traceID string) ([]*Log, error) {
+	var logs []*Log
+	for _, seg := range s.logs.newestFirst(time.Time{}, time.Time{}) {
+		if !s.logs.mayHaveTrace(seg, traceID) {
+			continue
+		}
+		err := s.logs.scan(seg, time.Time{}, time.Time{}, func(data []byte) error {
+			var l Log
+			if err := json.Unmarshal(data, &l); err != nil {
+				return err
+			}
+			if l.TraceID != nil && *l.TraceID == traceID {
+				logs = append(logs, &l)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	return logs, nil
+}
+
+// GetServiceLogs returns up to limit logs of a service within [from, to],
+// oldest first, skipping segments whose service bloom filter rules it out
+func (s *FileStorage) GetServiceLogs(service string, from, to time.Time, limit int) ([]*Log, error) {
+	var logs []*Log
+	for _, seg := range s.logs.newestFirst(from, to) {
+		if !s.logs.mayHaveService(seg, service) {
+			continue
+		}
+		err := s.logs.scan(seg, from, to, func(data []byte) error {
+			var l Log
+			if err := json.Unmarshal(data, &l); err != nil {
+				return err
+			}
+			if l.ServiceName == service && !l.Timestamp.Before(from) && !l.Timestamp.After(to) {
+				logs = append(logs, &l)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	return logs, nil
+}
+
+// Service methods
+func (s *FileStorage) GetServices() ([]string, error) {
+	return s.catalog.names(), nil
+}
+
+func (s *FileStorage) ObserveResource(res ServiceResource) error {
+	s.catalog.observeResource(res)
+	return nil
+}
+
+func (s *FileStorage) GetServiceCatalog(window time.Duration) ([]*ServiceSummary, error) {
+	return s.catalog.summaries(window), nil
+}
+
+func (s *FileStorage) GetServiceDetail(name string, window time.Duration) (*ServiceDetail, error) {
+	return s.catalog.detail(name, window), nil
+}
+
+func (s *FileStorage) GetServiceGraph(window time.Duration) (*ServiceGraph, error) {
+	return s.graph.graph(window), nil
+}
+
+func (s *FileStorage) catalogPath() string {
+	return filepath.Join(s.config.File.Dir, "services.json")
+}
+
+// loadCatalog restores the service catalog, seeding it from the segment
+// indexes when no catalog has been written yet
+func (s *FileStorage) loadCatalog() error {
+	data, err := os.ReadFile(s.catalogPath())
+	if os.IsNotExist(err) {
+		return s.backfillCatalog()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read service catalog: %w", err)
+	}
+
+	var records []*catalogRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse service catalog: %w", err)
+	}
+	s.catalog.restore(records)
+	return nil
+}
+
+func (s *FileStorage) backfillCatalog() error {
+	now := time.Now()
+	records := make(map[string]*catalogRecord)
+	signals := map[string]*signalStore{"metrics": s.metrics, "traces": s.traces, "logs": s.logs}
+	for signal, store := range signals {
+		store.serviceRanges(func(service string, start, end time.Time) {
+			// The live segment ends in the future
+			if end.After(now) {
+				end = now
+			}
+			r, ok := records[service]
+			if !ok {
+				r = &catalogRecord{Name: service, FirstSeen: start, LastSeen: end}
+				records[service] = r
+			}
+			if start.Before(r.FirstSeen) {
+				r.FirstSeen = start
+			}
+			if end.After(r.LastSeen) {
+				r.LastSeen = end
+			}
+			for _, existing := range r.Signals {
+				if existing == signal {
+					return
+				}
+			}
+			r.Signals = append(r.Signals, signal)
+		})
+	}
+
+	list := make([]*catalogRecord, 0, len(records))
+	for _, r := range records {
+		sort.Strings(r.Signals)
+		list = append(list, r)
+	}
+	s.catalog.restore(list)
+	return nil
+}
+
+// writeCatalog rewrites the catalog file whenever an entry has changed
+func (s *FileStorage) writeCatalog(_ []*catalogRecord) error {
+	data, err := json.Marshal(s.catalog.snapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp := s.catalogPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write service catalog: %w", err)
+	}
+	return os.Rename(tmp, s.catalogPath())
+}
+
+// CleanupOldData deletes every segment that lies entirely outside the
+// retention window
+func (s *FileStorage) CleanupOldData() error {
+	cutoff := time.Now().AddDate(0, 0, -s.config.RetentionDays)
+
+	if len(s.catalog.forget(cutoff)) > 0 {
+		if err := s.writeCatalog(nil); err != nil {
+			return err
+		}
+	}
+
+	if err := s.cleanupAlertHistory(cutoff); err != nil {
+		return err
+	}
+
+	for _, store := range []*signalStore{s.metrics, s.traces, s.logs} {
+		if err := store.dropBefore(cutoff); err != nil {
+			return fmt.Errorf("failed to cleanup old data: %w", err)
+		}
+	}
+
+	for _, tier := range s.config.Rollups.Tiers {
+		cutoff := time.Now().AddDate(0, 0, -tier.RetentionDays)
+		if err := s.rollupTiers[tier.Resolution].dropBefore(cutoff); err != nil {
+			return fmt.Errorf("failed to cleanup %s rollups: %w", FormatResolution(tier.Resolution), err)
+		}
+	}
+
+	return nil
+}
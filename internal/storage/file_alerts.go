@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+// alertFile is the layout of alerts.json, which holds the API-defined rules,
+// current alert states, silences, maintenance windows and inhibit rules.
+// Transitions are appended to alert_history.jsonl.
+type alertFile struct {
+	Rules              []*config.AlertRuleConfig `json:"rules"`
+	States             []*AlertState             `json:"states"`
+	Silences           []*Silence                `json:"silences,omitempty"`
+	MaintenanceWindows []*MaintenanceWindow      `json:"maintenance_windows,omitempty"`
+	InhibitRules       []*InhibitRule            `json:"inhibit_rules,omitempty"`
+}
+
+func (s *FileStorage) alertsPath() string {
+	return filepath.Join(s.config.File.Dir, "alerts.json")
+}
+
+func (s *FileStorage) alertHistoryPath() string {
+	return filepath.Join(s.config.File.Dir, "alert_history.jsonl")
+}
+
+// loadAlerts restores rules and states, and finds the last event ID so new
+// events continue the sequence
+func (s *FileStorage) loadAlerts() error {
+	data, err := os.ReadFile(s.alertsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read alerts: %w", err)
+	}
+	if err == nil {
+		var file alertFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to parse alerts: %w", err)
+		}
+		for _, rule := range file.Rules {
+			s.alertRules[rule.Name] = rule
+		}
+		for _, st := range file.States {
+			s.alertStates[alertStateKey(st.RuleName, st.Labels)] = st
+		}
+		for _, silence := range file.Silences {
+			s.silences[silence.ID] = silence
+		}
+		for _, window := range file.MaintenanceWindows {
+			s.maintenance[window.Name] = window
+		}
+		for _, rule := range file.InhibitRules {
+			s.inhibitRules[rule.Name] = rule
+		}
+	}
+
+	return s.scanAlertHistory(func(e *AlertEvent) {
+		if e.ID > s.lastAlertEventID {
+			s.lastAlertEventID = e.ID
+		}
+	})
+}
+
+// writeAlerts rewrites alerts.json. The caller must hold alertMu.
+func (s *FileStorage) writeAlerts() error {
+	file := alertFile{
+		Rules:  make([]*config.AlertRuleConfig, 0, len(s.alertRules)),
+		States: make([]*AlertState, 0, len(s.alertStates)),
+	}
+	for _, rule := range s.alertRules {
+		file.Rules = append(file.Rules, rule)
+	}
+	for _, st := range s.alertStates {
+		file.States = append(file.States, st)
+	}
+	sort.Slice(file.Rules, func(i, j int) bool { return file.Rules[i].Name < file.Rules[j].Name })
+	file.Silences = s.sortedSilences()
+	file.MaintenanceWindows = s.sortedMaintenanceWindows()
+	file.InhibitRules = s.sortedInhibitRules()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp := s.alertsPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write alerts: %w", err)
+	}
+	return os.Rename(tmp, s.alertsPath())
+}
+
+func (s *FileStorage) SaveAlertRule(rule *config.AlertRuleConfig) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	s.alertRules[rule.Name] = rule
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) DeleteAlertRule(name string) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	delete(s.alertRules, name)
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) GetAlertRules() ([]*config.AlertRuleConfig, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	rules := make([]*config.AlertRuleConfig, 0, len(s.alertRules))
+	for _, rule := range s.alertRules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules, nil
+}
+
+func (s *FileStorage) SaveAlertState(state *AlertState) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	copied := *state
+	s.alertStates[alertStateKey(state.RuleName, state.Labels)] = &copied
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) DeleteAlertState(ruleName, labels string) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	delete(s.alertStates, alertStateKey(ruleName, labels))
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) GetAlertStates() ([]*AlertState, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	states := make([]*AlertState, 0, len(s.alertStates))
+	for _, st := range s.alertStates {
+		copied := *st
+		states = append(states, &copied)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].RuleName != states[j].RuleName {
+			return states[i].RuleName < states[j].RuleName
+		}
+		return states[i].Labels < states[j].Labels
+	})
+	return states, nil
+}
+
+func (s *FileStorage) InsertAlertEvent(event *AlertEvent) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	s.lastAlertEventID++
+	event.ID = s.lastAlertEventID
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.alertHistoryPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open alert history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to insert alert event: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStorage) GetAlertHistory(q AlertHistoryQuery) ([]*AlertEvent, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	var events []*AlertEvent
+	err := s.scanAlertHistory(func(e *AlertEvent) {
+		if (q.RuleName == "" || e.RuleName == q.RuleName) &&
+			!e.Timestamp.Before(q.Start) && e.Timestamp.Before(q.End) {
+			events = append(events, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+	return events, nil
+}
+
+func (s *FileStorage) scanAlertHistory(fn func(e *AlertEvent)) error {
+	f, err := os.Open(s.alertHistoryPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open alert history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AlertEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(&e)
+	}
+	return scanner.Err()
+}
+
+func (s *FileStorage) SaveSilence(silence *Silence) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	copied := *silence
+	s.silences[silence.ID] = &copied
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) DeleteSilence(id string) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	delete(s.silences, id)
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) GetSilences() ([]*Silence, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	return s.sortedSilences(), nil
+}
+
+func (s *FileStorage) SaveMaintenanceWindow(window *MaintenanceWindow) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	copied := *window
+	s.maintenance[window.Name] = &copied
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) DeleteMaintenanceWindow(name string) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	delete(s.maintenance, name)
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) GetMaintenanceWindows() ([]*MaintenanceWindow, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	return s.sortedMaintenanceWindows(), nil
+}
+
+func (s *FileStorage) SaveInhibitRule(rule *InhibitRule) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	copied := *rule
+	s.inhibitRules[rule.Name] = &copied
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) DeleteInhibitRule(name string) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	delete(s.inhibitRules, name)
+	return s.writeAlerts()
+}
+
+func (s *FileStorage) GetInhibitRules() ([]*InhibitRule, error) {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	return s.sortedInhibitRules(), nil
+}
+
+// The sorted* helpers return copies and must be called with alertMu held
+
+func (s *FileStorage) sortedSilences() []*Silence {
+	silences := make([]*Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		copied := *silence
+		silences = append(silences, &copied)
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].CreatedAt.Before(silences[j].CreatedAt) })
+	return silences
+}
+
+func (s *FileStorage) sortedMaintenanceWindows() []*MaintenanceWindow {
+	windows := make([]*MaintenanceWindow, 0, len(s.maintenance))
+	for _, window := range s.maintenance {
+		copied := *window
+		windows = append(windows, &copied)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Name < windows[j].Name })
+	return windows
+}
+
+func (s *FileStorage) sortedInhibitRules() []*InhibitRule {
+	rules := make([]*InhibitRule, 0, len(s.inhibitRules))
+	for _, rule := range s.inhibitRules {
+		copied := *rule
+		rules = append(rules, &copied)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// cleanupAlertHistory drops expired silences and rewrites the history without
+// events older than cutoff
+func (s *FileStorage) cleanupAlertHistory(cutoff time.Time) error {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	expired := false
+	for id, silence := range s.silences {
+		if silence.EndsAt.Before(cutoff) {
+			delete(s.silences, id)
+			expired = true
+		}
+	}
+	if expired {
+		if err := s.writeAlerts(); err != nil {
+			return err
+		}
+	}
+
+	var kept bytes.Buffer
+	dropped := false
+	err := s.scanAlertHistory(func(e *AlertEvent) {
+		if e.Timestamp.Before(cutoff) {
+			dropped = true
+			return
+		}
+		data, _ := json.Marshal(e)
+		kept.Write(append(data, '\n'))
+	})
+	if err != nil || !dropped {
+		return err
+	}
+
+	tmp := s.alertHistoryPath() + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to cleanup alert history: %w", err)
+	}
+	return os.Rename(tmp, s.alertHistoryPath())
+}
+
+// Count scans the segments overlapping the query range, skipping those whose
+// service bloom filter rules out the service
+func (s *FileStorage) Count(q CountQuery) (int64, error) {
+	var store *signalStore
+	var match func(data []byte) (bool, error)
+	switch q.Signal {
+	case "logs":
+		store = s.logs
+		match = func(data []byte) (bool, error) {
+			var l Log
+			if err := json.Unmarshal(data, &l); err != nil {
+				return false, err
+			}
+			return q.matchesLog(&l) && !l.Timestamp.Before(q.Start) && l.Timestamp.Before(q.End), nil
+		}
+	case "traces":
+		store = s.traces
+		match = func(data []byte) (bool, error) {
+			var t Trace
+			if err := json.Unmarshal(data, &t); err != nil {
+				return false, err
+			}
+			return q.matchesSpan(&t) && !t.StartTime.Before(q.Start) && t.StartTime.Before(q.End), nil
+		}
+	default:
+		return 0, fmt.Errorf("unsupported signal: %s", q.Signal)
+	}
+
+	var total int64
+	for _, seg := range store.newestFirst(q.Start, q.End) {
+		if q.ServiceName != "" && !store.mayHaveService(seg, q.ServiceName) {
+			continue
+		}
+		err := store.scan(seg, q.Start, q.End, func(data []byte) error {
+			ok, err := match(data)
+			if ok {
+				total++
+			}
+			return err
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to count %s: %w", q.Signal, err)
+		}
+	}
+	return total, nil
+}
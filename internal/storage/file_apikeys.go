@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func (s *FileStorage) apiKeysPath() string {
+	return filepath.Join(s.config.File.Dir, "api_keys.json")
+}
+
+func (s *FileStorage) loadAPIKeys() error {
+	data, err := os.ReadFile(s.apiKeysPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	for _, key := range keys {
+		s.apiKeys[key.Name] = key
+	}
+	return nil
+}
+
+// sortedAPIKeys lists the API keys, oldest first. The caller must hold
+// apiKeyMu.
+func (s *FileStorage) sortedAPIKeys() []*APIKey {
+	keys := make([]*APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+func (s *FileStorage) SaveAPIKeys(keys []*APIKey) error {
+	s.apiKeyMu.Lock()
+	defer s.apiKeyMu.Unlock()
+
+	for _, key := range keys {
+		s.apiKeys[key.Name] = key
+	}
+
+	data, err := json.Marshal(s.sortedAPIKeys())
+	if err != nil {
+		return err
+	}
+	tmp := s.apiKeysPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write API keys: %w", err)
+	}
+	return os.Rename(tmp, s.apiKeysPath())
+}
+
+func (s *FileStorage) GetAPIKeys() ([]*APIKey, error) {
+	s.apiKeyMu.Lock()
+	defer s.apiKeyMu.Unlock()
+
+	return s.sortedAPIKeys(), nil
+}
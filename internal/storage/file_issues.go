@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func (s *FileStorage) issuesPath() string {
+	return filepath.Join(s.config.File.Dir, "issues.json")
+}
+
+func (s *FileStorage) loadIssues() error {
+	data, err := os.ReadFile(s.issuesPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read issues: %w", err)
+	}
+
+	var issues []*Issue
+	if err := json.Unmarshal(data, &issues); err != nil {
+		return fmt.Errorf("failed to parse issues: %w", err)
+	}
+	for _, issue := range issues {
+		s.issues[issue.ID] = issue
+	}
+	return nil
+}
+
+// writeIssues rewrites issues.json. The caller must hold issueMu.
+func (s *FileStorage) writeIssues() error {
+	data, err := json.Marshal(s.sortedIssues())
+	if err != nil {
+		return err
+	}
+	tmp := s.issuesPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write issues: %w", err)
+	}
+	return os.Rename(tmp, s.issuesPath())
+}
+
+// sortedIssues lists the issues, most recently seen first. The caller must
+// hold issueMu.
+func (s *FileStorage) sortedIssues() []*Issue {
+	issues := make([]*Issue, 0, len(s.issues))
+	for _, issue := range s.issues {
+		issues = append(issues, issue)
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].LastSeen.After(issues[j].LastSeen) })
+	return issues
+}
+
+func (s *FileStorage) SaveIssues(issues []*Issue) error {
+	s.issueMu.Lock()
+	defer s.issueMu.Unlock()
+
+	for _, issue := range issues {
+		s.issues[issue.ID] = issue
+	}
+	return s.writeIssues()
+}
+
+func (s *FileStorage) GetIssues() ([]*Issue, error) {
+	s.issueMu.Lock()
+	defer s.issueMu.Unlock()
+
+	return s.sortedIssues(), nil
+}
+
+// PruneIssues drops issues not seen since before
+func (s *FileStorage) PruneIssues(before time.Time) error {
+	s.issueMu.Lock()
+	defer s.issueMu.Unlock()
+
+	changed := false
+	for id, issue := range s.issues {
+		if issue.LastSeen.Before(before) {
+			delete(s.issues, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.writeIssues()
+}
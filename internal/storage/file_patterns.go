@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// filePattern holds a pattern with its bucket counts keyed by unix nanos
+type filePattern struct {
+	pattern LogPattern
+	counts  map[int64]int64
+}
+
+func (s *FileStorage) patternsPath() string {
+	return filepath.Join(s.config.File.Dir, "patterns.json")
+}
+
+func (s *FileStorage) loadPatterns() error {
+	data, err := os.ReadFile(s.patternsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read log patterns: %w", err)
+	}
+
+	var patterns []*LogPattern
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		return fmt.Errorf("failed to parse log patterns: %w", err)
+	}
+	for _, p := range patterns {
+		fp := &filePattern{pattern: *p, counts: make(map[int64]int64, len(p.Counts))}
+		for _, c := range p.Counts {
+			fp.counts[c.Timestamp.UnixNano()] = c.Count
+		}
+		fp.pattern.Counts = nil
+		s.patterns[p.ID] = fp
+	}
+	return nil
+}
+
+// writePatterns rewrites patterns.json. The caller must hold patternMu.
+func (s *FileStorage) writePatterns() error {
+	data, err := json.Marshal(s.sortedPatterns())
+	if err != nil {
+		return err
+	}
+	tmp := s.patternsPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write log patterns: %w", err)
+	}
+	return os.Rename(tmp, s.patternsPath())
+}
+
+// sortedPatterns copies the patterns with their counts, oldest first. The
+// caller must hold patternMu.
+func (s *FileStorage) sortedPatterns() []*LogPattern {
+	patterns := make([]*LogPattern, 0, len(s.patterns))
+	for _, fp := range s.patterns {
+		p := fp.pattern
+		p.Counts = make([]*PatternCount, 0, len(fp.counts))
+		for ts, count := range fp.counts {
+			p.Counts = append(p.Counts, &PatternCount{Timestamp: time.Unix(0, ts), Count: count})
+		}
+		sort.Slice(p.Counts, func(i, j int) bool { return p.Counts[i].Timestamp.Before(p.Counts[j].Timestamp) })
+		patterns = append(patterns, &p)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].FirstSeen.Before(patterns[j].FirstSeen) })
+	return patterns
+}
+
+// GetPatternLogs returns the logs assigned to a pattern, newest first
+func (s *FileStorage) GetPatternLogs(patternID string, limit int, offset int) ([]*Log, error) {
+	return recent(s.logs, limit, offset, func(l *Log) time.Time { return l.Timestamp },
+		func(l *Log) bool { return l.PatternID == patternID })
+}
+
+func (s *FileStorage) SaveLogPatterns(patterns []*LogPattern) error {
+	s.patternMu.Lock()
+	defer s.patternMu.Unlock()
+
+	for _, p := range patterns {
+		fp, ok := s.patterns[p.ID]
+		if !ok {
+			fp = &filePattern{counts: make(map[int64]int64)}
+			s.patterns[p.ID] = fp
+		}
+		fp.pattern = *p
+		fp.pattern.Counts = nil
+		for _, c := range p.Counts {
+			fp.counts[c.Timestamp.UnixNano()] = c.Count
+		}
+	}
+	return s.writePatterns()
+}
+
+func (s *FileStorage) GetLogPatterns() ([]*LogPattern, error) {
+	s.patternMu.Lock()
+	defer s.patternMu.Unlock()
+
+	return s.sortedPatterns(), nil
+}
+
+// PruneLogPatterns drops bucket counts older than before, and patterns not
+// seen since
+func (s *FileStorage) PruneLogPatterns(before time.Time) error {
+	s.patternMu.Lock()
+	defer s.patternMu.Unlock()
+
+	cutoff := before.UnixNano()
+	changed := false
+	for id, fp := range s.patterns {
+		if fp.pattern.LastSeen.Before(before) {
+			delete(s.patterns, id)
+			changed = true
+			continue
+		}
+		for ts := range fp.counts {
+			if ts < cutoff {
+				delete(fp.counts, ts)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.writePatterns()
+}
@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func testFileConfig(dir string) config.StorageConfig {
+	cfg := config.DefaultConfig().Storage
+	cfg.Type = "file"
+	cfg.File.Dir = dir
+	// Flushes are driven by the tests
+	cfg.File.FlushInterval = time.Hour
+	return cfg
+}
+
+func openTestFileStorage(t *testing.T, cfg config.StorageConfig) *FileStorage {
+	t.Helper()
+	s, err := NewFileStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	return s
+}
+
+func spanIDs(spans []*Trace) []string {
+	ids := make([]string, 0, len(spans))
+	for _, span := range spans {
+		ids = append(ids, span.SpanID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	now := time.Now()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	cfg := testFileConfig(t.TempDir())
+
+	s := openTestFileStorage(t, cfg)
+	spans := []*Trace{
+		{TraceID: traceID, SpanID: "a", ServiceName: "checkout", StartTime: now.Add(-2 * time.Hour)},
+		{TraceID: traceID, SpanID: "b", ServiceName: "payments", StartTime: now},
+		{TraceID: "other", SpanID: "c", ServiceName: "checkout", StartTime: now},
+	}
+	for _, span := range spans {
+		if err := s.InsertTrace(span); err != nil {
+			t.Fatalf("InsertTrace: %v", err)
+		}
+	}
+	logTrace := traceID
+	if err := s.InsertLog(&Log{Timestamp: now, ServiceName: "payments", Message: "charged", TraceID: &logTrace}); err != nil {
+		t.Fatalf("InsertLog: %v", err)
+	}
+	if err := s.InsertMetric(&Metric{Timestamp: now, MetricName: "requests", Value: 3, ServiceName: "checkout"}); err != nil {
+		t.Fatalf("InsertMetric: %v", err)
+	}
+
+	// Records are readable before and after being flushed and reopened
+	for _, stage := range []string{"pending", "reopened"} {
+		if stage == "reopened" {
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			s = openTestFileStorage(t, cfg)
+		}
+
+		got, err := s.GetTrace(traceID)
+		if err != nil {
+			t.Fatalf("%s: GetTrace: %v", stage, err)
+		}
+		if ids := spanIDs(got); !equalStrings(ids, []string{"a", "b"}) {
+			t.Errorf("%s: GetTrace spans = %v, want [a b]", stage, ids)
+		}
+
+		logs, err := s.GetTraceLogs(traceID)
+		if err != nil {
+			t.Fatalf("%s: GetTraceLogs: %v", stage, err)
+		}
+		if len(logs) != 1 || logs[0].Message != "charged" {
+			t.Errorf("%s: GetTraceLogs = %+v, want the charged log", stage, logs)
+		}
+
+		metrics, err := s.GetMetrics(10, 0)
+		if err != nil {
+			t.Fatalf("%s: GetMetrics: %v", stage, err)
+		}
+		if len(metrics) != 1 || metrics[0].Value != 3 {
+			t.Errorf("%s: GetMetrics = %+v, want one metric of 3", stage, metrics)
+		}
+	}
+
+	// IDs continue after a reopen
+	span := &Trace{TraceID: "next", SpanID: "d", ServiceName: "checkout", StartTime: now}
+	if err := s.InsertTrace(span); err != nil {
+		t.Fatalf("InsertTrace: %v", err)
+	}
+	if span.ID <= 3 {
+		t.Errorf("span ID after reopen = %d, want > 3", span.ID)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestFileStorageCrashRecovery(t *testing.T) {
+	now := time.Now()
+	cfg := testFileConfig(t.TempDir())
+
+	s := openTestFileStorage(t, cfg)
+	if err := s.InsertTrace(&Trace{TraceID: "t1", SpanID: "a", ServiceName: "checkout", StartTime: now}); err != nil {
+		t.Fatalf("InsertTrace: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A block written before a crash but never indexed
+	data, err := filepath.Glob(filepath.Join(cfg.File.Dir, "traces", "*"+segmentDataExt))
+	if err != nil || len(data) != 1 {
+		t.Fatalf("segment data files = %v (%v), want one", data, err)
+	}
+	file, err := os.OpenFile(data[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("torn block")); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	s = openTestFileStorage(t, cfg)
+	if err := s.InsertTrace(&Trace{TraceID: "t1", SpanID: "b", ServiceName: "checkout", StartTime: now}); err != nil {
+		t.Fatalf("InsertTrace: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openTestFileStorage(t, cfg)
+	defer s.Close()
+	got, err := s.GetTrace("t1")
+	if err != nil {
+		t.Fatalf("GetTrace after recovery: %v", err)
+	}
+	if ids := spanIDs(got); !equalStrings(ids, []string{"a", "b"}) {
+		t.Errorf("GetTrace spans = %v, want [a b]", ids)
+	}
+}
+
+func TestFileStorageRetention(t *testing.T) {
+	now := time.Now()
+	cfg := testFileConfig(t.TempDir())
+	cfg.RetentionDays = 1
+
+	s := openTestFileStorage(t, cfg)
+	defer s.Close()
+
+	tests := []struct {
+		spanID string
+		age    time.Duration
+		kept   bool
+	}{
+		{"expired", 72 * time.Hour, false},
+		{"boundary", 26 * time.Hour, false},
+		{"recent", time.Hour, true},
+		{"current", 0, true},
+	}
+	for _, tt := range tests {
+		if err := s.InsertTrace(&Trace{TraceID: "t1", SpanID: tt.spanID, ServiceName: "checkout", StartTime: now.Add(-tt.age)}); err != nil {
+			t.Fatalf("InsertTrace: %v", err)
+		}
+	}
+	if err := s.traces.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if err := s.CleanupOldData(); err != nil {
+		t.Fatalf("CleanupOldData: %v", err)
+	}
+
+	got, err := s.GetTrace("t1")
+	if err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	var want []string
+	for _, tt := range tests {
+		if tt.kept {
+			want = append(want, tt.spanID)
+		}
+	}
+	sort.Strings(want)
+	if ids := spanIDs(got); !equalStrings(ids, want) {
+		t.Errorf("spans after cleanup = %v, want %v", ids, want)
+	}
+
+	indexes, _ := filepath.Glob(filepath.Join(cfg.File.Dir, "traces", "*"+segmentIndexExt))
+	if len(indexes) != len(s.traces.segments) {
+		t.Errorf("index files = %d, want one per remaining segment (%d)", len(indexes), len(s.traces.segments))
+	}
+}
+
+func TestSegmentFlushClosesSealedSegments(t *testing.T) {
+	now := time.Now()
+	cfg := testFileConfig(t.TempDir())
+
+	s := openTestFileStorage(t, cfg)
+	defer s.Close()
+
+	old := &Trace{TraceID: "t1", SpanID: "old", ServiceName: "checkout", StartTime: now.Add(-3 * time.Hour)}
+	current := &Trace{TraceID: "t1", SpanID: "current", ServiceName: "checkout", StartTime: now}
+	for _, span := range []*Trace{old, current} {
+		if err := s.InsertTrace(span); err != nil {
+			t.Fatalf("InsertTrace: %v", err)
+		}
+	}
+	if err := s.traces.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	for _, seg := range s.traces.segments {
+		sealed := seg.sealed(now)
+		if open := seg.file != nil; open == sealed {
+			t.Errorf("segment %s: file open = %v, sealed = %v", seg.dataPath, open, sealed)
+		}
+		if seg.dirty {
+			t.Errorf("segment %s: index not written", seg.dataPath)
+		}
+	}
+
+	// An unchanged index is not rewritten
+	past := now.Add(-time.Minute).Truncate(time.Second)
+	for _, seg := range s.traces.segments {
+		if err := os.Chtimes(seg.indexPath, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.traces.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	for _, seg := range s.traces.segments {
+		info, err := os.Stat(seg.indexPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(past) {
+			t.Errorf("segment %s: index rewritten without changes", seg.dataPath)
+		}
+	}
+
+	// A late record reopens the sealed segment
+	late := &Trace{TraceID: "t1", SpanID: "late", ServiceName: "checkout", StartTime: old.StartTime}
+	if err := s.InsertTrace(late); err != nil {
+		t.Fatalf("InsertTrace: %v", err)
+	}
+	if err := s.traces.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	got, err := s.GetTrace("t1")
+	if err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	if ids := spanIDs(got); !equalStrings(ids, []string{"current", "late", "old"}) {
+		t.Errorf("spans = %v, want [current late old]", ids)
+	}
+}
+
+// Run with -race: queries consult bloom filters that inserts update
+func TestFileStorageConcurrentInsertAndQuery(t *testing.T) {
+	s := openTestFileStorage(t, testFileConfig(t.TempDir()))
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			s.InsertTrace(&Trace{TraceID: "t1", SpanID: "a", ServiceName: "checkout", StartTime: time.Now()})
+			s.InsertLog(&Log{Timestamp: time.Now(), ServiceName: "checkout", Message: "m"})
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if _, err := s.GetTrace("t1"); err != nil {
+			t.Fatalf("GetTrace: %v", err)
+		}
+		if _, err := s.GetServiceLogs("checkout", time.Time{}, time.Time{}, 10); err != nil {
+			t.Fatalf("GetServiceLogs: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestFileStorageRecentReadsNewestBlocks(t *testing.T) {
+	cfg := testFileConfig(t.TempDir())
+	cfg.File.SegmentDuration = time.Hour
+	cfg.File.BlockSize = 3
+	s := openTestFileStorage(t, cfg)
+	defer s.Close()
+
+	// Ten records an hour over three hours, written out of order so blocks
+	// overlap in time, and one late record for the oldest hour
+	base := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	var times []time.Time
+	for i := 0; i < 30; i++ {
+		times = append(times, base.Add(time.Duration((i*7)%30)*6*time.Minute))
+	}
+	times = append(times, base.Add(time.Minute))
+	for _, ts := range times {
+		if err := s.InsertLog(&Log{Timestamp: ts, ServiceName: "checkout", Message: ts.Format(time.RFC3339Nano)}); err != nil {
+			t.Fatalf("InsertLog: %v", err)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+
+	for _, page := range []struct{ limit, offset int }{{1, 0}, {5, 0}, {4, 8}, {10, 25}, {50, 0}, {5, 40}} {
+		logs, err := s.GetLogs(page.limit, page.offset)
+		if err != nil {
+			t.Fatalf("GetLogs(%d, %d): %v", page.limit, page.offset, err)
+		}
+		want := times[min(page.offset, len(times)):min(page.offset+page.limit, len(times))]
+		if len(logs) != len(want) {
+			t.Fatalf("GetLogs(%d, %d) returned %d logs, want %d", page.limit, page.offset, len(logs), len(want))
+		}
+		for i, l := range logs {
+			if !l.Timestamp.Equal(want[i]) {
+				t.Errorf("GetLogs(%d, %d)[%d] = %v, want %v", page.limit, page.offset, i, l.Timestamp, want[i])
+			}
+		}
+	}
+
+	// The newest records are found without reading the oldest segment
+	if err := s.logs.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	oldest := s.logs.newestFirst(time.Time{}, time.Time{})[2]
+	if err := os.WriteFile(oldest.dataPath, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetLogs(10, 0); err != nil {
+		t.Errorf("GetLogs of the newest records: %v", err)
+	}
+	if _, err := s.GetLogs(30, 0); err == nil {
+		t.Error("GetLogs reaching the corrupt segment succeeded, want an error")
+	}
+}
+
+func TestFileStorageScanReleasesLock(t *testing.T) {
+	s := openTestFileStorage(t, testFileConfig(t.TempDir()))
+	defer s.Close()
+
+	now := time.Now()
+	if err := s.InsertLog(&Log{Timestamp: now, ServiceName: "checkout", Message: "first"}); err != nil {
+		t.Fatalf("InsertLog: %v", err)
+	}
+	// Writes are not blocked while records are read
+	for _, seg := range s.logs.newestFirst(time.Time{}, time.Time{}) {
+		err := s.logs.scan(seg, time.Time{}, time.Time{}, func(data []byte) error {
+			return s.InsertLog(&Log{Timestamp: now, ServiceName: "checkout", Message: "second"})
+		})
+		if err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+	}
+	logs, err := s.GetLogs(10, 0)
+	if err != nil || len(logs) != 2 {
+		t.Errorf("GetLogs = %d logs, %v; want 2", len(logs), err)
+	}
+}
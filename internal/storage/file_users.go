@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// userFile is the layout of users.json, which holds the API-defined users
+// and their tokens
+type userFile struct {
+	Users  []*User      `json:"users"`
+	Tokens []*UserToken `json:"tokens"`
+}
+
+func (s *FileStorage) usersPath() string {
+	return filepath.Join(s.config.File.Dir, "users.json")
+}
+
+func (s *FileStorage) loadUsers() error {
+	data, err := os.ReadFile(s.usersPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users: %w", err)
+	}
+
+	var file userFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse users: %w", err)
+	}
+	for _, user := range file.Users {
+		s.users[user.Username] = user
+	}
+	for _, token := range file.Tokens {
+		s.userTokens[token.ID] = token
+	}
+	return nil
+}
+
+// sortedUsers lists the users by name. The caller must hold userMu.
+func (s *FileStorage) sortedUsers() []*User {
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// sortedUserTokens lists the user tokens, oldest first. The caller must hold
+// userMu.
+func (s *FileStorage) sortedUserTokens() []*UserToken {
+	tokens := make([]*UserToken, 0, len(s.userTokens))
+	for _, token := range s.userTokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// writeUsers rewrites users.json. The caller must hold userMu.
+func (s *FileStorage) writeUsers() error {
+	data, err := json.Marshal(userFile{Users: s.sortedUsers(), Tokens: s.sortedUserTokens()})
+	if err != nil {
+		return err
+	}
+	tmp := s.usersPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	return os.Rename(tmp, s.usersPath())
+}
+
+func (s *FileStorage) SaveUser(user *User) error {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	s.users[user.Username] = user
+	return s.writeUsers()
+}
+
+// DeleteUser removes a user with its tokens
+func (s *FileStorage) DeleteUser(username string) error {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	delete(s.users, username)
+	for id, token := range s.userTokens {
+		if token.Username == username {
+			delete(s.userTokens, id)
+		}
+	}
+	return s.writeUsers()
+}
+
+func (s *FileStorage) GetUsers() ([]*User, error) {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	return s.sortedUsers(), nil
+}
+
+func (s *FileStorage) SaveUserToken(token *UserToken) error {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	s.userTokens[token.ID] = token
+	return s.writeUsers()
+}
+
+func (s *FileStorage) DeleteUserToken(id string) error {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	delete(s.userTokens, id)
+	return s.writeUsers()
+}
+
+func (s *FileStorage) GetUserTokens() ([]*UserToken, error) {
+	s.userMu.Lock()
+	defer s.userMu.Unlock()
+
+	return s.sortedUserTokens(), nil
+}
@@ -1,23 +1,112 @@
 package storage
 
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
 // Storage interface defines the contract for data storage
 type Storage interface {
 	// Metrics
 	InsertMetric(metric *Metric) error
 	GetMetrics(limit int, offset int) ([]*Metric, error)
+	QueryMetricRange(q MetricQuery) (*MetricQueryResult, error)
+	QueryExemplars(q MetricQuery) ([]*Exemplar, error)
 
 	// Traces
 	InsertTrace(trace *Trace) error
 	GetTraces(limit int, offset int) ([]*Trace, error)
+	GetTrace(traceID string) ([]*Trace, error)
 
 	// Logs
 	InsertLog(log *Log) error
 	GetLogs(limit int, offset int) ([]*Log, error)
+	GetTraceLogs(traceID string) ([]*Log, error)
+	GetServiceLogs(service string, from, to time.Time, limit int) ([]*Log, error)
+	GetPatternLogs(patternID string, limit int, offset int) ([]*Log, error)
+
+	// Log patterns
+	SaveLogPatterns(patterns []*LogPattern) error
+	GetLogPatterns() ([]*LogPattern, error)
+	PruneLogPatterns(before time.Time) error
+
+	// Issues
+	SaveIssues(issues []*Issue) error
+	GetIssues() ([]*Issue, error)
+	PruneIssues(before time.Time) error
+
+	// API keys
+	SaveAPIKeys(keys []*APIKey) error
+	GetAPIKeys() ([]*APIKey, error)
+
+	// Users
+	SaveUser(user *User) error
+	DeleteUser(username string) error
+	GetUsers() ([]*User, error)
+	SaveUserToken(token *UserToken) error
+	DeleteUserToken(id string) error
+	GetUserTokens() ([]*UserToken, error)
 
 	// Services
 	GetServices() ([]string, error)
+	ObserveResource(res ServiceResource) error
+	GetServiceCatalog(window time.Duration) ([]*ServiceSummary, error)
+	GetServiceDetail(name string, window time.Duration) (*ServiceDetail, error)
+	GetServiceGraph(window time.Duration) (*ServiceGraph, error)
+
+	// Counts
+	Count(q CountQuery) (int64, error)
+
+	// Alerting
+	SaveAlertRule(rule *config.AlertRuleConfig) error
+	DeleteAlertRule(name string) error
+	GetAlertRules() ([]*config.AlertRuleConfig, error)
+	SaveAlertState(state *AlertState) error
+	DeleteAlertState(ruleName, labels string) error
+	GetAlertStates() ([]*AlertState, error)
+	InsertAlertEvent(event *AlertEvent) error
+	GetAlertHistory(q AlertHistoryQuery) ([]*AlertEvent, error)
+	SaveSilence(silence *Silence) error
+	DeleteSilence(id string) error
+	GetSilences() ([]*Silence, error)
+	SaveMaintenanceWindow(window *MaintenanceWindow) error
+	DeleteMaintenanceWindow(name string) error
+	GetMaintenanceWindows() ([]*MaintenanceWindow, error)
+	SaveInhibitRule(rule *InhibitRule) error
+	DeleteInhibitRule(name string) error
+	GetInhibitRules() ([]*InhibitRule, error)
 
 	// Cleanup
 	CleanupOldData() error
 	Close() error
 }
+
+// New creates the storage backend selected by cfg.Type
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Type {
+	case "sqlite":
+		return NewSQLiteStorage(cfg)
+	case "file":
+		return NewFileStorage(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Type)
+	}
+}
+
+// TenantConfig returns the storage config of a tenant other than the default
+// one. Its database and segments live under a "tenants" directory next to
+// the default tenant's.
+func TenantConfig(cfg config.StorageConfig, tenant string) config.StorageConfig {
+	if cfg.Path != ":memory:" {
+		cfg.Path = tenantPath(cfg.Path, tenant)
+	}
+	cfg.File.Dir = tenantPath(cfg.File.Dir, tenant)
+	return cfg
+}
+
+func tenantPath(path, tenant string) string {
+	return filepath.Join(filepath.Dir(path), "tenants", tenant, filepath.Base(path))
+}
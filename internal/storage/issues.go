@@ -0,0 +1,33 @@
+package storage
+
+import "time"
+
+// Issue statuses. A resolved issue that occurs again becomes regressed.
+const (
+	IssueUnresolved = "unresolved"
+	IssueResolved   = "resolved"
+	IssueIgnored    = "ignored"
+	IssueRegressed  = "regressed"
+)
+
+// Issue groups the occurrences of an exception sharing a fingerprint: its
+// type and normalized stack frames, or its normalized message when there is
+// no stack trace. Occurrences come from exception span events and error
+// logs.
+type Issue struct {
+	ID            string     `json:"id"` // fingerprint
+	Type          string     `json:"type"`
+	Message       string     `json:"message"`
+	Culprit       string     `json:"culprit,omitempty"` // top stack frame
+	Stacktrace    string     `json:"stacktrace,omitempty"`
+	Status        string     `json:"status"`
+	Count         int64      `json:"count"`
+	FirstSeen     time.Time  `json:"first_seen"`
+	LastSeen      time.Time  `json:"last_seen"`
+	Services      []string   `json:"services"`
+	Versions      []string   `json:"versions"`
+	SampleTraceID string     `json:"sample_trace_id,omitempty"`
+	SampleSpanID  string     `json:"sample_span_id,omitempty"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+	RegressedAt   *time.Time `json:"regressed_at,omitempty"`
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// partitionsMarker starts the per-partition section of a migration file. The
+// SQL that follows is run once for every partition table of the named signal,
+// with {{partition}} replaced by the table name.
+const partitionsMarker = "-- +partitions "
+
+// Migration is a single ordered, forward-only schema change
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	SQL     string `json:"-"`
+
+	PartitionSignal string `json:"-"`
+	PartitionSQL    string `json:"-"`
+}
+
+// loadMigrations returns the embedded migrations ordered by version. Files are
+// named NNNN_description.sql.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, desc, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m := Migration{Version: version, Name: desc, SQL: string(data)}
+		if i := strings.Index(m.SQL, partitionsMarker); i >= 0 {
+			section := m.SQL[i+len(partitionsMarker):]
+			signal, body, _ := strings.Cut(section, "\n")
+			m.SQL = m.SQL[:i]
+			m.PartitionSignal = strings.TrimSpace(signal)
+			m.PartitionSQL = body
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// schemaVersion returns the highest applied migration version, or 0 for a
+// database that has never been migrated
+func schemaVersion(db *sql.DB) (int, error) {
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// pendingMigrations returns the migrations newer than the database schema. It
+// fails if the database was written by a newer binary.
+func pendingMigrations(db *sql.DB) (int, []Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	if current > latest {
+		return current, nil, fmt.Errorf("database schema version %d is newer than the latest known version %d; refusing to start", current, latest)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	return current, pending, nil
+}
+
+// migrate applies all pending migrations, each in its own transaction. When
+// the database already holds data it is backed up first.
+func (s *SQLiteStorage) migrate() error {
+	current, pending, err := pendingMigrations(s.db)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := s.backup(current); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	for _, m := range pending {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", m.Version, err)
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := applyToPartitions(tx, m); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// applyToPartitions runs the per-partition section of m against every
+// partition table of its signal
+func applyToPartitions(tx *sql.Tx, m Migration) error {
+	if m.PartitionSignal == "" {
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT name FROM partitions WHERE signal = ?`, m.PartitionSignal)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := tx.Exec(strings.ReplaceAll(m.PartitionSQL, "{{partition}}", name)); err != nil {
+			return fmt.Errorf("partition %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// backup snapshots a non-empty database next to the original file before it
+// is migrated
+func (s *SQLiteStorage) backup(version int) error {
+	if s.config.Path == ":memory:" {
+		return nil
+	}
+
+	var tables int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'`).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", s.config.Path, version, time.Now().Format("20060102T150405"))
+	if _, err := s.db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return fmt.Errorf("failed to back up database to %s: %w", backupPath, err)
+	}
+
+	return nil
+}
+
+// PendingMigrations opens the SQLite database described by cfg and reports the
+// current schema version and the migrations that would be applied, without
+// applying them
+func PendingMigrations(cfg config.StorageConfig) (int, []Migration, error) {
+	db, err := openSQLiteDB(cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+
+	return pendingMigrations(db)
+}
@@ -0,0 +1,48 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created before versioned
+-- migrations were introduced are adopted without changes.
+CREATE TABLE IF NOT EXISTS metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL,
+	metric_name TEXT NOT NULL,
+	value REAL NOT NULL,
+	labels TEXT,
+	service_name TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS traces (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	trace_id TEXT NOT NULL,
+	span_id TEXT NOT NULL,
+	parent_span_id TEXT,
+	service_name TEXT,
+	operation_name TEXT,
+	start_time INTEGER NOT NULL,
+	duration_nanos INTEGER NOT NULL,
+	attributes TEXT,
+	status_code TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL,
+	service_name TEXT,
+	level TEXT,
+	message TEXT,
+	attributes TEXT,
+	trace_id TEXT,
+	span_id TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+-- Indexes for performance
+CREATE INDEX IF NOT EXISTS idx_metrics_timestamp ON metrics(timestamp);
+CREATE INDEX IF NOT EXISTS idx_metrics_service ON metrics(service_name);
+CREATE INDEX IF NOT EXISTS idx_metrics_name ON metrics(metric_name);
+CREATE INDEX IF NOT EXISTS idx_traces_trace_id ON traces(trace_id);
+CREATE INDEX IF NOT EXISTS idx_traces_service ON traces(service_name);
+CREATE INDEX IF NOT EXISTS idx_traces_start_time ON traces(start_time);
+CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp);
+CREATE INDEX IF NOT EXISTS idx_logs_service ON logs(service_name);
+CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level);
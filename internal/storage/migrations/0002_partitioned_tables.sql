@@ -0,0 +1,61 @@
+-- Signals are sharded into time-partitioned tables (metrics_p20261018_0000,
+-- ...) registered in the partitions catalog. The base tables become empty
+-- templates whose column definitions are copied into every new partition.
+ALTER TABLE metrics RENAME TO metrics_legacy;
+ALTER TABLE traces RENAME TO traces_legacy;
+ALTER TABLE logs RENAME TO logs_legacy;
+
+CREATE TABLE metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL,
+	metric_name TEXT NOT NULL,
+	value REAL NOT NULL,
+	labels TEXT,
+	service_name TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE TABLE traces (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	trace_id TEXT NOT NULL,
+	span_id TEXT NOT NULL,
+	parent_span_id TEXT,
+	service_name TEXT,
+	operation_name TEXT,
+	start_time INTEGER NOT NULL,
+	duration_nanos INTEGER NOT NULL,
+	attributes TEXT,
+	status_code TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE TABLE logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL,
+	service_name TEXT,
+	level TEXT,
+	message TEXT,
+	attributes TEXT,
+	trace_id TEXT,
+	span_id TEXT,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE TABLE partitions (
+	name TEXT PRIMARY KEY,
+	signal TEXT NOT NULL,
+	start_time INTEGER NOT NULL,
+	end_time INTEGER NOT NULL
+);
+
+CREATE INDEX idx_partitions_signal ON partitions(signal, start_time);
+
+-- Rows written before partitioning stay queryable as a single partition per
+-- signal spanning their full time range. An empty legacy table gets an empty
+-- range and is dropped by the next retention pass.
+INSERT INTO partitions (name, signal, start_time, end_time)
+SELECT 'metrics_legacy', 'metrics', COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp) + 1, 0) FROM metrics_legacy;
+INSERT INTO partitions (name, signal, start_time, end_time)
+SELECT 'traces_legacy', 'traces', COALESCE(MIN(start_time), 0), COALESCE(MAX(start_time) + 1, 0) FROM traces_legacy;
+INSERT INTO partitions (name, signal, start_time, end_time)
+SELECT 'logs_legacy', 'logs', COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp) + 1, 0) FROM logs_legacy;
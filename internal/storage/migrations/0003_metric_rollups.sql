@@ -0,0 +1,20 @@
+-- Downsampled metric tiers. Each row aggregates one series over one bucket of
+-- a tier; resolution is in seconds and bucket is the bucket start in unix
+-- nanos. Rows are merged on conflict so partial aggregates can be flushed
+-- repeatedly.
+CREATE TABLE metric_rollups (
+	resolution INTEGER NOT NULL,
+	bucket INTEGER NOT NULL,
+	metric_name TEXT NOT NULL,
+	service_name TEXT NOT NULL DEFAULT '',
+	labels TEXT NOT NULL DEFAULT '',
+	min REAL NOT NULL,
+	max REAL NOT NULL,
+	sum REAL NOT NULL,
+	count INTEGER NOT NULL,
+	last REAL NOT NULL,
+	last_timestamp INTEGER NOT NULL,
+	PRIMARY KEY (resolution, metric_name, service_name, labels, bucket)
+);
+
+CREATE INDEX idx_metric_rollups_bucket ON metric_rollups(resolution, bucket);
@@ -0,0 +1,94 @@
+-- Metric identities (name, service, label set) are normalized into the series
+-- dictionary. Datapoints and rollups reference series by ID, and
+-- series_labels is an inverted index from label pairs to series. A series is
+-- unique by its full identity; the fingerprint only speeds up lookups, as
+-- distinct identities may share one.
+CREATE TABLE series (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	fingerprint INTEGER NOT NULL,
+	metric_name TEXT NOT NULL,
+	service_name TEXT NOT NULL DEFAULT '',
+	labels TEXT NOT NULL DEFAULT '{}',
+	created_at INTEGER DEFAULT (strftime('%s', 'now')),
+	UNIQUE (metric_name, service_name, labels)
+);
+
+CREATE INDEX idx_series_fingerprint ON series(fingerprint);
+
+CREATE TABLE series_labels (
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	series_id INTEGER NOT NULL,
+	PRIMARY KEY (name, value, series_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_series_labels_series ON series_labels(series_id);
+
+CREATE TRIGGER series_labels_index AFTER INSERT ON series
+BEGIN
+	INSERT OR IGNORE INTO series_labels (name, value, series_id)
+	SELECT key, value, NEW.id
+	FROM json_each(CASE WHEN json_valid(NEW.labels) THEN NEW.labels ELSE '{}' END);
+END;
+
+DROP TABLE metrics;
+
+CREATE TABLE metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	series_id INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	value REAL NOT NULL,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+INSERT OR IGNORE INTO series (fingerprint, metric_name, service_name, labels)
+SELECT DISTINCT series_fingerprint(metric_name, service_name, labels), metric_name, service_name, labels
+FROM metric_rollups;
+
+CREATE TABLE metric_rollups_v2 (
+	resolution INTEGER NOT NULL,
+	bucket INTEGER NOT NULL,
+	series_id INTEGER NOT NULL,
+	min REAL NOT NULL,
+	max REAL NOT NULL,
+	sum REAL NOT NULL,
+	count INTEGER NOT NULL,
+	last REAL NOT NULL,
+	last_timestamp INTEGER NOT NULL,
+	PRIMARY KEY (resolution, series_id, bucket)
+);
+
+INSERT INTO metric_rollups_v2 (resolution, bucket, series_id, min, max, sum, count, last, last_timestamp)
+SELECT r.resolution, r.bucket, s.id, r.min, r.max, r.sum, r.count, r.last, r.last_timestamp
+FROM metric_rollups r
+JOIN series s ON s.fingerprint = series_fingerprint(r.metric_name, r.service_name, r.labels)
+	AND s.metric_name = r.metric_name AND s.service_name = r.service_name AND s.labels = r.labels;
+
+DROP TABLE metric_rollups;
+ALTER TABLE metric_rollups_v2 RENAME TO metric_rollups;
+CREATE INDEX idx_metric_rollups_bucket ON metric_rollups(resolution, bucket);
+
+-- +partitions metrics
+INSERT OR IGNORE INTO series (fingerprint, metric_name, service_name, labels)
+SELECT DISTINCT series_fingerprint(metric_name, COALESCE(service_name, ''), COALESCE(labels, '{}')),
+	metric_name, COALESCE(service_name, ''), COALESCE(labels, '{}')
+FROM {{partition}};
+
+CREATE TABLE {{partition}}_v2 (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	series_id INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	value REAL NOT NULL,
+	created_at INTEGER DEFAULT (strftime('%s', 'now'))
+);
+
+INSERT INTO {{partition}}_v2 (id, series_id, timestamp, value, created_at)
+SELECT m.id, s.id, m.timestamp, m.value, m.created_at
+FROM {{partition}} m
+JOIN series s ON s.fingerprint = series_fingerprint(m.metric_name, COALESCE(m.service_name, ''), COALESCE(m.labels, '{}'))
+	AND s.metric_name = m.metric_name AND s.service_name = COALESCE(m.service_name, '') AND s.labels = COALESCE(m.labels, '{}');
+
+DROP TABLE {{partition}};
+ALTER TABLE {{partition}}_v2 RENAME TO {{partition}};
+CREATE INDEX idx_{{partition}}_timestamp ON {{partition}}(timestamp);
+CREATE INDEX idx_{{partition}}_series_id_timestamp ON {{partition}}(series_id, timestamp);
@@ -0,0 +1,18 @@
+-- The service catalog is maintained at ingest. Entries are written back
+-- periodically; per-minute activity counters are kept in memory only.
+CREATE TABLE services (
+	name TEXT PRIMARY KEY,
+	first_seen INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL,
+	signals TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE TABLE service_instances (
+	service_name TEXT NOT NULL,
+	instance_id TEXT NOT NULL,
+	version TEXT NOT NULL DEFAULT '',
+	environment TEXT NOT NULL DEFAULT '',
+	first_seen INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL,
+	PRIMARY KEY (service_name, instance_id)
+);
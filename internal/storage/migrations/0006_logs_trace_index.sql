@@ -0,0 +1,4 @@
+-- Logs are looked up by trace ID to correlate them with spans. New partitions
+-- get the index from the partition definition; existing ones are indexed here.
+-- +partitions logs
+CREATE INDEX IF NOT EXISTS idx_{{partition}}_trace_id ON {{partition}}(trace_id);
@@ -0,0 +1,14 @@
+-- Exemplars link metric datapoints to example traces. They are few compared
+-- to datapoints, so a single table keyed by series and time is enough.
+CREATE TABLE exemplars (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	series_id INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	value REAL NOT NULL,
+	trace_id TEXT NOT NULL DEFAULT '',
+	span_id TEXT NOT NULL DEFAULT '',
+	attributes TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_exemplars_series_timestamp ON exemplars(series_id, timestamp);
+CREATE INDEX idx_exemplars_timestamp ON exemplars(timestamp);
@@ -0,0 +1,33 @@
+-- Alerting rules created through the API (rules from the config file are not
+-- stored), the current state of each alert and the history of transitions
+CREATE TABLE alert_rules (
+	name TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE alert_states (
+	rule_name TEXT NOT NULL,
+	labels TEXT NOT NULL,
+	annotations TEXT NOT NULL DEFAULT '{}',
+	state TEXT NOT NULL,
+	value REAL NOT NULL,
+	active_at INTEGER NOT NULL,
+	fired_at INTEGER NOT NULL DEFAULT 0,
+	resolved_at INTEGER NOT NULL DEFAULT 0,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (rule_name, labels)
+);
+
+CREATE TABLE alert_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	rule_name TEXT NOT NULL,
+	labels TEXT NOT NULL,
+	state TEXT NOT NULL,
+	value REAL NOT NULL,
+	timestamp INTEGER NOT NULL
+);
+
+CREATE INDEX idx_alert_history_timestamp ON alert_history(timestamp);
+CREATE INDEX idx_alert_history_rule_name ON alert_history(rule_name, timestamp);
@@ -0,0 +1,24 @@
+-- Silences, recurring maintenance windows and inhibition rules, stored as
+-- JSON definitions
+CREATE TABLE silences (
+	id TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	ends_at INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_silences_ends_at ON silences(ends_at);
+
+CREATE TABLE maintenance_windows (
+	name TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE inhibit_rules (
+	name TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
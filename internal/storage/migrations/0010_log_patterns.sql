@@ -0,0 +1,27 @@
+-- Log patterns are mined at ingest and written back periodically with their
+-- counts per time bucket. Logs reference the pattern they were assigned.
+CREATE TABLE log_patterns (
+	id TEXT PRIMARY KEY,
+	service_name TEXT NOT NULL,
+	template TEXT NOT NULL,
+	count INTEGER NOT NULL DEFAULT 0,
+	first_seen INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL
+);
+
+CREATE INDEX idx_log_patterns_last_seen ON log_patterns(last_seen);
+
+CREATE TABLE log_pattern_counts (
+	pattern_id TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (pattern_id, timestamp)
+);
+
+CREATE INDEX idx_log_pattern_counts_timestamp ON log_pattern_counts(timestamp);
+
+ALTER TABLE logs ADD COLUMN pattern_id TEXT;
+
+-- +partitions logs
+ALTER TABLE {{partition}} ADD COLUMN pattern_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_{{partition}}_pattern_id ON {{partition}}(pattern_id);
@@ -0,0 +1,9 @@
+-- Error tracking issues are grouped at ingest and written back periodically
+CREATE TABLE issues (
+	id TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	status TEXT NOT NULL,
+	last_seen INTEGER NOT NULL
+);
+
+CREATE INDEX idx_issues_last_seen ON issues(last_seen);
@@ -0,0 +1,7 @@
+-- Ingestion API keys created through the admin API. Only secret hashes are
+-- stored; last-used times are written back periodically.
+CREATE TABLE api_keys (
+	name TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Local user accounts and their API tokens created through the API. Only
+-- password and token hashes are stored.
+CREATE TABLE users (
+	username TEXT PRIMARY KEY,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+CREATE TABLE user_tokens (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	definition TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_user_tokens_username ON user_tokens (username);
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// partitionedTable describes how a signal's template table is sharded. Each
+// index is a column list.
+type partitionedTable struct {
+	indexes []string
+}
+
+var partitionedTables = map[string]partitionedTable{
+	"metrics": {indexes: []string{"timestamp", "series_id, timestamp"}},
+	"traces":  {indexes: []string{"start_time", "trace_id", "service_name"}},
+	"logs":    {indexes: []string{"timestamp", "service_name", "level", "trace_id", "pattern_id"}},
+}
+
+// partition is one table holding a signal's rows for [start, end) in unix nanos
+type partition struct {
+	name   string
+	signal string
+	start  int64
+	end    int64
+}
+
+func (p partition) legacy() bool {
+	return p.name == p.signal+"_legacy"
+}
+
+func (p partition) overlaps(from, to int64) bool {
+	return p.start < to && p.end > from
+}
+
+// loadPartitions reads the partition catalog into memory
+func (s *SQLiteStorage) loadPartitions() error {
+	rows, err := s.db.Query(`SELECT name, signal, start_time, end_time FROM partitions ORDER BY start_time`)
+	if err != nil {
+		return fmt.Errorf("failed to load partitions: %w", err)
+	}
+	defer rows.Close()
+
+	s.partitions = make(map[string][]partition)
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.name, &p.signal, &p.start, &p.end); err != nil {
+			return err
+		}
+		s.partitions[p.signal] = append(s.partitions[p.signal], p)
+	}
+	return rows.Err()
+}
+
+// partitionFor returns the partition table that rows of signal at ts should be
+// written to, creating it from the signal's template on first use
+func (s *SQLiteStorage) partitionFor(signal string, ts int64) (string, error) {
+	s.partitionMu.RLock()
+	for _, p := range s.partitions[signal] {
+		if !p.legacy() && ts >= p.start && ts < p.end {
+			s.partitionMu.RUnlock()
+			return p.name, nil
+		}
+	}
+	s.partitionMu.RUnlock()
+
+	s.partitionMu.Lock()
+	defer s.partitionMu.Unlock()
+
+	start := time.Unix(0, ts).UTC().Truncate(s.config.PartitionInterval)
+	p := partition{
+		name:   fmt.Sprintf("%s_p%s", signal, start.Format("20060102_1504")),
+		signal: signal,
+		start:  start.UnixNano(),
+		end:    start.Add(s.config.PartitionInterval).UnixNano(),
+	}
+
+	// Another writer may have created it, or the partition interval may have
+	// changed since a partition with this name was created. Widen it rather
+	// than creating an overlapping one.
+	for i, existing := range s.partitions[signal] {
+		if existing.name != p.name {
+			continue
+		}
+		if ts < existing.end {
+			return existing.name, nil
+		}
+		if _, err := s.db.Exec(`UPDATE partitions SET end_time = ? WHERE name = ?`, p.end, p.name); err != nil {
+			return "", fmt.Errorf("failed to extend partition %s: %w", p.name, err)
+		}
+		s.partitions[signal][i].end = p.end
+		return p.name, nil
+	}
+
+	if err := s.createPartition(p); err != nil {
+		return "", err
+	}
+
+	partitions := append(s.partitions[signal], p)
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].start < partitions[j].start })
+	s.partitions[signal] = partitions
+
+	return p.name, nil
+}
+
+// createPartition copies the template table definition for p.signal and
+// registers the new table in the catalog
+func (s *SQLiteStorage) createPartition(p partition) error {
+	var templateSQL string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, p.signal).Scan(&templateSQL)
+	if err != nil {
+		return fmt.Errorf("failed to read %s template: %w", p.signal, err)
+	}
+
+	prefix := "CREATE TABLE " + p.signal
+	if !strings.HasPrefix(templateSQL, prefix) {
+		return fmt.Errorf("unexpected %s template definition", p.signal)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{"CREATE TABLE IF NOT EXISTS " + p.name + templateSQL[len(prefix):]}
+	for _, columns := range partitionedTables[p.signal].indexes {
+		index := fmt.Sprintf("idx_%s_%s", p.name, strings.ReplaceAll(columns, ", ", "_"))
+		queries = append(queries, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s(%s)`, index, p.name, columns))
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", p.name, err)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO partitions (name, signal, start_time, end_time) VALUES (?, ?, ?, ?)`,
+		p.name, p.signal, p.start, p.end)
+	if err != nil {
+		return fmt.Errorf("failed to register partition %s: %w", p.name, err)
+	}
+
+	return tx.Commit()
+}
+
+// partitionsIn returns the partitions of signal overlapping [from, to), newest
+// first
+func (s *SQLiteStorage) partitionsIn(signal string, from, to int64) []partition {
+	s.partitionMu.RLock()
+	defer s.partitionMu.RUnlock()
+
+	var matched []partition
+	for _, p := range s.partitions[signal] {
+		if p.overlaps(from, to) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].end > matched[j].end })
+	return matched
+}
+
+// allTime spans every representable timestamp
+const (
+	allTimeFrom = int64(-1 << 63)
+	allTimeTo   = int64(1<<63 - 1)
+)
+
+// queryNewest fans a newest-first query out across the partitions of signal in
+// [from, to). Partitions are visited newest first and the walk stops once the
+// next partition cannot contain anything newer than the rows already
+// collected. fetch must return at most limit rows ordered newest first.
+func queryNewest[T any](s *SQLiteStorage, signal string, from, to int64, need int,
+	fetch func(table string, limit int) ([]T, error), tsOf func(T) int64) ([]T, error) {
+
+	if need <= 0 {
+		return nil, nil
+	}
+
+	var rows []T
+	partitions := s.partitionsIn(signal, from, to)
+	for i, p := range partitions {
+		batch, err := fetch(p.name, need)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, batch...)
+		sort.SliceStable(rows, func(a, b int) bool { return tsOf(rows[a]) > tsOf(rows[b]) })
+		if len(rows) > need {
+			rows = rows[:need]
+		}
+
+		if len(rows) == need && i+1 < len(partitions) && partitions[i+1].end <= tsOf(rows[need-1]) {
+			break
+		}
+	}
+
+	return rows, nil
+}
+
+// dropPartitionsBefore drops every partition whose range ended at or before
+// cutoff
+func (s *SQLiteStorage) dropPartitionsBefore(cutoff int64) error {
+	s.partitionMu.Lock()
+	defer s.partitionMu.Unlock()
+
+	var firstErr error
+	for signal, partitions := range s.partitions {
+		var kept []partition
+		for _, p := range partitions {
+			if p.end > cutoff {
+				kept = append(kept, p)
+				continue
+			}
+			if err := s.dropPartition(p); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				kept = append(kept, p)
+			}
+		}
+		s.partitions[signal] = kept
+	}
+
+	return firstErr
+}
+
+func (s *SQLiteStorage) dropPartition(p partition) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + p.name); err != nil {
+		return fmt.Errorf("failed to drop partition %s: %w", p.name, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM partitions WHERE name = ?`, p.name); err != nil {
+		return fmt.Errorf("failed to unregister partition %s: %w", p.name, err)
+	}
+
+	return tx.Commit()
+}
+
+// queryRows runs query against every partition of signal overlapping
+// [from, to), passing each result set to scan
+func (s *SQLiteStorage) queryRows(signal string, from, to int64, query func(table string) (*sql.Rows, error), scan func(rows *sql.Rows) error) error {
+	for _, p := range s.partitionsIn(signal, from, to) {
+		rows, err := query(p.name)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package storage
+
+import "time"
+
+// LogPattern is a log message template mined at ingest. Variable tokens of
+// the template are "<*>". Counts holds the number of matching logs per time
+// bucket; when saving, only the buckets given are written.
+type LogPattern struct {
+	ID          string          `json:"id"`
+	ServiceName string          `json:"service_name"`
+	Template    string          `json:"template"`
+	Count       int64           `json:"count"`
+	FirstSeen   time.Time       `json:"first_seen"`
+	LastSeen    time.Time       `json:"last_seen"`
+	Counts      []*PatternCount `json:"counts,omitempty"`
+}
+
+// PatternCount is the number of logs matching a pattern in the bucket
+// starting at Timestamp
+type PatternCount struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count"`
+}
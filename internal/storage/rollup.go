@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// ErrInvalidMetricQuery is returned for metric queries missing what they need
+var ErrInvalidMetricQuery = errors.New("invalid metric query")
+
+// MetricRollup aggregates the datapoints of one series within one bucket of a
+// rollup tier. Rollups are mergeable, so partial aggregates of the same bucket
+// can be written repeatedly and combined.
+type MetricRollup struct {
+	Resolution    time.Duration `json:"resolution"`
+	Bucket        time.Time     `json:"bucket"`
+	MetricName    string        `json:"metric_name"`
+	ServiceName   string        `json:"service_name"`
+	Labels        string        `json:"labels"`
+	Min           float64       `json:"min"`
+	Max           float64       `json:"max"`
+	Sum           float64       `json:"sum"`
+	Count         int64         `json:"count"`
+	Last          float64       `json:"last"`
+	LastTimestamp time.Time     `json:"last_timestamp"`
+}
+
+func newMetricRollup(resolution time.Duration, bucket time.Time, m *Metric) *MetricRollup {
+	return &MetricRollup{
+		Resolution:    resolution,
+		Bucket:        bucket,
+		MetricName:    m.MetricName,
+		ServiceName:   m.ServiceName,
+		Labels:        m.Labels,
+		Min:           m.Value,
+		Max:           m.Value,
+		Sum:           m.Value,
+		Count:         1,
+		Last:          m.Value,
+		LastTimestamp: m.Timestamp,
+	}
+}
+
+func (r *MetricRollup) merge(o *MetricRollup) {
+	if o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if o.Max > r.Max {
+		r.Max = o.Max
+	}
+	r.Sum += o.Sum
+	r.Count += o.Count
+	if !o.LastTimestamp.Before(r.LastTimestamp) {
+		r.Last = o.Last
+		r.LastTimestamp = o.LastTimestamp
+	}
+}
+
+func (r *MetricRollup) key() rollupKey {
+	return rollupKey{
+		resolution: r.Resolution,
+		bucket:     r.Bucket.UnixNano(),
+		series:     seriesKey{r.MetricName, r.ServiceName, r.Labels},
+	}
+}
+
+type seriesKey struct {
+	name    string
+	service string
+	labels  string
+}
+
+type rollupKey struct {
+	resolution time.Duration
+	bucket     int64
+	series     seriesKey
+}
+
+// rollupAggregator accumulates partial rollups for every tier between flushes
+type rollupAggregator struct {
+	mu      sync.Mutex
+	tiers   []config.RollupTierConfig
+	pending map[rollupKey]*MetricRollup
+	flushed time.Time // the rollups of datapoints observed before it are written
+}
+
+func newRollupAggregator(tiers []config.RollupTierConfig) *rollupAggregator {
+	return &rollupAggregator{
+		tiers:   tiers,
+		pending: make(map[rollupKey]*MetricRollup),
+		flushed: time.Now(),
+	}
+}
+
+func (a *rollupAggregator) observe(m *Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, tier := range a.tiers {
+		if tier.Resolution <= 0 {
+			continue
+		}
+		rollup := newMetricRollup(tier.Resolution, m.Timestamp.Truncate(tier.Resolution), m)
+		key := rollup.key()
+		if existing, ok := a.pending[key]; ok {
+			existing.merge(rollup)
+		} else {
+			a.pending[key] = rollup
+		}
+	}
+}
+
+// drain returns the accumulated rollups grouped by tier resolution and resets
+// the aggregator
+func (a *rollupAggregator) drain() map[time.Duration][]*MetricRollup {
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = make(map[rollupKey]*MetricRollup)
+	a.mu.Unlock()
+
+	byTier := make(map[time.Duration][]*MetricRollup)
+	for _, rollup := range pending {
+		byTier[rollup.Resolution] = append(byTier[rollup.Resolution], rollup)
+	}
+	return byTier
+}
+
+// requeue merges rollups that failed to be written back into the pending
+// ones, so the next flush retries them
+func (a *rollupAggregator) requeue(rollups []*MetricRollup) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, rollup := range rollups {
+		key := rollup.key()
+		if existing, ok := a.pending[key]; ok {
+			existing.merge(rollup)
+		} else {
+			a.pending[key] = rollup
+		}
+	}
+}
+
+// markFlushed advances the watermark once every rollup drained at t is
+// written
+func (a *rollupAggregator) markFlushed(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flushed = t
+}
+
+// watermark returns the time before which observed datapoints are reflected
+// in the written rollups
+func (a *rollupAggregator) watermark() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushed
+}
+
+// runRollupLoop periodically drains the aggregator into write until done is
+// closed, then performs a final flush. Rollups that fail to be written are
+// retried at the next flush, and the watermark stays put until they are.
+func runRollupLoop(agg *rollupAggregator, interval time.Duration, done <-chan struct{}, write func(time.Duration, []*MetricRollup) error) {
+	flush := func() {
+		drained := time.Now()
+		failed := false
+		for resolution, rollups := range agg.drain() {
+			if err := write(resolution, rollups); err != nil {
+				logger.Get().Error("Failed to write metric rollups, retrying at the next flush",
+					zap.Error(err),
+					zap.String("resolution", FormatResolution(resolution)),
+					zap.Int("count", len(rollups)),
+				)
+				agg.requeue(rollups)
+				failed = true
+			}
+		}
+		if !failed {
+			agg.markFlushed(drained)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-done:
+			flush()
+			return
+		}
+	}
+}
+
+// MetricQuery selects the datapoints of one metric over a time range,
+// aggregated into buckets of Step
+type MetricQuery struct {
+	MetricName  string
+	ServiceName string
+	Matchers    []LabelMatcher
+	Start       time.Time
+	End         time.Time
+	Step        time.Duration
+	Exemplars   bool // attach the series' exemplars to the result
+}
+
+type MetricQueryResult struct {
+	Resolution string          `json:"resolution"`
+	Step       string          `json:"step"`
+	Series     []*MetricSeries `json:"series"`
+}
+
+type MetricSeries struct {
+	MetricName  string          `json:"metric_name"`
+	ServiceName string          `json:"service_name"`
+	Labels      string          `json:"labels"`
+	Samples     []*MetricSample `json:"samples"`
+	Exemplars   []*Exemplar     `json:"exemplars,omitempty"`
+}
+
+type MetricSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Sum       float64   `json:"sum"`
+	Count     int64     `json:"count"`
+	Last      float64   `json:"last"`
+	Avg       float64   `json:"avg"`
+}
+
+// tierSpan is the part of a query's time range answered at one tier
+// resolution, zero for raw datapoints
+type tierSpan struct {
+	resolution time.Duration
+	start      time.Time
+	end        time.Time
+}
+
+// planTiers splits [start, end) between the rollup tiers whose resolution
+// still fits in step, coarsest first. A tier answers only its whole buckets
+// within its retention and before the flushed watermark; what is left falls
+// back to finer tiers and finally to raw datapoints.
+func planTiers(tiers []config.RollupTierConfig, step time.Duration, start, end, flushed, now time.Time) []tierSpan {
+	var fitting []config.RollupTierConfig
+	for _, tier := range tiers {
+		if tier.Resolution > 0 && tier.Resolution <= step {
+			fitting = append(fitting, tier)
+		}
+	}
+	sort.Slice(fitting, func(i, j int) bool { return fitting[i].Resolution > fitting[j].Resolution })
+
+	var spans []tierSpan
+	remaining := []tierSpan{{start: start, end: end}}
+	for _, tier := range fitting {
+		retained := bucketAfter(now.AddDate(0, 0, -tier.RetentionDays), tier.Resolution)
+		complete := flushed.Truncate(tier.Resolution)
+
+		var rest []tierSpan
+		for _, r := range remaining {
+			from := bucketAfter(r.start, tier.Resolution)
+			if from.Before(retained) {
+				from = retained
+			}
+			to := r.end.Truncate(tier.Resolution)
+			if to.After(complete) {
+				to = complete
+			}
+			if !from.Before(to) {
+				rest = append(rest, r)
+				continue
+			}
+			spans = append(spans, tierSpan{resolution: tier.Resolution, start: from, end: to})
+			if r.start.Before(from) {
+				rest = append(rest, tierSpan{start: r.start, end: from})
+			}
+			if to.Before(r.end) {
+				rest = append(rest, tierSpan{start: to, end: r.end})
+			}
+		}
+		remaining = rest
+	}
+	return append(spans, remaining...)
+}
+
+// bucketAfter returns the start of the first bucket of resolution starting at
+// or after t
+func bucketAfter(t time.Time, resolution time.Duration) time.Time {
+	bucket := t.Truncate(resolution)
+	if bucket.Before(t) {
+		bucket = bucket.Add(resolution)
+	}
+	return bucket
+}
+
+// queryMetricRange answers q from the coarsest tiers that satisfy its step,
+// falling back to finer tiers and raw datapoints where a tier holds no
+// complete data, and re-buckets the result to the step
+func queryMetricRange(q MetricQuery, agg *rollupAggregator,
+	raw func(MetricQuery) ([]*Metric, error),
+	rollups func(time.Duration, MetricQuery) ([]*MetricRollup, error),
+	exemplars func(MetricQuery) ([]*Exemplar, error)) (*MetricQueryResult, error) {
+
+	if q.MetricName == "" {
+		return nil, fmt.Errorf("%w: metric name is required", ErrInvalidMetricQuery)
+	}
+
+	var resolution time.Duration
+	var source []*MetricRollup
+	for _, span := range planTiers(agg.tiers, q.Step, q.Start, q.End, agg.watermark(), time.Now()) {
+		part := q
+		part.Start, part.End = span.start, span.end
+		if span.resolution == 0 {
+			points, err := raw(part)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range points {
+				source = append(source, newMetricRollup(0, m.Timestamp, m))
+			}
+			continue
+		}
+		found, err := rollups(span.resolution, part)
+		if err != nil {
+			return nil, err
+		}
+		source = append(source, found...)
+		resolution = max(resolution, span.resolution)
+	}
+
+	step := q.Step
+	if step <= 0 {
+		step = resolution
+	}
+
+	buckets := make(map[rollupKey]*MetricRollup)
+	for _, r := range source {
+		bucket := r.Bucket
+		if step > 0 {
+			bucket = bucket.Truncate(step)
+		}
+		r.Resolution = step
+		r.Bucket = bucket
+		if existing, ok := buckets[r.key()]; ok {
+			existing.merge(r)
+		} else {
+			buckets[r.key()] = r
+		}
+	}
+
+	seriesByKey := make(map[seriesKey]*MetricSeries)
+	for key, r := range buckets {
+		series, ok := seriesByKey[key.series]
+		if !ok {
+			series = &MetricSeries{MetricName: r.MetricName, ServiceName: r.ServiceName, Labels: r.Labels}
+			seriesByKey[key.series] = series
+		}
+		series.Samples = append(series.Samples, &MetricSample{
+			Timestamp: r.Bucket,
+			Min:       r.Min,
+			Max:       r.Max,
+			Sum:       r.Sum,
+			Count:     r.Count,
+			Last:      r.Last,
+			Avg:       r.Sum / float64(r.Count),
+		})
+	}
+
+	if q.Exemplars {
+		found, err := exemplars(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range found {
+			if series, ok := seriesByKey[seriesKey{e.MetricName, e.ServiceName, e.Labels}]; ok {
+				series.Exemplars = append(series.Exemplars, e)
+			}
+		}
+	}
+
+	result := &MetricQueryResult{
+		Resolution: FormatResolution(resolution),
+		Step:       FormatResolution(step),
+		Series:     make([]*MetricSeries, 0, len(seriesByKey)),
+	}
+	for _, series := range seriesByKey {
+		sort.Slice(series.Samples, func(i, j int) bool {
+			return series.Samples[i].Timestamp.Before(series.Samples[j].Timestamp)
+		})
+		result.Series = append(result.Series, series)
+	}
+	sort.Slice(result.Series, func(i, j int) bool {
+		if result.Series[i].ServiceName != result.Series[j].ServiceName {
+			return result.Series[i].ServiceName < result.Series[j].ServiceName
+		}
+		return result.Series[i].Labels < result.Series[j].Labels
+	})
+
+	return result, nil
+}
+
+// FormatResolution renders a tier resolution compactly ("1m", "1h"), or "raw"
+// for zero
+func FormatResolution(d time.Duration) string {
+	if d == 0 {
+		return "raw"
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func TestPlanTiers(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tiers := []config.RollupTierConfig{
+		{Resolution: time.Minute, RetentionDays: 1},
+		{Resolution: time.Hour, RetentionDays: 30},
+	}
+	at := func(d time.Duration) time.Time { return now.Add(d) }
+
+	tests := []struct {
+		name    string
+		step    time.Duration
+		start   time.Time
+		end     time.Time
+		flushed time.Time
+		want    []tierSpan
+	}{
+		{
+			"finer than every tier",
+			30 * time.Second, at(-time.Hour), now, now,
+			[]tierSpan{{0, at(-time.Hour), now}},
+		},
+		{
+			"flushed tier",
+			time.Minute, at(-time.Hour), now, now,
+			[]tierSpan{{time.Minute, at(-time.Hour), now}},
+		},
+		{
+			"unflushed window falls back to raw",
+			time.Minute, at(-time.Hour), now, at(-10 * time.Minute),
+			[]tierSpan{{time.Minute, at(-time.Hour), at(-10 * time.Minute)}, {0, at(-10 * time.Minute), now}},
+		},
+		{
+			"partial buckets at the edges are raw",
+			time.Minute, at(-time.Hour - 30*time.Second), at(-30 * time.Second), now,
+			[]tierSpan{{time.Minute, at(-time.Hour), at(-time.Minute)}, {0, at(-time.Hour - 30*time.Second), at(-time.Hour)}, {0, at(-time.Minute), at(-30 * time.Second)}},
+		},
+		{
+			"coarsest tier first, finer tier for its unflushed bucket",
+			time.Hour, at(-3 * time.Hour), now, at(-30 * time.Minute),
+			[]tierSpan{{time.Hour, at(-3 * time.Hour), at(-time.Hour)}, {time.Minute, at(-time.Hour), at(-30 * time.Minute)}, {0, at(-30 * time.Minute), now}},
+		},
+		{
+			"past the finer tier's retention",
+			time.Minute, at(-48 * time.Hour), at(-47 * time.Hour), now,
+			[]tierSpan{{0, at(-48 * time.Hour), at(-47 * time.Hour)}},
+		},
+		{
+			"past raw retention the coarser tier answers",
+			time.Hour, at(-10 * 24 * time.Hour), at(-9 * 24 * time.Hour), now,
+			[]tierSpan{{time.Hour, at(-10 * 24 * time.Hour), at(-9 * 24 * time.Hour)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planTiers(tiers, tt.step, tt.start, tt.end, tt.flushed, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("planTiers = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].resolution != tt.want[i].resolution || !got[i].start.Equal(tt.want[i].start) || !got[i].end.Equal(tt.want[i].end) {
+					t.Errorf("span %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMetricRangeRequiresMetric(t *testing.T) {
+	_, err := queryMetricRange(MetricQuery{Start: time.Now().Add(-time.Hour), End: time.Now()}, newRollupAggregator(nil), nil, nil, nil)
+	if !errors.Is(err, ErrInvalidMetricQuery) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidMetricQuery)
+	}
+}
+
+func TestRollupLoopRetriesFailedWrites(t *testing.T) {
+	agg := newRollupAggregator([]config.RollupTierConfig{{Resolution: time.Minute, RetentionDays: 1}})
+	start := agg.watermark()
+	now := time.Now()
+	agg.observe(&Metric{MetricName: "cpu", Timestamp: now, Value: 1})
+
+	written := make(chan []*MetricRollup, 10)
+	attempts := 0
+	write := func(resolution time.Duration, rollups []*MetricRollup) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("database is locked")
+		}
+		written <- rollups
+		return nil
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		runRollupLoop(agg, 10*time.Millisecond, done, write)
+		close(finished)
+	}()
+	var rollups []*MetricRollup
+	select {
+	case rollups = <-written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("failed rollups not retried")
+	}
+	close(done)
+	<-finished
+
+	if len(rollups) != 1 || rollups[0].Count != 1 || rollups[0].Sum != 1 {
+		t.Errorf("retried rollups = %+v", rollups)
+	}
+	if !agg.watermark().After(start) {
+		t.Error("watermark not advanced after the retry")
+	}
+}
+
+func TestRollupLoopHoldsWatermarkOnFailure(t *testing.T) {
+	agg := newRollupAggregator([]config.RollupTierConfig{{Resolution: time.Minute, RetentionDays: 1}})
+	start := agg.watermark()
+	agg.observe(&Metric{MetricName: "cpu", Timestamp: time.Now(), Value: 1})
+
+	done := make(chan struct{})
+	close(done)
+	runRollupLoop(agg, time.Hour, done, func(time.Duration, []*MetricRollup) error {
+		return errors.New("disk full")
+	})
+
+	if !agg.watermark().Equal(start) {
+		t.Error("watermark advanced past unwritten rollups")
+	}
+	if len(agg.drain()[time.Minute]) != 1 {
+		t.Error("failed rollups not kept for a retry")
+	}
+}
+
+func TestSQLiteQueryMetricRangeReadsUnflushedData(t *testing.T) {
+	cfg := config.DefaultConfig().Storage
+	cfg.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	cfg.Rollups.FlushInterval = time.Hour
+	s, err := NewSQLiteStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		m := &Metric{MetricName: "cpu", ServiceName: "api", Labels: "{}", Timestamp: now.Add(-time.Duration(i) * time.Second), Value: float64(i)}
+		if err := s.InsertMetric(m); err != nil {
+			t.Fatalf("InsertMetric: %v", err)
+		}
+	}
+
+	result, err := s.QueryMetricRange(MetricQuery{MetricName: "cpu", Start: now.Add(-time.Hour), End: now.Add(time.Second), Step: time.Minute})
+	if err != nil {
+		t.Fatalf("QueryMetricRange: %v", err)
+	}
+	count := int64(0)
+	for _, series := range result.Series {
+		for _, sample := range series.Samples {
+			count += sample.Count
+		}
+	}
+	if count != 3 {
+		t.Errorf("%d datapoints counted, want the 3 not yet rolled up", count)
+	}
+}
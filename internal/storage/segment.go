@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	segmentDataExt  = ".seg"
+	segmentIndexExt = ".idx"
+
+	serviceBloomBits = 4096
+	bloomHashCount   = 7
+)
+
+// segment is one time partition of a single signal. Records are buffered in
+// memory and appended to the data file as independently gzip-compressed
+// blocks. The sidecar index holds a sparse time index (one entry per block)
+// plus bloom filters over the trace IDs and services in the segment.
+type segment struct {
+	start     time.Time
+	end       time.Time
+	dataPath  string
+	indexPath string
+
+	file         *os.File
+	index        segmentIndex
+	traceBloom   *bloomFilter
+	serviceBloom *bloomFilter
+	services     map[string]bool
+	pending      []segmentRecord
+	dirty        bool // index changed since it was last written
+}
+
+type segmentIndex struct {
+	Start        int64        `json:"start"`
+	End          int64        `json:"end"`
+	Count        int64        `json:"count"`
+	LastID       int64        `json:"last_id"`
+	Size         int64        `json:"size"`
+	Services     []string     `json:"services"`
+	Blocks       []blockIndex `json:"blocks"`
+	TraceBloom   []byte       `json:"trace_bloom"`
+	ServiceBloom []byte       `json:"service_bloom"`
+}
+
+type blockIndex struct {
+	Offset  int64 `json:"offset"`
+	Length  int64 `json:"length"`
+	Count   int   `json:"count"`
+	MinTime int64 `json:"min_time"`
+	MaxTime int64 `json:"max_time"`
+}
+
+type segmentRecord struct {
+	id        int64
+	timestamp int64
+	data      []byte
+}
+
+func newSegment(dir string, start time.Time, duration time.Duration, bloomBits int) *segment {
+	base := filepath.Join(dir, fmt.Sprintf("%d", start.Unix()))
+	return &segment{
+		start:        start,
+		end:          start.Add(duration),
+		dataPath:     base + segmentDataExt,
+		indexPath:    base + segmentIndexExt,
+		index:        segmentIndex{Start: start.UnixNano(), End: start.Add(duration).UnixNano()},
+		traceBloom:   newBloomFilter(bloomBits, bloomHashCount),
+		serviceBloom: newBloomFilter(serviceBloomBits, bloomHashCount),
+		services:     make(map[string]bool),
+	}
+}
+
+// openSegment loads a segment from its index file
+func openSegment(indexPath string) (*segment, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var index segmentIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse segment index %s: %w", indexPath, err)
+	}
+
+	seg := &segment{
+		start:        time.Unix(0, index.Start),
+		end:          time.Unix(0, index.End),
+		dataPath:     indexPath[:len(indexPath)-len(segmentIndexExt)] + segmentDataExt,
+		indexPath:    indexPath,
+		index:        index,
+		traceBloom:   &bloomFilter{},
+		serviceBloom: &bloomFilter{},
+		services:     make(map[string]bool),
+	}
+	if err := seg.traceBloom.UnmarshalBinary(index.TraceBloom); err != nil {
+		return nil, fmt.Errorf("failed to load trace bloom filter %s: %w", indexPath, err)
+	}
+	if err := seg.serviceBloom.UnmarshalBinary(index.ServiceBloom); err != nil {
+		return nil, fmt.Errorf("failed to load service bloom filter %s: %w", indexPath, err)
+	}
+	for _, service := range index.Services {
+		seg.services[service] = true
+	}
+
+	return seg, nil
+}
+
+func (s *segment) append(rec segmentRecord, service string, traceID string) {
+	s.pending = append(s.pending, rec)
+	if service != "" {
+		s.serviceBloom.Add(service)
+		s.services[service] = true
+	}
+	if traceID != "" {
+		s.traceBloom.Add(traceID)
+	}
+}
+
+// flush compresses the pending records into a new block and appends it to
+// the data file, then rewrites the index if it changed. An index that failed
+// to be written is retried on the next flush.
+func (s *segment) flush() error {
+	if len(s.pending) > 0 {
+		if err := s.writeBlock(); err != nil {
+			return err
+		}
+	}
+	if !s.dirty {
+		return nil
+	}
+	return s.writeIndex()
+}
+
+// writeBlock appends the pending records to the data file as one block. A
+// failed write is truncated away, so the data file never holds bytes the
+// index does not describe, and the records stay pending.
+func (s *segment) writeBlock() error {
+
+	if s.file == nil {
+		file, err := os.OpenFile(s.dataPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment %s: %w", s.dataPath, err)
+		}
+		// Drop any block that was written before a crash but never indexed
+		if info, err := file.Stat(); err == nil && info.Size() != s.index.Size {
+			if err := file.Truncate(s.index.Size); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to truncate segment %s: %w", s.dataPath, err)
+			}
+		}
+		s.file = file
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	block := blockIndex{
+		Offset:  s.index.Size,
+		Count:   len(s.pending),
+		MinTime: s.pending[0].timestamp,
+		MaxTime: s.pending[0].timestamp,
+	}
+	for _, rec := range s.pending {
+		if rec.timestamp < block.MinTime {
+			block.MinTime = rec.timestamp
+		}
+		if rec.timestamp > block.MaxTime {
+			block.MaxTime = rec.timestamp
+		}
+		if _, err := zw.Write(rec.data); err != nil {
+			return fmt.Errorf("failed to compress block: %w", err)
+		}
+		if _, err := zw.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("failed to compress block: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress block: %w", err)
+	}
+
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		// Reopening truncates the file back to the indexed size
+		s.closeFile()
+		return fmt.Errorf("failed to write block to %s: %w", s.dataPath, err)
+	}
+	block.Length = int64(buf.Len())
+
+	s.index.Blocks = append(s.index.Blocks, block)
+	s.index.Size += block.Length
+	s.index.Count += int64(block.Count)
+	s.index.LastID = s.pending[len(s.pending)-1].id
+	s.pending = s.pending[:0]
+	s.dirty = true
+	return nil
+}
+
+func (s *segment) writeIndex() error {
+	s.index.Services = s.index.Services[:0]
+	for service := range s.services {
+		s.index.Services = append(s.index.Services, service)
+	}
+	sort.Strings(s.index.Services)
+	s.index.TraceBloom, _ = s.traceBloom.MarshalBinary()
+	s.index.ServiceBloom, _ = s.serviceBloom.MarshalBinary()
+
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		return fmt.Errorf("failed to encode segment index: %w", err)
+	}
+
+	tmp := s.indexPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write segment index: %w", err)
+	}
+	if err := os.Rename(tmp, s.indexPath); err != nil {
+		return fmt.Errorf("failed to write segment index: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// overlaps reports whether the segment may hold records in [from, to]. A zero
+// bound is treated as open.
+func (s *segment) overlaps(from, to time.Time) bool {
+	if !from.IsZero() && !s.end.After(from) {
+		return false
+	}
+	if !to.IsZero() && s.start.After(to) {
+		return false
+	}
+	return true
+}
+
+// segmentSnapshot is what a read needs of a segment. It is copied under the
+// store's lock, so disk reads and decoding happen without holding it.
+// Blocks are never rewritten, only appended, so the copied index stays valid.
+type segmentSnapshot struct {
+	dataPath string
+	blocks   []blockIndex
+	pending  []segmentRecord
+}
+
+func (s *segment) snapshot() segmentSnapshot {
+	return segmentSnapshot{
+		dataPath: s.dataPath,
+		blocks:   s.index.Blocks[:len(s.index.Blocks):len(s.index.Blocks)],
+		pending:  append([]segmentRecord(nil), s.pending...),
+	}
+}
+
+// open opens the data file, or returns nil when retention removed the
+// segment since the snapshot was taken
+func (s segmentSnapshot) open() (*os.File, error) {
+	file, err := os.Open(s.dataPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment %s: %w", s.dataPath, err)
+	}
+	return file, nil
+}
+
+// scan calls fn with every record in the segment whose block overlaps the
+// given time range, including records not yet flushed to disk.
+func (s segmentSnapshot) scan(from, to time.Time, fn func(data []byte) error) error {
+	if len(s.blocks) > 0 {
+		file, err := s.open()
+		if err != nil || file == nil {
+			return err
+		}
+		defer file.Close()
+
+		for _, block := range s.blocks {
+			if !from.IsZero() && block.MaxTime < from.UnixNano() {
+				continue
+			}
+			if !to.IsZero() && block.MinTime > to.UnixNano() {
+				continue
+			}
+			if err := scanBlock(file, block, fn); err != nil {
+				return fmt.Errorf("failed to read block at %d in %s: %w", block.Offset, s.dataPath, err)
+			}
+		}
+	}
+
+	for _, rec := range s.pending {
+		if err := fn(rec.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanNewest calls fn with the records of one block at a time, in order of
+// the blocks' latest record, newest first. The pending records count as one
+// block. Before each block, stop is asked whether to go on given the block's
+// latest record time.
+func (s segmentSnapshot) scanNewest(stop func(maxTime int64) bool, fn func(data []byte) error) error {
+	type chunk struct {
+		maxTime int64
+		block   *blockIndex // nil for the pending records
+	}
+	chunks := make([]chunk, 0, len(s.blocks)+1)
+	for i := range s.blocks {
+		chunks = append(chunks, chunk{maxTime: s.blocks[i].MaxTime, block: &s.blocks[i]})
+	}
+	if len(s.pending) > 0 {
+		latest := s.pending[0].timestamp
+		for _, rec := range s.pending {
+			latest = max(latest, rec.timestamp)
+		}
+		chunks = append(chunks, chunk{maxTime: latest})
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].maxTime > chunks[j].maxTime })
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for _, c := range chunks {
+		if stop(c.maxTime) {
+			return nil
+		}
+		if c.block == nil {
+			for _, rec := range s.pending {
+				if err := fn(rec.data); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if file == nil {
+			var err error
+			if file, err = s.open(); err != nil || file == nil {
+				return err
+			}
+		}
+		if err := scanBlock(file, *c.block, fn); err != nil {
+			return fmt.Errorf("failed to read block at %d in %s: %w", c.block.Offset, s.dataPath, err)
+		}
+	}
+	return nil
+}
+
+func scanBlock(file *os.File, block blockIndex, fn func(data []byte) error) error {
+	zr, err := gzip.NewReader(io.NewSectionReader(file, block.Offset, block.Length))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// sealed reports whether the segment's time range has passed, so only late
+// records are still written to it
+func (s *segment) sealed(now time.Time) bool {
+	return !s.end.After(now)
+}
+
+// closeFile closes the data file, which the next flush reopens if records
+// arrive late
+func (s *segment) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *segment) close() error {
+	err := s.flush()
+	if closeErr := s.closeFile(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// remove closes the segment and deletes its files from disk
+func (s *segment) remove() error {
+	s.closeFile()
+	s.pending = nil
+	if err := os.Remove(s.dataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(s.indexPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
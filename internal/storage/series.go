@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the functions the schema relies on
+// registered on every connection
+const sqliteDriver = "sqlite3_telemorph"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("series_fingerprint", seriesFingerprint, true)
+		},
+	})
+}
+
+// seriesFingerprint hashes a metric identity. Labels are the canonical JSON
+// produced at ingest (json.Marshal sorts map keys). Distinct identities may
+// collide, so a fingerprint narrows a lookup without deciding it.
+func seriesFingerprint(name, service, labels string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(service))
+	h.Write([]byte{0})
+	h.Write([]byte(labels))
+	return int64(h.Sum64())
+}
+
+// LabelMatcher selects series by one label. Type is one of =, !=, =~ and !~;
+// regular expressions are anchored.
+type LabelMatcher struct {
+	Name  string
+	Type  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// ParseLabelMatcher parses a matcher such as env=prod, env!=dev or
+// region=~"us-.*". Values may optionally be quoted.
+func ParseLabelMatcher(s string) (LabelMatcher, error) {
+	for _, op := range []string{"!=", "=~", "!~", "="} {
+		i := strings.Index(s, op)
+		if i <= 0 {
+			continue
+		}
+		m := LabelMatcher{
+			Name:  strings.TrimSpace(s[:i]),
+			Type:  op,
+			Value: strings.Trim(strings.TrimSpace(s[i+len(op):]), `"`),
+		}
+		if op == "=~" || op == "!~" {
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return LabelMatcher{}, fmt.Errorf("invalid regular expression in matcher %q: %w", s, err)
+			}
+			m.re = re
+		}
+		return m, nil
+	}
+	return LabelMatcher{}, fmt.Errorf("invalid label matcher %q", s)
+}
+
+// ParseSeriesSelector parses a Prometheus-style series selector such as
+// http.server.duration{service_name="api",route=~"/users/.*"} into a metric
+// query. The metric name may also be given as __name__, and service_name
+// selects the service; both only support equality.
+func ParseSeriesSelector(selector string) (MetricQuery, error) {
+	var q MetricQuery
+	selector = strings.TrimSpace(selector)
+
+	i := strings.IndexByte(selector, '{')
+	if i < 0 {
+		q.MetricName = selector
+		return q, nil
+	}
+	if !strings.HasSuffix(selector, "}") {
+		return q, fmt.Errorf("invalid series selector %q: missing closing brace", selector)
+	}
+	q.MetricName = strings.TrimSpace(selector[:i])
+
+	for _, part := range splitMatchers(selector[i+1 : len(selector)-1]) {
+		m, err := ParseLabelMatcher(part)
+		if err != nil {
+			return q, err
+		}
+		switch m.Name {
+		case "__name__", "service_name":
+			if m.Type != "=" {
+				return q, fmt.Errorf("only = is supported for %s", m.Name)
+			}
+			if m.Name == "__name__" {
+				q.MetricName = m.Value
+			} else {
+				q.ServiceName = m.Value
+			}
+		default:
+			q.Matchers = append(q.Matchers, m)
+		}
+	}
+	return q, nil
+}
+
+// splitMatchers splits the body of a selector on commas outside quotes
+func splitMatchers(body string) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case ',':
+			if !quoted {
+				parts = append(parts, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, body[start:])
+
+	result := parts[:0]
+	for _, part := range parts {
+		if strings.TrimSpace(part) != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// Matches reports whether a label set satisfies the matcher. A missing label
+// has the empty value.
+func (m LabelMatcher) Matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	switch m.Type {
+	case "=":
+		return value == m.Value
+	case "!=":
+		return value != m.Value
+	case "=~":
+		return m.re.MatchString(value)
+	case "!~":
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func matchesAll(matchers []LabelMatcher, labels string) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	parsed := make(map[string]string)
+	json.Unmarshal([]byte(labels), &parsed)
+	for _, m := range matchers {
+		if !m.Matches(parsed) {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesInfo is a row of the series dictionary
+type seriesInfo struct {
+	id          int64
+	metricName  string
+	serviceName string
+	labels      string
+}
+
+// resolveSeries returns the dictionary ID of a metric identity, registering
+// it on first sight
+func (s *SQLiteStorage) resolveSeries(name, service, labels string) (int64, error) {
+	if labels == "" {
+		labels = "{}"
+	}
+	key := seriesKey{name, service, labels}
+
+	s.seriesMu.RLock()
+	id, ok := s.seriesIDs[key]
+	s.seriesMu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	fingerprint := seriesFingerprint(name, service, labels)
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO series (fingerprint, metric_name, service_name, labels) VALUES (?, ?, ?, ?)`,
+		fingerprint, name, service, labels)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register series: %w", err)
+	}
+	err = s.db.QueryRow(`SELECT id FROM series
+		WHERE fingerprint = ? AND metric_name = ? AND service_name = ? AND labels = ?`,
+		fingerprint, name, service, labels).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve series: %w", err)
+	}
+
+	s.seriesMu.Lock()
+	s.seriesIDs[key] = id
+	s.seriesMu.Unlock()
+
+	return id, nil
+}
+
+// cleanupSeries removes the series that retention has left without
+// datapoints, rollups or exemplars
+func (s *SQLiteStorage) cleanupSeries() error {
+	s.seriesGC.Lock()
+	defer s.seriesGC.Unlock()
+
+	tables := []string{"metric_rollups", "exemplars"}
+	s.partitionMu.RLock()
+	for _, p := range s.partitions["metrics"] {
+		tables = append(tables, p.name)
+	}
+	s.partitionMu.RUnlock()
+
+	query := `DELETE FROM series WHERE 1 = 1`
+	for _, table := range tables {
+		query += ` AND id NOT IN (SELECT series_id FROM ` + table + `)`
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup series: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM series_labels WHERE series_id NOT IN (SELECT id FROM series)`); err != nil {
+		return fmt.Errorf("failed to cleanup series labels: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		s.seriesMu.Lock()
+		s.seriesIDs = make(map[seriesKey]int64)
+		s.seriesMu.Unlock()
+	}
+	return nil
+}
+
+// matchSeries resolves the series selected by q. Equality matchers are looked
+// up in the inverted label index; the remaining matchers are applied to the
+// candidates' label sets.
+func (s *SQLiteStorage) matchSeries(q MetricQuery) (map[int64]seriesInfo, error) {
+	query := `SELECT id, metric_name, service_name, labels FROM series WHERE metric_name = ?`
+	args := []interface{}{q.MetricName}
+	if q.ServiceName != "" {
+		query += ` AND service_name = ?`
+		args = append(args, q.ServiceName)
+	}
+
+	var rest []LabelMatcher
+	for _, m := range q.Matchers {
+		if m.Type == "=" && m.Value != "" {
+			query += ` AND id IN (SELECT series_id FROM series_labels WHERE name = ? AND value = ?)`
+			args = append(args, m.Name, m.Value)
+			continue
+		}
+		rest = append(rest, m)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matched := make(map[int64]seriesInfo)
+	for rows.Next() {
+		var info seriesInfo
+		if err := rows.Scan(&info.id, &info.metricName, &info.serviceName, &info.labels); err != nil {
+			return nil, err
+		}
+		if matchesAll(rest, info.labels) {
+			matched[info.id] = info
+		}
+	}
+
+	return matched, rows.Err()
+}
+
+// seriesIDList renders the IDs of matched series for an IN clause
+func seriesIDList(matched map[int64]seriesInfo) string {
+	ids := make([]string, 0, len(matched))
+	for id := range matched {
+		ids = append(ids, fmt.Sprintf("%d", id))
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ", ")
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func testSQLiteConfig(dir string) config.StorageConfig {
+	cfg := config.DefaultConfig().Storage
+	cfg.Path = filepath.Join(dir, "telemorph.db")
+	return cfg
+}
+
+func openTestSQLiteStorage(t *testing.T, cfg config.StorageConfig) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func countRows(t *testing.T, s *SQLiteStorage, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestResolveSeriesFingerprintCollision(t *testing.T) {
+	s := openTestSQLiteStorage(t, testSQLiteConfig(t.TempDir()))
+
+	// Another identity already holds the fingerprint of http_requests
+	fingerprint := seriesFingerprint("http_requests", "api", `{"code":"200"}`)
+	if _, err := s.db.Exec(`INSERT INTO series (fingerprint, metric_name, service_name, labels) VALUES (?, ?, ?, ?)`,
+		fingerprint, "queue_depth", "worker", `{"queue":"mail"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := s.resolveSeries("http_requests", "api", `{"code":"200"}`)
+	if err != nil {
+		t.Fatalf("resolveSeries: %v", err)
+	}
+	var name, labels string
+	if err := s.db.QueryRow(`SELECT metric_name, labels FROM series WHERE id = ?`, id).Scan(&name, &labels); err != nil {
+		t.Fatal(err)
+	}
+	if name != "http_requests" || labels != `{"code":"200"}` {
+		t.Errorf("resolved to series %d of %s%s, want http_requests", id, name, labels)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM series_labels WHERE series_id = ? AND name = 'code'`, id); n != 1 {
+		t.Errorf("%d label index rows for the new series, want 1", n)
+	}
+
+	again, err := s.resolveSeries("http_requests", "api", `{"code":"200"}`)
+	if err != nil || again != id {
+		t.Errorf("resolveSeries again = %d, %v; want %d", again, err, id)
+	}
+	s.seriesIDs = make(map[seriesKey]int64)
+	if again, err := s.resolveSeries("http_requests", "api", `{"code":"200"}`); err != nil || again != id {
+		t.Errorf("resolveSeries without the cache = %d, %v; want %d", again, err, id)
+	}
+}
+
+func TestCleanupRemovesUnusedSeries(t *testing.T) {
+	cfg := testSQLiteConfig(t.TempDir())
+	cfg.Rollups.Tiers = nil
+	s := openTestSQLiteStorage(t, cfg)
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -cfg.RetentionDays-2)
+	metrics := []*Metric{
+		{MetricName: "cpu", ServiceName: "api", Labels: `{"host":"a"}`, Timestamp: old, Value: 1},
+		{MetricName: "cpu", ServiceName: "api", Labels: `{"host":"b"}`, Timestamp: old, Value: 1},
+		{MetricName: "cpu", ServiceName: "api", Labels: `{"host":"b"}`, Timestamp: now, Value: 2},
+	}
+	for _, m := range metrics {
+		if err := s.InsertMetric(m); err != nil {
+			t.Fatalf("InsertMetric: %v", err)
+		}
+	}
+	gone, kept := metrics[0].SeriesID, metrics[2].SeriesID
+
+	if err := s.CleanupOldData(); err != nil {
+		t.Fatalf("CleanupOldData: %v", err)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM series WHERE id = ?`, gone); n != 0 {
+		t.Error("series without datapoints kept")
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM series_labels WHERE series_id = ?`, gone); n != 0 {
+		t.Error("label index of a removed series kept")
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM series WHERE id = ?`, kept); n != 1 {
+		t.Error("series with datapoints removed")
+	}
+
+	// A removed series is registered again when it reappears
+	m := &Metric{MetricName: "cpu", ServiceName: "api", Labels: `{"host":"a"}`, Timestamp: now, Value: 3}
+	if err := s.InsertMetric(m); err != nil {
+		t.Fatalf("InsertMetric: %v", err)
+	}
+	if m.SeriesID == gone {
+		t.Errorf("datapoint written to removed series %d", gone)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM series WHERE id = ?`, m.SeriesID); n != 1 {
+		t.Error("reappearing series not registered")
+	}
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+// latencyBounds are the upper bounds, in milliseconds, of the edge latency
+// histogram buckets. A final overflow bucket catches everything slower.
+var latencyBounds = [...]float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// ServiceGraph is the service map over a window. Nodes that only appear as
+// peer.service/server.address of client spans are not instrumented.
+type ServiceGraph struct {
+	Window string              `json:"window"`
+	Nodes  []*ServiceGraphNode `json:"nodes"`
+	Edges  []*ServiceGraphEdge `json:"edges"`
+}
+
+type ServiceGraphNode struct {
+	Name         string `json:"name"`
+	Instrumented bool   `json:"instrumented"`
+}
+
+// ServiceGraphEdge aggregates the calls from Source to Target. Latencies are
+// in milliseconds, estimated from a histogram.
+type ServiceGraphEdge struct {
+	Source      string  `json:"source"`
+	Target      string  `json:"target"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	RequestRate float64 `json:"request_rate"`
+	ErrorRate   float64 `json:"error_rate"`
+	LatencyP50  float64 `json:"latency_p50_ms"`
+	LatencyP90  float64 `json:"latency_p90_ms"`
+	LatencyP99  float64 `json:"latency_p99_ms"`
+}
+
+type edgeKey struct {
+	source string
+	target string
+}
+
+type edgeBucket struct {
+	minute   int64
+	requests int64
+	errors   int64
+	latency  [len(latencyBounds) + 1]int64
+}
+
+type edgeStats struct {
+	activity [catalogHistory]edgeBucket
+}
+
+type spanKey struct {
+	traceID string
+	spanID  string
+}
+
+// pendingSpan is a span kept until its parent and children have had time to
+// arrive
+type pendingSpan struct {
+	service  string
+	peer     string
+	duration time.Duration
+	isError  bool
+	matched  bool // a child in another service has been seen
+	expires  time.Time
+}
+
+type orphanSpan struct {
+	service  string
+	duration time.Duration
+	isError  bool
+	expires  time.Time
+}
+
+// serviceGraph derives caller->callee edges at ingest. A span whose parent
+// belongs to another service yields an edge carrying the child's duration; a
+// client span naming a peer that never produced a child span yields an edge
+// to that uninstrumented peer once it expires.
+type serviceGraph struct {
+	mu      sync.Mutex
+	config  config.ServiceGraphConfig
+	spans   map[spanKey]*pendingSpan
+	orphans map[spanKey][]orphanSpan // keyed by the missing parent
+	edges   map[edgeKey]*edgeStats
+	nodes   map[string]bool // name -> instrumented
+}
+
+func newServiceGraph(cfg config.ServiceGraphConfig) *serviceGraph {
+	return &serviceGraph{
+		config:  cfg,
+		spans:   make(map[spanKey]*pendingSpan),
+		orphans: make(map[spanKey][]orphanSpan),
+		edges:   make(map[edgeKey]*edgeStats),
+		nodes:   make(map[string]bool),
+	}
+}
+
+func (g *serviceGraph) observe(trace *Trace) {
+	if trace.TraceID == "" || trace.SpanID == "" {
+		return
+	}
+	now := time.Now()
+	duration := time.Duration(trace.DurationNanos)
+	isError := IsErrorStatus(trace.StatusCode)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.nodes[trace.ServiceName] = true
+
+	key := spanKey{trace.TraceID, trace.SpanID}
+	span := &pendingSpan{
+		service:  trace.ServiceName,
+		peer:     peerService(trace.Attributes),
+		duration: duration,
+		isError:  isError,
+		expires:  now.Add(g.config.Wait),
+	}
+
+	// Children that arrived first
+	for _, child := range g.orphans[key] {
+		if child.service != span.service {
+			g.record(span.service, child.service, child.duration, child.isError, now)
+			span.matched = true
+		}
+	}
+	delete(g.orphans, key)
+
+	if trace.ParentSpanID != nil && *trace.ParentSpanID != "" {
+		parentKey := spanKey{trace.TraceID, *trace.ParentSpanID}
+		if parent, ok := g.spans[parentKey]; ok {
+			if parent.service != span.service {
+				g.record(parent.service, span.service, duration, isError, now)
+				parent.matched = true
+			}
+		} else if len(g.spans)+len(g.orphans) < g.config.MaxPending {
+			g.orphans[parentKey] = append(g.orphans[parentKey], orphanSpan{
+				service:  span.service,
+				duration: duration,
+				isError:  isError,
+				expires:  span.expires,
+			})
+		}
+	}
+
+	if len(g.spans)+len(g.orphans) < g.config.MaxPending {
+		g.spans[key] = span
+	}
+}
+
+// expire drops pending spans whose wait has elapsed, turning unmatched client
+// spans into edges to their uninstrumented peers
+func (g *serviceGraph) expire(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, span := range g.spans {
+		if now.Before(span.expires) {
+			continue
+		}
+		if span.peer != "" && !span.matched && span.peer != span.service {
+			if _, ok := g.nodes[span.peer]; !ok {
+				g.nodes[span.peer] = false
+			}
+			g.record(span.service, span.peer, span.duration, span.isError, now)
+		}
+		delete(g.spans, key)
+	}
+	for key, children := range g.orphans {
+		if now.After(children[0].expires) {
+			delete(g.orphans, key)
+		}
+	}
+
+	// Forget edges with no calls left in the retained history
+	oldest := now.Unix()/60 - catalogHistory
+	for key, stats := range g.edges {
+		stale := true
+		for _, b := range stats.activity {
+			if b.minute > oldest {
+				stale = false
+				break
+			}
+		}
+		if stale {
+			delete(g.edges, key)
+		}
+	}
+}
+
+// record adds one call to an edge. The caller must hold the lock.
+func (g *serviceGraph) record(source, target string, duration time.Duration, isError bool, now time.Time) {
+	key := edgeKey{source, target}
+	stats, ok := g.edges[key]
+	if !ok {
+		stats = &edgeStats{}
+		g.edges[key] = stats
+	}
+
+	minute := now.Unix() / 60
+	b := &stats.activity[minute%catalogHistory]
+	if b.minute != minute {
+		*b = edgeBucket{minute: minute}
+	}
+	b.requests++
+	if isError {
+		b.errors++
+	}
+	ms := float64(duration) / float64(time.Millisecond)
+	b.latency[sort.SearchFloat64s(latencyBounds[:], ms)]++
+}
+
+// graph summarizes the edges active within window, which is bounded to the
+// retained history
+func (g *serviceGraph) graph(window time.Duration) *ServiceGraph {
+	if window <= 0 {
+		window = g.config.Window
+	}
+	if window < time.Minute {
+		window = time.Minute
+	}
+	if window > catalogHistory*time.Minute {
+		window = catalogHistory * time.Minute
+	}
+	window = window.Truncate(time.Minute)
+	first := time.Now().Unix()/60 - int64(window/time.Minute) + 1
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := &ServiceGraph{
+		Window: FormatResolution(window),
+		Nodes:  []*ServiceGraphNode{},
+		Edges:  []*ServiceGraphEdge{},
+	}
+	active := make(map[string]bool)
+	for key, stats := range g.edges {
+		edge := &ServiceGraphEdge{Source: key.source, Target: key.target}
+		var latency [len(latencyBounds) + 1]int64
+		for _, b := range stats.activity {
+			if b.minute < first {
+				continue
+			}
+			edge.Requests += b.requests
+			edge.Errors += b.errors
+			for i, n := range b.latency {
+				latency[i] += n
+			}
+		}
+		if edge.Requests == 0 {
+			continue
+		}
+		edge.RequestRate = float64(edge.Requests) / window.Seconds()
+		edge.ErrorRate = float64(edge.Errors) / float64(edge.Requests)
+		edge.LatencyP50 = latencyPercentile(latency[:], 0.50)
+		edge.LatencyP90 = latencyPercentile(latency[:], 0.90)
+		edge.LatencyP99 = latencyPercentile(latency[:], 0.99)
+		result.Edges = append(result.Edges, edge)
+		active[key.source] = true
+		active[key.target] = true
+	}
+	sort.Slice(result.Edges, func(i, j int) bool {
+		if result.Edges[i].Source != result.Edges[j].Source {
+			return result.Edges[i].Source < result.Edges[j].Source
+		}
+		return result.Edges[i].Target < result.Edges[j].Target
+	})
+
+	for _, name := range sortedKeys(active) {
+		result.Nodes = append(result.Nodes, &ServiceGraphNode{Name: name, Instrumented: g.nodes[name]})
+	}
+	return result
+}
+
+// run expires pending spans until done is closed
+func (g *serviceGraph) run(done <-chan struct{}) {
+	interval := g.config.Wait / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			g.expire(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// latencyPercentile estimates the q-quantile of a latency histogram by linear
+// interpolation within the bucket it falls in
+func latencyPercentile(counts []int64, q float64) float64 {
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen float64
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		if seen+float64(n) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = latencyBounds[i-1]
+			}
+			if i == len(latencyBounds) {
+				return lower
+			}
+			return lower + (latencyBounds[i]-lower)*(rank-seen)/float64(n)
+		}
+		seen += float64(n)
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
+
+// peerService returns the remote service named by a client span's
+// attributes, preferring peer.service over server.address
+func peerService(attributes string) string {
+	if !strings.Contains(attributes, "peer.service") && !strings.Contains(attributes, "server.address") {
+		return ""
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal([]byte(attributes), &attrs); err != nil {
+		return ""
+	}
+	for _, key := range []string{"peer.service", "server.address"} {
+		if value, ok := attrs[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -4,26 +4,58 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"open-telemorph-prime/internal/config"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type SQLiteStorage struct {
 	db     *sql.DB
 	config config.StorageConfig
+
+	partitionMu sync.RWMutex
+	partitions  map[string][]partition
+
+	seriesMu  sync.RWMutex
+	seriesIDs map[seriesKey]int64
+
+	// seriesGC is held by writers from resolving a series until its rows
+	// are written, and exclusively while unused series are removed
+	seriesGC sync.RWMutex
+
+	rollups *rollupAggregator
+	catalog *serviceCatalog
+	graph   *serviceGraph
+	done    chan struct{}
+	wg      sync.WaitGroup
 }
 
 type Metric struct {
-	ID          int64     `json:"id"`
+	ID          int64       `json:"id"`
+	SeriesID    int64       `json:"series_id,omitempty"`
+	Timestamp   time.Time   `json:"timestamp"`
+	MetricName  string      `json:"metric_name"`
+	Value       float64     `json:"value"`
+	Labels      string      `json:"labels"` // JSON string
+	ServiceName string      `json:"service_name"`
+	Exemplars   []*Exemplar `json:"exemplars,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// Exemplar is an example measurement of a metric, recorded with the trace
+// and span it was taken in. The metric identity fields are filled in when
+// exemplars are queried.
+type Exemplar struct {
+	MetricName  string    `json:"metric_name,omitempty"`
+	ServiceName string    `json:"service_name,omitempty"`
+	Labels      string    `json:"labels,omitempty"`
 	Timestamp   time.Time `json:"timestamp"`
-	MetricName  string    `json:"metric_name"`
 	Value       float64   `json:"value"`
-	Labels      string    `json:"labels"` // JSON string
-	ServiceName string    `json:"service_name"`
-	CreatedAt   time.Time `json:"created_at"`
+	TraceID     string    `json:"trace_id,omitempty"`
+	SpanID      string    `json:"span_id,omitempty"`
+	Attributes  string    `json:"attributes,omitempty"` // JSON string of filtered attributes
 }
 
 type Trace struct {
@@ -49,92 +81,77 @@ type Log struct {
 	Attributes  string    `json:"attributes"` // JSON string
 	TraceID     *string   `json:"trace_id"`
 	SpanID      *string   `json:"span_id"`
+	PatternID   string    `json:"pattern_id,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
 func NewSQLiteStorage(cfg config.StorageConfig) (*SQLiteStorage, error) {
-	// Create data directory if it doesn't exist
-	if err := createDataDir(cfg.Path); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
-
-	db, err := sql.Open("sqlite3", cfg.Path+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000")
+	db, err := openSQLiteDB(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
 	storage := &SQLiteStorage{
-		db:     db,
-		config: cfg,
+		db:        db,
+		config:    cfg,
+		seriesIDs: make(map[seriesKey]int64),
+		rollups:   newRollupAggregator(cfg.Rollups.Tiers),
+		catalog:   newServiceCatalog(cfg.Catalog),
+		graph:     newServiceGraph(cfg.ServiceGraph),
+		done:      make(chan struct{}),
+	}
+
+	// Bring the schema up to date
+	if err := storage.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	// Create tables
-	if err := storage.createTables(); err != nil {
+	if err := storage.loadPartitions(); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+		return nil, err
 	}
 
+	if err := storage.loadCatalog(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	storage.wg.Add(3)
+	go func() {
+		defer storage.wg.Done()
+		runRollupLoop(storage.rollups, cfg.Rollups.FlushInterval, storage.done, storage.writeRollups)
+	}()
+	go func() {
+		defer storage.wg.Done()
+		runCatalogLoop(storage.catalog, cfg.Catalog.FlushInterval, storage.done, storage.writeCatalog)
+	}()
+	go func() {
+		defer storage.wg.Done()
+		storage.graph.run(storage.done)
+	}()
+
 	return storage, nil
 }
 
-func (s *SQLiteStorage) Close() error {
-	return s.db.Close()
-}
+func openSQLiteDB(cfg config.StorageConfig) (*sql.DB, error) {
+	// Create data directory if it doesn't exist
+	if err := createDataDir(cfg.Path); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
 
-func (s *SQLiteStorage) createTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS metrics (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp INTEGER NOT NULL,
-			metric_name TEXT NOT NULL,
-			value REAL NOT NULL,
-			labels TEXT,
-			service_name TEXT,
-			created_at INTEGER DEFAULT (strftime('%s', 'now'))
-		)`,
-		`CREATE TABLE IF NOT EXISTS traces (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			trace_id TEXT NOT NULL,
-			span_id TEXT NOT NULL,
-			parent_span_id TEXT,
-			service_name TEXT,
-			operation_name TEXT,
-			start_time INTEGER NOT NULL,
-			duration_nanos INTEGER NOT NULL,
-			attributes TEXT,
-			status_code TEXT,
-			created_at INTEGER DEFAULT (strftime('%s', 'now'))
-		)`,
-		`CREATE TABLE IF NOT EXISTS logs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp INTEGER NOT NULL,
-			service_name TEXT,
-			level TEXT,
-			message TEXT,
-			attributes TEXT,
-			trace_id TEXT,
-			span_id TEXT,
-			created_at INTEGER DEFAULT (strftime('%s', 'now'))
-		)`,
-		// Indexes for performance
-		`CREATE INDEX IF NOT EXISTS idx_metrics_timestamp ON metrics(timestamp)`,
-		`CREATE INDEX IF NOT EXISTS idx_metrics_service ON metrics(service_name)`,
-		`CREATE INDEX IF NOT EXISTS idx_metrics_name ON metrics(metric_name)`,
-		`CREATE INDEX IF NOT EXISTS idx_traces_trace_id ON traces(trace_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_traces_service ON traces(service_name)`,
-		`CREATE INDEX IF NOT EXISTS idx_traces_start_time ON traces(start_time)`,
-		`CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp)`,
-		`CREATE INDEX IF NOT EXISTS idx_logs_service ON logs(service_name)`,
-		`CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level)`,
-	}
-
-	for _, query := range queries {
-		if _, err := s.db.Exec(query); err != nil {
-			return fmt.Errorf("failed to execute query %s: %w", query, err)
-		}
+	db, err := sql.Open(sqliteDriver, cfg.Path+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	return nil
+	return db, nil
+}
+
+func (s *SQLiteStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
 }
 
 func createDataDir(path string) error {
@@ -156,56 +173,105 @@ func createDataDir(path string) error {
 
 // Metric methods
 func (s *SQLiteStorage) InsertMetric(metric *Metric) error {
-	query := `INSERT INTO metrics (timestamp, metric_name, value, labels, service_name) 
-			  VALUES (?, ?, ?, ?, ?)`
+	table, err := s.partitionFor("metrics", metric.Timestamp.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	s.seriesGC.RLock()
+	defer s.seriesGC.RUnlock()
+
+	seriesID, err := s.resolveSeries(metric.MetricName, metric.ServiceName, metric.Labels)
+	if err != nil {
+		return err
+	}
+	metric.SeriesID = seriesID
 
-	_, err := s.db.Exec(query,
+	query := `INSERT INTO ` + table + ` (series_id, timestamp, value) 
+			  VALUES (?, ?, ?)`
+
+	_, err = s.db.Exec(query,
+		seriesID,
 		metric.Timestamp.UnixNano(),
-		metric.MetricName,
 		metric.Value,
-		metric.Labels,
-		metric.ServiceName,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	for _, e := range metric.Exemplars {
+		_, err := s.db.Exec(`INSERT INTO exemplars (series_id, timestamp, value, trace_id, span_id, attributes)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			seriesID, e.Timestamp.UnixNano(), e.Value, e.TraceID, e.SpanID, e.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to insert exemplar: %w", err)
+		}
+	}
+
+	s.rollups.observe(metric)
+	s.catalog.observe(metric.ServiceName, "metrics", false)
+	return nil
 }
 
 func (s *SQLiteStorage) GetMetrics(limit int, offset int) ([]*Metric, error) {
-	query := `SELECT id, timestamp, metric_name, value, labels, service_name, created_at 
-			  FROM metrics 
-			  ORDER BY timestamp DESC 
-			  LIMIT ? OFFSET ?`
-
-	rows, err := s.db.Query(query, limit, offset)
+	metrics, err := queryNewest(s, "metrics", allTimeFrom, allTimeTo, offset+limit,
+		func(table string, limit int) ([]*Metric, error) {
+			rows, err := s.db.Query(`SELECT `+metricColumns+` 
+				FROM `+table+` m JOIN series s ON s.id = m.series_id 
+				ORDER BY m.timestamp DESC 
+				LIMIT ?`, limit)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var metrics []*Metric
+			for rows.Next() {
+				m, err := scanMetric(rows)
+				if err != nil {
+					return nil, err
+				}
+				metrics = append(metrics, m)
+			}
+			return metrics, rows.Err()
+		},
+		func(m *Metric) int64 { return m.Timestamp.UnixNano() })
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var metrics []*Metric
-	for rows.Next() {
-		var m Metric
-		var timestamp, createdAt int64
+	return page(metrics, offset), nil
+}
 
-		err := rows.Scan(&m.ID, &timestamp, &m.MetricName, &m.Value, &m.Labels, &m.ServiceName, &createdAt)
-		if err != nil {
-			return nil, err
-		}
+// metricColumns selects a datapoint joined with its series as m and s
+const metricColumns = `m.id, m.series_id, m.timestamp, s.metric_name, m.value, s.labels, s.service_name, m.created_at`
+
+func scanMetric(rows *sql.Rows) (*Metric, error) {
+	var m Metric
+	var timestamp, createdAt int64
 
-		m.Timestamp = time.Unix(0, timestamp)
-		m.CreatedAt = time.Unix(createdAt, 0)
-		metrics = append(metrics, &m)
+	err := rows.Scan(&m.ID, &m.SeriesID, &timestamp, &m.MetricName, &m.Value, &m.Labels, &m.ServiceName, &createdAt)
+	if err != nil {
+		return nil, err
 	}
 
-	return metrics, nil
+	m.Timestamp = time.Unix(0, timestamp)
+	m.CreatedAt = time.Unix(createdAt, 0)
+	return &m, nil
 }
 
 // Trace methods
 func (s *SQLiteStorage) InsertTrace(trace *Trace) error {
-	query := `INSERT INTO traces (trace_id, span_id, parent_span_id, service_name, operation_name, 
+	table, err := s.partitionFor("traces", trace.StartTime.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ` + table + ` (trace_id, span_id, parent_span_id, service_name, operation_name, 
 			  start_time, duration_nanos, attributes, status_code) 
 			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := s.db.Exec(query,
+	_, err = s.db.Exec(query,
 		trace.TraceID,
 		trace.SpanID,
 		trace.ParentSpanID,
@@ -216,47 +282,98 @@ func (s *SQLiteStorage) InsertTrace(trace *Trace) error {
 		trace.Attributes,
 		trace.StatusCode,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	s.catalog.observe(trace.ServiceName, "traces", IsErrorStatus(trace.StatusCode))
+	s.graph.observe(trace)
+	return nil
 }
 
-func (s *SQLiteStorage) GetTraces(limit int, offset int) ([]*Trace, error) {
-	query := `SELECT id, trace_id, span_id, parent_span_id, service_name, operation_name, 
-			  start_time, duration_nanos, attributes, status_code, created_at 
-			  FROM traces 
-			  ORDER BY start_time DESC 
-			  LIMIT ? OFFSET ?`
+const traceColumns = `id, trace_id, span_id, parent_span_id, service_name, operation_name, 
+			  start_time, duration_nanos, attributes, status_code, created_at`
 
-	rows, err := s.db.Query(query, limit, offset)
+func (s *SQLiteStorage) GetTraces(limit int, offset int) ([]*Trace, error) {
+	traces, err := queryNewest(s, "traces", allTimeFrom, allTimeTo, offset+limit,
+		func(table string, limit int) ([]*Trace, error) {
+			rows, err := s.db.Query(`SELECT `+traceColumns+` 
+				FROM `+table+` 
+				ORDER BY start_time DESC 
+				LIMIT ?`, limit)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var traces []*Trace
+			for rows.Next() {
+				t, err := scanTrace(rows)
+				if err != nil {
+					return nil, err
+				}
+				traces = append(traces, t)
+			}
+			return traces, rows.Err()
+		},
+		func(t *Trace) int64 { return t.StartTime.UnixNano() })
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
+	return page(traces, offset), nil
+}
+
+func (s *SQLiteStorage) GetTrace(traceID string) ([]*Trace, error) {
 	var traces []*Trace
-	for rows.Next() {
-		var t Trace
-		var startTime, createdAt int64
+	err := s.queryRows("traces", allTimeFrom, allTimeTo,
+		func(table string) (*sql.Rows, error) {
+			return s.db.Query(`SELECT `+traceColumns+` FROM `+table+` WHERE trace_id = ?`, traceID)
+		},
+		func(rows *sql.Rows) error {
+			t, err := scanTrace(rows)
+			if err != nil {
+				return err
+			}
+			traces = append(traces, t)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(&t.ID, &t.TraceID, &t.SpanID, &t.ParentSpanID, &t.ServiceName,
-			&t.OperationName, &startTime, &t.DurationNanos, &t.Attributes, &t.StatusCode, &createdAt)
-		if err != nil {
-			return nil, err
-		}
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].StartTime.Before(traces[j].StartTime)
+	})
+	return traces, nil
+}
+
+func scanTrace(rows *sql.Rows) (*Trace, error) {
+	var t Trace
+	var startTime, createdAt int64
 
-		t.StartTime = time.Unix(0, startTime)
-		t.CreatedAt = time.Unix(createdAt, 0)
-		traces = append(traces, &t)
+	err := rows.Scan(&t.ID, &t.TraceID, &t.SpanID, &t.ParentSpanID, &t.ServiceName,
+		&t.OperationName, &startTime, &t.DurationNanos, &t.Attributes, &t.StatusCode, &createdAt)
+	if err != nil {
+		return nil, err
 	}
 
-	return traces, nil
+	t.StartTime = time.Unix(0, startTime)
+	t.CreatedAt = time.Unix(createdAt, 0)
+	return &t, nil
 }
 
 // Log methods
 func (s *SQLiteStorage) InsertLog(log *Log) error {
-	query := `INSERT INTO logs (timestamp, service_name, level, message, attributes, trace_id, span_id) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?)`
+	table, err := s.partitionFor("logs", log.Timestamp.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ` + table + ` (timestamp, service_name, level, message, attributes, trace_id, span_id, pattern_id) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := s.db.Exec(query,
+	_, err = s.db.Exec(query,
 		log.Timestamp.UnixNano(),
 		log.ServiceName,
 		log.Level,
@@ -264,84 +381,159 @@ func (s *SQLiteStorage) InsertLog(log *Log) error {
 		log.Attributes,
 		log.TraceID,
 		log.SpanID,
+		nullString(log.PatternID),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	s.catalog.observe(log.ServiceName, "logs", false)
+	return nil
 }
 
 func (s *SQLiteStorage) GetLogs(limit int, offset int) ([]*Log, error) {
-	query := `SELECT id, timestamp, service_name, level, message, attributes, trace_id, span_id, created_at 
-			  FROM logs 
-			  ORDER BY timestamp DESC 
-			  LIMIT ? OFFSET ?`
-
-	rows, err := s.db.Query(query, limit, offset)
+	logs, err := queryNewest(s, "logs", allTimeFrom, allTimeTo, offset+limit,
+		func(table string, limit int) ([]*Log, error) {
+			rows, err := s.db.Query(`SELECT `+logColumns+` 
+				FROM `+table+` 
+				ORDER BY timestamp DESC 
+				LIMIT ?`, limit)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var logs []*Log
+			for rows.Next() {
+				l, err := scanLog(rows)
+				if err != nil {
+					return nil, err
+				}
+				logs = append(logs, l)
+			}
+			return logs, rows.Err()
+		},
+		func(l *Log) int64 { return l.Timestamp.UnixNano() })
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
+	return page(logs, offset), nil
+}
+
+// GetTraceLogs returns the logs carrying a trace ID, oldest first
+func (s *SQLiteStorage) GetTraceLogs(traceID string) ([]*Log, error) {
 	var logs []*Log
-	for rows.Next() {
-		var l Log
-		var timestamp, createdAt int64
+	err := s.queryRows("logs", allTimeFrom, allTimeTo,
+		func(table string) (*sql.Rows, error) {
+			return s.db.Query(`SELECT `+logColumns+` FROM `+table+` WHERE trace_id = ?`, traceID)
+		},
+		func(rows *sql.Rows) error {
+			l, err := scanLog(rows)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, l)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(&l.ID, &timestamp, &l.ServiceName, &l.Level, &l.Message,
-			&l.Attributes, &l.TraceID, &l.SpanID, &createdAt)
-		if err != nil {
-			return nil, err
-		}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	return logs, nil
+}
 
-		l.Timestamp = time.Unix(0, timestamp)
-		l.CreatedAt = time.Unix(createdAt, 0)
-		logs = append(logs, &l)
+// GetServiceLogs returns up to limit logs of a service within [from, to],
+// oldest first
+func (s *SQLiteStorage) GetServiceLogs(service string, from, to time.Time, limit int) ([]*Log, error) {
+	var logs []*Log
+	err := s.queryRows("logs", from.UnixNano(), to.UnixNano()+1,
+		func(table string) (*sql.Rows, error) {
+			return s.db.Query(`SELECT `+logColumns+` FROM `+table+` 
+				WHERE service_name = ? AND timestamp >= ? AND timestamp <= ? 
+				ORDER BY timestamp 
+				LIMIT ?`, service, from.UnixNano(), to.UnixNano(), limit)
+		},
+		func(rows *sql.Rows) error {
+			l, err := scanLog(rows)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, l)
+			return nil
+		})
+	if err != nil {
+		return nil, err
 	}
 
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
 	return logs, nil
 }
 
-// Service methods
-func (s *SQLiteStorage) GetServices() ([]string, error) {
-	query := `SELECT DISTINCT service_name FROM (
-		SELECT service_name FROM metrics WHERE service_name IS NOT NULL AND service_name != ''
-		UNION
-		SELECT service_name FROM traces WHERE service_name IS NOT NULL AND service_name != ''
-		UNION
-		SELECT service_name FROM logs WHERE service_name IS NOT NULL AND service_name != ''
-	) ORDER BY service_name`
-
-	rows, err := s.db.Query(query)
+const logColumns = `id, timestamp, service_name, level, message, attributes, trace_id, span_id, pattern_id, created_at`
+
+func scanLog(rows *sql.Rows) (*Log, error) {
+	var l Log
+	var timestamp, createdAt int64
+	var patternID sql.NullString
+
+	err := rows.Scan(&l.ID, &timestamp, &l.ServiceName, &l.Level, &l.Message,
+		&l.Attributes, &l.TraceID, &l.SpanID, &patternID, &createdAt)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var services []string
-	for rows.Next() {
-		var service string
-		if err := rows.Scan(&service); err != nil {
-			return nil, err
-		}
-		services = append(services, service)
+	l.Timestamp = time.Unix(0, timestamp)
+	l.PatternID = patternID.String
+	l.CreatedAt = time.Unix(createdAt, 0)
+	return &l, nil
+}
+
+// page drops the first offset rows of a newest-first result
+func page[T any](rows []T, offset int) []T {
+	if offset >= len(rows) {
+		return nil
 	}
+	return rows[offset:]
+}
 
-	return services, nil
+// Service methods
+func (s *SQLiteStorage) GetServices() ([]string, error) {
+	return s.catalog.names(), nil
 }
 
-// Cleanup old data
+// Cleanup old data by dropping partitions that lie entirely outside the
+// retention window
 func (s *SQLiteStorage) CleanupOldData() error {
-	cutoff := time.Now().AddDate(0, 0, -s.config.RetentionDays).UnixNano()
+	cutoff := time.Now().AddDate(0, 0, -s.config.RetentionDays)
 
-	queries := []string{
-		`DELETE FROM metrics WHERE timestamp < ?`,
-		`DELETE FROM traces WHERE start_time < ?`,
-		`DELETE FROM logs WHERE timestamp < ?`,
+	if err := s.dropPartitionsBefore(cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("failed to cleanup old data: %w", err)
 	}
 
-	for _, query := range queries {
-		if _, err := s.db.Exec(query, cutoff); err != nil {
-			return fmt.Errorf("failed to cleanup old data: %w", err)
-		}
+	if err := s.cleanupCatalog(cutoff); err != nil {
+		return err
 	}
 
-	return nil
+	if _, err := s.db.Exec(`DELETE FROM exemplars WHERE timestamp < ?`, cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("failed to cleanup exemplars: %w", err)
+	}
+
+	if err := s.cleanupAlertHistory(cutoff); err != nil {
+		return err
+	}
+
+	if err := s.cleanupRollups(); err != nil {
+		return err
+	}
+
+	return s.cleanupSeries()
 }
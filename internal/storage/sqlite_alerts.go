@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func (s *SQLiteStorage) SaveAlertRule(rule *config.AlertRuleConfig) error {
+	definition, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	_, err = s.db.Exec(`INSERT INTO alert_rules (name, definition, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET definition = excluded.definition, updated_at = excluded.updated_at`,
+		rule.Name, string(definition), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to save alert rule %s: %w", rule.Name, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteAlertRule(name string) error {
+	if _, err := s.db.Exec(`DELETE FROM alert_rules WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete alert rule %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetAlertRules() ([]*config.AlertRuleConfig, error) {
+	rows, err := s.db.Query(`SELECT definition FROM alert_rules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*config.AlertRuleConfig
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return nil, err
+		}
+		var rule config.AlertRuleConfig
+		if err := json.Unmarshal([]byte(definition), &rule); err != nil {
+			return nil, fmt.Errorf("failed to parse alert rule: %w", err)
+		}
+		rules = append(rules, &rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *SQLiteStorage) SaveAlertState(state *AlertState) error {
+	_, err := s.db.Exec(`INSERT INTO alert_states (rule_name, labels, annotations, state, value, active_at, fired_at, resolved_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (rule_name, labels) DO UPDATE SET
+		annotations = excluded.annotations,
+		state = excluded.state,
+		value = excluded.value,
+		active_at = excluded.active_at,
+		fired_at = excluded.fired_at,
+		resolved_at = excluded.resolved_at,
+		updated_at = excluded.updated_at`,
+		state.RuleName, state.Labels, state.Annotations, state.State, state.Value,
+		state.ActiveAt.UnixNano(), optionalUnixNano(state.FiredAt), optionalUnixNano(state.ResolvedAt),
+		state.UpdatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to save alert state: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteAlertState(ruleName, labels string) error {
+	if _, err := s.db.Exec(`DELETE FROM alert_states WHERE rule_name = ? AND labels = ?`, ruleName, labels); err != nil {
+		return fmt.Errorf("failed to delete alert state: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetAlertStates() ([]*AlertState, error) {
+	rows, err := s.db.Query(`SELECT rule_name, labels, annotations, state, value, active_at, fired_at, resolved_at, updated_at
+		FROM alert_states ORDER BY rule_name, labels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []*AlertState
+	for rows.Next() {
+		var st AlertState
+		var activeAt, firedAt, resolvedAt, updatedAt int64
+		err := rows.Scan(&st.RuleName, &st.Labels, &st.Annotations, &st.State, &st.Value,
+			&activeAt, &firedAt, &resolvedAt, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+		st.ActiveAt = time.Unix(0, activeAt)
+		st.FiredAt = optionalTime(firedAt)
+		st.ResolvedAt = optionalTime(resolvedAt)
+		st.UpdatedAt = time.Unix(0, updatedAt)
+		states = append(states, &st)
+	}
+
+	return states, rows.Err()
+}
+
+func (s *SQLiteStorage) InsertAlertEvent(event *AlertEvent) error {
+	result, err := s.db.Exec(`INSERT INTO alert_history (rule_name, labels, state, value, timestamp) VALUES (?, ?, ?, ?, ?)`,
+		event.RuleName, event.Labels, event.State, event.Value, event.Timestamp.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to insert alert event: %w", err)
+	}
+	event.ID, _ = result.LastInsertId()
+	return nil
+}
+
+func (s *SQLiteStorage) GetAlertHistory(q AlertHistoryQuery) ([]*AlertEvent, error) {
+	query := `SELECT id, rule_name, labels, state, value, timestamp FROM alert_history WHERE timestamp >= ? AND timestamp < ?`
+	args := []interface{}{q.Start.UnixNano(), q.End.UnixNano()}
+	if q.RuleName != "" {
+		query += ` AND rule_name = ?`
+		args = append(args, q.RuleName)
+	}
+	query += ` ORDER BY timestamp DESC, id DESC LIMIT ?`
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AlertEvent
+	for rows.Next() {
+		var e AlertEvent
+		var timestamp int64
+		if err := rows.Scan(&e.ID, &e.RuleName, &e.Labels, &e.State, &e.Value, &timestamp); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(0, timestamp)
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
+
+// Count counts the logs or spans matching q across the partitions it spans
+func (s *SQLiteStorage) Count(q CountQuery) (int64, error) {
+	timeColumn := "timestamp"
+	var conditions []string
+	var args []interface{}
+	if q.ServiceName != "" {
+		conditions = append(conditions, "service_name = ?")
+		args = append(args, q.ServiceName)
+	}
+
+	switch q.Signal {
+	case "logs":
+		if q.Level != "" {
+			conditions = append(conditions, "level = ? COLLATE NOCASE")
+			args = append(args, q.Level)
+		}
+		if q.Contains != "" {
+			conditions = append(conditions, "instr(message, ?) > 0")
+			args = append(args, q.Contains)
+		}
+	case "traces":
+		timeColumn = "start_time"
+		if q.Operation != "" {
+			conditions = append(conditions, "operation_name = ?")
+			args = append(args, q.Operation)
+		}
+		if q.ErrorsOnly {
+			conditions = append(conditions, "status_code IN ('STATUS_CODE_ERROR', '2')")
+		}
+	default:
+		return 0, fmt.Errorf("unsupported signal: %s", q.Signal)
+	}
+
+	conditions = append(conditions, timeColumn+" >= ?", timeColumn+" < ?")
+	args = append(args, q.Start.UnixNano(), q.End.UnixNano())
+	where := strings.Join(conditions, " AND ")
+
+	var total int64
+	err := s.queryRows(q.Signal, q.Start.UnixNano(), q.End.UnixNano(),
+		func(table string) (*sql.Rows, error) {
+			return s.db.Query(`SELECT COUNT(*) FROM `+table+` WHERE `+where, args...)
+		},
+		func(rows *sql.Rows) error {
+			var n int64
+			if err := rows.Scan(&n); err != nil {
+				return err
+			}
+			total += n
+			return nil
+		})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", q.Signal, err)
+	}
+	return total, nil
+}
+
+func (s *SQLiteStorage) SaveSilence(silence *Silence) error {
+	definition, err := json.Marshal(silence)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO silences (id, definition, ends_at, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET definition = excluded.definition, ends_at = excluded.ends_at`,
+		silence.ID, string(definition), silence.EndsAt.UnixNano(), silence.CreatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to save silence %s: %w", silence.ID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteSilence(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM silences WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete silence %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetSilences() ([]*Silence, error) {
+	var silences []*Silence
+	err := s.queryDefinitions(`SELECT definition FROM silences ORDER BY created_at`, func(data []byte) error {
+		var silence Silence
+		if err := json.Unmarshal(data, &silence); err != nil {
+			return fmt.Errorf("failed to parse silence: %w", err)
+		}
+		silences = append(silences, &silence)
+		return nil
+	})
+	return silences, err
+}
+
+func (s *SQLiteStorage) SaveMaintenanceWindow(window *MaintenanceWindow) error {
+	return s.saveDefinition("maintenance_windows", window.Name, window)
+}
+
+func (s *SQLiteStorage) DeleteMaintenanceWindow(name string) error {
+	if _, err := s.db.Exec(`DELETE FROM maintenance_windows WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete maintenance window %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetMaintenanceWindows() ([]*MaintenanceWindow, error) {
+	var windows []*MaintenanceWindow
+	err := s.queryDefinitions(`SELECT definition FROM maintenance_windows ORDER BY name`, func(data []byte) error {
+		var window MaintenanceWindow
+		if err := json.Unmarshal(data, &window); err != nil {
+			return fmt.Errorf("failed to parse maintenance window: %w", err)
+		}
+		windows = append(windows, &window)
+		return nil
+	})
+	return windows, err
+}
+
+func (s *SQLiteStorage) SaveInhibitRule(rule *InhibitRule) error {
+	return s.saveDefinition("inhibit_rules", rule.Name, rule)
+}
+
+func (s *SQLiteStorage) DeleteInhibitRule(name string) error {
+	if _, err := s.db.Exec(`DELETE FROM inhibit_rules WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete inhibit rule %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetInhibitRules() ([]*InhibitRule, error) {
+	var rules []*InhibitRule
+	err := s.queryDefinitions(`SELECT definition FROM inhibit_rules ORDER BY name`, func(data []byte) error {
+		var rule InhibitRule
+		if err := json.Unmarshal(data, &rule); err != nil {
+			return fmt.Errorf("failed to parse inhibit rule: %w", err)
+		}
+		rules = append(rules, &rule)
+		return nil
+	})
+	return rules, err
+}
+
+// saveDefinition upserts a named JSON definition into a table keyed by name
+func (s *SQLiteStorage) saveDefinition(table, name string, v interface{}) error {
+	definition, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	_, err = s.db.Exec(`INSERT INTO `+table+` (name, definition, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET definition = excluded.definition, updated_at = excluded.updated_at`,
+		name, string(definition), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to save %s %s: %w", table, name, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) queryDefinitions(query string, fn func(data []byte) error) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return err
+		}
+		if err := fn([]byte(definition)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// cleanupAlertHistory drops alert events and expired silences older than the
+// retention window
+func (s *SQLiteStorage) cleanupAlertHistory(cutoff time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM alert_history WHERE timestamp < ?`, cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("failed to cleanup alert history: %w", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM silences WHERE ends_at < ?`, cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("failed to cleanup silences: %w", err)
+	}
+	return nil
+}
+
+func optionalUnixNano(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func optionalTime(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos)
+	return &t
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SaveAPIKeys upserts API keys
+func (s *SQLiteStorage) SaveAPIKeys(keys []*APIKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		definition, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO api_keys (name, definition, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET definition = excluded.definition`,
+			key.Name, string(definition), key.CreatedAt.UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to save API key %s: %w", key.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetAPIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	err := s.queryDefinitions(`SELECT definition FROM api_keys ORDER BY created_at`, func(data []byte) error {
+		var key APIKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return fmt.Errorf("failed to parse API key: %w", err)
+		}
+		keys = append(keys, &key)
+		return nil
+	})
+	return keys, err
+}
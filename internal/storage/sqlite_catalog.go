@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// loadCatalog restores the service catalog, seeding it from the stored
+// signals the first time a database without a catalog is opened
+func (s *SQLiteStorage) loadCatalog() error {
+	records := make(map[string]*catalogRecord)
+
+	rows, err := s.db.Query(`SELECT name, first_seen, last_seen, signals FROM services`)
+	if err != nil {
+		return fmt.Errorf("failed to load service catalog: %w", err)
+	}
+	for rows.Next() {
+		var r catalogRecord
+		var firstSeen, lastSeen int64
+		var signals string
+		if err := rows.Scan(&r.Name, &firstSeen, &lastSeen, &signals); err != nil {
+			rows.Close()
+			return err
+		}
+		r.FirstSeen = time.Unix(0, firstSeen)
+		r.LastSeen = time.Unix(0, lastSeen)
+		json.Unmarshal([]byte(signals), &r.Signals)
+		records[r.Name] = &r
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT service_name, instance_id, version, environment, first_seen, last_seen FROM service_instances`)
+	if err != nil {
+		return fmt.Errorf("failed to load service instances: %w", err)
+	}
+	for rows.Next() {
+		var inst ServiceInstance
+		var service string
+		var firstSeen, lastSeen int64
+		if err := rows.Scan(&service, &inst.ID, &inst.Version, &inst.Environment, &firstSeen, &lastSeen); err != nil {
+			rows.Close()
+			return err
+		}
+		inst.FirstSeen = time.Unix(0, firstSeen)
+		inst.LastSeen = time.Unix(0, lastSeen)
+		if r, ok := records[service]; ok {
+			r.Instances = append(r.Instances, &inst)
+		}
+	}
+	rows.Close()
+
+	if len(records) == 0 {
+		return s.backfillCatalog()
+	}
+
+	list := make([]*catalogRecord, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	s.catalog.restore(list)
+	return nil
+}
+
+// backfillCatalog derives catalog entries from the services and time ranges
+// found in the stored signals
+func (s *SQLiteStorage) backfillCatalog() error {
+	records := make(map[string]*catalogRecord)
+	add := func(signal, service string, first, last int64) {
+		r, ok := records[service]
+		if !ok {
+			r = &catalogRecord{Name: service, FirstSeen: time.Unix(0, first), LastSeen: time.Unix(0, last)}
+			records[service] = r
+		}
+		if t := time.Unix(0, first); t.Before(r.FirstSeen) {
+			r.FirstSeen = t
+		}
+		if t := time.Unix(0, last); t.After(r.LastSeen) {
+			r.LastSeen = t
+		}
+		for _, existing := range r.Signals {
+			if existing == signal {
+				return
+			}
+		}
+		r.Signals = append(r.Signals, signal)
+	}
+
+	queries := map[string]string{
+		"metrics": `SELECT s.service_name, MIN(m.timestamp), MAX(m.timestamp) FROM %s m
+			JOIN series s ON s.id = m.series_id WHERE s.service_name != '' GROUP BY s.service_name`,
+		"traces": `SELECT service_name, MIN(start_time), MAX(start_time) FROM %s
+			WHERE service_name IS NOT NULL AND service_name != '' GROUP BY service_name`,
+		"logs": `SELECT service_name, MIN(timestamp), MAX(timestamp) FROM %s
+			WHERE service_name IS NOT NULL AND service_name != '' GROUP BY service_name`,
+	}
+	for signal, query := range queries {
+		err := s.queryRows(signal, allTimeFrom, allTimeTo,
+			func(table string) (*sql.Rows, error) {
+				return s.db.Query(fmt.Sprintf(query, table))
+			},
+			func(rows *sql.Rows) error {
+				var service string
+				var first, last int64
+				if err := rows.Scan(&service, &first, &last); err != nil {
+					return err
+				}
+				add(signal, service, first, last)
+				return nil
+			})
+		if err != nil {
+			return fmt.Errorf("failed to backfill service catalog: %w", err)
+		}
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	list := make([]*catalogRecord, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	s.catalog.restore(list)
+	return s.writeCatalog(list)
+}
+
+// writeCatalog upserts catalog entries and their instances
+func (s *SQLiteStorage) writeCatalog(records []*catalogRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		signals, _ := json.Marshal(r.Signals)
+		_, err := tx.Exec(`INSERT INTO services (name, first_seen, last_seen, signals) VALUES (?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			signals = excluded.signals`,
+			r.Name, r.FirstSeen.UnixNano(), r.LastSeen.UnixNano(), string(signals))
+		if err != nil {
+			return fmt.Errorf("failed to write service %s: %w", r.Name, err)
+		}
+
+		for _, inst := range r.Instances {
+			_, err := tx.Exec(`INSERT INTO service_instances (service_name, instance_id, version, environment, first_seen, last_seen)
+				VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (service_name, instance_id) DO UPDATE SET
+				version = excluded.version,
+				environment = excluded.environment,
+				last_seen = MAX(last_seen, excluded.last_seen)`,
+				r.Name, inst.ID, inst.Version, inst.Environment, inst.FirstSeen.UnixNano(), inst.LastSeen.UnixNano())
+			if err != nil {
+				return fmt.Errorf("failed to write instance of service %s: %w", r.Name, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// cleanupCatalog forgets services and instances that have not reported
+// within the retention window
+func (s *SQLiteStorage) cleanupCatalog(cutoff time.Time) error {
+	for _, name := range s.catalog.forget(cutoff) {
+		if _, err := s.db.Exec(`DELETE FROM services WHERE name = ?`, name); err != nil {
+			return fmt.Errorf("failed to remove service %s: %w", name, err)
+		}
+	}
+	if _, err := s.db.Exec(`DELETE FROM service_instances WHERE last_seen < ?`, cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("failed to remove stale service instances: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) ObserveResource(res ServiceResource) error {
+	s.catalog.observeResource(res)
+	return nil
+}
+
+func (s *SQLiteStorage) GetServiceCatalog(window time.Duration) ([]*ServiceSummary, error) {
+	return s.catalog.summaries(window), nil
+}
+
+func (s *SQLiteStorage) GetServiceDetail(name string, window time.Duration) (*ServiceDetail, error) {
+	return s.catalog.detail(name, window), nil
+}
+
+func (s *SQLiteStorage) GetServiceGraph(window time.Duration) (*ServiceGraph, error) {
+	return s.graph.graph(window), nil
+}
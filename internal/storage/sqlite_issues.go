@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SaveIssues upserts issues
+func (s *SQLiteStorage) SaveIssues(issues []*Issue) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, issue := range issues {
+		definition, err := json.Marshal(issue)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO issues (id, definition, status, last_seen) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+			definition = excluded.definition,
+			status = excluded.status,
+			last_seen = excluded.last_seen`,
+			issue.ID, string(definition), issue.Status, issue.LastSeen.UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to save issue %s: %w", issue.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetIssues() ([]*Issue, error) {
+	var issues []*Issue
+	err := s.queryDefinitions(`SELECT definition FROM issues ORDER BY last_seen DESC`, func(data []byte) error {
+		var issue Issue
+		if err := json.Unmarshal(data, &issue); err != nil {
+			return fmt.Errorf("failed to parse issue: %w", err)
+		}
+		issues = append(issues, &issue)
+		return nil
+	})
+	return issues, err
+}
+
+// PruneIssues drops issues not seen since before
+func (s *SQLiteStorage) PruneIssues(before time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM issues WHERE last_seen < ?`, before.UnixNano()); err != nil {
+		return fmt.Errorf("failed to prune issues: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// GetPatternLogs returns the logs assigned to a pattern, newest first
+func (s *SQLiteStorage) GetPatternLogs(patternID string, limit int, offset int) ([]*Log, error) {
+	logs, err := queryNewest(s, "logs", allTimeFrom, allTimeTo, offset+limit,
+		func(table string, limit int) ([]*Log, error) {
+			rows, err := s.db.Query(`SELECT `+logColumns+` 
+				FROM `+table+` 
+				WHERE pattern_id = ? 
+				ORDER BY timestamp DESC 
+				LIMIT ?`, patternID, limit)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var logs []*Log
+			for rows.Next() {
+				l, err := scanLog(rows)
+				if err != nil {
+					return nil, err
+				}
+				logs = append(logs, l)
+			}
+			return logs, rows.Err()
+		},
+		func(l *Log) int64 { return l.Timestamp.UnixNano() })
+	if err != nil {
+		return nil, err
+	}
+
+	return page(logs, offset), nil
+}
+
+// SaveLogPatterns upserts patterns and the bucket counts they carry
+func (s *SQLiteStorage) SaveLogPatterns(patterns []*LogPattern) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range patterns {
+		_, err := tx.Exec(`INSERT INTO log_patterns (id, service_name, template, count, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+			template = excluded.template,
+			count = excluded.count,
+			last_seen = excluded.last_seen`,
+			p.ID, p.ServiceName, p.Template, p.Count, p.FirstSeen.UnixNano(), p.LastSeen.UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to save log pattern %s: %w", p.ID, err)
+		}
+
+		for _, c := range p.Counts {
+			_, err := tx.Exec(`INSERT INTO log_pattern_counts (pattern_id, timestamp, count) VALUES (?, ?, ?)
+				ON CONFLICT (pattern_id, timestamp) DO UPDATE SET count = excluded.count`,
+				p.ID, c.Timestamp.UnixNano(), c.Count)
+			if err != nil {
+				return fmt.Errorf("failed to save log pattern %s counts: %w", p.ID, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetLogPatterns returns every stored pattern with its bucket counts, oldest
+// bucket first
+func (s *SQLiteStorage) GetLogPatterns() ([]*LogPattern, error) {
+	rows, err := s.db.Query(`SELECT id, service_name, template, count, first_seen, last_seen FROM log_patterns`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load log patterns: %w", err)
+	}
+	defer rows.Close()
+
+	var patterns []*LogPattern
+	byID := make(map[string]*LogPattern)
+	for rows.Next() {
+		var p LogPattern
+		var firstSeen, lastSeen int64
+		if err := rows.Scan(&p.ID, &p.ServiceName, &p.Template, &p.Count, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		p.FirstSeen = time.Unix(0, firstSeen)
+		p.LastSeen = time.Unix(0, lastSeen)
+		patterns = append(patterns, &p)
+		byID[p.ID] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts, err := s.db.Query(`SELECT pattern_id, timestamp, count FROM log_pattern_counts ORDER BY timestamp`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load log pattern counts: %w", err)
+	}
+	defer counts.Close()
+
+	for counts.Next() {
+		var id string
+		var timestamp, count int64
+		if err := counts.Scan(&id, &timestamp, &count); err != nil {
+			return nil, err
+		}
+		if p, ok := byID[id]; ok {
+			p.Counts = append(p.Counts, &PatternCount{Timestamp: time.Unix(0, timestamp), Count: count})
+		}
+	}
+	if err := counts.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].FirstSeen.Before(patterns[j].FirstSeen) })
+	return patterns, nil
+}
+
+// PruneLogPatterns drops bucket counts older than before, and patterns not
+// seen since
+func (s *SQLiteStorage) PruneLogPatterns(before time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM log_pattern_counts WHERE timestamp < ?`, before.UnixNano()); err != nil {
+		return fmt.Errorf("failed to prune log pattern counts: %w", err)
+	}
+	_, err := s.db.Exec(`DELETE FROM log_patterns WHERE last_seen < ?`, before.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to prune log patterns: %w", err)
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// writeRollups merges partial rollups of one tier into metric_rollups
+func (s *SQLiteStorage) writeRollups(resolution time.Duration, rollups []*MetricRollup) error {
+	s.seriesGC.RLock()
+	defer s.seriesGC.RUnlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO metric_rollups (resolution, bucket, series_id,
+			  min, max, sum, count, last, last_timestamp)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT (resolution, series_id, bucket) DO UPDATE SET
+			  min = MIN(min, excluded.min),
+			  max = MAX(max, excluded.max),
+			  sum = sum + excluded.sum,
+			  count = count + excluded.count,
+			  last = CASE WHEN excluded.last_timestamp >= last_timestamp THEN excluded.last ELSE last END,
+			  last_timestamp = MAX(last_timestamp, excluded.last_timestamp)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		seriesID, err := s.resolveSeries(r.MetricName, r.ServiceName, r.Labels)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(int64(resolution/time.Second), r.Bucket.UnixNano(), seriesID,
+			r.Min, r.Max, r.Sum, r.Count, r.Last, r.LastTimestamp.UnixNano())
+		if err != nil {
+			return fmt.Errorf("failed to write rollup: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) queryRollups(resolution time.Duration, q MetricQuery) ([]*MetricRollup, error) {
+	matched, err := s.matchSeries(q)
+	if err != nil || len(matched) == 0 {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT bucket, series_id, min, max, sum, count, last, last_timestamp
+			  FROM metric_rollups
+			  WHERE resolution = ? AND series_id IN (`+seriesIDList(matched)+`) AND bucket >= ? AND bucket < ?`,
+		int64(resolution/time.Second), q.Start.Truncate(resolution).UnixNano(), q.End.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []*MetricRollup
+	for rows.Next() {
+		r := MetricRollup{Resolution: resolution}
+		var bucket, seriesID, lastTimestamp int64
+		err := rows.Scan(&bucket, &seriesID, &r.Min, &r.Max, &r.Sum, &r.Count, &r.Last, &lastTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		info := matched[seriesID]
+		r.MetricName, r.ServiceName, r.Labels = info.metricName, info.serviceName, info.labels
+		r.Bucket = time.Unix(0, bucket)
+		r.LastTimestamp = time.Unix(0, lastTimestamp)
+		rollups = append(rollups, &r)
+	}
+
+	return rollups, rows.Err()
+}
+
+// queryRawMetrics reads the raw datapoints of the series matched by q from
+// the partitions covering its time range
+func (s *SQLiteStorage) queryRawMetrics(q MetricQuery) ([]*Metric, error) {
+	matched, err := s.matchSeries(q)
+	if err != nil || len(matched) == 0 {
+		return nil, err
+	}
+	from, to := q.Start.UnixNano(), q.End.UnixNano()
+
+	var metrics []*Metric
+	err = s.queryRows("metrics", from, to,
+		func(table string) (*sql.Rows, error) {
+			return s.db.Query(`SELECT `+metricColumns+`
+				FROM `+table+` m JOIN series s ON s.id = m.series_id
+				WHERE m.series_id IN (`+seriesIDList(matched)+`) AND m.timestamp >= ? AND m.timestamp < ?`, from, to)
+		},
+		func(rows *sql.Rows) error {
+			m, err := scanMetric(rows)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, m)
+			return nil
+		})
+
+	return metrics, err
+}
+
+func (s *SQLiteStorage) QueryMetricRange(q MetricQuery) (*MetricQueryResult, error) {
+	return queryMetricRange(q, s.rollups, s.queryRawMetrics, s.queryRollups, s.QueryExemplars)
+}
+
+// QueryExemplars returns the exemplars of the series matched by q within its
+// time range, oldest first
+func (s *SQLiteStorage) QueryExemplars(q MetricQuery) ([]*Exemplar, error) {
+	matched, err := s.matchSeries(q)
+	if err != nil || len(matched) == 0 {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT series_id, timestamp, value, trace_id, span_id, attributes
+			  FROM exemplars
+			  WHERE series_id IN (`+seriesIDList(matched)+`) AND timestamp >= ? AND timestamp < ?
+			  ORDER BY timestamp`,
+		q.Start.UnixNano(), q.End.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exemplars []*Exemplar
+	for rows.Next() {
+		var e Exemplar
+		var seriesID, timestamp int64
+		if err := rows.Scan(&seriesID, &timestamp, &e.Value, &e.TraceID, &e.SpanID, &e.Attributes); err != nil {
+			return nil, err
+		}
+		info := matched[seriesID]
+		e.MetricName, e.ServiceName, e.Labels = info.metricName, info.serviceName, info.labels
+		e.Timestamp = time.Unix(0, timestamp)
+		exemplars = append(exemplars, &e)
+	}
+
+	return exemplars, rows.Err()
+}
+
+// cleanupRollups applies each tier's own retention window
+func (s *SQLiteStorage) cleanupRollups() error {
+	for _, tier := range s.config.Rollups.Tiers {
+		cutoff := time.Now().AddDate(0, 0, -tier.RetentionDays).UnixNano()
+		_, err := s.db.Exec(`DELETE FROM metric_rollups WHERE resolution = ? AND bucket < ?`,
+			int64(tier.Resolution/time.Second), cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to cleanup %s rollups: %w", FormatResolution(tier.Resolution), err)
+		}
+	}
+	return nil
+}
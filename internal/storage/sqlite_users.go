@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func (s *SQLiteStorage) SaveUser(user *User) error {
+	definition, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO users (username, definition, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET definition = excluded.definition`,
+		user.Username, string(definition), user.CreatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to save user %s: %w", user.Username, err)
+	}
+	return nil
+}
+
+// DeleteUser removes a user with its tokens
+func (s *SQLiteStorage) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_tokens WHERE username = ?`, username); err != nil {
+		return fmt.Errorf("failed to delete tokens of user %s: %w", username, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE username = ?`, username); err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", username, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetUsers() ([]*User, error) {
+	var users []*User
+	err := s.queryDefinitions(`SELECT definition FROM users ORDER BY username`, func(data []byte) error {
+		var user User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return fmt.Errorf("failed to parse user: %w", err)
+		}
+		users = append(users, &user)
+		return nil
+	})
+	return users, err
+}
+
+func (s *SQLiteStorage) SaveUserToken(token *UserToken) error {
+	definition, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO user_tokens (id, username, definition, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET definition = excluded.definition`,
+		token.ID, token.Username, string(definition), token.CreatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to save user token %s: %w", token.ID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteUserToken(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM user_tokens WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete user token %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetUserTokens() ([]*UserToken, error) {
+	var tokens []*UserToken
+	err := s.queryDefinitions(`SELECT definition FROM user_tokens ORDER BY created_at`, func(data []byte) error {
+		var token UserToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return fmt.Errorf("failed to parse user token: %w", err)
+		}
+		tokens = append(tokens, &token)
+		return nil
+	})
+	return tokens, err
+}
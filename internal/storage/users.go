@@ -0,0 +1,29 @@
+package storage
+
+import "time"
+
+// User is a local account created through the admin API. Only the bcrypt
+// hash of its password is stored.
+type User struct {
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"password_hash"`
+	Role         string     `json:"role"`
+	Tenants      []string   `json:"tenants,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	LastLogin    *time.Time `json:"last_login,omitempty"`
+}
+
+// UserToken is a bearer token for the API acting as a user, with that user's
+// current role and tenants, or those of Tenants the user still has. Only the SHA-256 hash of the token is stored, with a short
+// prefix to tell tokens apart.
+type UserToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Username  string     `json:"username"`
+	Hash      string     `json:"hash"`
+	Prefix    string     `json:"prefix"`
+	Tenants   []string   `json:"tenants,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
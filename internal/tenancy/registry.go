@@ -0,0 +1,406 @@
+package tenancy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"open-telemorph-prime/internal/alerting"
+	"open-telemorph-prime/internal/anomaly"
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/issues"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/patterns"
+	"open-telemorph-prime/internal/sampling"
+	"open-telemorph-prime/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidTenant  = errors.New("invalid tenant ID")
+	ErrTenantRequired = errors.New("tenant ID is required")
+	ErrUnknownTenant  = errors.New("unknown tenant")
+	ErrTooManyTenants = errors.New("too many tenants")
+	ErrTenantMismatch = errors.New("tenant header does not match the API key's tenant")
+	ErrTenantDenied   = errors.New("not permitted to query tenant")
+)
+
+// tenantID restricts tenant IDs to characters that are safe in directory
+// names
+var tenantID = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// Gin context keys of the request's tenant, and of the tenant assigned and
+// the tenants allowed ahead of the middleware
+const (
+	contextKey  = "tenant"
+	assignedKey = "assigned_tenant"
+	allowedKey  = "allowed_tenants"
+)
+
+// allTenants in the allowed tenants of a request allows every tenant
+const allTenants = "*"
+
+// ValidID reports whether id can name a tenant
+func ValidID(id string) bool {
+	return tenantID.MatchString(id)
+}
+
+// Tenant is one tenant's storage with the processors that read and write it
+type Tenant struct {
+	ID            string
+	RetentionDays int
+
+	Storage  storage.Storage
+	Patterns *patterns.Miner
+	Issues   *issues.Tracker
+	Sampler  *sampling.Sampler
+	Limits   *sampling.Limiter
+	Detector *anomaly.Detector
+	Alerts   *alerting.Engine
+}
+
+// TenantStatus describes an open tenant
+type TenantStatus struct {
+	ID            string `json:"id"`
+	Default       bool   `json:"default"`
+	Listed        bool   `json:"listed"`
+	RetentionDays int    `json:"retention_days"`
+	LimitsEnabled bool   `json:"limits_enabled"`
+}
+
+// Registry opens tenants on first use and keeps them until shutdown. With
+// tenancy disabled it only holds the default tenant.
+type Registry struct {
+	config *config.Config
+	listed map[string]config.TenantConfig
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	tenants map[string]*Tenant
+	started bool
+}
+
+// NewRegistry validates the tenancy config and opens the default tenant
+func NewRegistry(cfg *config.Config) (*Registry, error) {
+	r := &Registry{
+		config:  cfg,
+		listed:  make(map[string]config.TenantConfig),
+		logger:  logger.Get(),
+		tenants: make(map[string]*Tenant),
+	}
+
+	if !tenantID.MatchString(cfg.Tenancy.DefaultTenant) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidTenant, cfg.Tenancy.DefaultTenant)
+	}
+	for _, t := range cfg.Tenancy.Tenants {
+		if !tenantID.MatchString(t.ID) {
+			return nil, fmt.Errorf("%w %q", ErrInvalidTenant, t.ID)
+		}
+		if _, ok := r.listed[t.ID]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", t.ID)
+		}
+		if t.RetentionDays < 0 {
+			return nil, fmt.Errorf("%w %q: retention_days must not be negative", ErrInvalidTenant, t.ID)
+		}
+		r.listed[t.ID] = t
+	}
+
+	if _, err := r.open(cfg.Tenancy.DefaultTenant); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Default returns the default tenant
+func (r *Registry) Default() *Tenant {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tenants[r.config.Tenancy.DefaultTenant]
+}
+
+// Resolve returns the tenant named by a request, opening it on first use.
+// An empty ID names the default tenant.
+func (r *Registry) Resolve(id string) (*Tenant, error) {
+	if !r.config.Tenancy.Enabled {
+		return r.Default(), nil
+	}
+	if id == "" {
+		if r.config.Tenancy.RequireTenant {
+			return nil, ErrTenantRequired
+		}
+		return r.Default(), nil
+	}
+	if !tenantID.MatchString(id) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidTenant, id)
+	}
+	if _, ok := r.listed[id]; !ok && id != r.config.Tenancy.DefaultTenant && !r.config.Tenancy.AllowUnlisted {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, id)
+	}
+	return r.open(id)
+}
+
+func (r *Registry) open(id string) (*Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.tenants[id]; ok {
+		return t, nil
+	}
+	if len(r.tenants) >= r.config.Tenancy.MaxTenants {
+		return nil, fmt.Errorf("%w: at most %d", ErrTooManyTenants, r.config.Tenancy.MaxTenants)
+	}
+
+	t, err := r.build(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tenant %q: %w", id, err)
+	}
+	if r.started {
+		t.start()
+	}
+	r.tenants[id] = t
+
+	r.logger.Info("Opened tenant",
+		zap.String("tenant", id),
+		zap.Int("retention_days", t.RetentionDays),
+	)
+	return t, nil
+}
+
+// build opens a tenant's storage and creates its processors
+func (r *Registry) build(id string) (*Tenant, error) {
+	cfg := r.config
+	storageCfg := cfg.Storage
+	if id != cfg.Tenancy.DefaultTenant {
+		storageCfg = storage.TenantConfig(cfg.Storage, id)
+	}
+	limits := cfg.Ingestion.Limits
+	if listed, ok := r.listed[id]; ok {
+		if listed.RetentionDays > 0 {
+			storageCfg.RetentionDays = listed.RetentionDays
+		}
+		if listed.Limits != nil {
+			limits = *listed.Limits
+		}
+	}
+
+	store, err := storage.New(storageCfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tenant{ID: id, RetentionDays: storageCfg.RetentionDays, Storage: store}
+
+	if t.Patterns, err = patterns.NewMiner(store, cfg.Ingestion.LogPatterns); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize log patterns: %w", err)
+	}
+	if t.Issues, err = issues.NewTracker(store, cfg.Ingestion.Issues); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize issues: %w", err)
+	}
+	if t.Sampler, err = sampling.NewSampler(store, cfg.Ingestion.TailSampling); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize tail sampling: %w", err)
+	}
+	if t.Limits, err = sampling.NewLimiter(limits); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize ingest limits: %w", err)
+	}
+	t.Detector = anomaly.NewDetector(store, cfg.Anomaly)
+	if t.Alerts, err = alerting.NewEngine(store, cfg.Alerting, t.Detector); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize alerting: %w", err)
+	}
+	return t, nil
+}
+
+// Start starts the processors of the open tenants and of those opened later
+func (r *Registry) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tenants {
+		t.start()
+	}
+	r.started = true
+}
+
+// Stop stops the processors of every tenant, flushing what they hold
+func (r *Registry) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tenants {
+		t.stop()
+	}
+	r.started = false
+}
+
+// Close closes the storage of every tenant
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for id, t := range r.tenants {
+		if err := t.Storage.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupOldData enforces each tenant's retention window
+func (r *Registry) CleanupOldData() error {
+	var errs []error
+	for _, t := range r.list() {
+		if err := t.Storage.CleanupOldData(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", t.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Tenants describes the open tenants, sorted by ID
+func (r *Registry) Tenants() []*TenantStatus {
+	list := []*TenantStatus{}
+	for _, t := range r.list() {
+		_, listed := r.listed[t.ID]
+		list = append(list, &TenantStatus{
+			ID:            t.ID,
+			Default:       t.ID == r.config.Tenancy.DefaultTenant,
+			Listed:        listed,
+			RetentionDays: t.RetentionDays,
+			LimitsEnabled: t.Limits.Config().Enabled,
+		})
+	}
+	return list
+}
+
+func (r *Registry) list() []*Tenant {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Enabled reports whether requests are separated by tenant
+func (r *Registry) Enabled() bool {
+	return r.config.Tenancy.Enabled
+}
+
+// Header is the header, or gRPC metadata key, naming a request's tenant
+func (r *Registry) Header() string {
+	return r.config.Tenancy.Header
+}
+
+// Middleware resolves the tenant of each request from the tenant header, or
+// from the tenant assigned to the request, rejecting requests whose tenant
+// cannot be served or is not among the tenants allowed to the request
+func (r *Registry) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(r.config.Tenancy.Header)
+		if assigned := c.GetString(assignedKey); assigned != "" {
+			if id != "" && id != assigned {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrTenantMismatch.Error()})
+				return
+			}
+			id = assigned
+		}
+		if allowed, ok := c.Get(allowedKey); ok && !r.allows(allowed.([]string), id) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s %q", ErrTenantDenied, r.target(id))})
+			return
+		}
+
+		t, err := r.Resolve(id)
+		if err != nil {
+			c.AbortWithStatusJSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(contextKey, t)
+		c.Next()
+	}
+}
+
+// allows reports whether a request limited to the allowed tenants may use
+// the tenant named by id. Without allowed tenants only the default tenant
+// may be used, and with tenancy disabled it is the only tenant.
+func (r *Registry) allows(allowed []string, id string) bool {
+	if !r.config.Tenancy.Enabled {
+		return true
+	}
+	target := r.target(id)
+	if len(allowed) == 0 {
+		return target == r.config.Tenancy.DefaultTenant
+	}
+	for _, t := range allowed {
+		if t == target || t == allTenants {
+			return true
+		}
+	}
+	return false
+}
+
+// target is the tenant a request names, the default tenant when it names
+// none
+func (r *Registry) target(id string) string {
+	if id == "" {
+		return r.config.Tenancy.DefaultTenant
+	}
+	return id
+}
+
+// Restrict limits a request to tenants ahead of the middleware, as a
+// signed-in user's tenants do. "*" allows every tenant and no tenants the
+// default tenant only. The tenant header of requests never restricted is
+// trusted.
+func Restrict(c *gin.Context, tenants []string) {
+	if tenants == nil {
+		tenants = []string{}
+	}
+	c.Set(allowedKey, tenants)
+}
+
+// Assign fixes the tenant of a request ahead of the middleware, as an API
+// key bound to a tenant does
+func Assign(c *gin.Context, id string) {
+	c.Set(assignedKey, id)
+}
+
+// FromContext returns the tenant the middleware resolved for a request
+func FromContext(c *gin.Context) *Tenant {
+	return c.MustGet(contextKey).(*Tenant)
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidTenant):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTenantRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUnknownTenant), errors.Is(err, ErrTooManyTenants):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (t *Tenant) start() {
+	t.Patterns.Start()
+	t.Issues.Start()
+	t.Sampler.Start()
+	t.Alerts.Start()
+}
+
+func (t *Tenant) stop() {
+	t.Patterns.Stop()
+	t.Issues.Stop()
+	t.Sampler.Stop()
+	t.Alerts.Stop()
+}
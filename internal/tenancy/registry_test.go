@@ -0,0 +1,262 @@
+package tenancy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Storage.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	cfg.Tenancy.Enabled = true
+	cfg.Tenancy.Tenants = []config.TenantConfig{{ID: "team-a"}, {ID: "team-b"}}
+
+	r, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// serveTenant runs a request through the middleware, with prepare setting up
+// what an authentication middleware would ahead of it
+func serveTenant(r *Registry, header string, prepare gin.HandlerFunc) (int, string) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var served string
+	router.GET("/", prepare, r.Middleware(), func(c *gin.Context) {
+		served = FromContext(c).ID
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set("X-Scope-OrgID", header)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code, served
+}
+
+func TestMiddlewareRestrictsTenants(t *testing.T) {
+	r := newTestRegistry(t)
+
+	tests := []struct {
+		name       string
+		allowed    []string
+		restricted bool
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"unrestricted header", nil, false, "team-b", http.StatusOK, "team-b"},
+		{"unrestricted default", nil, false, "", http.StatusOK, "default"},
+		{"unrestricted unlisted", nil, false, "team-c", http.StatusForbidden, ""},
+		{"allowed tenant", []string{"team-a"}, true, "team-a", http.StatusOK, "team-a"},
+		{"other tenant", []string{"team-a"}, true, "team-b", http.StatusForbidden, ""},
+		{"default not granted", []string{"team-a"}, true, "", http.StatusForbidden, ""},
+		{"no tenants default", nil, true, "", http.StatusOK, "default"},
+		{"no tenants header", nil, true, "team-a", http.StatusForbidden, ""},
+		{"no tenants named default", []string{}, true, "default", http.StatusOK, "default"},
+		{"all tenants", []string{"*"}, true, "team-b", http.StatusOK, "team-b"},
+		{"all tenants unlisted", []string{"*"}, true, "team-c", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, served := serveTenant(r, tt.header, func(c *gin.Context) {
+				if tt.restricted {
+					Restrict(c, tt.allowed)
+				}
+			})
+			if status != tt.wantStatus || served != tt.wantTenant {
+				t.Errorf("status = %d, tenant = %q; want %d, %q", status, served, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAssignedTenant(t *testing.T) {
+	r := newTestRegistry(t)
+
+	tests := []struct {
+		name       string
+		header     string
+		allowed    []string
+		wantStatus int
+		wantTenant string
+	}{
+		{"without header", "", nil, http.StatusOK, "team-a"},
+		{"matching header", "team-a", nil, http.StatusOK, "team-a"},
+		{"conflicting header", "team-b", nil, http.StatusForbidden, ""},
+		{"outside allowed tenants", "", []string{"team-b"}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, served := serveTenant(r, tt.header, func(c *gin.Context) {
+				Assign(c, "team-a")
+				if tt.allowed != nil {
+					Restrict(c, tt.allowed)
+				}
+			})
+			if status != tt.wantStatus || served != tt.wantTenant {
+				t.Errorf("status = %d, tenant = %q; want %d, %q", status, served, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestMiddlewareTenancyDisabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Storage.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	r, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	defer r.Close()
+
+	// Every request is served from the default tenant, whatever it names
+	status, served := serveTenant(r, "team-a", func(c *gin.Context) {
+		Restrict(c, nil)
+	})
+	if status != http.StatusOK || served != "default" {
+		t.Errorf("status = %d, tenant = %q; want 200, default", status, served)
+	}
+}
+
+func TestNewRegistryValidatesTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *config.TenancyConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *config.TenancyConfig) {}, false},
+		{"invalid default", func(c *config.TenancyConfig) { c.DefaultTenant = "../default" }, true},
+		{"invalid tenant", func(c *config.TenancyConfig) { c.Tenants = []config.TenantConfig{{ID: "team a"}} }, true},
+		{"hidden tenant", func(c *config.TenancyConfig) { c.Tenants = []config.TenantConfig{{ID: ".team-a"}} }, true},
+		{"duplicate tenant", func(c *config.TenancyConfig) { c.Tenants = []config.TenantConfig{{ID: "team-a"}, {ID: "team-a"}} }, true},
+		{"negative retention", func(c *config.TenancyConfig) { c.Tenants = []config.TenantConfig{{ID: "team-a", RetentionDays: -1}} }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Storage.Path = filepath.Join(t.TempDir(), "telemorph.db")
+			cfg.Tenancy.Enabled = true
+			tt.modify(&cfg.Tenancy)
+
+			r, err := NewRegistry(cfg)
+			if err == nil {
+				r.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRegistry error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(c *config.TenancyConfig)
+		id         string
+		wantErr    error
+		wantTenant string
+	}{
+		{"no header", func(c *config.TenancyConfig) {}, "", nil, "default"},
+		{"listed", func(c *config.TenancyConfig) {}, "team-a", nil, "team-a"},
+		{"default by name", func(c *config.TenancyConfig) {}, "default", nil, "default"},
+		{"header required", func(c *config.TenancyConfig) { c.RequireTenant = true }, "", ErrTenantRequired, ""},
+		{"invalid", func(c *config.TenancyConfig) {}, "../team-a", ErrInvalidTenant, ""},
+		{"unlisted", func(c *config.TenancyConfig) {}, "team-c", ErrUnknownTenant, ""},
+		{"unlisted allowed", func(c *config.TenancyConfig) { c.AllowUnlisted = true }, "team-c", nil, "team-c"},
+		{"too many", func(c *config.TenancyConfig) { c.MaxTenants = 1 }, "team-a", ErrTooManyTenants, ""},
+		{"disabled", func(c *config.TenancyConfig) { c.Enabled = false }, "team-a", nil, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Storage.Path = filepath.Join(t.TempDir(), "telemorph.db")
+			cfg.Tenancy.Enabled = true
+			cfg.Tenancy.Tenants = []config.TenantConfig{{ID: "team-a"}, {ID: "team-b"}}
+			tt.modify(&cfg.Tenancy)
+			r, err := NewRegistry(cfg)
+			if err != nil {
+				t.Fatalf("NewRegistry: %v", err)
+			}
+			defer r.Close()
+
+			tenant, err := r.Resolve(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resolve(%q) error = %v, want %v", tt.id, err, tt.wantErr)
+			}
+			if err == nil && tenant.ID != tt.wantTenant {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.id, tenant.ID, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Storage.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	cfg.Storage.RetentionDays = 30
+	cfg.Tenancy.Enabled = true
+	cfg.Tenancy.Tenants = []config.TenantConfig{{ID: "team-a", RetentionDays: 7}, {ID: "team-b"}}
+	r, err := NewRegistry(cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	defer r.Close()
+
+	teamA, err := r.Resolve("team-a")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	teamB, err := r.Resolve("team-b")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if err := teamA.Storage.InsertLog(&storage.Log{Timestamp: time.Now(), ServiceName: "checkout", Message: "team-a only"}); err != nil {
+		t.Fatalf("InsertLog: %v", err)
+	}
+
+	for _, tenant := range []*Tenant{r.Default(), teamB} {
+		logs, err := tenant.Storage.GetLogs(10, 0)
+		if err != nil || len(logs) != 0 {
+			t.Errorf("tenant %s logs = %d, %v; want none", tenant.ID, len(logs), err)
+		}
+	}
+	if logs, err := teamA.Storage.GetLogs(10, 0); err != nil || len(logs) != 1 {
+		t.Errorf("tenant team-a logs = %d, %v; want 1", len(logs), err)
+	}
+
+	// Opening a tenant again returns the same one
+	again, err := r.Resolve("team-a")
+	if err != nil || again != teamA {
+		t.Errorf("second Resolve = %p, %v; want %p", again, err, teamA)
+	}
+
+	want := map[string]int{"default": 30, "team-a": 7, "team-b": 30}
+	tenants := r.Tenants()
+	if len(tenants) != len(want) {
+		t.Fatalf("Tenants = %d, want %d", len(tenants), len(want))
+	}
+	for _, status := range tenants {
+		if status.RetentionDays != want[status.ID] {
+			t.Errorf("tenant %s retention = %d, want %d", status.ID, status.RetentionDays, want[status.ID])
+		}
+		if status.Default != (status.ID == "default") || status.Listed == (status.ID == "default") {
+			t.Errorf("tenant %s status = %+v", status.ID, status)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+var ErrInvalidTLSConfig = errors.New("invalid TLS config")
+
+// Reloader provides the TLS configuration of a listener. Its certificate and
+// client CAs are read again when their files change; a failed reload keeps
+// the previous ones in use.
+type Reloader struct {
+	name       string // listener, for logs
+	config     config.TLSConfig
+	logger     *zap.Logger
+	clientAuth tls.ClientAuthType
+	minVersion uint16
+
+	mu       sync.RWMutex
+	current  *tls.Config
+	modTimes map[string]time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewReloader validates cfg and loads the listener's certificate
+func NewReloader(name string, cfg config.TLSConfig) (*Reloader, error) {
+	r := &Reloader{
+		name:     name,
+		config:   cfg,
+		logger:   logger.Get(),
+		modTimes: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("%w for %s: cert_file and key_file are required", ErrInvalidTLSConfig, name)
+	}
+	if cfg.ReloadInterval < 0 {
+		return nil, fmt.Errorf("%w for %s: reload_interval must not be negative", ErrInvalidTLSConfig, name)
+	}
+
+	switch cfg.MinVersion {
+	case "", "1.2":
+		r.minVersion = tls.VersionTLS12
+	case "1.3":
+		r.minVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("%w for %s: unknown min_version %q (want 1.2 or 1.3)", ErrInvalidTLSConfig, name, cfg.MinVersion)
+	}
+
+	switch cfg.ClientAuth {
+	case "":
+		r.clientAuth = tls.NoClientCert
+		if cfg.ClientCAFile != "" {
+			r.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	case "none":
+		r.clientAuth = tls.NoClientCert
+	case "optional":
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("%w for %s: unknown client_auth %q (want none, optional or require)", ErrInvalidTLSConfig, name, cfg.ClientAuth)
+	}
+	if r.clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("%w for %s: client_auth %q needs a client_ca_file", ErrInvalidTLSConfig, name, cfg.ClientAuth)
+	}
+
+	if err := r.load(); err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrInvalidTLSConfig, name, err)
+	}
+	return r, nil
+}
+
+// files lists the files the configuration is read from
+func (r *Reloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the certificate and client CAs and makes them current
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	current := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+		MinVersion:   r.minVersion,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		current.ClientCAs = x509.NewCertPool()
+		if !current.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.current = current
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	r.logger.Info("Loaded TLS certificate",
+		zap.String("listener", r.name),
+		zap.String("subject", cert.Leaf.Subject.String()),
+		zap.Time("not_after", cert.Leaf.NotAfter),
+		zap.Bool("client_auth", r.clientAuth != tls.NoClientCert),
+	)
+	return nil
+}
+
+// changed reports whether any of the files was modified since the last load
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start checks the files for changes every reload interval. Without an
+// interval the files are not checked again.
+func (r *Reloader) Start() {
+	if r.config.ReloadInterval == 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.config.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.load(); err != nil {
+					r.logger.Error("Failed to reload TLS certificate, keeping the current one",
+						zap.String("listener", r.name),
+						zap.Error(err),
+					)
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reloader) Stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+// Config returns the listener's TLS configuration. Each handshake uses the
+// certificate and client CAs current at the time.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.current, nil
+		},
+	}
+}
@@ -0,0 +1,257 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	tls  tls.Certificate
+}
+
+// newTestCert issues a certificate for cn, signed by parent or self-signed
+// when parent is nil
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		tls:  tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert},
+	}
+}
+
+// write stores the certificate and key in dir as name.crt and name.key. The
+// modification time is set to mod so that rewrites are seen as changes.
+func (c *testCert) write(t *testing.T, dir, name string, mod time.Time) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writeFile(t, certFile, c.pem, mod)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), mod)
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served returns the common name of the certificate the reloader serves
+func served(t *testing.T, r *Reloader) string {
+	t.Helper()
+	cfg, err := r.Config().GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Certificates[0].Leaf.Subject.CommonName
+}
+
+func TestNewReloaderValidates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).write(t, dir, "server", time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+	emptyFile := filepath.Join(dir, "empty.crt")
+	writeFile(t, emptyFile, nil, time.Now())
+
+	valid := config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", ReloadInterval: time.Minute}
+	tests := []struct {
+		name    string
+		modify  func(c *config.TLSConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *config.TLSConfig) {}, false},
+		{"no reload", func(c *config.TLSConfig) { c.ReloadInterval = 0 }, false},
+		{"negative reload", func(c *config.TLSConfig) { c.ReloadInterval = -time.Second }, true},
+		{"no certificate", func(c *config.TLSConfig) { c.CertFile = "" }, true},
+		{"no key", func(c *config.TLSConfig) { c.KeyFile = "" }, true},
+		{"missing certificate", func(c *config.TLSConfig) { c.CertFile = filepath.Join(dir, "missing.crt") }, true},
+		{"mismatched key", func(c *config.TLSConfig) { c.CertFile = caFile }, true},
+		{"tls 1.3", func(c *config.TLSConfig) { c.MinVersion = "1.3" }, false},
+		{"unknown version", func(c *config.TLSConfig) { c.MinVersion = "1.1" }, true},
+		{"client CA", func(c *config.TLSConfig) { c.ClientCAFile = caFile }, false},
+		{"optional client certificate", func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = caFile, "optional" }, false},
+		{"client auth without CA", func(c *config.TLSConfig) { c.ClientAuth = "require" }, true},
+		{"unknown client auth", func(c *config.TLSConfig) { c.ClientCAFile, c.ClientAuth = caFile, "always" }, true},
+		{"empty client CA", func(c *config.TLSConfig) { c.ClientCAFile = emptyFile }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			r, err := NewReloader("test", cfg)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTLSConfig) {
+					t.Errorf("NewReloader error = %v, want %v", err, ErrInvalidTLSConfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewReloader: %v", err)
+			}
+			r.Start()
+			r.Stop()
+		})
+	}
+}
+
+func TestReloaderReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	start := time.Now().Add(-time.Minute)
+	certFile, keyFile := newTestCert(t, "first", ca).write(t, dir, "server", start)
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"polling", 10 * time.Millisecond},
+		{"without polling", 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestCert(t, "first", ca).write(t, dir, "server", start)
+			r, err := NewReloader("test", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: tt.interval})
+			if err != nil {
+				t.Fatalf("NewReloader: %v", err)
+			}
+			r.Start()
+			defer r.Stop()
+			if got := served(t, r); got != "first" {
+				t.Fatalf("served %q, want first", got)
+			}
+
+			newTestCert(t, "second", ca).write(t, dir, "server", start.Add(time.Duration(i+1)*time.Second))
+			want := "second"
+			if tt.interval == 0 {
+				want = "first"
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for served(t, r) != want && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if tt.interval == 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if got := served(t, r); got != want {
+				t.Fatalf("served %q after the files changed, want %q", got, want)
+			}
+
+			// A broken certificate is not loaded and the current one stays
+			writeFile(t, certFile, []byte("not a certificate"), start.Add(time.Hour))
+			time.Sleep(50 * time.Millisecond)
+			if got := served(t, r); got != want {
+				t.Errorf("served %q after a failed reload, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReloaderRequiresClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	certFile, keyFile := newTestCert(t, "localhost", ca).write(t, dir, "server", time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	r, err := NewReloader("test", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name    string
+		client  *testCert
+		wantErr bool
+	}{
+		{"signed by the client CA", newTestCert(t, "client", ca), false},
+		{"no certificate", nil, true},
+		{"other CA", newTestCert(t, "client", newTestCert(t, "other-ca", nil)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.client != nil {
+				clientCfg.Certificates = []tls.Certificate{tt.client.tls}
+			}
+
+			serverConn, clientConn := net.Pipe()
+			errc := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				errc <- tls.Server(serverConn, r.Config()).Handshake()
+			}()
+			// The client may finish its side of a TLS 1.3 handshake before
+			// the server rejects its certificate, so the server's result
+			// decides. Closing the client unblocks the server's alert.
+			tls.Client(clientConn, clientCfg).Handshake()
+			clientConn.Close()
+			if err := <-errc; (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Record is the span, log or metric datapoint a statement runs on. Fields
+// holds the context's plain fields by path, such as "name" or "body".
+// Resource is the record's own copy of its resource attributes.
+type Record struct {
+	Fields     map[string]interface{}
+	Attributes map[string]interface{}
+	Resource   map[string]interface{}
+	Dropped    bool
+}
+
+// context lists the plain fields of a record kind
+type context struct {
+	name   string
+	fields map[string]bool
+}
+
+var (
+	spanContext = &context{name: "span", fields: map[string]bool{
+		"name": true, "kind": true, "status.code": true,
+		"trace_id": true, "span_id": true, "parent_span_id": true,
+	}}
+	logContext = &context{name: "log", fields: map[string]bool{
+		"body": true, "severity_text": true, "trace_id": true, "span_id": true,
+	}}
+	datapointContext = &context{name: "datapoint", fields: map[string]bool{
+		"metric.name": true, "value": true,
+	}}
+)
+
+// statement is a parsed transformation statement
+type statement struct {
+	source string
+	editor *editor
+	args   []expr
+	where  condition
+
+	applied atomic.Int64
+	errors  atomic.Int64
+}
+
+// run applies the statement to r if its condition holds
+func (st *statement) run(r *Record) error {
+	if st.where != nil {
+		ok, err := st.where.test(r)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if err := st.editor.fn(r, st.args); err != nil {
+		return err
+	}
+	st.applied.Add(1)
+	return nil
+}
+
+type expr interface {
+	eval(r *Record) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(*Record) (interface{}, error) {
+	return l.value, nil
+}
+
+type listExpr []expr
+
+func (l listExpr) eval(r *Record) (interface{}, error) {
+	items := make([]interface{}, 0, len(l))
+	for _, item := range l {
+		v, err := item.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// pathExpr is a record field, or an attribute map or one of its keys
+type pathExpr struct {
+	name  string
+	isMap bool
+	key   *string
+}
+
+func (p pathExpr) attrs(r *Record) map[string]interface{} {
+	if p.name == "resource.attributes" {
+		return r.Resource
+	}
+	return r.Attributes
+}
+
+func (p pathExpr) eval(r *Record) (interface{}, error) {
+	if !p.isMap {
+		return r.Fields[p.name], nil
+	}
+	if p.key == nil {
+		return p.attrs(r), nil
+	}
+	return p.attrs(r)[*p.key], nil
+}
+
+// set assigns the value at the path. Setting nil is a no-op, and whole maps
+// can only be replaced by maps.
+func (p pathExpr) set(r *Record, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	switch {
+	case !p.isMap:
+		r.Fields[p.name] = value
+	case p.key != nil:
+		p.attrs(r)[*p.key] = value
+	default:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set %s to %T", p.name, value)
+		}
+		attrs := p.attrs(r)
+		for key := range attrs {
+			delete(attrs, key)
+		}
+		for key, v := range m {
+			attrs[key] = v
+		}
+	}
+	return nil
+}
+
+type callExpr struct {
+	name string
+	fn   *converter
+	args []expr
+}
+
+func (c callExpr) eval(r *Record) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := c.fn.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return v, nil
+}
+
+type condition interface {
+	test(r *Record) (bool, error)
+}
+
+type andCond struct{ left, right condition }
+
+func (c andCond) test(r *Record) (bool, error) {
+	ok, err := c.left.test(r)
+	if err != nil || !ok {
+		return false, err
+	}
+	return c.right.test(r)
+}
+
+type orCond struct{ left, right condition }
+
+func (c orCond) test(r *Record) (bool, error) {
+	ok, err := c.left.test(r)
+	if err != nil || ok {
+		return ok, err
+	}
+	return c.right.test(r)
+}
+
+type notCond struct{ c condition }
+
+func (c notCond) test(r *Record) (bool, error) {
+	ok, err := c.c.test(r)
+	return !ok, err
+}
+
+// truthCond is a value used as a condition, which must be a boolean
+type truthCond struct{ value expr }
+
+func (c truthCond) test(r *Record) (bool, error) {
+	v, err := c.value.eval(r)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is %T, not a boolean", v)
+	}
+	return b, nil
+}
+
+type compareCond struct {
+	op          string
+	left, right expr
+}
+
+// test compares two values. Numbers compare numerically and strings
+// lexically; values of different types are only ever unequal.
+func (c compareCond) test(r *Record) (bool, error) {
+	left, err := c.left.eval(r)
+	if err != nil {
+		return false, err
+	}
+	right, err := c.right.eval(r)
+	if err != nil {
+		return false, err
+	}
+
+	cmp, comparable := compare(left, right)
+	switch c.op {
+	case "==":
+		return comparable && cmp == 0, nil
+	case "!=":
+		return !comparable || cmp != 0, nil
+	}
+	if !comparable {
+		return false, nil
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, errors.New("unknown operator " + c.op)
+}
+
+func compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		y, ok := b.(bool)
+		if !ok || x != y {
+			return 1, ok
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,374 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// editor changes a record. check validates the arguments when the statement
+// is parsed, and may replace literal patterns with compiled ones.
+type editor struct {
+	check func(args []expr) error
+	fn    func(r *Record, args []expr) error
+}
+
+var editors = map[string]*editor{
+	// set(target, value)
+	"set": {
+		check: func(args []expr) error {
+			if len(args) != 2 {
+				return errors.New("takes a target and a value")
+			}
+			_, ok := args[0].(pathExpr)
+			if !ok {
+				return errors.New("target must be a path")
+			}
+			return nil
+		},
+		fn: func(r *Record, args []expr) error {
+			value, err := args[1].eval(r)
+			if err != nil {
+				return err
+			}
+			return args[0].(pathExpr).set(r, value)
+		},
+	},
+	// delete_key(map, key)
+	"delete_key": {
+		check: func(args []expr) error {
+			if len(args) != 2 {
+				return errors.New("takes a map and a key")
+			}
+			return checkMap(args[0])
+		},
+		fn: func(r *Record, args []expr) error {
+			key, err := evalString(r, args[1])
+			if err != nil {
+				return err
+			}
+			delete(args[0].(pathExpr).attrs(r), key)
+			return nil
+		},
+	},
+	// delete_matching_keys(map, pattern)
+	"delete_matching_keys": {
+		check: func(args []expr) error {
+			if len(args) != 2 {
+				return errors.New("takes a map and a pattern")
+			}
+			if err := checkMap(args[0]); err != nil {
+				return err
+			}
+			return compilePattern(args, 1)
+		},
+		fn: func(r *Record, args []expr) error {
+			re := args[1].(patternExpr).re
+			attrs := args[0].(pathExpr).attrs(r)
+			for key := range attrs {
+				if re.MatchString(key) {
+					delete(attrs, key)
+				}
+			}
+			return nil
+		},
+	},
+	// keep_keys(map, [keys])
+	"keep_keys": {
+		check: func(args []expr) error {
+			if len(args) != 2 {
+				return errors.New("takes a map and a list of keys")
+			}
+			return checkMap(args[0])
+		},
+		fn: func(r *Record, args []expr) error {
+			v, err := args[1].eval(r)
+			if err != nil {
+				return err
+			}
+			keys, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("keys must be a list, not %T", v)
+			}
+			keep := make(map[string]bool, len(keys))
+			for _, key := range keys {
+				keep[stringify(key)] = true
+			}
+			attrs := args[0].(pathExpr).attrs(r)
+			for key := range attrs {
+				if !keep[key] {
+					delete(attrs, key)
+				}
+			}
+			return nil
+		},
+	},
+	// merge_maps(target, source, strategy): strategy is insert (new keys
+	// only), update (existing keys only) or upsert
+	"merge_maps": {
+		check: func(args []expr) error {
+			if len(args) != 3 {
+				return errors.New("takes a target map, a source map and a strategy")
+			}
+			if err := checkMap(args[0]); err != nil {
+				return err
+			}
+			strategy, ok := args[2].(literal)
+			switch {
+			case !ok:
+				return errors.New("strategy must be a string")
+			case strategy.value != "insert" && strategy.value != "update" && strategy.value != "upsert":
+				return fmt.Errorf("unknown strategy %v (want insert, update or upsert)", strategy.value)
+			}
+			return nil
+		},
+		fn: func(r *Record, args []expr) error {
+			v, err := args[1].eval(r)
+			if err != nil {
+				return err
+			}
+			source, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("source must be a map, not %T", v)
+			}
+			strategy := args[2].(literal).value
+			target := args[0].(pathExpr).attrs(r)
+			for key, value := range source {
+				_, exists := target[key]
+				if (strategy == "insert" && exists) || (strategy == "update" && !exists) {
+					continue
+				}
+				target[key] = value
+			}
+			return nil
+		},
+	},
+	// replace_pattern(target, pattern, replacement): replacement may
+	// refer to groups as $1
+	"replace_pattern": {
+		check: func(args []expr) error {
+			if len(args) != 3 {
+				return errors.New("takes a target, a pattern and a replacement")
+			}
+			if _, ok := args[0].(pathExpr); !ok {
+				return errors.New("target must be a path")
+			}
+			return compilePattern(args, 1)
+		},
+		fn: func(r *Record, args []expr) error {
+			target := args[0].(pathExpr)
+			v, err := target.eval(r)
+			if err != nil || v == nil {
+				return err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("target is %T, not a string", v)
+			}
+			replacement, err := evalString(r, args[2])
+			if err != nil {
+				return err
+			}
+			return target.set(r, args[1].(patternExpr).re.ReplaceAllString(s, replacement))
+		},
+	},
+	// truncate_all(map, limit)
+	"truncate_all": {
+		check: func(args []expr) error {
+			if len(args) != 2 {
+				return errors.New("takes a map and a limit")
+			}
+			return checkMap(args[0])
+		},
+		fn: func(r *Record, args []expr) error {
+			v, err := args[1].eval(r)
+			if err != nil {
+				return err
+			}
+			limit, ok := toFloat(v)
+			if !ok || limit < 0 {
+				return fmt.Errorf("limit must be a non-negative number, not %v", v)
+			}
+			attrs := args[0].(pathExpr).attrs(r)
+			for key, value := range attrs {
+				if s, ok := value.(string); ok && len(s) > int(limit) {
+					attrs[key] = s[:int(limit)]
+				}
+			}
+			return nil
+		},
+	},
+	// drop() discards the record
+	"drop": {
+		check: func(args []expr) error {
+			if len(args) != 0 {
+				return errors.New("takes no arguments")
+			}
+			return nil
+		},
+		fn: func(r *Record, args []expr) error {
+			r.Dropped = true
+			return nil
+		},
+	},
+}
+
+// converter is a function usable as a value
+type converter struct {
+	minArgs, maxArgs int
+	fn               func(args []interface{}) (interface{}, error)
+}
+
+func (c *converter) arity() string {
+	if c.minArgs == c.maxArgs {
+		return fmt.Sprintf("%d arguments", c.minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", c.minArgs, c.maxArgs)
+}
+
+var converters = map[string]*converter{
+	// ParseJSON(value) parses a JSON object into a map
+	"ParseJSON": {1, 1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("value is %T, not a string", args[0])
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}},
+	// IsMatch(value, pattern) reports whether a string matches a regular
+	// expression. Values other than strings never match.
+	"IsMatch": {2, 2, func(args []interface{}) (interface{}, error) {
+		pattern, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("pattern must be a string")
+		}
+		re, err := cachedPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := args[0].(string)
+		return ok && re.MatchString(s), nil
+	}},
+	// Concat([values], delimiter)
+	"Concat": {2, 2, func(args []interface{}) (interface{}, error) {
+		values, ok := args[0].([]interface{})
+		if !ok {
+			return nil, errors.New("values must be a list")
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = stringify(v)
+		}
+		return strings.Join(parts, stringify(args[1])), nil
+	}},
+	"ToLowerCase": {1, 1, func(args []interface{}) (interface{}, error) {
+		return strings.ToLower(stringify(args[0])), nil
+	}},
+	"ToUpperCase": {1, 1, func(args []interface{}) (interface{}, error) {
+		return strings.ToUpper(stringify(args[0])), nil
+	}},
+	// Int(value) converts a number or numeric string to an integer, nil when
+	// it is neither
+	"Int": {1, 1, func(args []interface{}) (interface{}, error) {
+		if n, ok := toFloat(args[0]); ok {
+			return int64(math.Trunc(n)), nil
+		}
+		if s, ok := args[0].(string); ok {
+			if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return int64(math.Trunc(n)), nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+// patternExpr is a regular expression literal compiled at parse time
+type patternExpr struct {
+	re *regexp.Regexp
+}
+
+func (p patternExpr) eval(*Record) (interface{}, error) {
+	return p.re.String(), nil
+}
+
+// compilePattern replaces the string literal args[i] with its compiled
+// regular expression
+func compilePattern(args []expr, i int) error {
+	lit, ok := args[i].(literal)
+	s, isString := lit.value.(string)
+	if !ok || !isString {
+		return errors.New("pattern must be a string")
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	args[i] = patternExpr{re}
+	return nil
+}
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+// cachedPattern compiles patterns given to IsMatch once
+func cachedPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+func checkMap(arg expr) error {
+	p, ok := arg.(pathExpr)
+	if !ok || !p.isMap || p.key != nil {
+		return errors.New("first argument must be attributes or resource.attributes")
+	}
+	return nil
+}
+
+func evalString(r *Record, arg expr) (string, error) {
+	v, err := arg.eval(r)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, not %T", v)
+	}
+	return s, nil
+}
+
+// stringify renders a value as an attribute string. Maps and lists are
+// encoded as JSON.
+func stringify(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
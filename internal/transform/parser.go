@@ -0,0 +1,361 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct // ( ) [ ] , .
+	tokOp    // == != < <= > >=
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string // unquoted strings
+	pos   int
+}
+
+// lex splits a statement into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i : j+1], value: value, pos: i})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:j], pos: i})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		case strings.ContainsRune("()[],.", rune(c)):
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+		case strings.ContainsRune("=!<>", rune(c)):
+			op := string(c)
+			if i+1 < len(src) && src[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unexpected %q at %d", op, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// parser builds a statement for one context from its tokens:
+//
+//	statement  = editor "(" [args] ")" ["where" condition]
+//	condition  = and {"or" and}
+//	and        = unary {"and" unary}
+//	unary      = "not" unary | "(" condition ")" | value [op value]
+//	value      = string | number | "true" | "false" | "nil" | list | path | Converter "(" [args] ")"
+//	path       = ident {"." ident} ["[" string "]"]
+type parser struct {
+	ctx    *context
+	tokens []token
+	pos    int
+}
+
+func parseStatement(ctx *context, src string) (*statement, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{ctx: ctx, tokens: tokens}
+
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, p.errorf(name, "expected an editor")
+	}
+	ed, ok := editors[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown editor %q", name.text)
+	}
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+	if err := ed.check(args); err != nil {
+		return nil, fmt.Errorf("%s: %w", name.text, err)
+	}
+	st := &statement{source: src, editor: ed, args: args}
+
+	if tok := p.peek(); tok.kind == tokIdent && tok.text == "where" {
+		p.next()
+		if st.where, err = p.condition(); err != nil {
+			return nil, err
+		}
+	}
+	if tok := p.next(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return st, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.next(); tok.text != text || (tok.kind != tokPunct && tok.kind != tokIdent) {
+		return p.errorf(tok, "expected %q", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("at %d: %s", tok.pos, fmt.Sprintf(format, args...))
+}
+
+// args parses a parenthesized argument list
+func (p *parser) args() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	if tok := p.peek(); tok.kind == tokPunct && tok.text == ")" {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		tok := p.next()
+		if tok.kind == tokPunct && tok.text == ")" {
+			return args, nil
+		}
+		if tok.kind != tokPunct || tok.text != "," {
+			return nil, p.errorf(tok, "expected \",\" or \")\"")
+		}
+	}
+}
+
+func (p *parser) condition() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokIdent && tok.text == "or"; tok = p.peek() {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokIdent && tok.text == "and"; tok = p.peek() {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (condition, error) {
+	tok := p.peek()
+	if tok.kind == tokIdent && tok.text == "not" {
+		p.next()
+		c, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{c}, nil
+	}
+	if tok.kind == tokPunct && tok.text == "(" {
+		p.next()
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	left, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); op.kind == tokOp {
+		p.next()
+		right, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return compareCond{op: op.text, left: left, right: right}, nil
+	}
+	return truthCond{left}, nil
+}
+
+func (p *parser) value() (expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literal{tok.value}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid number %q", tok.text)
+		}
+		return literal{n}, nil
+	case tokPunct:
+		if tok.text == "[" {
+			return p.list()
+		}
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "nil":
+			return literal{nil}, nil
+		}
+		if unicode.IsUpper(rune(tok.text[0])) {
+			return p.converter(tok)
+		}
+		return p.path(tok)
+	}
+	return nil, p.errorf(tok, "expected a value")
+}
+
+func (p *parser) list() (expr, error) {
+	var items listExpr
+	if tok := p.peek(); tok.kind == tokPunct && tok.text == "]" {
+		p.next()
+		return items, nil
+	}
+	for {
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		tok := p.next()
+		if tok.kind == tokPunct && tok.text == "]" {
+			return items, nil
+		}
+		if tok.kind != tokPunct || tok.text != "," {
+			return nil, p.errorf(tok, "expected \",\" or \"]\"")
+		}
+	}
+}
+
+func (p *parser) converter(name token) (expr, error) {
+	fn, ok := converters[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown converter %q", name.text)
+	}
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < fn.minArgs || len(args) > fn.maxArgs {
+		return nil, p.errorf(name, "%s takes %s", name.text, fn.arity())
+	}
+	return callExpr{name: name.text, fn: fn, args: args}, nil
+}
+
+func (p *parser) path(first token) (expr, error) {
+	name := first.text
+	for tok := p.peek(); tok.kind == tokPunct && tok.text == "."; tok = p.peek() {
+		p.next()
+		seg := p.next()
+		if seg.kind != tokIdent {
+			return nil, p.errorf(seg, "expected a path segment")
+		}
+		name += "." + seg.text
+	}
+
+	path := pathExpr{name: name}
+	switch name {
+	case "attributes", "resource.attributes":
+		path.isMap = true
+	default:
+		if !p.ctx.fields[name] {
+			return nil, p.errorf(first, "unknown path %q in %s context", name, p.ctx.name)
+		}
+	}
+
+	if tok := p.peek(); tok.kind == tokPunct && tok.text == "[" {
+		if !path.isMap {
+			return nil, p.errorf(tok, "%s cannot be indexed", name)
+		}
+		p.next()
+		key := p.next()
+		if key.kind != tokString {
+			return nil, p.errorf(key, "expected a string key")
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		path.key = &key.value
+	}
+	return path, nil
+}
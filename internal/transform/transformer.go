@@ -0,0 +1,149 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+var ErrInvalidStatement = errors.New("invalid transform statement")
+
+// StatementStatus is a statement with the number of records it changed and
+// the errors it hit since startup
+type StatementStatus struct {
+	Context   string `json:"context"`
+	Statement string `json:"statement"`
+	Applied   int64  `json:"applied"`
+	Errors    int64  `json:"errors"`
+}
+
+// Transformer runs the transformation statements on incoming spans, logs and
+// metric datapoints. A statement that fails is skipped and the rest still
+// run.
+type Transformer struct {
+	enabled    bool
+	spans      []*statement
+	logs       []*statement
+	datapoints []*statement
+	logger     *zap.Logger
+}
+
+// NewTransformer parses the statements of cfg
+func NewTransformer(cfg config.TransformConfig) (*Transformer, error) {
+	t := &Transformer{enabled: cfg.Enabled, logger: logger.Get()}
+
+	var err error
+	if t.spans, err = parseStatements(spanContext, cfg.TraceStatements); err != nil {
+		return nil, err
+	}
+	if t.logs, err = parseStatements(logContext, cfg.LogStatements); err != nil {
+		return nil, err
+	}
+	if t.datapoints, err = parseStatements(datapointContext, cfg.MetricStatements); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseStatements(ctx *context, sources []string) ([]*statement, error) {
+	statements := make([]*statement, 0, len(sources))
+	for _, src := range sources {
+		st, err := parseStatement(ctx, src)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidStatement, src, err)
+		}
+		statements = append(statements, st)
+	}
+	return statements, nil
+}
+
+// Spans reports whether statements run on spans
+func (t *Transformer) Spans() bool {
+	return t.enabled && len(t.spans) > 0
+}
+
+// Logs reports whether statements run on logs
+func (t *Transformer) Logs() bool {
+	return t.enabled && len(t.logs) > 0
+}
+
+// Datapoints reports whether statements run on metric datapoints
+func (t *Transformer) Datapoints() bool {
+	return t.enabled && len(t.datapoints) > 0
+}
+
+func (t *Transformer) Span(r *Record) {
+	t.run(spanContext, t.spans, r)
+}
+
+func (t *Transformer) Log(r *Record) {
+	t.run(logContext, t.logs, r)
+}
+
+func (t *Transformer) Datapoint(r *Record) {
+	t.run(datapointContext, t.datapoints, r)
+}
+
+// Statements lists the statements with their counters
+func (t *Transformer) Statements() []*StatementStatus {
+	list := []*StatementStatus{}
+	for _, group := range []struct {
+		ctx        *context
+		statements []*statement
+	}{{spanContext, t.spans}, {logContext, t.logs}, {datapointContext, t.datapoints}} {
+		for _, st := range group.statements {
+			list = append(list, &StatementStatus{
+				Context:   group.ctx.name,
+				Statement: st.source,
+				Applied:   st.applied.Load(),
+				Errors:    st.errors.Load(),
+			})
+		}
+	}
+	return list
+}
+
+func (t *Transformer) Enabled() bool {
+	return t.enabled
+}
+
+func (t *Transformer) run(ctx *context, statements []*statement, r *Record) {
+	for _, st := range statements {
+		if err := st.run(r); err != nil {
+			st.errors.Add(1)
+			t.logger.Debug("Transform statement failed",
+				zap.Error(err),
+				zap.String("context", ctx.name),
+				zap.String("statement", st.source),
+			)
+			continue
+		}
+		if r.Dropped {
+			return
+		}
+	}
+}
+
+// String renders a transformed value as an attribute or field string
+func String(v interface{}) string {
+	return stringify(v)
+}
+
+// Float reads a transformed numeric value, which may have been set as a
+// numeric string
+func Float(v interface{}) (float64, bool) {
+	if n, ok := toFloat(v); ok {
+		return n, true
+	}
+	if s, ok := v.(string); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,236 @@
+package transform
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"open-telemorph-prime/internal/config"
+)
+
+func TestNewTransformerRejectsInvalidStatements(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+	}{
+		{"empty", ``},
+		{"unknown editor", `rename(attributes["a"], "b")`},
+		{"unknown converter", `set(attributes["a"], Lower(name))`},
+		{"converter arity", `set(attributes["a"], ToLowerCase(name, "x"))`},
+		{"editor arity", `set(attributes["a"])`},
+		{"target not a path", `set("a", "b")`},
+		{"unknown path", `set(attributes["a"], body)`},
+		{"field indexed", `set(attributes["a"], name["x"])`},
+		{"invalid pattern", `delete_matching_keys(attributes, "(")`},
+		{"unknown strategy", `merge_maps(attributes, resource.attributes, "replace")`},
+		{"unterminated string", `set(attributes["a"], "b)`},
+		{"unexpected character", `set(attributes["a"], name) where name ~ "x"`},
+		{"trailing tokens", `drop() drop()`},
+		{"missing condition", `drop() where`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTransformer(config.TransformConfig{
+				Enabled:         true,
+				TraceStatements: []string{tt.statement},
+			})
+			if !errors.Is(err, ErrInvalidStatement) {
+				t.Errorf("NewTransformer(%q) error = %v, want %v", tt.statement, err, ErrInvalidStatement)
+			}
+		})
+	}
+}
+
+func TestNewTransformerChecksPathsPerContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.TransformConfig
+		wantErr bool
+	}{
+		{"span name", config.TransformConfig{TraceStatements: []string{`drop() where name == "GET /health"`}}, false},
+		{"log body", config.TransformConfig{LogStatements: []string{`drop() where body == ""`}}, false},
+		{"datapoint value", config.TransformConfig{MetricStatements: []string{`drop() where value < 0`}}, false},
+		{"body on a span", config.TransformConfig{TraceStatements: []string{`drop() where body == ""`}}, true},
+		{"span name on a log", config.TransformConfig{LogStatements: []string{`drop() where name == ""`}}, true},
+		{"value on a span", config.TransformConfig{TraceStatements: []string{`drop() where value < 0`}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTransformer(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTransformer error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformerSpan(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		fields    map[string]interface{}
+		attrs     map[string]interface{}
+		want      map[string]interface{}
+		dropped   bool
+	}{
+		{
+			name:      "set attribute",
+			statement: `set(attributes["env"], "prod")`,
+			attrs:     map[string]interface{}{},
+			want:      map[string]interface{}{"env": "prod"},
+		},
+		{
+			name:      "set from converter",
+			statement: `set(attributes["route"], ToLowerCase(name))`,
+			fields:    map[string]interface{}{"name": "GET /Users"},
+			attrs:     map[string]interface{}{},
+			want:      map[string]interface{}{"route": "get /users"},
+		},
+		{
+			name:      "condition holds",
+			statement: `delete_key(attributes, "token") where attributes["http.status"] >= 500`,
+			attrs:     map[string]interface{}{"token": "x", "http.status": int64(503)},
+			want:      map[string]interface{}{"http.status": int64(503)},
+		},
+		{
+			name:      "condition fails",
+			statement: `delete_key(attributes, "token") where attributes["http.status"] >= 500`,
+			attrs:     map[string]interface{}{"token": "x", "http.status": int64(200)},
+			want:      map[string]interface{}{"token": "x", "http.status": int64(200)},
+		},
+		{
+			name:      "different types are unequal",
+			statement: `delete_key(attributes, "token") where attributes["http.status"] == "200"`,
+			attrs:     map[string]interface{}{"token": "x", "http.status": int64(200)},
+			want:      map[string]interface{}{"token": "x", "http.status": int64(200)},
+		},
+		{
+			name:      "delete matching keys",
+			statement: `delete_matching_keys(attributes, "^secret\\.")`,
+			attrs:     map[string]interface{}{"secret.a": "1", "secret.b": "2", "user": "u"},
+			want:      map[string]interface{}{"user": "u"},
+		},
+		{
+			name:      "keep keys",
+			statement: `keep_keys(attributes, ["a", "c"])`,
+			attrs:     map[string]interface{}{"a": "1", "b": "2", "c": "3"},
+			want:      map[string]interface{}{"a": "1", "c": "3"},
+		},
+		{
+			name:      "replace pattern",
+			statement: `replace_pattern(attributes["url"], "token=\\w+", "token=***")`,
+			attrs:     map[string]interface{}{"url": "/cb?token=abc&x=1"},
+			want:      map[string]interface{}{"url": "/cb?token=***&x=1"},
+		},
+		{
+			name:      "truncate",
+			statement: `truncate_all(attributes, 3)`,
+			attrs:     map[string]interface{}{"a": "abcdef", "n": int64(123456)},
+			want:      map[string]interface{}{"a": "abc", "n": int64(123456)},
+		},
+		{
+			name:      "drop",
+			statement: `drop() where name == "GET /health" and not (kind == "client")`,
+			fields:    map[string]interface{}{"name": "GET /health", "kind": "server"},
+			attrs:     map[string]interface{}{},
+			want:      map[string]interface{}{},
+			dropped:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTransformer(config.TransformConfig{
+				Enabled:         true,
+				TraceStatements: []string{tt.statement},
+			})
+			if err != nil {
+				t.Fatalf("NewTransformer: %v", err)
+			}
+			fields := tt.fields
+			if fields == nil {
+				fields = map[string]interface{}{}
+			}
+			r := &Record{Fields: fields, Attributes: tt.attrs, Resource: map[string]interface{}{}}
+			tr.Span(r)
+
+			if !reflect.DeepEqual(r.Attributes, tt.want) {
+				t.Errorf("attributes = %v, want %v", r.Attributes, tt.want)
+			}
+			if r.Dropped != tt.dropped {
+				t.Errorf("dropped = %v, want %v", r.Dropped, tt.dropped)
+			}
+			if status := tr.Statements()[0]; status.Errors != 0 {
+				t.Errorf("errors = %d, want 0", status.Errors)
+			}
+		})
+	}
+}
+
+func TestTransformerCountsRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		body      interface{}
+		attrs     map[string]interface{}
+	}{
+		{"condition not a boolean", `drop() where attributes["flag"]`, "", map[string]interface{}{"flag": "yes"}},
+		{"invalid JSON", `merge_maps(attributes, ParseJSON(body), "upsert")`, "{not json", map[string]interface{}{}},
+		{"parse a non-string", `merge_maps(attributes, ParseJSON(body), "upsert")`, 42.0, map[string]interface{}{}},
+		{"source not a map", `merge_maps(attributes, body, "upsert")`, "text", map[string]interface{}{}},
+		{"replace a non-string", `replace_pattern(attributes["n"], "1", "2")`, "", map[string]interface{}{"n": int64(1)}},
+		{"negative limit", `truncate_all(attributes, -1)`, "", map[string]interface{}{}},
+		{"keys not a list", `keep_keys(attributes, body)`, "a", map[string]interface{}{}},
+		{"replace a map with a string", `set(attributes, body)`, "text", map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTransformer(config.TransformConfig{
+				Enabled:       true,
+				LogStatements: []string{tt.statement, `set(attributes["after"], true)`},
+			})
+			if err != nil {
+				t.Fatalf("NewTransformer: %v", err)
+			}
+			r := &Record{
+				Fields:     map[string]interface{}{"body": tt.body},
+				Attributes: tt.attrs,
+				Resource:   map[string]interface{}{},
+			}
+			tr.Log(r)
+
+			statuses := tr.Statements()
+			if statuses[0].Errors != 1 || statuses[0].Applied != 0 {
+				t.Errorf("failing statement applied = %d, errors = %d; want 0, 1", statuses[0].Applied, statuses[0].Errors)
+			}
+			// A failed statement is skipped and the rest still run
+			if r.Attributes["after"] != true || statuses[1].Applied != 1 {
+				t.Errorf("following statement did not run: %v", r.Attributes)
+			}
+			if r.Dropped {
+				t.Error("record dropped by a failing statement")
+			}
+		})
+	}
+}
+
+func TestTransformerStopsAfterDrop(t *testing.T) {
+	tr, err := NewTransformer(config.TransformConfig{
+		Enabled:          true,
+		MetricStatements: []string{`drop() where value < 0`, `set(attributes["seen"], true)`},
+	})
+	if err != nil {
+		t.Fatalf("NewTransformer: %v", err)
+	}
+	r := &Record{
+		Fields:     map[string]interface{}{"metric.name": "queue.depth", "value": -1.0},
+		Attributes: map[string]interface{}{},
+		Resource:   map[string]interface{}{},
+	}
+	tr.Datapoint(r)
+	if !r.Dropped {
+		t.Fatal("datapoint not dropped")
+	}
+	if _, ok := r.Attributes["seen"]; ok {
+		t.Error("statement ran after the record was dropped")
+	}
+}
@@ -0,0 +1,816 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles, each allowed everything the previous one is
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Sign-in methods of a principal
+const (
+	MethodPassword = "password"
+	MethodOIDC     = "oidc"
+	MethodToken    = "token"
+)
+
+var (
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidUser        = errors.New("invalid user")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserReadOnly       = errors.New("user is defined in the config file")
+	ErrLastAdmin          = errors.New("cannot remove the last admin")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrTokenForbidden     = errors.New("API tokens can only be managed by local users signed in with a password")
+	ErrNoRole             = errors.New("no role is granted to this user")
+	ErrOIDCDisabled       = errors.New("OIDC login is not enabled")
+	ErrOIDCBusy           = errors.New("too many OIDC logins in progress, try again later")
+)
+
+// username restricts usernames to characters that are safe in URLs
+var username = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+
+// minPasswordLength is the shortest password accepted for new users
+const minPasswordLength = 8
+
+// tokenPrefix marks API tokens, telling them apart from session tokens
+const tokenPrefix = "otpu_"
+
+// dummyHash is compared against when a username is unknown, so failed logins
+// take as long whether or not the user exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("open-telemorph-prime"), bcrypt.DefaultCost)
+
+type user struct {
+	storage.User
+	source string
+}
+
+// AllTenants in a user's tenants allows every tenant
+const AllTenants = "*"
+
+// UserStatus describes a user without its password hash
+type UserStatus struct {
+	Username  string     `json:"username"`
+	Role      string     `json:"role"`
+	Tenants   []string   `json:"tenants,omitempty"`
+	Source    string     `json:"source"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+}
+
+// TokenStatus describes an API token without its hash
+type TokenStatus struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Tenants   []string   `json:"tenants,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Principal is the user a request acts as. With tenancy enabled it may only
+// query Tenants, or the default tenant when there are none.
+type Principal struct {
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Tenants  []string `json:"tenants,omitempty"`
+	Method   string   `json:"method"`
+}
+
+// Allows reports whether the principal has at least the given role
+func (p *Principal) Allows(role string) bool {
+	return roleRank[p.Role] >= roleRank[role]
+}
+
+type session struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+// Manager holds the users, their sessions and API tokens, and signs users in
+// with passwords or through OIDC. Sessions are kept in memory, so a restart
+// signs everyone out.
+type Manager struct {
+	storage storage.Storage
+	config  config.AuthConfig
+	oidc    *oidcProvider
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	users    map[string]*user
+	tokens   map[string]*storage.UserToken // by hash
+	sessions map[string]*session           // by hash
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewManager validates the configured users and loads those created through
+// the API with their tokens
+func NewManager(store storage.Storage, cfg config.AuthConfig) (*Manager, error) {
+	m := &Manager{
+		storage:  store,
+		config:   cfg,
+		logger:   logger.Get(),
+		users:    make(map[string]*user),
+		tokens:   make(map[string]*storage.UserToken),
+		sessions: make(map[string]*session),
+	}
+
+	for _, def := range cfg.Users {
+		if err := validateUser(def.Username, def.Role); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidUser, def.Username, err)
+		}
+		if err := validateTenants(def.Tenants); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidUser, def.Username, err)
+		}
+		if _, err := bcrypt.Cost([]byte(def.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("%w %q: password_hash is not a bcrypt hash", ErrInvalidUser, def.Username)
+		}
+		if _, ok := m.users[def.Username]; ok {
+			return nil, fmt.Errorf("duplicate user %q", def.Username)
+		}
+		m.users[def.Username] = &user{
+			User:   storage.User{Username: def.Username, PasswordHash: def.PasswordHash, Role: def.Role, Tenants: def.Tenants},
+			source: "config",
+		}
+	}
+
+	stored, err := store.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	for _, u := range stored {
+		if _, ok := m.users[u.Username]; ok {
+			m.logger.Warn("Ignoring stored user shadowed by the config file", zap.String("username", u.Username))
+			continue
+		}
+		m.users[u.Username] = &user{User: *u, source: "api"}
+	}
+
+	tokens, err := store.GetUserTokens()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user tokens: %w", err)
+	}
+	for _, t := range tokens {
+		m.tokens[t.Hash] = t
+	}
+
+	if cfg.OIDC.Enabled {
+		if m.oidc, err = newOIDCProvider(cfg.OIDC); err != nil {
+			return nil, fmt.Errorf("invalid OIDC config: %w", err)
+		}
+	}
+	if cfg.Enabled && len(m.users) == 0 && m.oidc == nil {
+		return nil, errors.New("auth is enabled but no users are configured and OIDC is disabled")
+	}
+	return m, nil
+}
+
+func validateUser(name, role string) error {
+	if !username.MatchString(name) {
+		return errors.New("username must be 1-64 letters, digits, '_', '.', '@' or '-'")
+	}
+	if _, ok := roleRank[role]; !ok {
+		return fmt.Errorf("unknown role %q (want viewer, editor or admin)", role)
+	}
+	return nil
+}
+
+func validateTenants(tenants []string) error {
+	for _, id := range tenants {
+		if id != AllTenants && !tenancy.ValidID(id) {
+			return fmt.Errorf("invalid tenant %q", id)
+		}
+	}
+	return nil
+}
+
+// permitsTenant reports whether tenants include id, or all tenants
+func permitsTenant(tenants []string, id string) bool {
+	for _, t := range tenants {
+		if t == id || t == AllTenants {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword returns the bcrypt hash of a password, as expected in
+// auth.users
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Start prunes expired sessions and pending OIDC logins in the background
+func (m *Manager) Start() {
+	m.done = make(chan struct{})
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.prune(time.Now())
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) Stop() {
+	if m.done == nil {
+		return
+	}
+	close(m.done)
+	m.wg.Wait()
+}
+
+func (m *Manager) prune(now time.Time) {
+	m.mu.Lock()
+	for hash, s := range m.sessions {
+		if now.After(s.expiresAt) {
+			delete(m.sessions, hash)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.oidc != nil {
+		m.oidc.prune(now)
+	}
+}
+
+// Enabled reports whether users must sign in
+func (m *Manager) Enabled() bool {
+	return m.config.Enabled
+}
+
+// OIDCEnabled reports whether users may sign in through OIDC
+func (m *Manager) OIDCEnabled() bool {
+	return m.oidc != nil
+}
+
+// Login checks a user's password and opens a session, returning its token
+func (m *Manager) Login(name, password string) (*Principal, string, time.Time, error) {
+	m.mu.Lock()
+	u, ok := m.users[name]
+	var hash []byte
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, "", time.Time{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, "", time.Time{}, ErrInvalidCredentials
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	u.LastLogin = &now
+	if u.source == "api" {
+		copied := u.User
+		if err := m.storage.SaveUser(&copied); err != nil {
+			m.logger.Warn("Failed to save last login", zap.String("username", name), zap.Error(err))
+		}
+	}
+	principal := Principal{Username: u.Username, Role: u.Role, Tenants: u.Tenants, Method: MethodPassword}
+	m.mu.Unlock()
+
+	token, expiresAt, err := m.newSession(principal)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	m.logger.Info("User logged in", zap.String("username", name))
+	return &principal, token, expiresAt, nil
+}
+
+// OIDCLoginURL starts an OIDC login, returning the provider URL to send the
+// browser to. next is where the browser returns after signing in.
+func (m *Manager) OIDCLoginURL(ctx context.Context, next string) (string, error) {
+	if m.oidc == nil {
+		return "", ErrOIDCDisabled
+	}
+	return m.oidc.authURL(ctx, next)
+}
+
+// OIDCCallback completes an OIDC login and opens a session with the role
+// mapped from the user's claims
+func (m *Manager) OIDCCallback(ctx context.Context, state, code string) (*Principal, string, time.Time, string, error) {
+	if m.oidc == nil {
+		return nil, "", time.Time{}, "", ErrOIDCDisabled
+	}
+	claims, next, err := m.oidc.exchange(ctx, state, code)
+	if err != nil {
+		return nil, "", time.Time{}, "", err
+	}
+
+	name := claimString(claims, m.config.OIDC.UsernameClaim)
+	for _, fallback := range []string{"email", "sub"} {
+		if name == "" {
+			name = claimString(claims, fallback)
+		}
+	}
+	role := m.oidcRole(claims)
+	if role == "" {
+		m.logger.Warn("Refused OIDC login without a role", zap.String("username", name))
+		return nil, "", time.Time{}, "", ErrNoRole
+	}
+
+	principal := Principal{Username: name, Role: role, Tenants: m.oidcTenants(claims), Method: MethodOIDC}
+	token, expiresAt, err := m.newSession(principal)
+	if err != nil {
+		return nil, "", time.Time{}, "", err
+	}
+	m.logger.Info("User logged in through OIDC", zap.String("username", name), zap.String("role", role))
+	return &principal, token, expiresAt, next, nil
+}
+
+// oidcRole returns the highest role mapped from the roles claim, which may
+// be a string or a list, falling back to the default role
+func (m *Manager) oidcRole(claims map[string]interface{}) string {
+	var values []string
+	switch v := claims[m.config.OIDC.RolesClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := m.config.OIDC.DefaultRole
+	for _, value := range values {
+		if role := m.config.OIDC.RoleMapping[value]; roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// oidcTenants returns the default tenants with the valid tenant IDs of the
+// tenants claim, which may be a string or a list. The claim cannot grant
+// every tenant.
+func (m *Manager) oidcTenants(claims map[string]interface{}) []string {
+	tenants := append([]string(nil), m.config.OIDC.DefaultTenants...)
+	if m.config.OIDC.TenantsClaim == "" {
+		return tenants
+	}
+	var values []interface{}
+	switch v := claims[m.config.OIDC.TenantsClaim].(type) {
+	case string:
+		values = []interface{}{v}
+	case []interface{}:
+		values = v
+	}
+	for _, value := range values {
+		if id, ok := value.(string); ok && tenancy.ValidID(id) {
+			tenants = append(tenants, id)
+		}
+	}
+	return tenants
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func (m *Manager) newSession(principal Principal) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(m.config.SessionTTL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[hashToken(token)] = &session{principal: principal, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// Logout ends the session of a token. API tokens are left alone.
+func (m *Manager) Logout(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, hashToken(token))
+}
+
+// Authenticate returns the principal of a session or API token. Sessions of
+// local users and API tokens take the user's current role and tenants; a
+// token limited to some tenants keeps only those the user still has.
+func (m *Manager) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	hash := hashToken(token)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[hash]; ok {
+		if now.After(s.expiresAt) {
+			delete(m.sessions, hash)
+			return nil, ErrUnauthenticated
+		}
+		principal := s.principal
+		if principal.Method == MethodPassword {
+			u, ok := m.users[principal.Username]
+			if !ok {
+				delete(m.sessions, hash)
+				return nil, ErrUnauthenticated
+			}
+			principal.Role = u.Role
+			principal.Tenants = u.Tenants
+		}
+		return &principal, nil
+	}
+
+	if t, ok := m.tokens[hash]; ok && strings.HasPrefix(token, tokenPrefix) {
+		if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+			return nil, ErrUnauthenticated
+		}
+		u, ok := m.users[t.Username]
+		if !ok {
+			return nil, ErrUnauthenticated
+		}
+		tenants := u.Tenants
+		if len(t.Tenants) > 0 {
+			tenants = nil
+			for _, id := range t.Tenants {
+				if permitsTenant(u.Tenants, id) {
+					tenants = append(tenants, id)
+				}
+			}
+			// Without tenants the token would fall back to the default
+			// tenant, which it was not limited to
+			if len(tenants) == 0 {
+				return nil, ErrUnauthenticated
+			}
+		}
+		return &Principal{Username: u.Username, Role: u.Role, Tenants: tenants, Method: MethodToken}, nil
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Users lists the users, sorted by name
+func (m *Manager) Users() []*UserStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*UserStatus, 0, len(m.users))
+	for _, u := range m.users {
+		list = append(list, u.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// CreateUser adds a local user with a password
+func (m *Manager) CreateUser(def config.UserConfig) (*UserStatus, error) {
+	if err := validateUser(def.Username, def.Role); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+	if err := validateTenants(def.Tenants); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+	hash, err := HashPassword(def.Password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[def.Username]; ok {
+		return nil, ErrUserExists
+	}
+	now := time.Now()
+	u := &user{
+		User:   storage.User{Username: def.Username, PasswordHash: hash, Role: def.Role, Tenants: def.Tenants, CreatedAt: now, UpdatedAt: now},
+		source: "api",
+	}
+	if err := m.save(u); err != nil {
+		return nil, err
+	}
+	m.users[u.Username] = u
+
+	m.logger.Info("Created user", zap.String("username", u.Username), zap.String("role", u.Role))
+	return u.status(), nil
+}
+
+// UpdateUser changes a user's role, password or tenants; empty fields are
+// kept, while an empty tenant list leaves the user the default tenant
+func (m *Manager) UpdateUser(name string, def config.UserConfig) (*UserStatus, error) {
+	var hash string
+	if def.Password != "" {
+		var err error
+		if hash, err = HashPassword(def.Password); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+		}
+	}
+	if _, ok := roleRank[def.Role]; def.Role != "" && !ok {
+		return nil, fmt.Errorf("%w: unknown role %q (want viewer, editor or admin)", ErrInvalidUser, def.Role)
+	}
+	if err := validateTenants(def.Tenants); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.apiUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if def.Role != "" && def.Role != RoleAdmin && m.lastAdmin(u) {
+		return nil, ErrLastAdmin
+	}
+
+	updated := *u
+	if def.Role != "" {
+		updated.Role = def.Role
+	}
+	if hash != "" {
+		updated.PasswordHash = hash
+	}
+	if def.Tenants != nil {
+		updated.Tenants = def.Tenants
+	}
+	updated.UpdatedAt = time.Now()
+	if err := m.save(&updated); err != nil {
+		return nil, err
+	}
+	*u = updated
+
+	if hash != "" {
+		m.endSessions(name)
+	}
+	m.logger.Info("Updated user", zap.String("username", name), zap.String("role", u.Role))
+	return u.status(), nil
+}
+
+// DeleteUser removes a user with its tokens and sessions
+func (m *Manager) DeleteUser(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.apiUser(name)
+	if err != nil {
+		return err
+	}
+	if m.lastAdmin(u) {
+		return ErrLastAdmin
+	}
+	if err := m.storage.DeleteUser(name); err != nil {
+		return err
+	}
+	delete(m.users, name)
+	for hash, t := range m.tokens {
+		if t.Username == name {
+			delete(m.tokens, hash)
+		}
+	}
+	m.endSessions(name)
+
+	m.logger.Info("Deleted user", zap.String("username", name))
+	return nil
+}
+
+// apiUser returns a user created through the API. The caller must hold mu.
+func (m *Manager) apiUser(name string) (*user, error) {
+	u, ok := m.users[name]
+	switch {
+	case !ok:
+		return nil, ErrUserNotFound
+	case u.source == "config":
+		return nil, ErrUserReadOnly
+	}
+	return u, nil
+}
+
+// lastAdmin reports whether u is the only local admin, whom removing would
+// leave nobody able to manage users. The caller must hold mu.
+func (m *Manager) lastAdmin(u *user) bool {
+	if u.Role != RoleAdmin {
+		return false
+	}
+	for _, other := range m.users {
+		if other != u && other.Role == RoleAdmin {
+			return false
+		}
+	}
+	return true
+}
+
+// endSessions signs a user out everywhere. The caller must hold mu.
+func (m *Manager) endSessions(name string) {
+	for hash, s := range m.sessions {
+		if s.principal.Method == MethodPassword && s.principal.Username == name {
+			delete(m.sessions, hash)
+		}
+	}
+}
+
+// save stores a user. The caller must hold mu.
+func (m *Manager) save(u *user) error {
+	copied := u.User
+	if err := m.storage.SaveUser(&copied); err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+	return nil
+}
+
+// tokenOwner returns the local user whose API tokens a principal may
+// manage. OIDC users may share a local user's name and tokens could mint
+// further tokens, so only password sessions qualify. The caller must hold
+// mu.
+func (m *Manager) tokenOwner(principal *Principal) (*user, error) {
+	if principal.Method != MethodPassword {
+		return nil, ErrTokenForbidden
+	}
+	u, ok := m.users[principal.Username]
+	if !ok {
+		return nil, ErrTokenForbidden
+	}
+	return u, nil
+}
+
+// Tokens lists the API tokens of a principal's user, oldest first
+func (m *Manager) Tokens(principal *Principal) ([]*TokenStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.tokenOwner(principal)
+	if err != nil {
+		return nil, err
+	}
+	list := []*TokenStatus{}
+	for _, t := range m.tokens {
+		if t.Username == u.Username {
+			list = append(list, tokenStatus(t))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// CreateToken adds an API token for the local user of a password session,
+// expiring after ttl unless ttl is zero. A token limited to tenants may only query those of them the
+// user has; otherwise it has all of the user's. The token is not stored and
+// cannot be shown again.
+func (m *Manager) CreateToken(principal *Principal, tokenName string, tenants []string, ttl time.Duration) (*TokenStatus, string, error) {
+	if tokenName == "" || len(tokenName) > 64 {
+		return nil, "", fmt.Errorf("%w: name must have 1-64 characters", ErrInvalidToken)
+	}
+	if ttl < 0 {
+		return nil, "", fmt.Errorf("%w: expires_in must not be negative", ErrInvalidToken)
+	}
+	if err := validateTenants(tenants); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = tokenPrefix + secret
+	id, err := randomID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.tokenOwner(principal)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, tenant := range tenants {
+		if !permitsTenant(u.Tenants, tenant) {
+			return nil, "", fmt.Errorf("%w: user may not query tenant %q", ErrInvalidToken, tenant)
+		}
+	}
+	t := &storage.UserToken{
+		ID:        id,
+		Name:      tokenName,
+		Username:  u.Username,
+		Tenants:   tenants,
+		Hash:      hashToken(secret),
+		Prefix:    secret[:len(tokenPrefix)+6],
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := t.CreatedAt.Add(ttl)
+		t.ExpiresAt = &expiresAt
+	}
+	if err := m.storage.SaveUserToken(t); err != nil {
+		return nil, "", fmt.Errorf("failed to save user token: %w", err)
+	}
+	m.tokens[t.Hash] = t
+
+	m.logger.Info("Created API token", zap.String("username", u.Username), zap.String("token", t.Name))
+	return tokenStatus(t), secret, nil
+}
+
+// DeleteToken revokes one of the API tokens of a principal's user
+func (m *Manager) DeleteToken(principal *Principal, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.tokenOwner(principal)
+	if err != nil {
+		return err
+	}
+	for hash, t := range m.tokens {
+		if t.ID != id || t.Username != u.Username {
+			continue
+		}
+		if err := m.storage.DeleteUserToken(id); err != nil {
+			return err
+		}
+		delete(m.tokens, hash)
+		m.logger.Info("Deleted API token", zap.String("username", u.Username), zap.String("token", t.Name))
+		return nil
+	}
+	return ErrTokenNotFound
+}
+
+func (u *user) status() *UserStatus {
+	status := &UserStatus{Username: u.Username, Role: u.Role, Tenants: u.Tenants, Source: u.source, LastLogin: u.LastLogin}
+	if !u.CreatedAt.IsZero() {
+		createdAt, updatedAt := u.CreatedAt, u.UpdatedAt
+		status.CreatedAt, status.UpdatedAt = &createdAt, &updatedAt
+	}
+	return status
+}
+
+func tokenStatus(t *storage.UserToken) *TokenStatus {
+	return &TokenStatus{ID: t.ID, Name: t.Name, Prefix: t.Prefix, Tenants: t.Tenants, CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
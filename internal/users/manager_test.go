@@ -0,0 +1,261 @@
+package users
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery"
+
+func newTestManager(t *testing.T, defs ...config.UserConfig) *Manager {
+	t.Helper()
+	storageCfg := config.DefaultConfig().Storage
+	storageCfg.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	store, err := storage.New(storageCfg)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig().Auth
+	cfg.Enabled = true
+	for _, def := range defs {
+		def.PasswordHash = string(hash)
+		cfg.Users = append(cfg.Users, def)
+	}
+
+	m, err := NewManager(store, cfg)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m
+}
+
+func TestNewManagerValidatesTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"listed", []string{"team-a", "team_b"}, false},
+		{"all", []string{"*"}, false},
+		{"invalid", []string{"../team-a"}, true},
+		{"empty", []string{""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storageCfg := config.DefaultConfig().Storage
+			storageCfg.Path = filepath.Join(t.TempDir(), "telemorph.db")
+			store, err := storage.New(storageCfg)
+			if err != nil {
+				t.Fatalf("storage.New: %v", err)
+			}
+			defer store.Close()
+
+			cfg := config.DefaultConfig().Auth
+			cfg.Users = []config.UserConfig{{
+				Username:     "alice",
+				PasswordHash: string(dummyHash),
+				Role:         RoleViewer,
+				Tenants:      tt.tenants,
+			}}
+			_, err = NewManager(store, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewManager error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCarriesTenants(t *testing.T) {
+	m := newTestManager(t,
+		config.UserConfig{Username: "alice", Role: RoleAdmin, Tenants: []string{"team-a", "team-b"}},
+		config.UserConfig{Username: "bob", Role: RoleViewer},
+		config.UserConfig{Username: "root", Role: RoleAdmin, Tenants: []string{"*"}},
+	)
+
+	tests := []struct {
+		name        string
+		user        string
+		token       []string
+		wantErr     bool
+		wantTenants []string
+	}{
+		{"user tenants", "alice", nil, false, []string{"team-a", "team-b"}},
+		{"limited token", "alice", []string{"team-b"}, false, []string{"team-b"}},
+		{"token outside user tenants", "alice", []string{"team-c"}, true, nil},
+		{"token for all tenants", "alice", []string{"*"}, true, nil},
+		{"default tenant only", "bob", nil, false, nil},
+		{"token beyond default tenant", "bob", []string{"team-a"}, true, nil},
+		{"all tenants", "root", nil, false, []string{"*"}},
+		{"token within all tenants", "root", []string{"team-c"}, false, []string{"team-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, session, _, err := m.Login(tt.user, testPassword)
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			if !reflect.DeepEqual(principal.Tenants, m.users[tt.user].Tenants) {
+				t.Errorf("login tenants = %v, want %v", principal.Tenants, m.users[tt.user].Tenants)
+			}
+
+			secret := session
+			if tt.token != nil {
+				_, secret, err = m.CreateToken(principal, "ci", tt.token, 0)
+				if tt.wantErr {
+					if !errors.Is(err, ErrInvalidToken) {
+						t.Errorf("CreateToken error = %v, want %v", err, ErrInvalidToken)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("CreateToken: %v", err)
+				}
+			}
+
+			got, err := m.Authenticate(secret)
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if !reflect.DeepEqual(got.Tenants, tt.wantTenants) {
+				t.Errorf("tenants = %v, want %v", got.Tenants, tt.wantTenants)
+			}
+		})
+	}
+}
+
+func TestAuthenticateFollowsTenantChanges(t *testing.T) {
+	m := newTestManager(t, config.UserConfig{Username: "admin", Role: RoleAdmin, Tenants: []string{"*"}})
+	if _, err := m.CreateUser(config.UserConfig{
+		Username: "carol",
+		Password: testPassword,
+		Role:     RoleViewer,
+		Tenants:  []string{"team-a", "team-b"},
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	carol, session, _, err := m.Login("carol", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	_, limited, err := m.CreateToken(carol, "team-a only", []string{"team-a"}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	_, full, err := m.CreateToken(carol, "all of carol's", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	if _, err := m.UpdateUser("carol", config.UserConfig{Tenants: []string{"team-b"}}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	for name, secret := range map[string]string{"session": session, "token": full} {
+		got, err := m.Authenticate(secret)
+		if err != nil {
+			t.Fatalf("%s: Authenticate: %v", name, err)
+		}
+		if !reflect.DeepEqual(got.Tenants, []string{"team-b"}) {
+			t.Errorf("%s: tenants = %v, want [team-b]", name, got.Tenants)
+		}
+	}
+	// The limited token lost its only tenant and must not fall back to the
+	// default tenant
+	if _, err := m.Authenticate(limited); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("limited token error = %v, want %v", err, ErrUnauthenticated)
+	}
+
+	if _, err := m.CreateUser(config.UserConfig{
+		Username: "dave",
+		Password: testPassword,
+		Role:     RoleViewer,
+		Tenants:  []string{"team/a"},
+	}); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("CreateUser with an invalid tenant error = %v, want %v", err, ErrInvalidUser)
+	}
+}
+
+func TestTokensRequirePasswordSession(t *testing.T) {
+	m := newTestManager(t, config.UserConfig{Username: "admin", Role: RoleAdmin, Tenants: []string{"*"}})
+	admin, _, _, err := m.Login("admin", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	_, secret, err := m.CreateToken(admin, "ci", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	tokenPrincipal, err := m.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		wantErr   error
+	}{
+		{"password session", admin, nil},
+		// An OIDC user sharing the local admin's name must not get the
+		// admin's tokens or role
+		{"oidc namesake", &Principal{Username: "admin", Role: RoleViewer, Method: MethodOIDC}, ErrTokenForbidden},
+		{"api token", tokenPrincipal, ErrTokenForbidden},
+		{"unknown user", &Principal{Username: "ghost", Role: RoleAdmin, Method: MethodPassword}, ErrTokenForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := m.CreateToken(tt.principal, "new", nil, 0); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateToken error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := m.Tokens(tt.principal); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Tokens error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if err := m.DeleteToken(tt.principal, "any"); !errors.Is(err, tt.wantErr) {
+					t.Errorf("DeleteToken error = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCTenants(t *testing.T) {
+	tests := []struct {
+		name     string
+		claim    string
+		defaults []string
+		claims   map[string]interface{}
+		want     []string
+	}{
+		{"no claim configured", "", []string{"default"}, map[string]interface{}{"tenants": "team-a"}, []string{"default"}},
+		{"string claim", "tenants", nil, map[string]interface{}{"tenants": "team-a"}, []string{"team-a"}},
+		{"list claim", "tenants", []string{"shared"}, map[string]interface{}{"tenants": []interface{}{"team-a", "team-b"}}, []string{"shared", "team-a", "team-b"}},
+		{"claim cannot grant all", "tenants", nil, map[string]interface{}{"tenants": []interface{}{"*", "../x", 3.0, "team-a"}}, []string{"team-a"}},
+		{"missing claim", "tenants", nil, map[string]interface{}{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{}
+			m.config.OIDC.TenantsClaim = tt.claim
+			m.config.OIDC.DefaultTenants = tt.defaults
+			if got := m.oidcTenants(tt.claims); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("oidcTenants = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
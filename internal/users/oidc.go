@@ -0,0 +1,444 @@
+package users
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+)
+
+// oidcLoginTimeout bounds the time between starting a login and the
+// provider redirecting back
+const oidcLoginTimeout = 10 * time.Minute
+
+// clockSkew is the leeway given to ID token expiry checks
+const clockSkew = time.Minute
+
+// maxPendingLogins bounds the logins started but not completed, which
+// anyone can start
+const maxPendingLogins = 10000
+
+// keyRefreshInterval is the least time between fetches of the provider's
+// signing keys, so ID tokens naming unknown keys cannot flood the provider
+const keyRefreshInterval = time.Minute
+
+// oidcProvider signs users in with the authorization code flow and PKCE.
+// The provider's metadata is discovered on the first login, so startup does
+// not depend on the provider being reachable.
+type oidcProvider struct {
+	config config.OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	pending       map[string]*oidcLogin // by state
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+	nonce     string
+	verifier  string
+	next      string
+	expiresAt time.Time
+}
+
+func newOIDCProvider(cfg config.OIDCConfig) (*oidcProvider, error) {
+	switch {
+	case cfg.Issuer == "":
+		return nil, errors.New("issuer is required")
+	case cfg.ClientID == "":
+		return nil, errors.New("client_id is required")
+	case cfg.RedirectURL == "":
+		return nil, errors.New("redirect_url is required")
+	}
+	if _, ok := roleRank[cfg.DefaultRole]; cfg.DefaultRole != "" && !ok {
+		return nil, fmt.Errorf("unknown default_role %q (want viewer, editor or admin)", cfg.DefaultRole)
+	}
+	for value, role := range cfg.RoleMapping {
+		if _, ok := roleRank[role]; !ok {
+			return nil, fmt.Errorf("unknown role %q for %q in role_mapping (want viewer, editor or admin)", role, value)
+		}
+	}
+	if err := validateTenants(cfg.DefaultTenants); err != nil {
+		return nil, fmt.Errorf("default_tenants: %v", err)
+	}
+
+	return &oidcProvider{
+		config:  cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keys:    make(map[string]crypto.PublicKey),
+		pending: make(map[string]*oidcLogin),
+	}, nil
+}
+
+// authURL starts a login and returns the provider's authorization URL
+func (p *oidcProvider) authURL(ctx context.Context, next string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	now := time.Now()
+	p.mu.Lock()
+	if len(p.pending) >= maxPendingLogins {
+		p.pruneLocked(now)
+	}
+	if len(p.pending) >= maxPendingLogins {
+		p.mu.Unlock()
+		return "", ErrOIDCBusy
+	}
+	p.pending[state] = &oidcLogin{nonce: nonce, verifier: verifier, next: next, expiresAt: now.Add(oidcLoginTimeout)}
+	p.mu.Unlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// exchange redeems the authorization code of a login and returns the claims
+// of the verified ID token with the URL the login started from
+func (p *oidcProvider) exchange(ctx context.Context, state, code string) (map[string]interface{}, string, error) {
+	p.mu.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(login.expiresAt) {
+		return nil, "", fmt.Errorf("%w: unknown or expired login state", ErrUnauthenticated)
+	}
+	if code == "" {
+		return nil, "", fmt.Errorf("%w: missing authorization code", ErrUnauthenticated)
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &tokens); err != nil {
+		return nil, "", fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, "", errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verify(ctx, d, tokens.IDToken, login.nonce)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return claims, login.next, nil
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims
+func (p *oidcProvider) verify(ctx context.Context, d *oidcDiscovery, raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	key, err := p.key(ctx, d, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if claimString(claims, "iss") != d.Issuer {
+		return nil, fmt.Errorf("ID token issuer %q is not %q", claimString(claims, "iss"), d.Issuer)
+	}
+	if !hasAudience(claims["aud"], p.config.ClientID) {
+		return nil, errors.New("ID token is not issued to this client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token is expired")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token key is not an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid ID token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("ID token key is not a P-256 key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("invalid ID token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q (want RS256 or ES256)", alg)
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, item := range v {
+			if item == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover fetches the provider metadata once
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	d = &oidcDiscovery{}
+	if err := p.do(req, d); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC provider reports issuer %q, not %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC provider metadata lacks endpoints")
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// key returns a signing key of the provider, refetching the key set when
+// the ID is unknown, as after key rotation, at most once per
+// keyRefreshInterval
+func (p *oidcProvider) key(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	now := time.Now()
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	refresh := now.Sub(p.keysFetchedAt) >= keyRefreshInterval
+	if !ok && refresh {
+		p.keysFetchedAt = now
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("unknown ID token key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.do(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if parsed, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = parsed
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token key %q", kid)
+}
+
+func (p *oidcProvider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %d: %s", req.Method, req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (p *oidcProvider) prune(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneLocked(now)
+}
+
+// pruneLocked drops expired logins. The caller must hold mu.
+func (p *oidcProvider) pruneLocked(now time.Time) {
+	for state, login := range p.pending {
+		if now.After(login.expiresAt) {
+			delete(p.pending, state)
+		}
+	}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,338 @@
+package users
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+)
+
+// mockProvider is an OIDC provider issuing RS256 ID tokens for the codes of
+// logins authorized through authorize
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	// signer and signerKid sign the ID tokens, the published key unless set
+	signer    *rsa.PrivateKey
+	signerKid string
+	// modify changes the claims of the next ID tokens
+	modify func(claims map[string]interface{})
+
+	jwksFetches atomic.Int32
+
+	mu    sync.Mutex
+	codes map[string]url.Values // authorization request by code
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{t: t, key: key, kid: "key-1", codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize stands in for the browser signing in at the authorization URL,
+// returning the state and code the provider redirects back with
+func (p *mockProvider) authorize(authURL string) (string, string) {
+	p.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatalf("parse authorization URL: %v", err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("client_id") != "telemorph" || query.Get("code_challenge_method") != "S256" {
+		p.t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	code, err := randomToken(8)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.codes[code] = query
+	p.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != "telemorph" || secret != "client-secret" {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+	r.ParseForm()
+	p.mu.Lock()
+	login, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != login.Get("code_challenge") {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":                p.server.URL,
+		"aud":                "telemorph",
+		"sub":                "248289761001",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              login.Get("nonce"),
+		"preferred_username": "jane",
+		"groups":             []string{"observability-admins"},
+		"tenants":            []string{"team-a"},
+	}
+	if p.modify != nil {
+		p.modify(claims)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(claims)})
+}
+
+func (p *mockProvider) sign(claims map[string]interface{}) string {
+	signer, kid := p.key, p.kid
+	if p.signer != nil {
+		signer = p.signer
+	}
+	if p.signerKid != "" {
+		kid = p.signerKid
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newOIDCManager(t *testing.T, p *mockProvider) *Manager {
+	t.Helper()
+	storageCfg := config.DefaultConfig().Storage
+	storageCfg.Path = filepath.Join(t.TempDir(), "telemorph.db")
+	store, err := storage.New(storageCfg)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	cfg := config.DefaultConfig().Auth
+	cfg.Enabled = true
+	cfg.OIDC.Enabled = true
+	cfg.OIDC.Issuer = p.server.URL
+	cfg.OIDC.ClientID = "telemorph"
+	cfg.OIDC.ClientSecret = "client-secret"
+	cfg.OIDC.RedirectURL = "http://localhost:8080/auth/oidc/callback"
+	cfg.OIDC.RoleMapping = map[string]string{"observability-admins": RoleAdmin, "developers": RoleEditor}
+	cfg.OIDC.TenantsClaim = "tenants"
+
+	m, err := NewManager(store, cfg)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m
+}
+
+// signInThroughProvider runs a login through the mock provider
+func signInThroughProvider(m *Manager, p *mockProvider, next string) (*Principal, string, string, error) {
+	ctx := context.Background()
+	authURL, err := m.OIDCLoginURL(ctx, next)
+	if err != nil {
+		p.t.Fatalf("OIDCLoginURL: %v", err)
+	}
+	state, code := p.authorize(authURL)
+	principal, token, _, gotNext, err := m.OIDCCallback(ctx, state, code)
+	return principal, token, gotNext, err
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newMockProvider(t)
+	m := newOIDCManager(t, p)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(claims map[string]interface{})
+		signer   *rsa.PrivateKey
+		wantErr  error
+		wantRole string
+	}{
+		{name: "admin group", wantRole: RoleAdmin},
+		{name: "editor group", modify: func(c map[string]interface{}) { c["groups"] = "developers" }, wantRole: RoleEditor},
+		{name: "no role", modify: func(c map[string]interface{}) { c["groups"] = []string{"sales"} }, wantErr: ErrNoRole},
+		{name: "wrong nonce", modify: func(c map[string]interface{}) { c["nonce"] = "replayed" }, wantErr: ErrUnauthenticated},
+		{name: "expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: ErrUnauthenticated},
+		{name: "other audience", modify: func(c map[string]interface{}) { c["aud"] = "someone-else" }, wantErr: ErrUnauthenticated},
+		{name: "other issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, wantErr: ErrUnauthenticated},
+		{name: "forged signature", signer: otherKey, wantErr: ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.modify, p.signer = tt.modify, tt.signer
+			defer func() { p.modify, p.signer = nil, nil }()
+
+			principal, token, next, err := signInThroughProvider(m, p, "/dashboard")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("OIDCCallback error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OIDCCallback: %v", err)
+			}
+
+			want := &Principal{Username: "jane", Role: tt.wantRole, Tenants: []string{"team-a"}, Method: MethodOIDC}
+			if !reflect.DeepEqual(principal, want) {
+				t.Errorf("principal = %+v, want %+v", principal, want)
+			}
+			if next != "/dashboard" {
+				t.Errorf("next = %q, want /dashboard", next)
+			}
+			got, err := m.Authenticate(token)
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("Authenticate = %+v, %v; want %+v", got, err, want)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsUnknownState(t *testing.T) {
+	p := newMockProvider(t)
+	m := newOIDCManager(t, p)
+	ctx := context.Background()
+
+	authURL, err := m.OIDCLoginURL(ctx, "/")
+	if err != nil {
+		t.Fatalf("OIDCLoginURL: %v", err)
+	}
+	state, code := p.authorize(authURL)
+
+	if _, _, _, _, err := m.OIDCCallback(ctx, "forged", code); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("unknown state error = %v, want %v", err, ErrUnauthenticated)
+	}
+	if _, _, _, _, err := m.OIDCCallback(ctx, state, code); err != nil {
+		t.Fatalf("OIDCCallback: %v", err)
+	}
+	// A state is good for one login only
+	if _, _, _, _, err := m.OIDCCallback(ctx, state, code); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("replayed state error = %v, want %v", err, ErrUnauthenticated)
+	}
+}
+
+func TestOIDCUnknownKeyRefetchIsLimited(t *testing.T) {
+	p := newMockProvider(t)
+	m := newOIDCManager(t, p)
+
+	if _, _, _, err := signInThroughProvider(m, p, "/"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if n := p.jwksFetches.Load(); n != 1 {
+		t.Fatalf("key set fetches = %d, want 1", n)
+	}
+
+	// ID tokens naming an unknown key refetch the key set at most once per
+	// interval
+	p.signerKid = "unknown"
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := signInThroughProvider(m, p, "/"); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("login with an unknown key error = %v, want %v", err, ErrUnauthenticated)
+		}
+	}
+	if n := p.jwksFetches.Load(); n != 1 {
+		t.Errorf("key set fetches = %d, want 1 within the refresh interval", n)
+	}
+
+	// A rotated key is picked up once the interval has passed
+	p.kid = "unknown"
+	m.oidc.mu.Lock()
+	m.oidc.keysFetchedAt = time.Now().Add(-keyRefreshInterval)
+	m.oidc.mu.Unlock()
+	if _, _, _, err := signInThroughProvider(m, p, "/"); err != nil {
+		t.Fatalf("login with a rotated key: %v", err)
+	}
+	if n := p.jwksFetches.Load(); n != 2 {
+		t.Errorf("key set fetches = %d, want 2", n)
+	}
+}
+
+func TestOIDCPendingLoginsAreBounded(t *testing.T) {
+	p := newMockProvider(t)
+	m := newOIDCManager(t, p)
+	ctx := context.Background()
+
+	fill := func(expiresAt time.Time) {
+		m.oidc.mu.Lock()
+		defer m.oidc.mu.Unlock()
+		for i := len(m.oidc.pending); i < maxPendingLogins; i++ {
+			m.oidc.pending[fmt.Sprintf("state-%d", i)] = &oidcLogin{expiresAt: expiresAt}
+		}
+	}
+
+	// Expired logins are swept to make room
+	fill(time.Now().Add(-time.Second))
+	if _, err := m.OIDCLoginURL(ctx, "/"); err != nil {
+		t.Fatalf("OIDCLoginURL with expired logins: %v", err)
+	}
+	if n := len(m.oidc.pending); n != 1 {
+		t.Errorf("pending logins = %d, want 1 after sweeping", n)
+	}
+
+	fill(time.Now().Add(time.Minute))
+	if _, err := m.OIDCLoginURL(ctx, "/"); !errors.Is(err, ErrOIDCBusy) {
+		t.Errorf("OIDCLoginURL error = %v, want %v", err, ErrOIDCBusy)
+	}
+	if n := len(m.oidc.pending); n != maxPendingLogins {
+		t.Errorf("pending logins = %d, want %d", n, maxPendingLogins)
+	}
+}
@@ -0,0 +1,190 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"open-telemorph-prime/internal/alerting"
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAlertRules lists the alerting rules with their health and alerts
+func (s *Service) GetAlertRules(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	rules := tenant.Alerts.Rules()
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"total": len(rules),
+	})
+}
+
+func (s *Service) GetAlertRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	rule, err := tenant.Alerts.Rule(c.Param("name"))
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// CreateAlertRule adds a rule. Durations are Go duration strings ("5m").
+func (s *Service) CreateAlertRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def config.AlertRuleConfig
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := tenant.Alerts.CreateRule(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (s *Service) UpdateAlertRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def config.AlertRuleConfig
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def.Name = c.Param("name")
+
+	rule, err := tenant.Alerts.UpdateRule(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (s *Service) DeleteAlertRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	if err := tenant.Alerts.DeleteRule(c.Param("name")); err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetAlerts lists pending, firing and recently resolved alerts, optionally
+// filtered by state
+func (s *Service) GetAlerts(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	alerts := tenant.Alerts.Alerts(c.Query("state"))
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+		"total":  len(alerts),
+	})
+}
+
+// GetAlertHistory returns alert state transitions, newest first. The range
+// defaults to the last 24 hours.
+func (s *Service) GetAlertHistory(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	end, err := parseTime(c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end: " + err.Error()})
+		return
+	}
+	start, err := parseTime(c.Query("start"), end.Add(-24*time.Hour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start: " + err.Error()})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	events, err := tenant.Storage.GetAlertHistory(storage.AlertHistoryQuery{
+		RuleName: c.Query("rule"),
+		Start:    start,
+		End:      end,
+		Limit:    limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if events == nil {
+		events = []*storage.AlertEvent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+// GetNotificationChannels lists the configured notification channels
+func (s *Service) GetNotificationChannels(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	channels := tenant.Alerts.Channels()
+	c.JSON(http.StatusOK, gin.H{
+		"channels": channels,
+		"total":    len(channels),
+	})
+}
+
+// TestNotificationChannel sends a test alert to a channel and returns the
+// delivery. A failed delivery is still reported with status 200.
+func (s *Service) TestNotificationChannel(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	delivery, err := tenant.Alerts.TestChannel(c.Param("name"))
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// GetNotificationDeliveries returns the notification delivery log, newest
+// first, optionally filtered by channel and result (success or failed)
+func (s *Service) GetNotificationDeliveries(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	deliveries := tenant.Alerts.Deliveries(c.Query("channel"), c.Query("result"), limit)
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      len(deliveries),
+	})
+}
+
+func respondAlertError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, alerting.ErrRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+	case errors.Is(err, alerting.ErrChannelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+	case errors.Is(err, alerting.ErrSilenceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Silence not found"})
+	case errors.Is(err, alerting.ErrWindowNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+	case errors.Is(err, alerting.ErrInhibitRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inhibit rule not found"})
+	case errors.Is(err, alerting.ErrRuleExists), errors.Is(err, alerting.ErrWindowExists),
+		errors.Is(err, alerting.ErrInhibitRuleExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, alerting.ErrRuleReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, alerting.ErrInvalidRule), errors.Is(err, alerting.ErrInvalidSilence),
+		errors.Is(err, alerting.ErrInvalidWindow), errors.Is(err, alerting.ErrInvalidInhibitRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
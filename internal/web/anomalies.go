@@ -0,0 +1,119 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"open-telemorph-prime/internal/anomaly"
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAnomalies scores the series selected by query, averaged over window
+// (default 5m), against their baselines, most anomalous first.
+// anomalous=true keeps only series beyond the threshold.
+func (s *Service) GetAnomalies(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q, threshold, ok := s.anomalyQuery(c)
+	if !ok {
+		return
+	}
+	window := 5 * time.Minute
+	if value := c.Query("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window"})
+			return
+		}
+	}
+
+	scores, err := tenant.Detector.Scores(q, window, threshold, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("anomalous") == "true" {
+		filtered := []*anomaly.Score{}
+		for _, score := range scores {
+			if score.Anomalous {
+				filtered = append(filtered, score)
+			}
+		}
+		scores = filtered
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scores":    scores,
+		"threshold": threshold,
+		"total":     len(scores),
+	})
+}
+
+// QueryAnomalyRange scores every step of the selected series over a time
+// range, with the expected value and band, for charting
+func (s *Service) QueryAnomalyRange(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q, threshold, ok := s.anomalyQuery(c)
+	if !ok {
+		return
+	}
+
+	end, err := parseTime(c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end: " + err.Error()})
+		return
+	}
+	start, err := parseTime(c.Query("start"), end.Add(-24*time.Hour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start: " + err.Error()})
+		return
+	}
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return
+	}
+	step, err := parseStep(c.Query("step"), time.Hour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid step: " + err.Error()})
+		return
+	}
+	q.Start, q.End, q.Step = start, end, step
+
+	series, err := tenant.Detector.QueryRange(q, threshold, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"series":    series,
+		"threshold": threshold,
+	})
+}
+
+// anomalyQuery parses the query selector and threshold parameters shared by
+// the anomaly endpoints, responding with 400 when they are invalid
+func (s *Service) anomalyQuery(c *gin.Context) (storage.MetricQuery, float64, bool) {
+	tenant := tenancy.FromContext(c)
+	q, err := storage.ParseSeriesSelector(c.Query("query"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, 0, false
+	}
+	if q.MetricName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query must name a metric"})
+		return q, 0, false
+	}
+
+	threshold := tenant.Detector.Threshold()
+	if value := c.Query("threshold"); value != "" {
+		if threshold, err = strconv.ParseFloat(value, 64); err != nil || threshold <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid threshold"})
+			return q, 0, false
+		}
+	}
+	return q, threshold, true
+}
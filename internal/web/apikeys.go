@@ -0,0 +1,79 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"open-telemorph-prime/internal/auth"
+	"open-telemorph-prime/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAPIKeys lists the ingestion API keys and client certificate mappings
+// with their scope and last use. Secrets are never listed.
+func (s *Service) GetAPIKeys(c *gin.Context) {
+	keys := s.auth.Keys()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":      s.auth.Enabled(),
+		"keys":         keys,
+		"client_certs": s.auth.ClientCerts(),
+		"total":        len(keys),
+	})
+}
+
+// CreateAPIKey adds an API key. The response holds the generated secret,
+// which cannot be retrieved later.
+func (s *Service) CreateAPIKey(c *gin.Context) {
+	var def config.APIKeyConfig
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, secret, err := s.auth.CreateKey(def)
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": key, "secret": secret})
+}
+
+// RotateAPIKey replaces the secret of an API key; the old secret stops
+// working at once
+func (s *Service) RotateAPIKey(c *gin.Context) {
+	key, secret, err := s.auth.RotateKey(c.Param("name"))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key, "secret": secret})
+}
+
+// RevokeAPIKey stops an API key from authenticating
+func (s *Service) RevokeAPIKey(c *gin.Context) {
+	key, err := s.auth.RevokeKey(c.Param("name"))
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func respondAPIKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, auth.ErrAPIKeyReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAPIKeyExists), errors.Is(err, auth.ErrAPIKeyRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidAPIKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"open-telemorph-prime/internal/issues"
+
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetIssues lists issues, optionally filtered by status and service, sorted
+// by last_seen (default), first_seen or count
+func (s *Service) GetIssues(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q := issues.Query{
+		Status:  c.Query("status"),
+		Service: c.Query("service"),
+		Sort:    c.DefaultQuery("sort", "last_seen"),
+	}
+	switch q.Sort {
+	case "last_seen", "first_seen", "count":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sort (want last_seen, first_seen or count)"})
+		return
+	}
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(value); err != nil || q.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	list := tenant.Issues.Issues(q)
+	c.JSON(http.StatusOK, gin.H{
+		"issues": list,
+		"total":  len(list),
+	})
+}
+
+func (s *Service) GetIssue(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	issue, err := tenant.Issues.Issue(c.Param("id"))
+	if err != nil {
+		respondIssueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, issue)
+}
+
+// UpdateIssue sets the status of an issue to unresolved, resolved or ignored
+func (s *Service) UpdateIssue(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	issue, err := tenant.Issues.SetStatus(c.Param("id"), req.Status)
+	if err != nil {
+		respondIssueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, issue)
+}
+
+func respondIssueError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, issues.ErrIssueNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Issue not found"})
+	case errors.Is(err, issues.ErrInvalidStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"open-telemorph-prime/internal/patterns"
+
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLogPatterns lists the log patterns seen within window (default 1h),
+// most frequent first. service restricts them to one service.
+func (s *Service) GetLogPatterns(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q, ok := patternQuery(c, time.Hour)
+	if !ok {
+		return
+	}
+
+	list := tenant.Patterns.Top(q)
+	c.JSON(http.StatusOK, gin.H{
+		"patterns": list,
+		"total":    len(list),
+		"window":   q.Window.String(),
+	})
+}
+
+// GetNewLogPatterns lists the log patterns first seen within window (default
+// 1h), newest first
+func (s *Service) GetNewLogPatterns(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q, ok := patternQuery(c, time.Hour)
+	if !ok {
+		return
+	}
+
+	list := tenant.Patterns.New(q)
+	c.JSON(http.StatusOK, gin.H{
+		"patterns": list,
+		"total":    len(list),
+		"window":   q.Window.String(),
+	})
+}
+
+// GetLogPatternTrends returns the counts per step of the patterns given as
+// pattern parameters, or else of the top patterns, over window (default 6h)
+// and their change from the preceding window
+func (s *Service) GetLogPatternTrends(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	q, ok := patternQuery(c, 6*time.Hour)
+	if !ok {
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 10
+	}
+	step, err := parseStep(c.Query("step"), 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid step: " + err.Error()})
+		return
+	}
+
+	trends := tenant.Patterns.Trends(patterns.TrendQuery{Query: q, IDs: c.QueryArray("pattern"), Step: step})
+	c.JSON(http.StatusOK, gin.H{
+		"trends": trends,
+		"total":  len(trends),
+		"window": q.Window.String(),
+	})
+}
+
+// patternQuery parses the service, window and limit parameters shared by the
+// log pattern endpoints, responding with 400 when they are invalid
+func patternQuery(c *gin.Context, defaultWindow time.Duration) (patterns.Query, bool) {
+	q := patterns.Query{Service: c.Query("service"), Window: defaultWindow}
+
+	window, err := parseWindow(c.Query("window"))
+	if err != nil || window < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window"})
+		return q, false
+	}
+	if window > 0 {
+		q.Window = window
+	}
+	if value := c.Query("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil || q.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return q, false
+		}
+	}
+	return q, true
+}
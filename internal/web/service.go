@@ -1,33 +1,53 @@
 package web
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
+	"open-telemorph-prime/internal/auth"
 	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/redaction"
 	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+	"open-telemorph-prime/internal/transform"
+	"open-telemorph-prime/internal/users"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Service serves the API and web UI. API handlers read the data of the
+// tenant resolved for the request.
 type Service struct {
-	storage storage.Storage
-	config  config.WebConfig
+	tenants   *tenancy.Registry
+	auth      *auth.Authenticator
+	users     *users.Manager
+	config    config.WebConfig
+	redactor  *redaction.Redactor
+	transform *transform.Transformer
 }
 
-func NewService(storage storage.Storage, config config.WebConfig) *Service {
+func NewService(tenants *tenancy.Registry, authenticator *auth.Authenticator, userManager *users.Manager, config config.WebConfig, redactor *redaction.Redactor, transformer *transform.Transformer) *Service {
 	return &Service{
-		storage: storage,
-		config:  config,
+		tenants:   tenants,
+		auth:      authenticator,
+		users:     userManager,
+		config:    config,
+		redactor:  redactor,
+		transform: transformer,
 	}
 }
 
 // API endpoints
 func (s *Service) GetMetrics(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	metrics, err := s.storage.GetMetrics(limit, offset)
+	metrics, err := tenant.Storage.GetMetrics(limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -41,11 +61,146 @@ func (s *Service) GetMetrics(c *gin.Context) {
 	})
 }
 
+// QueryMetricRange returns the series of one metric over a time range,
+// bucketed by step. Series can be narrowed with repeated match parameters
+// (match=env=prod&match=region=~us-.*). Step defaults to 1/300th of the range,
+// and the response reports which resolution tier served the query.
+// exemplars=true attaches each series' exemplars.
+func (s *Service) QueryMetricRange(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	end, err := parseTime(c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end: " + err.Error()})
+		return
+	}
+	start, err := parseTime(c.Query("start"), end.Add(-time.Hour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start: " + err.Error()})
+		return
+	}
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return
+	}
+	step, err := parseStep(c.Query("step"), end.Sub(start)/300)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid step: " + err.Error()})
+		return
+	}
+
+	var matchers []storage.LabelMatcher
+	for _, value := range c.QueryArray("match") {
+		matcher, err := storage.ParseLabelMatcher(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	result, err := tenant.Storage.QueryMetricRange(storage.MetricQuery{
+		MetricName:  c.Query("metric"),
+		ServiceName: c.Query("service"),
+		Matchers:    matchers,
+		Start:       start,
+		End:         end,
+		Step:        step,
+		Exemplars:   c.Query("exemplars") == "true",
+	})
+	if errors.Is(err, storage.ErrInvalidMetricQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// QueryExemplars serves exemplars for a series selector in the Prometheus
+// exemplar query API format, so that Grafana and similar clients can link
+// metric panels to traces
+func (s *Service) QueryExemplars(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	end, err := parseTime(c.Query("end"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": "invalid end: " + err.Error()})
+		return
+	}
+	start, err := parseTime(c.Query("start"), end.Add(-time.Hour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": "invalid start: " + err.Error()})
+		return
+	}
+
+	q, err := storage.ParseSeriesSelector(c.Query("query"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": err.Error()})
+		return
+	}
+	if q.MetricName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": "query must name a metric"})
+		return
+	}
+	q.Start, q.End = start, end
+
+	exemplars, err := tenant.Storage.QueryExemplars(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "errorType": "internal", "error": err.Error()})
+		return
+	}
+
+	type promExemplar struct {
+		Labels    map[string]string `json:"labels"`
+		Value     string            `json:"value"`
+		Timestamp float64           `json:"timestamp"`
+	}
+	type promSeries struct {
+		SeriesLabels map[string]string `json:"seriesLabels"`
+		Exemplars    []promExemplar    `json:"exemplars"`
+	}
+
+	data := []*promSeries{}
+	bySeries := make(map[string]*promSeries)
+	for _, e := range exemplars {
+		key := e.MetricName + "\x00" + e.ServiceName + "\x00" + e.Labels
+		series, ok := bySeries[key]
+		if !ok {
+			labels := make(map[string]string)
+			json.Unmarshal([]byte(e.Labels), &labels)
+			labels["__name__"] = e.MetricName
+			labels["service_name"] = e.ServiceName
+			series = &promSeries{SeriesLabels: labels, Exemplars: []promExemplar{}}
+			bySeries[key] = series
+			data = append(data, series)
+		}
+
+		labels := make(map[string]string)
+		json.Unmarshal([]byte(e.Attributes), &labels)
+		if e.TraceID != "" {
+			labels["trace_id"] = e.TraceID
+		}
+		if e.SpanID != "" {
+			labels["span_id"] = e.SpanID
+		}
+		series.Exemplars = append(series.Exemplars, promExemplar{
+			Labels:    labels,
+			Value:     strconv.FormatFloat(e.Value, 'f', -1, 64),
+			Timestamp: float64(e.Timestamp.UnixNano()) / float64(time.Second),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
+
 func (s *Service) GetTraces(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	traces, err := s.storage.GetTraces(limit, offset)
+	traces, err := tenant.Storage.GetTraces(limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -59,11 +214,201 @@ func (s *Service) GetTraces(c *gin.Context) {
 	})
 }
 
+func (s *Service) GetTrace(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	spans, err := tenant.Storage.GetTrace(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(spans) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trace not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id": c.Param("id"),
+		"spans":    spans,
+	})
+}
+
+// GetTraceLogs returns the logs of a trace grouped under the spans they
+// belong to. Logs that carry the trace ID but no known span ID are returned
+// as trace_logs.
+func (s *Service) GetTraceLogs(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	traceID := c.Param("id")
+
+	spans, err := tenant.Storage.GetTrace(traceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logs, err := tenant.Storage.GetTraceLogs(traceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(spans) == 0 && len(logs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trace not found"})
+		return
+	}
+
+	bySpan := make(map[string][]*storage.Log)
+	traceLogs := []*storage.Log{}
+	known := make(map[string]bool, len(spans))
+	for _, span := range spans {
+		known[span.SpanID] = true
+	}
+	for _, l := range logs {
+		if l.SpanID != nil && known[*l.SpanID] {
+			bySpan[*l.SpanID] = append(bySpan[*l.SpanID], l)
+		} else {
+			traceLogs = append(traceLogs, l)
+		}
+	}
+
+	spanLogs := make([]gin.H, 0, len(spans))
+	for _, span := range spans {
+		entries := bySpan[span.SpanID]
+		if entries == nil {
+			entries = []*storage.Log{}
+		}
+		spanLogs = append(spanLogs, gin.H{
+			"span_id":        span.SpanID,
+			"service_name":   span.ServiceName,
+			"operation_name": span.OperationName,
+			"start_time":     span.StartTime,
+			"duration_nanos": span.DurationNanos,
+			"logs":           entries,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":   traceID,
+		"spans":      spanLogs,
+		"trace_logs": traceLogs,
+		"total":      len(logs),
+	})
+}
+
+// GetSpan returns one span with its trace context, so a log carrying trace
+// and span IDs can link back to the span that emitted it
+func (s *Service) GetSpan(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	span, spans, err := findSpan(tenant.Storage, c.Param("id"), c.Param("span_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if span == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Span not found"})
+		return
+	}
+
+	var parent, root *storage.Trace
+	services := make(map[string]bool)
+	for _, candidate := range spans {
+		services[candidate.ServiceName] = true
+		if span.ParentSpanID != nil && candidate.SpanID == *span.ParentSpanID {
+			parent = candidate
+		}
+		if candidate.ParentSpanID == nil && (root == nil || candidate.StartTime.Before(root.StartTime)) {
+			root = candidate
+		}
+	}
+
+	serviceNames := make([]string, 0, len(services))
+	for name := range services {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":   span.TraceID,
+		"span":       span,
+		"parent":     parent,
+		"root":       root,
+		"span_count": len(spans),
+		"services":   serviceNames,
+	})
+}
+
+// GetSpanWindowLogs returns the logs a service wrote while a span was running,
+// whether or not they carry trace context. The service defaults to the span's
+// own, and padding (e.g. 500ms) widens the window on both sides.
+func (s *Service) GetSpanWindowLogs(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	padding, err := parseWindow(c.Query("padding"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid padding: " + err.Error()})
+		return
+	}
+
+	span, _, err := findSpan(tenant.Storage, c.Param("id"), c.Param("span_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if span == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Span not found"})
+		return
+	}
+
+	service := c.DefaultQuery("service", span.ServiceName)
+	from := span.StartTime.Add(-padding)
+	to := span.StartTime.Add(time.Duration(span.DurationNanos) + padding)
+
+	logs, err := tenant.Storage.GetServiceLogs(service, from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":     span.TraceID,
+		"span_id":      span.SpanID,
+		"service_name": service,
+		"from":         from,
+		"to":           to,
+		"data":         logs,
+		"total":        len(logs),
+	})
+}
+
+// findSpan returns the span with spanID and every span of its trace
+func findSpan(store storage.Storage, traceID, spanID string) (*storage.Trace, []*storage.Trace, error) {
+	spans, err := store.GetTrace(traceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, span := range spans {
+		if span.SpanID == spanID {
+			return span, spans, nil
+		}
+	}
+	return nil, spans, nil
+}
+
+// GetLogs lists logs newest first, only those assigned to a log pattern when
+// pattern is given
 func (s *Service) GetLogs(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	logs, err := s.storage.GetLogs(limit, offset)
+	var logs []*storage.Log
+	var err error
+	if pattern := c.Query("pattern"); pattern != "" {
+		logs, err = tenant.Storage.GetPatternLogs(pattern, limit, offset)
+	} else {
+		logs, err = tenant.Storage.GetLogs(limit, offset)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -77,8 +422,17 @@ func (s *Service) GetLogs(c *gin.Context) {
 	})
 }
 
+// GetServices lists the service catalog. Rates and error rates are computed
+// over window (e.g. 15m, at most 1h), which defaults to the configured window.
 func (s *Service) GetServices(c *gin.Context) {
-	services, err := s.storage.GetServices()
+	tenant := tenancy.FromContext(c)
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window: " + err.Error()})
+		return
+	}
+
+	services, err := tenant.Storage.GetServiceCatalog(window)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -86,10 +440,91 @@ func (s *Service) GetServices(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"services": services,
+		"total":    len(services),
 	})
 }
 
+// GetService returns one catalog entry with its instances and per-minute
+// activity over window
+func (s *Service) GetService(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window: " + err.Error()})
+		return
+	}
+
+	service, err := tenant.Storage.GetServiceDetail(c.Param("name"), window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if service == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, service)
+}
+
+// parseTime accepts unix seconds (optionally fractional) or RFC 3339
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseStep accepts a Go duration ("5m") or a number of seconds, rounding the
+// result to whole seconds
+func parseStep(value string, def time.Duration) (time.Duration, error) {
+	step := def
+	if value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			step = time.Duration(seconds * float64(time.Second))
+		} else if step, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	return step.Round(time.Second), nil
+}
+
+// GetServiceGraph returns the caller->callee edges between services over
+// window, with request rate, error rate and latency percentiles per edge
+func (s *Service) GetServiceGraph(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window: " + err.Error()})
+		return
+	}
+
+	graph, err := tenant.Storage.GetServiceGraph(window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, graph)
+}
+
+// parseWindow accepts a Go duration; empty selects the storage default
+func parseWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
 func (s *Service) Query(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
 	var queryReq struct {
 		Type   string `json:"type" binding:"required"`
 		Query  string `json:"query" binding:"required"`
@@ -108,21 +543,21 @@ func (s *Service) Query(c *gin.Context) {
 
 	switch queryReq.Type {
 	case "metrics":
-		metrics, err := s.storage.GetMetrics(queryReq.Limit, queryReq.Offset)
+		metrics, err := tenant.Storage.GetMetrics(queryReq.Limit, queryReq.Offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"data": metrics})
 	case "traces":
-		traces, err := s.storage.GetTraces(queryReq.Limit, queryReq.Offset)
+		traces, err := tenant.Storage.GetTraces(queryReq.Limit, queryReq.Offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"data": traces})
 	case "logs":
-		logs, err := s.storage.GetLogs(queryReq.Limit, queryReq.Offset)
+		logs, err := tenant.Storage.GetLogs(queryReq.Limit, queryReq.Offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -240,6 +675,71 @@ func (s *Service) SaveConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Configuration saved successfully"})
 }
 
+// GetSamplingStats returns the tail sampling decision counters since startup
+func (s *Service) GetSamplingStats(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	c.JSON(http.StatusOK, tenant.Sampler.Stats())
+}
+
+// GetIngestLimits returns the ingest policies in effect with the counters of
+// the services they applied to
+func (s *Service) GetIngestLimits(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	cfg := tenant.Limits.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  cfg.Enabled,
+		"policies": cfg.Policies,
+		"stats":    tenant.Limits.Stats(),
+	})
+}
+
+// UpdateIngestLimits replaces the ingest policies until restart
+func (s *Service) UpdateIngestLimits(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var cfg config.IngestLimitsConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tenant.Limits.Configure(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg = tenant.Limits.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  cfg.Enabled,
+		"policies": cfg.Policies,
+	})
+}
+
+// GetRedactionRules lists the redaction rules with the number of values each
+// changed since startup
+func (s *Service) GetRedactionRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.redactor.Enabled(),
+		"rules":   s.redactor.Rules(),
+	})
+}
+
+// GetTransformStatements lists the transformation statements with the number
+// of records each changed and the errors each hit since startup
+func (s *Service) GetTransformStatements(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":    s.transform.Enabled(),
+		"statements": s.transform.Statements(),
+	})
+}
+
+// GetTenants lists the tenants opened since startup with their retention
+func (s *Service) GetTenants(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.tenants.Enabled(),
+		"tenants": s.tenants.Tenants(),
+	})
+}
+
 func (s *Service) GetSystemStatus(c *gin.Context) {
 	// TODO: Implement system status retrieval
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,208 @@
+package web
+
+import (
+	"net/http"
+
+	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSilences lists silences with their status, newest first
+func (s *Service) GetSilences(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	silences := tenant.Alerts.Silences()
+	c.JSON(http.StatusOK, gin.H{
+		"silences": silences,
+		"total":    len(silences),
+	})
+}
+
+func (s *Service) GetSilence(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	silence, err := tenant.Alerts.Silence(c.Param("id"))
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, silence)
+}
+
+// CreateSilence adds a silence. starts_at defaults to now; times are RFC 3339.
+func (s *Service) CreateSilence(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.Silence
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	silence, err := tenant.Alerts.CreateSilence(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, silence)
+}
+
+func (s *Service) UpdateSilence(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.Silence
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def.ID = c.Param("id")
+
+	silence, err := tenant.Alerts.UpdateSilence(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, silence)
+}
+
+func (s *Service) DeleteSilence(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	if err := tenant.Alerts.DeleteSilence(c.Param("id")); err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetMaintenanceWindows lists maintenance windows and whether each is open
+func (s *Service) GetMaintenanceWindows(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	windows := tenant.Alerts.MaintenanceWindows()
+	c.JSON(http.StatusOK, gin.H{
+		"windows": windows,
+		"total":   len(windows),
+	})
+}
+
+func (s *Service) GetMaintenanceWindow(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	window, err := tenant.Alerts.MaintenanceWindow(c.Param("name"))
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, window)
+}
+
+func (s *Service) CreateMaintenanceWindow(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.MaintenanceWindow
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	window, err := tenant.Alerts.CreateMaintenanceWindow(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, window)
+}
+
+func (s *Service) UpdateMaintenanceWindow(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.MaintenanceWindow
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def.Name = c.Param("name")
+
+	window, err := tenant.Alerts.UpdateMaintenanceWindow(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, window)
+}
+
+func (s *Service) DeleteMaintenanceWindow(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	if err := tenant.Alerts.DeleteMaintenanceWindow(c.Param("name")); err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (s *Service) GetInhibitRules(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	rules := tenant.Alerts.InhibitRules()
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"total": len(rules),
+	})
+}
+
+func (s *Service) GetInhibitRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	rule, err := tenant.Alerts.InhibitRule(c.Param("name"))
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (s *Service) CreateInhibitRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.InhibitRule
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := tenant.Alerts.CreateInhibitRule(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (s *Service) UpdateInhibitRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	var def storage.InhibitRule
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def.Name = c.Param("name")
+
+	rule, err := tenant.Alerts.UpdateInhibitRule(def)
+	if err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (s *Service) DeleteInhibitRule(c *gin.Context) {
+	tenant := tenancy.FromContext(c)
+	if err := tenant.Alerts.DeleteInhibitRule(c.Param("name")); err != nil {
+		respondAlertError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -0,0 +1,272 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/users"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginPage renders the sign-in form, or sends signed-in users on
+func (s *Service) LoginPage(c *gin.Context) {
+	next := users.SafeRedirect(c.Query("next"))
+	if !s.users.Enabled() {
+		c.Redirect(http.StatusFound, next)
+		return
+	}
+	s.renderLogin(c, http.StatusOK, next, "")
+}
+
+// LoginForm signs a user in from the login page
+func (s *Service) LoginForm(c *gin.Context) {
+	next := users.SafeRedirect(c.PostForm("next"))
+	_, token, expiresAt, err := s.users.Login(c.PostForm("username"), c.PostForm("password"))
+	if err != nil {
+		s.renderLogin(c, http.StatusUnauthorized, next, err.Error())
+		return
+	}
+
+	s.users.SetSessionCookie(c, token, expiresAt)
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+func (s *Service) renderLogin(c *gin.Context, status int, next, message string) {
+	c.HTML(status, "login.html", gin.H{
+		"title": s.config.Title + " - Sign in",
+		"theme": s.config.Theme,
+		"next":  next,
+		"error": message,
+		"oidc":  s.users.OIDCEnabled(),
+	})
+}
+
+// Login signs a user in with a password. The session token is returned for
+// use as a bearer token and set as a cookie.
+func (s *Service) Login(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	principal, token, expiresAt, err := s.users.Login(req.Username, req.Password)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	s.users.SetSessionCookie(c, token, expiresAt)
+	c.JSON(http.StatusOK, gin.H{
+		"user":       principal,
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// Logout ends the request's session
+func (s *Service) Logout(c *gin.Context) {
+	s.users.Logout(s.users.Token(c))
+	s.users.ClearSessionCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// OIDCLogin sends the browser to the OIDC provider
+func (s *Service) OIDCLogin(c *gin.Context) {
+	next := users.SafeRedirect(c.Query("next"))
+	target, err := s.users.OIDCLoginURL(c.Request.Context(), next)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, users.ErrOIDCBusy) {
+			status = http.StatusServiceUnavailable
+		}
+		s.renderLogin(c, status, next, err.Error())
+		return
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// OIDCCallback completes an OIDC login when the provider redirects back
+func (s *Service) OIDCCallback(c *gin.Context) {
+	if message := c.Query("error"); message != "" {
+		if description := c.Query("error_description"); description != "" {
+			message += ": " + description
+		}
+		s.renderLogin(c, http.StatusUnauthorized, "/", message)
+		return
+	}
+
+	_, token, expiresAt, next, err := s.users.OIDCCallback(c.Request.Context(), c.Query("state"), c.Query("code"))
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, users.ErrUnauthenticated):
+			status = http.StatusUnauthorized
+		case errors.Is(err, users.ErrNoRole):
+			status = http.StatusForbidden
+		}
+		s.renderLogin(c, status, "/", err.Error())
+		return
+	}
+
+	s.users.SetSessionCookie(c, token, expiresAt)
+	c.Redirect(http.StatusFound, users.SafeRedirect(next))
+}
+
+// GetCurrentUser returns the signed-in user
+func (s *Service) GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.users.Enabled(),
+		"user":    users.FromContext(c),
+	})
+}
+
+// GetTokens lists the signed-in user's API tokens
+func (s *Service) GetTokens(c *gin.Context) {
+	principal := users.FromContext(c)
+	if principal == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user authentication is disabled"})
+		return
+	}
+
+	tokens, err := s.users.Tokens(principal)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+		"total":  len(tokens),
+	})
+}
+
+// CreateToken adds an API token for the signed-in user. expires_in is a
+// duration such as "720h"; tokens without it do not expire. tenants limits
+// the token to some of the user's tenants. The response holds the token,
+// which cannot be retrieved later.
+func (s *Service) CreateToken(c *gin.Context) {
+	principal := users.FromContext(c)
+	if principal == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user authentication is disabled"})
+		return
+	}
+	var req struct {
+		Name      string   `json:"name" binding:"required"`
+		Tenants   []string `json:"tenants"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in"})
+			return
+		}
+	}
+
+	token, secret, err := s.users.CreateToken(principal, req.Name, req.Tenants, ttl)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"token": token, "secret": secret})
+}
+
+// DeleteToken revokes one of the signed-in user's API tokens
+func (s *Service) DeleteToken(c *gin.Context) {
+	principal := users.FromContext(c)
+	if principal == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user authentication is disabled"})
+		return
+	}
+
+	if err := s.users.DeleteToken(principal, c.Param("id")); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token deleted"})
+}
+
+// GetUsers lists the users with their role and source
+func (s *Service) GetUsers(c *gin.Context) {
+	list := s.users.Users()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.users.Enabled(),
+		"users":   list,
+		"total":   len(list),
+	})
+}
+
+// CreateUser adds a local user: {"username", "password", "role", "tenants"}
+func (s *Service) CreateUser(c *gin.Context) {
+	var def config.UserConfig
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.users.CreateUser(def)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser changes a user's role, password or tenants
+func (s *Service) UpdateUser(c *gin.Context) {
+	var def config.UserConfig
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.users.UpdateUser(c.Param("username"), def)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (s *Service) DeleteUser(c *gin.Context) {
+	if err := s.users.DeleteUser(c.Param("username")); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+func respondUserError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, users.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, users.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, users.ErrTokenNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+	case errors.Is(err, users.ErrUserReadOnly), errors.Is(err, users.ErrTokenForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, users.ErrUserExists), errors.Is(err, users.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, users.ErrInvalidUser), errors.Is(err, users.ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
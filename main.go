@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"open-telemorph-prime/internal/auth"
 	"open-telemorph-prime/internal/config"
+	"open-telemorph-prime/internal/cors"
 	"open-telemorph-prime/internal/ingestion"
 	"open-telemorph-prime/internal/logger"
+	"open-telemorph-prime/internal/redaction"
 	"open-telemorph-prime/internal/storage"
+	"open-telemorph-prime/internal/tenancy"
+	"open-telemorph-prime/internal/tlsconfig"
+	"open-telemorph-prime/internal/transform"
+	"open-telemorph-prime/internal/users"
 	"open-telemorph-prime/internal/web"
 
 	"github.com/gin-gonic/gin"
@@ -21,13 +30,23 @@ import (
 )
 
 var (
-	configPath = flag.String("config", "config.yaml", "Path to configuration file")
-	version    = "0.1.0"
+	configPath     = flag.String("config", "config.yaml", "Path to configuration file")
+	showMigrations = flag.Bool("show-migrations", false, "Print pending SQLite schema migrations and exit without applying them")
+	hashPassword   = flag.Bool("hash-password", false, "Read a password from stdin, print its bcrypt hash for auth.users and exit")
+	version        = "0.1.0"
 )
 
 func main() {
 	flag.Parse()
 
+	if *hashPassword {
+		if err := printPasswordHash(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to hash password: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load(*configPath)
 	if err != nil {
@@ -45,34 +64,75 @@ func main() {
 
 	log := logger.Get()
 
-	// Initialize storage
-	storage, err := storage.NewSQLiteStorage(cfg.Storage)
+	if *showMigrations {
+		if err := printPendingMigrations(cfg.Storage); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check migrations: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Open the default tenant's storage and processors; other tenants are
+	// opened on their first request
+	tenants, err := tenancy.NewRegistry(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize tenants", zap.Error(err), zap.String("type", cfg.Storage.Type))
+	}
+	defer tenants.Close()
+
+	// Initialize the redaction rules
+	redactor, err := redaction.NewRedactor(cfg.Ingestion.Redaction)
+	if err != nil {
+		log.Fatal("Failed to initialize redaction", zap.Error(err))
+	}
+
+	// Initialize the transformation statements
+	transformer, err := transform.NewTransformer(cfg.Ingestion.Transform)
+	if err != nil {
+		log.Fatal("Failed to initialize transforms", zap.Error(err))
+	}
+
+	// Initialize the ingestion API keys, stored with the default tenant
+	authenticator, err := auth.NewAuthenticator(tenants.Default().Storage, cfg.Ingestion.Auth)
 	if err != nil {
-		log.Fatal("Failed to initialize storage", zap.Error(err))
+		log.Fatal("Failed to initialize ingestion auth", zap.Error(err))
+	}
+
+	// Initialize the users of the web UI and API, stored with the default
+	// tenant
+	userManager, err := users.NewManager(tenants.Default().Storage, cfg.Auth)
+	if err != nil {
+		log.Fatal("Failed to initialize users", zap.Error(err))
 	}
-	defer storage.Close()
 
 	// Initialize ingestion service
-	ingestionService := ingestion.NewService(storage, cfg.Ingestion)
+	ingestionService := ingestion.NewService(tenants, authenticator, cfg.Ingestion, redactor, transformer)
 
 	// Initialize web service
-	webService := web.NewService(storage, cfg.Web)
+	webService := web.NewService(tenants, authenticator, userManager, cfg.Web, redactor, transformer)
 
 	// Set up Gin router
 	if cfg.Server.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// The cross-origin policy runs for every route, so it can answer
+	// preflights for routes without an OPTIONS handler
+	corsPolicy, err := cors.NewPolicy(cfg.Server.CORS)
+	if err != nil {
+		log.Fatal("Failed to initialize CORS", zap.Error(err))
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
-	router.Use(corsMiddleware())
+	router.Use(corsPolicy.Middleware())
 
 	// Load HTML templates
 	router.LoadHTMLGlob("web/*.html")
 
 	// Register routes
-	registerRoutes(router, ingestionService, webService)
+	registerRoutes(router, tenants, userManager, ingestionService, webService)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -82,6 +142,34 @@ func main() {
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
+	// Serve over TLS when configured, reloading the certificate as it is
+	// renewed
+	var serverTLS *tlsconfig.Reloader
+	if cfg.Server.TLS.Enabled {
+		serverTLS, err = tlsconfig.NewReloader("server", cfg.Server.TLS)
+		if err != nil {
+			log.Fatal("Failed to initialize server TLS", zap.Error(err))
+		}
+		server.TLSConfig = serverTLS.Config()
+		serverTLS.Start()
+	}
+
+	// Enforce each tenant's retention window at startup and hourly thereafter
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if err := tenants.CleanupOldData(); err != nil {
+				log.Error("Failed to clean up old data", zap.Error(err))
+			}
+			<-ticker.C
+		}
+	}()
+
+	tenants.Start()
+	authenticator.Start()
+	userManager.Start()
+
 	// Start ingestion service
 	go func() {
 		if err := ingestionService.Start(); err != nil {
@@ -94,8 +182,15 @@ func main() {
 		log.Info("Starting Open-Telemorph-Prime server",
 			zap.Int("port", cfg.Server.Port),
 			zap.String("environment", cfg.Server.Environment),
+			zap.Bool("tls", serverTLS != nil),
 		)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if serverTLS != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
@@ -115,51 +210,171 @@ func main() {
 		log.Error("Error stopping ingestion service", zap.Error(err))
 	}
 
+	tenants.Stop()
+	authenticator.Stop()
+	userManager.Stop()
+
 	// Shutdown HTTP server
 	if err := server.Shutdown(ctx); err != nil {
 		log.Error("Error shutting down server", zap.Error(err))
 	}
+	if serverTLS != nil {
+		serverTLS.Stop()
+	}
 
 	log.Info("Open-Telemorph-Prime stopped")
 }
 
-func registerRoutes(router *gin.Engine, ingestionService *ingestion.Service, webService *web.Service) {
+func registerRoutes(router *gin.Engine, tenants *tenancy.Registry, userManager *users.Manager, ingestionService *ingestion.Service, webService *web.Service) {
 	// Health endpoints
 	router.GET("/health", healthCheck)
 	router.GET("/ready", readinessCheck)
 
-	// API routes
-	api := router.Group("/api/v1")
+	// Sign-in routes
+	router.GET("/login", webService.LoginPage)
+	router.POST("/login", webService.LoginForm)
+	router.GET("/auth/oidc/login", webService.OIDCLogin)
+	router.GET("/auth/oidc/callback", webService.OIDCCallback)
+	router.POST("/api/v1/auth/login", webService.Login)
+
+	session := router.Group("/api/v1/auth", userManager.Middleware())
+	{
+		session.POST("/logout", webService.Logout)
+		session.GET("/me", webService.GetCurrentUser)
+		session.GET("/tokens", webService.GetTokens)
+		session.POST("/tokens", webService.CreateToken)
+		session.DELETE("/tokens/:id", webService.DeleteToken)
+	}
+
+	// API routes. Every signed-in user may read; changes need the editor role.
+	api := router.Group("/api/v1", userManager.Middleware(), tenants.Middleware())
+	editor := api.Group("", userManager.Require(users.RoleEditor))
 	{
 		api.GET("/metrics", webService.GetMetrics)
+		api.GET("/metrics/query_range", webService.QueryMetricRange)
+		api.GET("/query_exemplars", webService.QueryExemplars)
+		api.GET("/anomalies", webService.GetAnomalies)
+		api.GET("/anomalies/query_range", webService.QueryAnomalyRange)
 		api.GET("/traces", webService.GetTraces)
+		api.GET("/traces/:id", webService.GetTrace)
+		api.GET("/traces/:id/logs", webService.GetTraceLogs)
+		api.GET("/traces/:id/spans/:span_id", webService.GetSpan)
+		api.GET("/traces/:id/spans/:span_id/logs", webService.GetSpanWindowLogs)
 		api.GET("/logs", webService.GetLogs)
+		api.GET("/logs/patterns", webService.GetLogPatterns)
+		api.GET("/logs/patterns/new", webService.GetNewLogPatterns)
+		api.GET("/logs/patterns/trends", webService.GetLogPatternTrends)
+		api.GET("/issues", webService.GetIssues)
+		api.GET("/issues/:id", webService.GetIssue)
+		editor.PUT("/issues/:id", webService.UpdateIssue)
 		api.GET("/services", webService.GetServices)
+		api.GET("/services/:name", webService.GetService)
+		api.GET("/service_graph", webService.GetServiceGraph)
+		api.GET("/alerts", webService.GetAlerts)
+		api.GET("/alerts/history", webService.GetAlertHistory)
+		api.GET("/alerts/channels", webService.GetNotificationChannels)
+		editor.POST("/alerts/channels/:name/test", webService.TestNotificationChannel)
+		api.GET("/alerts/deliveries", webService.GetNotificationDeliveries)
+		api.GET("/alerts/silences", webService.GetSilences)
+		editor.POST("/alerts/silences", webService.CreateSilence)
+		api.GET("/alerts/silences/:id", webService.GetSilence)
+		editor.PUT("/alerts/silences/:id", webService.UpdateSilence)
+		editor.DELETE("/alerts/silences/:id", webService.DeleteSilence)
+		api.GET("/alerts/maintenance_windows", webService.GetMaintenanceWindows)
+		editor.POST("/alerts/maintenance_windows", webService.CreateMaintenanceWindow)
+		api.GET("/alerts/maintenance_windows/:name", webService.GetMaintenanceWindow)
+		editor.PUT("/alerts/maintenance_windows/:name", webService.UpdateMaintenanceWindow)
+		editor.DELETE("/alerts/maintenance_windows/:name", webService.DeleteMaintenanceWindow)
+		api.GET("/alerts/inhibit_rules", webService.GetInhibitRules)
+		editor.POST("/alerts/inhibit_rules", webService.CreateInhibitRule)
+		api.GET("/alerts/inhibit_rules/:name", webService.GetInhibitRule)
+		editor.PUT("/alerts/inhibit_rules/:name", webService.UpdateInhibitRule)
+		editor.DELETE("/alerts/inhibit_rules/:name", webService.DeleteInhibitRule)
+		api.GET("/alerts/rules", webService.GetAlertRules)
+		editor.POST("/alerts/rules", webService.CreateAlertRule)
+		api.GET("/alerts/rules/:name", webService.GetAlertRule)
+		editor.PUT("/alerts/rules/:name", webService.UpdateAlertRule)
+		editor.DELETE("/alerts/rules/:name", webService.DeleteAlertRule)
 		api.POST("/query", webService.Query)
 	}
 
 	// Admin API routes
-	admin := router.Group("/api/v1/admin")
+	admin := router.Group("/api/v1/admin", userManager.Middleware(), userManager.Require(users.RoleAdmin), tenants.Middleware())
 	{
 		admin.GET("/config", webService.GetConfig)
 		admin.POST("/config", webService.SaveConfig)
 		admin.GET("/status", webService.GetSystemStatus)
+		admin.GET("/sampling", webService.GetSamplingStats)
+		admin.GET("/ingest_limits", webService.GetIngestLimits)
+		admin.PUT("/ingest_limits", webService.UpdateIngestLimits)
+		admin.GET("/redaction", webService.GetRedactionRules)
+		admin.GET("/transform", webService.GetTransformStatements)
+		admin.GET("/tenants", webService.GetTenants)
+		admin.GET("/api_keys", webService.GetAPIKeys)
+		admin.POST("/api_keys", webService.CreateAPIKey)
+		admin.POST("/api_keys/:name/rotate", webService.RotateAPIKey)
+		admin.DELETE("/api_keys/:name", webService.RevokeAPIKey)
+		admin.GET("/users", webService.GetUsers)
+		admin.POST("/users", webService.CreateUser)
+		admin.PUT("/users/:username", webService.UpdateUser)
+		admin.DELETE("/users/:username", webService.DeleteUser)
 	}
 
 	// OTLP endpoints are now served on dedicated ingestion ports (4317/4318)
 	// These are handled by the ingestion service directly
 
-	// Web UI
+	// Web UI. Pages need a session; static assets are served to the login
+	// page as well.
 	router.Static("/static", "./web/static")
-	router.GET("/", webService.Index)
-	router.GET("/dashboard", webService.Dashboard)
-	router.GET("/metrics", webService.MetricsPage)
-	router.GET("/traces", webService.TracesPage)
-	router.GET("/logs", webService.LogsPage)
-	router.GET("/services", webService.ServicesPage)
-	router.GET("/alerts", webService.AlertsPage)
-	router.GET("/query", webService.QueryPage)
-	router.GET("/admin", webService.AdminPage)
+	pages := router.Group("", userManager.Pages(users.RoleViewer))
+	{
+		pages.GET("/", webService.Index)
+		pages.GET("/dashboard", webService.Dashboard)
+		pages.GET("/metrics", webService.MetricsPage)
+		pages.GET("/traces", webService.TracesPage)
+		pages.GET("/logs", webService.LogsPage)
+		pages.GET("/services", webService.ServicesPage)
+		pages.GET("/alerts", webService.AlertsPage)
+		pages.GET("/query", webService.QueryPage)
+	}
+	router.GET("/admin", userManager.Pages(users.RoleAdmin), webService.AdminPage)
+}
+
+func printPendingMigrations(cfg config.StorageConfig) error {
+	if cfg.Type != "sqlite" {
+		return fmt.Errorf("schema migrations only apply to sqlite storage, configured type is %s", cfg.Type)
+	}
+
+	current, pending, err := storage.PendingMigrations(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Current schema version: %d\n", current)
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+	fmt.Println("Pending migrations:")
+	for _, m := range pending {
+		fmt.Printf("  %04d %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+// printPasswordHash reads a password from the first line of stdin and
+// prints its bcrypt hash
+func printPasswordHash() error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	hash, err := users.HashPassword(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
 }
 
 func healthCheck(c *gin.Context) {
@@ -177,18 +392,3 @@ func readinessCheck(c *gin.Context) {
 		"version":   version,
 	})
 }
-
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
@@ -293,6 +293,12 @@ class TelemorphApp {
         };
 
         const response = await fetch(url, { ...defaultOptions, ...options });
+
+        // The session expired or was ended; sign in again
+        if (response.status === 401) {
+            window.location.href = `/login?next=${encodeURIComponent(window.location.pathname)}`;
+            throw new Error('Not signed in');
+        }
         
         if (!response.ok) {
             throw new Error(`API call failed: ${response.status} ${response.statusText}`);